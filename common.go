@@ -1,7 +1,15 @@
 package winloader
 
 import (
+	"bytes"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+
 	"github.com/jchv/go-winloader/internal/loader"
+	"github.com/jchv/go-winloader/internal/memloader"
+	"github.com/jchv/go-winloader/internal/pdb"
+	"github.com/jchv/go-winloader/internal/pe"
 )
 
 // Proc represents a proc of a module.
@@ -9,3 +17,190 @@ type Proc = loader.Proc
 
 // Module represents a loaded module.
 type Module = loader.Module
+
+// InitializationFailedError is returned when a module's entrypoint returns
+// FALSE for DLL_PROCESS_ATTACH.
+type InitializationFailedError = loader.InitializationFailedError
+
+// ImageInfo contains information about an image being loaded from memory,
+// for use by load policies.
+type ImageInfo = memloader.ImageInfo
+
+// Policy is a function that can veto loading an image from memory before any
+// of its code, or the code of its dependencies, is executed.
+type Policy = memloader.Policy
+
+// DebugInfo contains the debug directory of a module, including the
+// CodeView record that identifies its PDB.
+type DebugInfo = pe.DebugInfo
+
+// CodeViewInfo identifies the PDB file that contains the symbols of a module.
+type CodeViewInfo = pe.CodeViewInfo
+
+// PDB is a parsed program database, containing the symbols of a module.
+type PDB = pdb.File
+
+// SourceLocation is the function, source file and line of an address.
+type SourceLocation = pdb.Location
+
+// OpenPDB parses a program database.
+func OpenPDB(r io.ReaderAt) (*PDB, error) {
+	return pdb.Open(r)
+}
+
+// ImportObject describes a symbol that an import library (.lib) imports from
+// a DLL.
+type ImportObject = pe.ImportObject
+
+// LoadImportLibrary parses an import library and returns the symbols that it
+// imports from DLLs.
+func LoadImportLibrary(r io.ReaderAt) ([]*ImportObject, error) {
+	a, err := pe.LoadArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	return a.Imports()
+}
+
+// VerifyImageCheckSum checks the CheckSum field in the optional header of an
+// image against its file data. Images without a checksum fail verification.
+func VerifyImageCheckSum(data []byte) error {
+	m, err := pe.LoadModule(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return pe.VerifyCheckSum(m, data)
+}
+
+// RebaseOptions specifies how an image is rebased by RebaseImage.
+type RebaseOptions = pe.RebaseOptions
+
+// RebaseImage returns a copy of an image with its base relocations applied
+// for a new preferred base address, so that it does not need to be relocated
+// when it is loaded there.
+func RebaseImage(data []byte, base uint64, opts RebaseOptions) ([]byte, error) {
+	return pe.Rebase(data, base, opts)
+}
+
+// RichHeader is the decoded Rich header of an image, which lists the tools
+// that built the objects linked into it.
+type RichHeader = pe.RichHeader
+
+// RichEntry is an entry of a Rich header.
+type RichEntry = pe.RichEntry
+
+// LoadRichHeader decodes the Rich header of an image file. It returns nil if
+// the image has no Rich header.
+func LoadRichHeader(r io.ReaderAt) (*RichHeader, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	return f.RichHeader()
+}
+
+// ImageOverlay returns the data appended to an image after its sections,
+// excluding the attribute certificate table and the COFF symbol table. It
+// returns nil if there is none.
+func ImageOverlay(data []byte) ([]byte, error) {
+	m, err := pe.LoadModule(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	o, err := m.Overlay(bytes.NewReader(data), int64(len(data)))
+	if err != nil || o == nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(o.NewReader(bytes.NewReader(data)))
+}
+
+// LoadOptions contains options for loading a module from memory.
+type LoadOptions struct {
+	// SkipTLSCallbacks specifies that TLS callbacks should not be called when
+	// the module is loaded. They will be called by Module.Initialize instead.
+	SkipTLSCallbacks bool
+
+	// SkipEntryPoint specifies that the module entrypoint should not be
+	// called when the module is loaded. It will be called by
+	// Module.Initialize instead.
+	SkipEntryPoint bool
+
+	// ValidateCallTargets specifies that Control Flow Guard checks in the
+	// module should validate call targets against the modules loaded from
+	// memory, instead of using the module's no-op check and dispatch
+	// functions. It is only supported on amd64.
+	ValidateCallTargets bool
+
+	// TrustedRoots specifies that the module must have a valid Authenticode
+	// signature that chains up to one of these root certificates. If nil, the
+	// signature is not checked.
+	TrustedRoots *x509.CertPool
+
+	// Policies specifies policies that are checked before any code of the
+	// module or of its dependencies is executed. If any of them returns an
+	// error, the load fails.
+	Policies []Policy
+
+	// StrictWX specifies that modules with sections that would be mapped both
+	// writable and executable should be refused.
+	StrictWX bool
+}
+
+// ObjectOptions contains options for loading a COFF object file from memory.
+type ObjectOptions struct {
+	// Libraries specifies the names of the libraries that undefined symbols
+	// of the object are looked up in, in order, if they are not found in
+	// Symbols. Libraries are loaded the same way as the imports of modules
+	// loaded from memory.
+	Libraries []string
+
+	// Symbols specifies the addresses of undefined symbols of the object by
+	// name. It is checked before Libraries.
+	Symbols map[string]uint64
+}
+
+// RunThread runs fn on a locked OS thread, sending DLL_THREAD_ATTACH to every
+// module loaded from memory before calling it and DLL_THREAD_DETACH after it
+// returns. This is necessary for calling into modules that use thread-local
+// storage or a statically linked C runtime from threads other than the one
+// that loaded them.
+func RunThread(fn func()) {
+	memloader.RunThread(fn)
+}
+
+// DisableThreadLibraryCalls disables thread attach and detach notifications
+// for a module loaded from memory.
+func DisableThreadLibraryCalls(module Module) error {
+	return memloader.DisableThreadLibraryCalls(module)
+}
+
+// ModuleDebugInfo returns the debug directory of a module loaded from memory.
+func ModuleDebugInfo(module Module) (*DebugInfo, error) {
+	return memloader.DebugInfo(module)
+}
+
+// Symbolize returns the function, source file and line of an address in a
+// module loaded from memory, using the symbols in p. The PDB must match the
+// module, which can be checked with PDB.Matches and the CodeView record from
+// ModuleDebugInfo.
+func Symbolize(module Module, p *PDB, addr uint64) (*SourceLocation, error) {
+	return memloader.Symbolize(module, p, addr)
+}
+
+// RequireNXCompat is a policy that vetoes images that are not marked as
+// compatible with data execution prevention.
+func RequireNXCompat(info *ImageInfo) error {
+	return memloader.RequireNXCompat(info)
+}
+
+// DenyWritableExecutableSections is a policy that vetoes images that contain
+// sections that are both writable and executable.
+func DenyWritableExecutableSections(info *ImageInfo) error {
+	return memloader.DenyWritableExecutableSections(info)
+}
+
+// DenyImports returns a policy that vetoes images that import from any of the
+// specified modules.
+func DenyImports(modules ...string) Policy {
+	return memloader.DenyImports(modules...)
+}
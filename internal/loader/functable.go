@@ -0,0 +1,56 @@
+package loader
+
+import (
+	"errors"
+	"sync"
+)
+
+// FunctionTable is a table of runtime function entries registered with
+// FunctionTables.
+type FunctionTable struct {
+	// Table is the address of the first runtime function entry.
+	Table uint64
+
+	// Count is the number of runtime function entries.
+	Count uint32
+
+	// Base is the base address of the image the functions belong to.
+	Base uint64
+}
+
+// FunctionTables implements ExceptionTables with an internal table. It is
+// intended for abstract machines that do not have a native exception
+// dispatcher; they can look up the registered tables when dispatching
+// exceptions or unwinding.
+type FunctionTables struct {
+	mu     sync.Mutex
+	tables []FunctionTable
+}
+
+// AddFunctionTable implements ExceptionTables.
+func (f *FunctionTables) AddFunctionTable(table uint64, count uint32, base uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tables = append(f.tables, FunctionTable{Table: table, Count: count, Base: base})
+	return nil
+}
+
+// DeleteFunctionTable implements ExceptionTables.
+func (f *FunctionTables) DeleteFunctionTable(table uint64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, t := range f.tables {
+		if t.Table == table {
+			f.tables = append(f.tables[:i:i], f.tables[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("function table not registered")
+}
+
+// Tables returns the registered function tables.
+func (f *FunctionTables) Tables() []FunctionTable {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FunctionTable{}, f.tables...)
+}
@@ -1,5 +1,27 @@
 package loader
 
+import "fmt"
+
+// InitializationFailedError is returned when a module's entrypoint returns
+// FALSE for DLL_PROCESS_ATTACH.
+type InitializationFailedError struct {
+	// LastErr contains the Windows error value after calling the entrypoint.
+	LastErr error
+}
+
+// Error implements the error interface.
+func (e *InitializationFailedError) Error() string {
+	if e.LastErr == nil {
+		return "module initialization failed"
+	}
+	return fmt.Sprintf("module initialization failed: %v", e.LastErr)
+}
+
+// Unwrap returns the Windows error value after calling the entrypoint.
+func (e *InitializationFailedError) Unwrap() error {
+	return e.LastErr
+}
+
 // Module represents a loaded Windows module.
 type Module interface {
 	// Proc returns a procedure by symbol name. Returns nil if the symbol is
@@ -9,6 +31,12 @@ type Module interface {
 	// Ordinal returns a procedure by ordinal.
 	Ordinal(ordinal uint64) Proc
 
+	// Initialize runs any module initialization that was deferred during
+	// loading, such as TLS callbacks and the entrypoint. It does nothing if
+	// the module is already initialized. If initialization fails, the module
+	// is unloaded; Free then does nothing and Initialize returns an error.
+	Initialize() error
+
 	// Free closes the module and frees the memory. After this, GetProcAddress
 	// will stop working and procedures will no longer function.
 	Free() error
@@ -32,10 +32,52 @@ type Memory interface {
 	// block. It should match the semantics of the VirtualProtect function on
 	// Windows.
 	Protect(addr, size uint64, protect int) error
+
+	// Decommit decommits a subregion of this allocated block, so that it no
+	// longer uses physical memory and is inaccessible. It should match the
+	// semantics of the VirtualFree function on Windows with MEM_DECOMMIT.
+	Decommit(addr, size uint64) error
+}
+
+// TLS is an interface for the static thread-local storage of an abstract
+// machine. Static TLS is used by images that have a TLS directory, such as
+// ones that use __declspec(thread) variables. Each image is assigned an index
+// into a per-thread array of pointers to its TLS data.
+type TLS interface {
+	// AllocTLSIndex allocates a static TLS index.
+	AllocTLSIndex() (uint32, error)
+
+	// FreeTLSIndex frees a static TLS index.
+	FreeTLSIndex(index uint32)
+
+	// SetTLSData allocates a block of TLS data for the current thread,
+	// initialized with data, and stores its address at index in the current
+	// thread's TLS array.
+	SetTLSData(index uint32, data []byte) error
+
+	// FreeTLSData frees the block of TLS data at index for the current thread,
+	// if there is one.
+	FreeTLSData(index uint32)
+}
+
+// ExceptionTables is an interface for registering the exception handling
+// tables of loaded images with an abstract machine, so that exceptions can
+// be dispatched and stacks unwound through them.
+type ExceptionTables interface {
+	// AddFunctionTable registers a table of count runtime function entries at
+	// address table, for the image loaded at base. It should match the
+	// semantics of RtlAddFunctionTable on Windows.
+	AddFunctionTable(table uint64, count uint32, base uint64) error
+
+	// DeleteFunctionTable unregisters a table registered by AddFunctionTable.
+	DeleteFunctionTable(table uint64) error
 }
 
 // Machine is an abstract machine interface.
 type Machine interface {
+	TLS
+	ExceptionTables
+
 	// IsArchitectureSupported returns whether or not an architecture is
 	// supported by this abstract machine. Machine is a PE machine ID.
 	IsArchitectureSupported(machine int) bool
@@ -0,0 +1,364 @@
+// Package loadertest implements the loader interfaces for testing loaders.
+// Memory is backed by byte slices and procedures are never executed; calls
+// are recorded and return scripted results instead.
+package loadertest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/jchv/go-winloader/internal/loader"
+	"github.com/jchv/go-winloader/internal/pe"
+	"github.com/jchv/go-winloader/internal/vmem"
+)
+
+// PageSize is the page size of Machine.
+const PageSize = 0x1000
+
+// Base is the address of allocations made by Machine at no particular
+// address.
+const Base = 0x20000000
+
+// Memory is a loader.Memory implementation backed by a byte slice. It tracks
+// the protection of each page, with decommitted pages having a protection of
+// zero.
+type Memory struct {
+	addr uint64
+	i    int64
+
+	// Data is the contents of the memory.
+	Data []byte
+
+	// Pages contains the protection of each page.
+	Pages []int
+
+	// Frees is the number of times the memory has been freed.
+	Frees int
+}
+
+// Read implements io.Reader.
+func (m *Memory) Read(b []byte) (n int, err error) {
+	if m.i >= int64(len(m.Data)) {
+		return 0, io.EOF
+	}
+	n = copy(b, m.Data[m.i:])
+	m.i += int64(n)
+	return n, nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (m *Memory) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < 0 || off >= int64(len(m.Data)) {
+		return 0, io.EOF
+	}
+	n = copy(b, m.Data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write implements io.Writer.
+func (m *Memory) Write(b []byte) (n int, err error) {
+	if m.i >= int64(len(m.Data)) {
+		return 0, io.ErrShortWrite
+	}
+	n = copy(m.Data[m.i:], b)
+	m.i += int64(n)
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt.
+func (m *Memory) WriteAt(b []byte, off int64) (n int, err error) {
+	if off < 0 || off >= int64(len(m.Data)) {
+		return 0, io.ErrShortWrite
+	}
+	n = copy(m.Data[off:], b)
+	if n < len(b) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker.
+func (m *Memory) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		m.i = offset
+	case io.SeekCurrent:
+		m.i += offset
+	case io.SeekEnd:
+		m.i = int64(len(m.Data)) + offset
+	}
+	return m.i, nil
+}
+
+// Free implements loader.Memory.
+func (m *Memory) Free() {
+	m.Frees++
+}
+
+// Freed returns whether the memory has been freed.
+func (m *Memory) Freed() bool {
+	return m.Frees > 0
+}
+
+// Addr implements loader.Memory.
+func (m *Memory) Addr() uint64 {
+	return m.addr
+}
+
+// Clear implements loader.Memory.
+func (m *Memory) Clear() {
+	for i := range m.Data {
+		m.Data[i] = 0
+	}
+}
+
+// pageRange returns the range of pages spanned by a subregion, like
+// VirtualProtect and VirtualFree.
+func (m *Memory) pageRange(addr, size uint64) (int, int, error) {
+	start, end := vmem.RoundDown(addr, PageSize)/PageSize, vmem.RoundUp(addr+size, PageSize)/PageSize
+	if size == 0 || end > uint64(len(m.Pages)) {
+		return 0, 0, fmt.Errorf("invalid range %#x+%#x", addr, size)
+	}
+	return int(start), int(end), nil
+}
+
+// Protect implements loader.Memory. It fails for pages that are not
+// committed.
+func (m *Memory) Protect(addr, size uint64, protect int) error {
+	start, end, err := m.pageRange(addr, size)
+	if err != nil {
+		return err
+	}
+	for i := start; i < end; i++ {
+		if m.Pages[i] == 0 {
+			return fmt.Errorf("page %#x is not committed", i*PageSize)
+		}
+		m.Pages[i] = protect
+	}
+	return nil
+}
+
+// Decommit implements loader.Memory.
+func (m *Memory) Decommit(addr, size uint64) error {
+	start, end, err := m.pageRange(addr, size)
+	if err != nil {
+		return err
+	}
+	for i := start; i < end; i++ {
+		m.Pages[i] = 0
+	}
+	return nil
+}
+
+// Uint32 returns the 32-bit value at the address addr.
+func (m *Memory) Uint32(addr uint64) uint32 {
+	return binary.LittleEndian.Uint32(m.Data[addr-m.addr:])
+}
+
+// Uint64 returns the 64-bit value at the address addr.
+func (m *Memory) Uint64(addr uint64) uint64 {
+	return binary.LittleEndian.Uint64(m.Data[addr-m.addr:])
+}
+
+// Protection returns the protection of the page containing the address addr.
+func (m *Memory) Protection(addr uint64) int {
+	return m.Pages[(addr-m.addr)/PageSize]
+}
+
+// Call records a call to a Proc.
+type Call struct {
+	Addr uint64
+	Args []uint64
+}
+
+// Result is a scripted result for a Proc.
+type Result struct {
+	R1      uint64
+	LastErr error
+}
+
+// Machine is a loader.Machine implementation that allocates Memory, records
+// procedure calls and returns scripted results. TLS data is recorded by
+// index.
+type Machine struct {
+	loader.FunctionTables
+
+	// Arch is the supported architecture. If zero, it is i386.
+	Arch int
+
+	// Results contains the results of procedures by address. Procedures
+	// that are not in Results return zero.
+	Results map[uint64]Result
+
+	// Calls contains the calls made to procedures, in order.
+	Calls []Call
+
+	// Allocs contains the memory allocated by the machine, in order.
+	Allocs []*Memory
+
+	// TLSIndices contains the allocated TLS indices, TLSData contains the
+	// TLS data set for each index, and TLSEvents describes each TLS
+	// operation, in order.
+	TLSIndices []uint32
+	TLSData    map[uint32][]byte
+	TLSEvents  []string
+}
+
+// IsArchitectureSupported implements loader.Machine.
+func (t *Machine) IsArchitectureSupported(machine int) bool {
+	if t.Arch == 0 {
+		return machine == pe.ImageFileMachinei386
+	}
+	return machine == t.Arch
+}
+
+// GetPageSize implements loader.Machine.
+func (t *Machine) GetPageSize() uint64 {
+	return PageSize
+}
+
+// Alloc implements loader.Machine. Memory is allocated at addr, or at Base
+// if addr is zero.
+func (t *Machine) Alloc(addr, size uint64, allocType, protect int) loader.Memory {
+	if addr == 0 {
+		addr = Base
+	}
+	mem := &Memory{addr: addr, Data: make([]byte, size), Pages: make([]int, vmem.RoundUp(size, PageSize)/PageSize)}
+	for i := range mem.Pages {
+		mem.Pages[i] = protect
+	}
+	t.Allocs = append(t.Allocs, mem)
+	return mem
+}
+
+// MemProc implements loader.Machine.
+func (t *Machine) MemProc(addr uint64) loader.Proc {
+	return Proc{t, addr}
+}
+
+// AllocTLSIndex implements loader.TLS. Indices are allocated from one.
+func (t *Machine) AllocTLSIndex() (uint32, error) {
+	index := uint32(len(t.TLSIndices) + 1)
+	t.TLSIndices = append(t.TLSIndices, index)
+	return index, nil
+}
+
+// FreeTLSIndex implements loader.TLS.
+func (t *Machine) FreeTLSIndex(index uint32) {
+	t.TLSEvents = append(t.TLSEvents, fmt.Sprintf("free index %d", index))
+}
+
+// SetTLSData implements loader.TLS.
+func (t *Machine) SetTLSData(index uint32, data []byte) error {
+	if t.TLSData == nil {
+		t.TLSData = map[uint32][]byte{}
+	}
+	t.TLSData[index] = append([]byte{}, data...)
+	t.TLSEvents = append(t.TLSEvents, fmt.Sprintf("set data %d", index))
+	return nil
+}
+
+// FreeTLSData implements loader.TLS.
+func (t *Machine) FreeTLSData(index uint32) {
+	if _, ok := t.TLSData[index]; ok {
+		delete(t.TLSData, index)
+		t.TLSEvents = append(t.TLSEvents, fmt.Sprintf("free data %d", index))
+	}
+}
+
+// Proc is a loader.Proc implementation for Machine. Procs that do not belong
+// to a machine return zero when called.
+type Proc struct {
+	machine *Machine
+	addr    uint64
+}
+
+// Call implements loader.Proc.
+func (p Proc) Call(a ...uint64) (r1, r2 uint64, lastErr error) {
+	if p.machine == nil {
+		return 0, 0, nil
+	}
+	p.machine.Calls = append(p.machine.Calls, Call{p.addr, a})
+	result := p.machine.Results[p.addr]
+	return result.R1, 0, result.LastErr
+}
+
+// Addr implements loader.Proc.
+func (p Proc) Addr() uint64 {
+	return p.addr
+}
+
+// Library is a module with the specified exports, by name and by ordinal.
+type Library map[interface{}]uint64
+
+// Proc implements loader.Module.
+func (l Library) Proc(name string) loader.Proc {
+	if addr, ok := l[name]; ok {
+		return Proc{addr: addr}
+	}
+	return nil
+}
+
+// Ordinal implements loader.Module.
+func (l Library) Ordinal(ordinal uint64) loader.Proc {
+	if addr, ok := l[ordinal]; ok {
+		return Proc{addr: addr}
+	}
+	return nil
+}
+
+// Initialize implements loader.Module.
+func (l Library) Initialize() error {
+	return nil
+}
+
+// Free implements loader.Module.
+func (l Library) Free() error {
+	return nil
+}
+
+// Loader is a loader.Loader that loads Library modules by name.
+type Loader map[string]Library
+
+// Load implements loader.Loader.
+func (l Loader) Load(libname string) (loader.Module, error) {
+	if lib, ok := l[libname]; ok {
+		return lib, nil
+	}
+	return nil, fmt.Errorf("library %s not found", libname)
+}
+
+// TrackingLoader is a loader.Loader that records the libraries that are
+// loaded and freed through it.
+type TrackingLoader struct {
+	Next   loader.Loader
+	Loaded []string
+	Freed  []string
+}
+
+// Load implements loader.Loader.
+func (l *TrackingLoader) Load(libname string) (loader.Module, error) {
+	mod, err := l.Next.Load(libname)
+	if err != nil {
+		return nil, err
+	}
+	l.Loaded = append(l.Loaded, libname)
+	return &trackedModule{Module: mod, l: l, name: libname}, nil
+}
+
+// trackedModule is a module loaded by a TrackingLoader.
+type trackedModule struct {
+	loader.Module
+	l    *TrackingLoader
+	name string
+}
+
+// Free implements loader.Module.
+func (m *trackedModule) Free() error {
+	m.l.Freed = append(m.l.Freed, m.name)
+	return m.Module.Free()
+}
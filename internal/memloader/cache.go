@@ -20,13 +20,25 @@ func NewCache(next loader.Loader) *Cache {
 	}
 }
 
+// cachedModule is a module returned from the cache. The cache does not count
+// references to its modules, so freeing it does nothing; the module stays
+// loaded until it is freed by whoever added it.
+type cachedModule struct {
+	loader.Module
+}
+
+// Free implements loader.Module
+func (cachedModule) Free() error {
+	return nil
+}
+
 // Load implements loader.Loader by loading from cache or falling back.
 func (c *Cache) Load(libname string) (loader.Module, error) {
 	if m, ok := c.cache[strings.ToLower(libname)]; ok {
-		return m, nil
+		return cachedModule{m}, nil
 	}
 	if m, ok := c.cache[strings.ToLower(libname)+".dll"]; ok {
-		return m, nil
+		return cachedModule{m}, nil
 	}
 	return c.next.Load(libname)
 }
@@ -0,0 +1,52 @@
+package memloader
+
+import (
+	"errors"
+
+	"github.com/jchv/go-winloader/internal/loader"
+	"github.com/jchv/go-winloader/internal/pdb"
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// DebugInfo returns the decoded debug directory of a memory module, which
+// identifies the PDB containing its symbols. Debug data that is not mapped
+// into memory is not available.
+func DebugInfo(mod loader.Module) (*pe.DebugInfo, error) {
+	m, ok := mod.(*module)
+	if !ok {
+		return nil, errors.New("module was not loaded by the memory loader")
+	}
+	return m.debug, m.debugErr
+}
+
+// Symbolize returns the function, source file and line of an address in a
+// memory module, using the symbols in p. The PDB must match the CodeView
+// record of the module. It returns nil if there is no symbol for the
+// address.
+func Symbolize(mod loader.Module, p *pdb.File, addr uint64) (*pdb.Location, error) {
+	m, ok := mod.(*module)
+	if !ok {
+		return nil, errors.New("module was not loaded by the memory loader")
+	}
+	if m.debug == nil || m.debug.CodeView == nil {
+		return nil, errors.New("module has no codeview record")
+	}
+	if !p.Matches(m.debug.CodeView) {
+		return nil, errors.New("pdb does not match module")
+	}
+
+	// Addresses in the PDB are relative to sections of the image, which are
+	// numbered starting at 1.
+	base := m.memory.Addr()
+	if addr < base || addr-base >= uint64(m.pemod.Header.OptionalHeader.SizeOfImage) {
+		return nil, errors.New("address is outside of module")
+	}
+	rva := uint32(addr - base)
+	section := m.pemod.SectionByRVA(rva)
+	for i := range m.pemod.Sections {
+		if &m.pemod.Sections[i] == section {
+			return p.Lookup(uint16(i+1), rva-section.VirtualAddress)
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,148 @@
+package memloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loadertest"
+	"github.com/jchv/go-winloader/internal/pdb"
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+var testPDBGUID = pe.GUID{Data1: 0x12345678, Data2: 0x9ABC, Data3: 0xDEF0, Data4: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+
+// makeDebugImage builds an image with a debug directory containing CodeView,
+// POGO, REPRO and extended DLL characteristics entries. The CodeView record
+// matches testdata/tiny.pdb in the pdb package.
+func makeDebugImage() []byte {
+	const rdata, fileOffset = 0x2000, 0x400
+	data := make([]byte, 0x200)
+
+	codeview := &bytes.Buffer{}
+	binary.Write(codeview, binary.LittleEndian, uint32(pe.CodeViewSignatureRSDS))
+	binary.Write(codeview, binary.LittleEndian, testPDBGUID)
+	binary.Write(codeview, binary.LittleEndian, uint32(3))
+	codeview.WriteString("C:\\build\\test.pdb\x00")
+	copy(data[0x80:], codeview.Bytes())
+
+	pogo := []byte("PGU\x00\x00\x10\x00\x00\x10\x00\x00\x00.text$mn\x00\x00\x00\x00")
+	copy(data[0xC0:], pogo)
+
+	binary.LittleEndian.PutUint32(data[0x100:], pe.ImageDLLCharacteristicsExCETCompat)
+
+	dirs := []pe.ImageDebugDirectory{
+		{Type: pe.ImageDebugTypeCodeView, SizeOfData: uint32(codeview.Len()), AddressOfRawData: rdata + 0x80, PointerToRawData: fileOffset + 0x80},
+		{Type: pe.ImageDebugTypePOGO, SizeOfData: uint32(len(pogo)), AddressOfRawData: rdata + 0xC0, PointerToRawData: fileOffset + 0xC0},
+		{Type: pe.ImageDebugTypeRepro},
+		{Type: pe.ImageDebugTypeExDLLCharacteristics, SizeOfData: 4, AddressOfRawData: rdata + 0x100, PointerToRawData: fileOffset + 0x100},
+	}
+	dir := &bytes.Buffer{}
+	binary.Write(dir, binary.LittleEndian, dirs)
+	copy(data, dir.Bytes())
+
+	return makeImage(0, map[int]pe.ImageDataDirectory{
+		pe.ImageDirectoryEntryDebug: {VirtualAddress: rdata, Size: uint32(dir.Len())},
+	}, []testSection{{
+		name:            ".text",
+		rva:             0x1000,
+		virtualSize:     0x30,
+		data:            make([]byte, 0x30),
+		characteristics: pe.ImageSectionCharacteristicsMemoryRead | pe.ImageSectionCharacteristicsMemoryExecute,
+	}, {
+		name:            ".rdata",
+		rva:             rdata,
+		virtualSize:     0x200,
+		data:            data,
+		characteristics: pe.ImageSectionCharacteristicsMemoryRead,
+	}})
+}
+
+func checkDebugInfo(t *testing.T, info *pe.DebugInfo) {
+	t.Helper()
+	if len(info.Entries) != 4 {
+		t.Fatalf("expected 4 debug entries got %d", len(info.Entries))
+	}
+	cv := info.CodeView
+	if cv == nil {
+		t.Fatal("expected CodeView record")
+	}
+	if cv.GUID != testPDBGUID || cv.Age != 3 || cv.Path != "C:\\build\\test.pdb" {
+		t.Errorf("unexpected CodeView record %+v", cv)
+	}
+	if path := cv.SymbolServerPath(); path != "test.pdb/123456789ABCDEF001020304050607083/test.pdb" {
+		t.Errorf("unexpected symbol server path %q", path)
+	}
+	if info.POGO == nil || len(info.POGO.Entries) != 1 || info.POGO.Entries[0].Name != ".text$mn" || info.POGO.Entries[0].Size != 0x10 {
+		t.Errorf("unexpected POGO record %+v", info.POGO)
+	}
+	if !info.Repro || len(info.ReproHash) != 0 {
+		t.Errorf("expected repro without hash got %v, %x", info.Repro, info.ReproHash)
+	}
+	if info.ExDLLCharacteristics != pe.ImageDLLCharacteristicsExCETCompat {
+		t.Errorf("unexpected extended DLL characteristics %#x", info.ExDLLCharacteristics)
+	}
+}
+
+func TestDebugInfo(t *testing.T) {
+	image := makeDebugImage()
+
+	// Loaded modules.
+	ldr := New(Options{Machine: &loadertest.Machine{}})
+	mod, err := ldr.LoadMem(image)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	defer mod.Free()
+	info, err := DebugInfo(mod)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	checkDebugInfo(t, info)
+
+	// Parsed files.
+	file, err := pe.NewFile(bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	info, err = file.DebugInfo()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	checkDebugInfo(t, info)
+}
+
+func TestSymbolize(t *testing.T) {
+	r, err := os.Open("../pdb/testdata/tiny.pdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	p, err := pdb.Open(r)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+
+	ldr := New(Options{Machine: &loadertest.Machine{}})
+	mod, err := ldr.LoadMem(makeDebugImage())
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	defer mod.Free()
+
+	loc, err := Symbolize(mod, p, testBase+0x1016)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if loc == nil || loc.Function != "Sub" || loc.Offset != 6 || loc.File != `C:\build\tiny.c` || loc.Line != 9 {
+		t.Errorf("unexpected location %+v", loc)
+	}
+
+	if loc, err := Symbolize(mod, p, testBase+0x2000); loc != nil || err != nil {
+		t.Errorf("expected no location in data section got %+v, %v", loc, err)
+	}
+	if _, err := Symbolize(mod, p, testBase-1); err == nil {
+		t.Error("expected error for address outside of module")
+	}
+}
@@ -0,0 +1,30 @@
+package memloader
+
+import (
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// addFunctionTable registers the exception directory of the module with the
+// machine, if it has one in a supported format.
+func (m *module) addFunctionTable() error {
+	dir := m.pemod.Header.OptionalHeader.DataDirectory[pe.ImageDirectoryEntryException]
+	size := pe.RuntimeFunctionEntrySize(int(m.pemod.Header.FileHeader.Machine))
+	if dir.Size == 0 || size == 0 {
+		return nil
+	}
+	table := m.memory.Addr() + uint64(dir.VirtualAddress)
+	if err := m.machine.AddFunctionTable(table, dir.Size/uint32(size), m.memory.Addr()); err != nil {
+		return err
+	}
+	m.functionTable = table
+	return nil
+}
+
+// deleteFunctionTable unregisters the exception directory of the module.
+func (m *module) deleteFunctionTable() {
+	if m.functionTable == 0 {
+		return
+	}
+	m.machine.DeleteFunctionTable(m.functionTable)
+	m.functionTable = 0
+}
@@ -0,0 +1,160 @@
+package memloader
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// Default security cookie values, as emitted by the linker. The loader
+// replaces these with random values.
+const (
+	defaultSecurityCookie32 = 0xBB40E64E
+	defaultSecurityCookie64 = 0x00002B992DDFA232
+)
+
+var (
+	// loadedMu guards loaded.
+	loadedMu sync.Mutex
+
+	// loaded contains all modules that are currently mapped by the memory
+	// loader, including ones that have not been initialized yet.
+	loaded []*module
+)
+
+// registerLoaded adds a module to the list of loaded modules.
+func registerLoaded(m *module) {
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+	loaded = append(loaded, m)
+}
+
+// unregisterLoaded removes a module from the list of loaded modules.
+func unregisterLoaded(m *module) {
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+	for n, i := range loaded {
+		if i == m {
+			loaded = append(loaded[:n:n], loaded[n+1:]...)
+			return
+		}
+	}
+}
+
+// loadConfig processes the load configuration directory of the module. It
+// initializes the security cookie and installs the Control Flow Guard
+// functions. This must be done after relocation, but before the memory
+// protection is set.
+func (m *module) loadConfig(guardCheck, guardDispatch uint64) error {
+	cfg, err := pe.LoadConfigDirectory(m.pemod, m.memory)
+	if err != nil || cfg == nil {
+		return err
+	}
+	m.loadcfg = cfg
+
+	if m.guard, err = pe.LoadGuardInfo(m.pemod, m.memory, m.memory.Addr()); err != nil {
+		return err
+	}
+
+	if err := m.initSecurityCookie(); err != nil {
+		return err
+	}
+
+	if m.pemod.Header.OptionalHeader.DllCharacteristics&pe.ImageDLLCharacteristicsGuardCF != 0 {
+		if cfg.GuardCFCheckFunctionPointer != 0 && guardCheck != 0 {
+			if err := m.writePtr(cfg.GuardCFCheckFunctionPointer, guardCheck); err != nil {
+				return err
+			}
+		}
+		if cfg.GuardCFDispatchFunctionPointer != 0 && guardDispatch != 0 {
+			if err := m.writePtr(cfg.GuardCFDispatchFunctionPointer, guardDispatch); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// initSecurityCookie replaces the default security cookie of the module with
+// a random value, like the Windows loader does. Cookies that have already
+// been changed from the default are left alone.
+func (m *module) initSecurityCookie() error {
+	if m.loadcfg.SecurityCookie == 0 {
+		return nil
+	}
+
+	psize, def, mask := 4, uint64(defaultSecurityCookie32), uint64(0xFFFFFFFF)
+	if m.pemod.IsPE64 {
+		// The upper 16 bits are cleared, so the cookie is never a valid
+		// canonical pointer.
+		psize, def, mask = 8, defaultSecurityCookie64, 0x0000FFFFFFFFFFFF
+	}
+
+	b := [8]byte{}
+	if _, err := m.memory.ReadAt(b[:psize], int64(m.loadcfg.SecurityCookie-m.memory.Addr())); err != nil {
+		return err
+	}
+	if binary.LittleEndian.Uint64(b[:])&mask != def {
+		return nil
+	}
+
+	cookie := def
+	for cookie == def || cookie == 0 {
+		if _, err := rand.Read(b[:]); err != nil {
+			return err
+		}
+		cookie = binary.LittleEndian.Uint64(b[:]) & mask
+	}
+	return m.writePtr(m.loadcfg.SecurityCookie, cookie)
+}
+
+// writePtr writes a pointer-sized value into the module at the specified
+// virtual address.
+func (m *module) writePtr(va uint64, value uint64) error {
+	psize := 4
+	if m.pemod.IsPE64 {
+		psize = 8
+	}
+	b := [8]byte{}
+	binary.LittleEndian.PutUint64(b[:], value)
+	_, err := m.memory.WriteAt(b[:psize], int64(va-m.memory.Addr()))
+	return err
+}
+
+// IsValidCallTarget returns whether addr is a valid indirect call target with
+// respect to the modules loaded by the memory loader. Addresses inside a
+// memory module with a Control Flow Guard function table are valid if they
+// are in the table. Addresses inside other memory modules are valid if they
+// are inside an executable section. Addresses outside of all memory modules
+// are always considered valid.
+func IsValidCallTarget(addr uint64) bool {
+	loadedMu.Lock()
+	defer loadedMu.Unlock()
+	for _, m := range loaded {
+		base := m.memory.Addr()
+		if addr < base || addr-base >= uint64(m.pemod.Header.OptionalHeader.SizeOfImage) {
+			continue
+		}
+		rva := addr - base
+		if m.guard != nil && m.guard.Flags&pe.ImageGuardCFFunctionTablePresent != 0 {
+			return m.guard.IsValidCallTarget(uint32(rva))
+		}
+		for _, section := range m.pemod.Sections {
+			if section.Characteristics&pe.ImageSectionCharacteristicsMemoryExecute == 0 {
+				continue
+			}
+			size := uint64(section.PhysicalAddressOrVirtualSize)
+			if size == 0 {
+				size = uint64(section.SizeOfRawData)
+			}
+			if rva >= uint64(section.VirtualAddress) && rva-uint64(section.VirtualAddress) < size {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+package memloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loadertest"
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// offsetOfDllCharacteristics32 is the file offset of the DllCharacteristics
+// field in images built by makeImage.
+const offsetOfDllCharacteristics32 = pe.SizeOfImageDOSHeader + 4 + pe.SizeOfImageFileHeader + 70
+
+func makeLoadConfigImage() []byte {
+	data := make([]byte, 0x200)
+	binary.LittleEndian.PutUint32(data[0x00:], defaultSecurityCookie32)
+	binary.LittleEndian.PutUint32(data[0x04:], testBase+0x2000)
+	binary.LittleEndian.PutUint32(data[0x08:], testBase+0x2004)
+	dir := &bytes.Buffer{}
+	binary.Write(dir, binary.LittleEndian, pe.ImageLoadConfigDirectory32{
+		Size:                           0x5C,
+		SecurityCookie:                 testBase + 0x1000,
+		GuardCFCheckFunctionPointer:    testBase + 0x1004,
+		GuardCFDispatchFunctionPointer: testBase + 0x1008,
+	})
+	copy(data[0x40:], dir.Bytes()[:0x5C])
+	image := makeImage(0, map[int]pe.ImageDataDirectory{
+		pe.ImageDirectoryEntryLoadConfig: {VirtualAddress: 0x1040, Size: 0x40},
+	}, []testSection{{
+		name:            ".rdata",
+		rva:             0x1000,
+		virtualSize:     0x200,
+		data:            data,
+		characteristics: pe.ImageSectionCharacteristicsMemoryRead | pe.ImageSectionCharacteristicsMemoryWrite,
+	}, {
+		name:            ".text",
+		rva:             0x2000,
+		virtualSize:     0x10,
+		data:            []byte{0xC3},
+		characteristics: pe.ImageSectionCharacteristicsMemoryRead | pe.ImageSectionCharacteristicsMemoryExecute,
+	}})
+	binary.LittleEndian.PutUint16(image[offsetOfDllCharacteristics32:], pe.ImageDLLCharacteristicsGuardCF)
+	return image
+}
+
+func TestLoadConfig(t *testing.T) {
+	machine := &loadertest.Machine{}
+	ldr := New(Options{Machine: machine, GuardCFCheckFunction: 0x12345678})
+	mod, err := ldr.LoadMem(makeLoadConfigImage())
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	mem := machine.Allocs[0]
+
+	b := [12]byte{}
+	mem.ReadAt(b[:], 0x1000)
+	if cookie := binary.LittleEndian.Uint32(b[0:]); cookie == defaultSecurityCookie32 || cookie == 0 {
+		t.Errorf("expected security cookie to be initialized, got %#x", cookie)
+	}
+	if check := binary.LittleEndian.Uint32(b[4:]); check != 0x12345678 {
+		t.Errorf("expected guard check function %#x, got %#x", 0x12345678, check)
+	}
+	if dispatch := binary.LittleEndian.Uint32(b[8:]); dispatch != testBase+0x2004 {
+		t.Errorf("expected guard dispatch function to be kept, got %#x", dispatch)
+	}
+
+	tests := []struct {
+		addr     uint64
+		expected bool
+	}{
+		{testBase + 0x1000, false},
+		{testBase + 0x2000, true},
+		{testBase + 0x2010, false},
+		{0x7FFE0000, true},
+	}
+	for _, test := range tests {
+		if actual := IsValidCallTarget(test.addr); actual != test.expected {
+			t.Errorf("IsValidCallTarget(%#x): expected %v got %v", test.addr, test.expected, actual)
+		}
+	}
+
+	mod.Free()
+	if !IsValidCallTarget(testBase + 0x1000) {
+		t.Error("expected addresses of freed modules to be valid call targets")
+	}
+}
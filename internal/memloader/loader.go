@@ -3,8 +3,10 @@ package memloader
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"io"
+	"runtime"
+	"sync"
 
 	"github.com/jchv/go-winloader/internal/loader"
 	"github.com/jchv/go-winloader/internal/pe"
@@ -12,6 +14,12 @@ import (
 	"github.com/jchv/go-winloader/internal/winloader"
 )
 
+// Reason values passed to DLL entrypoints and TLS callbacks.
+const (
+	dllProcessDetach = 0
+	dllProcessAttach = 1
+)
+
 // module implements a module for the memory loader.
 type module struct {
 	machine   loader.Machine
@@ -19,6 +27,49 @@ type module struct {
 	pemod     *pe.Module
 	exports   *pe.ExportTable
 	hinstance uint64
+
+	// tlsCallbacks contains the addresses of the TLS callbacks.
+	tlsCallbacks []uint64
+
+	// tls contains the static TLS state, if the module uses TLS.
+	tls *tlsInfo
+
+	// loadcfg contains the load configuration of the module, if it has one.
+	loadcfg *pe.ImageLoadConfigDirectory64
+
+	// guard contains the Control Flow Guard metadata of the module, if it
+	// has a load configuration.
+	guard *pe.GuardInfo
+
+	// debug contains the decoded debug directory of the module. debugErr
+	// contains the error from decoding it, if any.
+	debug    *pe.DebugInfo
+	debugErr error
+
+	// functionTable is the address of the registered function table, if
+	// any.
+	functionTable uint64
+
+	// deps contains the modules loaded to resolve the imports of the
+	// module, in load order. They are freed along with the module.
+	deps []loader.Module
+
+	// mu guards tlsAttached, entryAttached and unloaded, and is held while
+	// the module receives process and thread notifications.
+	mu sync.Mutex
+
+	// tlsAttached and entryAttached specify whether the TLS callbacks and
+	// entrypoint have been called for process attach, respectively.
+	tlsAttached   bool
+	entryAttached bool
+
+	// unloaded specifies that the module has been unloaded, either by Free
+	// or because its initialization failed.
+	unloaded bool
+
+	// noThreadCalls specifies that the module should not receive thread
+	// attach and detach notifications.
+	noThreadCalls bool
 }
 
 // Proc implements loader.Module
@@ -39,23 +90,152 @@ func (m *module) Ordinal(ordinal uint64) loader.Proc {
 	return m.machine.MemProc(addr)
 }
 
+// errUnloaded is returned when initializing a module that has been unloaded.
+var errUnloaded = errors.New("module has been unloaded")
+
+// Initialize implements loader.Module
+func (m *module) Initialize() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unloaded {
+		return errUnloaded
+	}
+	if m.tlsAttached && m.entryAttached {
+		return nil
+	}
+
+	// The process attach notifications may run on another thread than the
+	// load, so the TLS data is set up for this one first.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	if err := m.attachTLSData(); err != nil {
+		return err
+	}
+	if !m.tlsAttached {
+		m.attachTLS()
+	}
+	if !m.entryAttached {
+		return m.attachEntryPoint()
+	}
+	return nil
+}
+
 // Free implements loader.Module
 func (m *module) Free() error {
-	// Execute entrypoint for detach.
-	entry := m.machine.MemProc(m.memory.Addr() + uint64(m.pemod.Header.OptionalHeader.AddressOfEntryPoint))
-	entry.Call(uint64(m.memory.Addr()), 0, 0)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unloaded {
+		return nil
+	}
+	unregisterAttached(m)
+
+	// Execute TLS callbacks and entrypoint for detach.
+	if m.tlsAttached {
+		m.callTLSCallbacks(dllProcessDetach)
+	}
+	if m.entryAttached {
+		m.callEntryPoint(dllProcessDetach)
+	}
+	m.unload()
+	return nil
+}
 
-	// Free memory.
+// unload frees the resources of the module, including its memory, and the
+// modules it depends on.
+func (m *module) unload() {
+	m.unloaded = true
+	unregisterLoaded(m)
+	m.freeTLS()
+	m.deleteFunctionTable()
 	m.memory.Free()
+	freeModules(m.deps)
+	m.deps = nil
+}
+
+// dependencyLoader records the modules loaded through it, so that they can
+// be freed along with the module that depends on them.
+type dependencyLoader struct {
+	next loader.Loader
+	mods []loader.Module
+}
+
+// Load implements loader.Loader
+func (l *dependencyLoader) Load(libname string) (loader.Module, error) {
+	mod, err := l.next.Load(libname)
+	if err != nil {
+		return nil, err
+	}
+	l.mods = append(l.mods, mod)
+	return mod, nil
+}
+
+// freeModules frees modules in reverse load order.
+func freeModules(mods []loader.Module) {
+	for i := len(mods) - 1; i >= 0; i-- {
+		mods[i].Free()
+	}
+}
+
+// attachTLS calls the TLS callbacks for process attach.
+func (m *module) attachTLS() {
+	m.tlsAttached = true
+	m.callTLSCallbacks(dllProcessAttach)
+	registerAttached(m)
+}
+
+// attachEntryPoint calls the entrypoint for process attach. If the entrypoint
+// returns FALSE, the module is detached and unloaded, like Windows loader
+// does for LoadLibrary.
+func (m *module) attachEntryPoint() error {
+	if ok, lastErr := m.callEntryPoint(dllProcessAttach); !ok {
+		unregisterAttached(m)
+		m.callEntryPoint(dllProcessDetach)
+		if m.tlsAttached {
+			m.callTLSCallbacks(dllProcessDetach)
+			m.tlsAttached = false
+		}
+		m.unload()
+		return &loader.InitializationFailedError{LastErr: lastErr}
+	}
+	m.entryAttached = true
+	registerAttached(m)
 	return nil
 }
 
+// callTLSCallbacks calls each TLS callback with the specified reason.
+func (m *module) callTLSCallbacks(reason uint64) {
+	for _, addr := range m.tlsCallbacks {
+		m.machine.MemProc(addr).Call(m.hinstance, reason, 0)
+	}
+}
+
+// callEntryPoint calls the module entrypoint with the specified reason, if
+// the module has one. It returns false if the entrypoint returned FALSE.
+func (m *module) callEntryPoint(reason uint64) (bool, error) {
+	rva := m.pemod.Header.OptionalHeader.AddressOfEntryPoint
+	if rva == 0 {
+		return true, nil
+	}
+	entry := m.machine.MemProc(m.memory.Addr() + uint64(rva))
+	r1, _, lastErr := entry.Call(m.hinstance, reason, 0)
+	return uint32(r1) != 0, lastErr
+}
+
 // Loader implements a memory loader for PE files.
 type Loader struct {
 	next      loader.Loader
 	machine   loader.Machine
 	pebhacks  bool
 	prochinst bool
+	notls     bool
+	noentry   bool
+
+	guardCheck    uint64
+	guardDispatch uint64
+
+	verify   func(data []byte) error
+	policies []Policy
+	strictwx bool
 }
 
 // Options contains the options for creating a new memory loader.
@@ -76,6 +256,42 @@ type Options struct {
 	// HintUseProcessHInstance specifies that the memory loader should use the
 	// host process's HINSTANCE value for calling into entrypoints.
 	HintUseProcessHInstance bool
+
+	// SkipTLSCallbacks specifies that the memory loader should not call the
+	// TLS callbacks of the module when loading it. They will instead be
+	// called by Module.Initialize.
+	SkipTLSCallbacks bool
+
+	// SkipEntryPoint specifies that the memory loader should not call the
+	// entrypoint of the module when loading it. It will instead be called by
+	// Module.Initialize.
+	SkipEntryPoint bool
+
+	// GuardCFCheckFunction specifies the address of the function to install
+	// as the Control Flow Guard check function of modules that are built with
+	// CFG. If zero, the no-op check function of the module is kept.
+	GuardCFCheckFunction uint64
+
+	// GuardCFDispatchFunction specifies the address of the function to
+	// install as the Control Flow Guard dispatch function of modules that are
+	// built with CFG. If zero, the no-op dispatch function of the module is
+	// kept.
+	GuardCFDispatchFunction uint64
+
+	// VerifyImage specifies a function that is called with the raw image data
+	// before anything is mapped. If it returns an error, the load fails with
+	// that error. It can be used to verify the signature of the image, e.g.
+	// with pe.VerifyAuthenticode.
+	VerifyImage func(data []byte) error
+
+	// Policies specifies policies that are checked before any code of the
+	// module or of its dependencies is executed. If any of them returns an
+	// error, the load fails.
+	Policies []Policy
+
+	// StrictWX specifies that modules with sections that would be mapped
+	// both writable and executable should be refused.
+	StrictWX bool
 }
 
 // New creates a new loader with the specified options.
@@ -83,6 +299,15 @@ func New(opts Options) loader.MemLoader {
 	return &Loader{
 		next:    opts.Next,
 		machine: opts.Machine,
+		notls:   opts.SkipTLSCallbacks,
+		noentry: opts.SkipEntryPoint,
+
+		guardCheck:    opts.GuardCFCheckFunction,
+		guardDispatch: opts.GuardCFDispatchFunction,
+
+		verify:   opts.VerifyImage,
+		policies: opts.Policies,
+		strictwx: opts.StrictWX,
 	}
 }
 
@@ -93,6 +318,12 @@ func (l *Loader) LoadMem(data []byte) (loader.Module, error) {
 		return nil, err
 	}
 
+	if l.verify != nil {
+		if err := l.verify(data); err != nil {
+			return nil, err
+		}
+	}
+
 	if !l.machine.IsArchitectureSupported(int(bin.Header.FileHeader.Machine)) {
 		return nil, fmt.Errorf("image architecture not %04x not supported by this machine", bin.Header.FileHeader.Machine)
 	}
@@ -104,7 +335,7 @@ func (l *Loader) LoadMem(data []byte) (loader.Module, error) {
 
 	// If the image is not movable, allocate it at its preferred address.
 	if bin.Header.OptionalHeader.DllCharacteristics&pe.ImageDLLCharacteristicsDynamicBase == 0 {
-		mem = l.machine.Alloc(bin.Header.OptionalHeader.ImageBase, imageSize, vmem.MemCommit|vmem.MemReserve, vmem.PageExecuteReadWrite)
+		mem = l.machine.Alloc(bin.Header.OptionalHeader.ImageBase, imageSize, vmem.MemCommit|vmem.MemReserve, vmem.PageReadWrite)
 		if mem == nil {
 			return nil, fmt.Errorf("image could not be mapped at preferred base 0x%08x and cannot be relocated", bin.Header.OptionalHeader.ImageBase)
 		}
@@ -117,7 +348,7 @@ func (l *Loader) LoadMem(data []byte) (loader.Module, error) {
 		if mem != nil {
 			failedAllocs = append(failedAllocs, mem)
 		}
-		if mem = l.machine.Alloc(0, imageSize, vmem.MemCommit|vmem.MemReserve, vmem.PageExecuteReadWrite); mem == nil {
+		if mem = l.machine.Alloc(0, imageSize, vmem.MemCommit|vmem.MemReserve, vmem.PageReadWrite); mem == nil {
 			return nil, fmt.Errorf("allocation of %d bytes failed", imageSize)
 		}
 	}
@@ -126,22 +357,10 @@ func (l *Loader) LoadMem(data []byte) (loader.Module, error) {
 	}
 
 	realBase := mem.Addr()
-	hdrsize := uint64(bin.Header.OptionalHeader.SizeOfHeaders)
-	vmem.Alloc(realBase, hdrsize, vmem.MemCommit, vmem.PageReadWrite).Write(data[0:hdrsize])
-
-	// Map sections into memory
-	for _, section := range bin.Sections {
-		addr := realBase + uint64(section.VirtualAddress)
-		if section.SizeOfRawData == 0 {
-			size := uint64(bin.Header.OptionalHeader.SectionAlignment)
-			if size != 0 {
-				vmem.Alloc(addr, size, vmem.MemCommit, vmem.PageReadWrite).Clear()
-			}
-		} else {
-			sectionData := data[section.PointerToRawData : section.PointerToRawData+section.SizeOfRawData]
-			vmem.Alloc(addr, uint64(section.SizeOfRawData), vmem.MemCommit, vmem.PageReadWrite).Write(sectionData)
-		}
-		// TODO: need to set Misc.PhysicalAddress?
+	// Map headers and sections into memory.
+	if err := mapImage(bin, data, mem); err != nil {
+		mem.Free()
+		return nil, err
 	}
 
 	// TODO: Detect native byte order for relocations.
@@ -149,48 +368,34 @@ func (l *Loader) LoadMem(data []byte) (loader.Module, error) {
 	machine := int(bin.Header.FileHeader.Machine)
 
 	// Perform relocations
-	relocs := pe.LoadBaseRelocs(bin, mem)
+	relocs, err := pe.LoadBaseRelocs(bin, mem)
+	if err != nil {
+		mem.Free()
+		return nil, err
+	}
 	if err := pe.Relocate(machine, relocs, uint64(realBase), bin.Header.OptionalHeader.ImageBase, mem, order); err != nil {
+		mem.Free()
 		return nil, err
 	}
 
-	// Perform runtime linking
-	if err := pe.LinkModule(bin, mem, l.next); err != nil {
+	// Check load policies. This must be done before linking, since loading
+	// the dependencies of the module runs their initialization code.
+	imports, err := pe.LoadImports(bin, mem)
+	if err != nil {
+		mem.Free()
+		return nil, err
+	}
+	if err := checkPolicies(l.policies, &ImageInfo{Module: bin, Data: data, Imports: imports}); err != nil {
+		mem.Free()
 		return nil, err
 	}
 
-	// Set access flags.
-	for _, section := range bin.Sections {
-		executable := section.Characteristics&pe.ImageSectionCharacteristicsMemoryExecute != 0
-		readable := section.Characteristics&pe.ImageSectionCharacteristicsMemoryRead != 0
-		writable := section.Characteristics&pe.ImageSectionCharacteristicsMemoryWrite != 0
-		protect := vmem.PageNoAccess
-		switch {
-		case !executable && !readable && !writable:
-			protect = vmem.PageNoAccess
-		case !executable && !readable && writable:
-			protect = vmem.PageWriteCopy
-		case !executable && readable && !writable:
-			protect = vmem.PageReadOnly
-		case !executable && readable && writable:
-			protect = vmem.PageReadWrite
-		case executable && !readable && !writable:
-			protect = vmem.PageExecute
-		case executable && !readable && writable:
-			protect = vmem.PageExecuteWriteCopy
-		case executable && readable && !writable:
-			protect = vmem.PageExecuteRead
-		case executable && readable && writable:
-			protect = vmem.PageExecuteReadWrite
-		}
-		size := uint64(section.SizeOfRawData)
-		if size == 0 {
-			size = uint64(bin.Header.OptionalHeader.SectionAlignment)
-		}
-		err := mem.Protect(uint64(section.VirtualAddress), size, protect)
-		if err != nil {
-			return nil, err
-		}
+	// Perform runtime linking
+	deps := &dependencyLoader{next: l.next}
+	if _, err := pe.LinkModule(bin, mem, deps); err != nil {
+		freeModules(deps.mods)
+		mem.Free()
+		return nil, err
 	}
 
 	// Handle HINSTANCE setup.
@@ -209,45 +414,67 @@ func (l *Loader) LoadMem(data []byte) (loader.Module, error) {
 		memory:    mem,
 		pemod:     bin,
 		hinstance: hinstance,
+		deps:      deps.mods,
 	}
 
-	// Execute TLS callbacks.
-	tlsdir := bin.Header.OptionalHeader.DataDirectory[pe.ImageDirectoryEntryTLS]
-	if tlsdir.Size > 0 {
-		mem.Seek(int64(tlsdir.VirtualAddress), io.SeekStart)
-		dir := pe.ImageTLSDirectory64{}
-		b := [8]byte{}
-		psize := 4
-		if bin.IsPE64 {
-			psize = 8
-			binary.Read(mem, binary.LittleEndian, &dir)
-		} else {
-			dir32 := pe.ImageTLSDirectory32{}
-			binary.Read(mem, binary.LittleEndian, &dir32)
-			dir = dir32.To64()
-		}
-		mem.Seek(int64(dir.AddressOfCallBacks-realBase), io.SeekStart)
-		if dir.AddressOfCallBacks != 0 {
-			for {
-				mem.Read(b[:psize])
-				addr := binary.LittleEndian.Uint64(b[:])
-				if addr == 0 {
-					break
-				}
-				cb := l.machine.MemProc(addr)
-				cb.Call(hinstance, 1, 0)
-			}
-		}
+	registerLoaded(m)
+
+	// Set up thread-local storage.
+	if err := m.loadTLS(); err != nil {
+		m.unload()
+		return nil, err
 	}
 
-	// Execute entrypoint for attach.
-	entry := l.machine.MemProc(realBase + uint64(bin.Header.OptionalHeader.AddressOfEntryPoint))
-	entry.Call(hinstance, 1, 0)
+	// Process the load configuration.
+	if err := m.loadConfig(l.guardCheck, l.guardDispatch); err != nil {
+		m.unload()
+		return nil, err
+	}
 
 	m.exports, err = pe.LoadExports(bin, mem, realBase)
 	if err != nil {
+		m.unload()
+		return nil, err
+	}
+
+	// Decode the debug directory. Malformed debug information does not
+	// prevent loading; the error is reported by DebugInfo instead.
+	m.debug, m.debugErr = pe.LoadDebugInfo(bin, mem)
+
+	// Register exception handling tables.
+	if err := m.addFunctionTable(); err != nil {
+		m.unload()
 		return nil, err
 	}
 
+	// Set access flags.
+	if err := m.protect(l.strictwx); err != nil {
+		m.unload()
+		return nil, err
+	}
+
+	// The TLS data and the process attach notifications must happen on the
+	// same thread. Once attached, the module may receive thread
+	// notifications from RunThread, which waits for the lock.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.attachTLSData(); err != nil {
+		m.unload()
+		return nil, err
+	}
+
+	// Execute TLS callbacks and entrypoint for attach, unless deferred.
+	if !l.notls {
+		m.attachTLS()
+	}
+	if !l.noentry {
+		if err := m.attachEntryPoint(); err != nil {
+			return nil, err
+		}
+	}
+
 	return m, nil
 }
@@ -0,0 +1,433 @@
+package memloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loader"
+	"github.com/jchv/go-winloader/internal/loadertest"
+	"github.com/jchv/go-winloader/internal/pe"
+	"github.com/jchv/go-winloader/internal/vmem"
+)
+
+const (
+	tinyBase  = 0x400000
+	tinyEntry = tinyBase + 0x1000
+
+	testBase = 0x10000000
+)
+
+// testSection describes a section of a test image.
+type testSection struct {
+	name            string
+	rva             uint32
+	virtualSize     uint32
+	data            []byte
+	characteristics uint32
+
+	// rawSize overrides SizeOfRawData, which is otherwise the size of data
+	// rounded up to the file alignment.
+	rawSize uint32
+}
+
+// makeImage builds a PE32 image for the i386 machine with a fixed image base
+// of testBase.
+func makeImage(entry uint32, dirs map[int]pe.ImageDataDirectory, sections []testSection) []byte {
+	const fileAlign = 0x200
+	const sectAlign = 0x1000
+
+	nt := pe.ImageNTHeaders32{Signature: pe.PESignature}
+	nt.FileHeader.Machine = pe.ImageFileMachinei386
+	nt.FileHeader.NumberOfSections = uint16(len(sections))
+	nt.FileHeader.SizeOfOptionalHeader = pe.SizeOfImageOptionalHeader32
+	nt.FileHeader.Characteristics = pe.ImageFileExecutableImage | pe.ImageFileDLL | pe.ImageFile32BitMachine
+	opt := &nt.OptionalHeader
+	opt.Magic = pe.ImageNTOptionalHeader32Magic
+	opt.AddressOfEntryPoint = entry
+	opt.ImageBase = testBase
+	opt.SectionAlignment = sectAlign
+	opt.FileAlignment = fileAlign
+	opt.MajorSubsystemVersion = 4
+	opt.Subsystem = pe.ImageSubsystemWindowsGUI
+	opt.NumberOfRvaAndSizes = pe.NumDirectoryEntries
+	for i, dir := range dirs {
+		opt.DataDirectory[i] = dir
+	}
+
+	headerSize := uint32(pe.SizeOfImageDOSHeader + pe.SizeOfImageNTHeaders32 + len(sections)*40)
+	opt.SizeOfHeaders = uint32(vmem.RoundUp(uint64(headerSize), fileAlign))
+	opt.SizeOfImage = sectAlign
+
+	headers := []pe.ImageSectionHeader{}
+	fileOffset := opt.SizeOfHeaders
+	for _, section := range sections {
+		hdr := pe.ImageSectionHeader{
+			PhysicalAddressOrVirtualSize: section.virtualSize,
+			VirtualAddress:               section.rva,
+			SizeOfRawData:                uint32(vmem.RoundUp(uint64(len(section.data)), fileAlign)),
+			Characteristics:              section.characteristics,
+		}
+		copy(hdr.Name[:], section.name)
+		fileSize := hdr.SizeOfRawData
+		if section.rawSize != 0 {
+			hdr.SizeOfRawData = section.rawSize
+		}
+		if len(section.data) > 0 {
+			hdr.PointerToRawData = fileOffset
+			fileOffset += fileSize
+		}
+		headers = append(headers, hdr)
+		size := section.virtualSize
+		if hdr.SizeOfRawData > size {
+			size = hdr.SizeOfRawData
+		}
+		if end := uint32(vmem.RoundUp(uint64(section.rva+size), sectAlign)); end > opt.SizeOfImage {
+			opt.SizeOfImage = end
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	dos := pe.ImageDOSHeader{Signature: pe.MZSignature, NewHeaderAddr: pe.SizeOfImageDOSHeader}
+	binary.Write(buf, binary.LittleEndian, dos)
+	binary.Write(buf, binary.LittleEndian, nt)
+	binary.Write(buf, binary.LittleEndian, headers)
+	buf.Write(make([]byte, opt.SizeOfHeaders-uint32(buf.Len())))
+	for _, section := range sections {
+		buf.Write(section.data)
+		buf.Write(make([]byte, vmem.RoundUp(uint64(len(section.data)), fileAlign)-uint64(len(section.data))))
+	}
+	return buf.Bytes()
+}
+
+func loadTiny(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func checkCalls(t *testing.T, actual []loadertest.Call, expected []loadertest.Call) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d calls, got %d: %v", len(expected), len(actual), actual)
+	}
+	for i := range expected {
+		if actual[i].Addr != expected[i].Addr {
+			t.Errorf("call %d: expected address %#x, got %#x", i, expected[i].Addr, actual[i].Addr)
+		}
+		if len(actual[i].Args) != len(expected[i].Args) {
+			t.Errorf("call %d: expected args %v, got %v", i, expected[i].Args, actual[i].Args)
+			continue
+		}
+		for j := range expected[i].Args {
+			if actual[i].Args[j] != expected[i].Args[j] {
+				t.Errorf("call %d: expected args %v, got %v", i, expected[i].Args, actual[i].Args)
+				break
+			}
+		}
+	}
+}
+
+func TestLoadEntryPointSucceeds(t *testing.T) {
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 1}}}
+	ldr := New(Options{Machine: machine})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	checkCalls(t, machine.Calls, []loadertest.Call{{Addr: tinyEntry, Args: []uint64{tinyBase, dllProcessAttach, 0}}})
+	if mod.Proc("Add") == nil {
+		t.Error("expected proc Add to be found")
+	}
+	if err := mod.Free(); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	checkCalls(t, machine.Calls, []loadertest.Call{
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllProcessAttach, 0}},
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllProcessDetach, 0}},
+	})
+	if !machine.Allocs[0].Freed() {
+		t.Error("expected image memory to be freed")
+	}
+}
+
+func TestLoadEntryPointFails(t *testing.T) {
+	errFailed := errors.New("failed")
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 0, LastErr: errFailed}}}
+	ldr := New(Options{Machine: machine})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if mod != nil {
+		t.Error("expected nil module")
+	}
+	initErr, ok := err.(*loader.InitializationFailedError)
+	if !ok {
+		t.Fatalf("expected InitializationFailedError got %v", err)
+	}
+	if initErr.LastErr != errFailed {
+		t.Errorf("expected LastErr %v got %v", errFailed, initErr.LastErr)
+	}
+	if !errors.Is(err, errFailed) {
+		t.Error("expected error to wrap LastErr")
+	}
+	checkCalls(t, machine.Calls, []loadertest.Call{
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllProcessAttach, 0}},
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllProcessDetach, 0}},
+	})
+	if !machine.Allocs[0].Freed() {
+		t.Error("expected image memory to be freed")
+	}
+}
+
+func TestLoadEntryPointReturnsHighBits(t *testing.T) {
+	// BOOL is 32 bits; garbage in the upper bits of the register must not be
+	// treated as TRUE.
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 0xFFFFFFFF00000000}}}
+	ldr := New(Options{Machine: machine})
+	if _, err := ldr.LoadMem(loadTiny(t)); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLoadDeferredInitialization(t *testing.T) {
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 1}}}
+	ldr := New(Options{Machine: machine, SkipTLSCallbacks: true, SkipEntryPoint: true})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	checkCalls(t, machine.Calls, nil)
+	if err := mod.Initialize(); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if err := mod.Initialize(); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	checkCalls(t, machine.Calls, []loadertest.Call{{Addr: tinyEntry, Args: []uint64{tinyBase, dllProcessAttach, 0}}})
+}
+
+func TestLoadDeferredInitializationFails(t *testing.T) {
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 0}}}
+	ldr := New(Options{Machine: machine, SkipEntryPoint: true})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	err = mod.Initialize()
+	if _, ok := err.(*loader.InitializationFailedError); !ok {
+		t.Fatalf("expected InitializationFailedError got %v", err)
+	}
+	if !machine.Allocs[0].Freed() {
+		t.Error("expected image memory to be freed")
+	}
+}
+
+// TestFreeAfterFailedInitialization checks that a module that was unloaded
+// because its deferred initialization failed is not detached or freed again.
+func TestFreeAfterFailedInitialization(t *testing.T) {
+	const callback, entry = testBase + 0x1100, testBase + 0x1180
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{entry: {R1: 0}}}
+	ldr := New(Options{Machine: machine, SkipTLSCallbacks: true, SkipEntryPoint: true})
+	mod, err := ldr.LoadMem(makeTLSImage(0x1180))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if _, ok := mod.Initialize().(*loader.InitializationFailedError); !ok {
+		t.Fatal("expected InitializationFailedError")
+	}
+	expected := []loadertest.Call{
+		{Addr: callback, Args: []uint64{testBase, dllProcessAttach, 0}},
+		{Addr: entry, Args: []uint64{testBase, dllProcessAttach, 0}},
+		{Addr: entry, Args: []uint64{testBase, dllProcessDetach, 0}},
+		{Addr: callback, Args: []uint64{testBase, dllProcessDetach, 0}},
+	}
+	checkCalls(t, machine.Calls, expected)
+
+	if err := mod.Free(); err != nil {
+		t.Errorf("expected nil error got %v", err)
+	}
+	if err := mod.Initialize(); err == nil {
+		t.Error("expected error initializing unloaded module")
+	}
+	checkCalls(t, machine.Calls, expected)
+	if frees := machine.Allocs[0].Frees; frees != 1 {
+		t.Errorf("expected image memory to be freed once, got %d", frees)
+	}
+}
+
+func TestFreeWithoutInitialization(t *testing.T) {
+	machine := &loadertest.Machine{}
+	ldr := New(Options{Machine: machine, SkipEntryPoint: true})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	mod.Free()
+	checkCalls(t, machine.Calls, nil)
+}
+
+func TestLoadVerifyImageFails(t *testing.T) {
+	errUnsigned := errors.New("unsigned")
+	machine := &loadertest.Machine{}
+	ldr := New(Options{Machine: machine, VerifyImage: func(data []byte) error { return errUnsigned }})
+	if _, err := ldr.LoadMem(loadTiny(t)); err != errUnsigned {
+		t.Fatalf("expected %v got %v", errUnsigned, err)
+	}
+	if len(machine.Allocs) != 0 || len(machine.Calls) != 0 {
+		t.Error("expected nothing to be mapped or called")
+	}
+}
+
+// buildImportImage builds an image that imports the specified symbols by
+// name, with an entrypoint at testBase+0x1000.
+func buildImportImage(t *testing.T, imports map[string][]string) []byte {
+	text := &pe.BuilderSection{
+		Name:            ".text",
+		Characteristics: pe.ImageSectionCharacteristicsContainsCode | pe.ImageSectionCharacteristicsMemoryExecute | pe.ImageSectionCharacteristicsMemoryRead,
+		Data:            []byte{0xC3},
+	}
+	b := &pe.Builder{
+		Machine:    pe.ImageFileMachinei386,
+		ImageBase:  testBase,
+		EntryPoint: &pe.Ref{Section: text},
+		Sections:   []*pe.BuilderSection{text},
+	}
+	dlls := []string{}
+	for dll := range imports {
+		dlls = append(dlls, dll)
+	}
+	sort.Strings(dlls)
+	for _, dll := range dlls {
+		imp := &pe.BuilderImport{DLL: dll}
+		for _, name := range imports[dll] {
+			imp.Symbols = append(imp.Symbols, &pe.BuilderImportSymbol{Name: name})
+		}
+		b.Imports = append(b.Imports, imp)
+	}
+	image, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+func TestLoadFreesDependencies(t *testing.T) {
+	libs := loadertest.Loader{"a.dll": {"A": 0x1234}, "b.dll": {"B": 0x5678}}
+	entry := uint64(testBase + 0x1000)
+
+	// Dependencies are freed with the module.
+	next := &loadertest.TrackingLoader{Next: libs}
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{entry: {R1: 1}}}
+	mod, err := New(Options{Next: next, Machine: machine}).LoadMem(buildImportImage(t, map[string][]string{"a.dll": {"A"}, "b.dll": {"B"}}))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(next.Freed) != 0 {
+		t.Errorf("expected no libraries to be freed got %v", next.Freed)
+	}
+	mod.Free()
+	if !reflect.DeepEqual(next.Freed, []string{"b.dll", "a.dll"}) {
+		t.Errorf("expected libraries to be freed in reverse order got %v", next.Freed)
+	}
+
+	// Modules from a cache are not freed, since it does not count references.
+	cached := &loadertest.TrackingLoader{Next: libs}
+	lib, _ := cached.Load("a.dll")
+	cache := NewCache(loadertest.Loader{})
+	cache.Add("a.dll", lib)
+	machine = &loadertest.Machine{Results: map[uint64]loadertest.Result{entry: {R1: 1}}}
+	mod, err = New(Options{Next: cache, Machine: machine}).LoadMem(buildImportImage(t, map[string][]string{"a": {"A"}}))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	mod.Free()
+	if len(cached.Freed) != 0 {
+		t.Errorf("expected cached library not to be freed got %v", cached.Freed)
+	}
+
+	// Dependencies are freed if a later import can not be resolved.
+	next = &loadertest.TrackingLoader{Next: libs}
+	machine = &loadertest.Machine{}
+	if _, err := New(Options{Next: next, Machine: machine}).LoadMem(buildImportImage(t, map[string][]string{"a.dll": {"A"}, "b.dll": {"Missing"}})); err == nil {
+		t.Fatal("expected error for unresolved import")
+	}
+	if !reflect.DeepEqual(next.Freed, []string{"b.dll", "a.dll"}) {
+		t.Errorf("expected loaded libraries to be freed got %v", next.Freed)
+	}
+	if !machine.Allocs[0].Freed() {
+		t.Error("expected image memory to be freed")
+	}
+
+	// Dependencies are freed if the entrypoint fails.
+	next = &loadertest.TrackingLoader{Next: libs}
+	machine = &loadertest.Machine{Results: map[uint64]loadertest.Result{entry: {R1: 0}}}
+	if _, err := New(Options{Next: next, Machine: machine}).LoadMem(buildImportImage(t, map[string][]string{"a.dll": {"A"}})); err == nil {
+		t.Fatal("expected error for failed entrypoint")
+	}
+	if !reflect.DeepEqual(next.Freed, []string{"a.dll"}) {
+		t.Errorf("expected a.dll to be freed got %v", next.Freed)
+	}
+}
+
+func TestLoadBuiltImage(t *testing.T) {
+	text := &pe.BuilderSection{
+		Name:            ".text",
+		Characteristics: pe.ImageSectionCharacteristicsContainsCode | pe.ImageSectionCharacteristicsMemoryExecute | pe.ImageSectionCharacteristicsMemoryRead,
+		Data:            make([]byte, 0x10),
+	}
+	byName := &pe.BuilderImportSymbol{Name: "Add"}
+	byOrdinal := &pe.BuilderImportSymbol{Ordinal: 3}
+	text.Fixups = []pe.Fixup{
+		{Offset: 0, Type: pe.FixupVA32, Target: pe.Ref{Import: byName}},
+		{Offset: 4, Type: pe.FixupVA32, Target: pe.Ref{Import: byOrdinal}},
+	}
+	b := &pe.Builder{
+		Machine:    pe.ImageFileMachinei386,
+		ImageBase:  testBase,
+		EntryPoint: &pe.Ref{Section: text, Offset: 8},
+		Sections:   []*pe.BuilderSection{text},
+		Imports:    []*pe.BuilderImport{{DLL: "lib.dll", Symbols: []*pe.BuilderImportSymbol{byName, byOrdinal}}},
+		Exports:    []*pe.BuilderExport{{Name: "Entry", Ordinal: 7, Target: pe.Ref{Section: text, Offset: 8}}},
+	}
+	image, err := b.Build()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+
+	entry := uint64(testBase + 0x1008)
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{entry: {R1: 1}}}
+	ldr := New(Options{
+		Next:    loadertest.Loader{"lib.dll": {"Add": 0x1234, uint64(3): 0x5678}},
+		Machine: machine,
+	})
+	mod, err := ldr.LoadMem(image)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	defer mod.Free()
+	checkCalls(t, machine.Calls, []loadertest.Call{{Addr: entry, Args: []uint64{testBase, dllProcessAttach, 0}}})
+	if proc := mod.Proc("Entry"); proc == nil || proc.Addr() != entry {
+		t.Errorf("expected Entry at %#x got %v", entry, proc)
+	}
+	if proc := mod.Ordinal(7); proc == nil || proc.Addr() != entry {
+		t.Errorf("expected ordinal 7 at %#x got %v", entry, proc)
+	}
+
+	// The code refers to the import address table, which holds the
+	// addresses of the imports.
+	mem := machine.Allocs[0]
+	b4 := [4]byte{}
+	for i, expected := range []uint32{0x1234, 0x5678} {
+		mem.ReadAt(b4[:], int64(i*4)+0x1000)
+		slot := binary.LittleEndian.Uint32(b4[:]) - testBase
+		mem.ReadAt(b4[:], int64(slot))
+		if actual := binary.LittleEndian.Uint32(b4[:]); actual != expected {
+			t.Errorf("import %d: expected %#x got %#x", i, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package memloader
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// ImageInfo contains information about an image that is being loaded, for
+// use by load policies.
+type ImageInfo struct {
+	// Module is the parsed image.
+	Module *pe.Module
+
+	// Data contains the raw image data.
+	Data []byte
+
+	// Imports contains the imports of the image. They have not been resolved
+	// yet, so their addresses are zero.
+	Imports []pe.Import
+}
+
+// Policy is a function that is called before any code of an image or of the
+// modules it depends on is executed. If it returns an error, the load is
+// vetoed and fails with that error. Policies are called before the imports
+// of the image are resolved, so no module it depends on has been loaded.
+type Policy func(info *ImageInfo) error
+
+// checkPolicies calls each policy in order, returning the first error.
+func checkPolicies(policies []Policy, info *ImageInfo) error {
+	for _, policy := range policies {
+		if err := policy(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RequireNXCompat is a policy that vetoes images that are not marked as
+// compatible with data execution prevention.
+func RequireNXCompat(info *ImageInfo) error {
+	if info.Module.Header.OptionalHeader.DllCharacteristics&pe.ImageDLLCharacteristicsNXCompat == 0 {
+		return fmt.Errorf("image is not NX compatible")
+	}
+	return nil
+}
+
+// DenyWritableExecutableSections is a policy that vetoes images that contain
+// sections that are both writable and executable.
+func DenyWritableExecutableSections(info *ImageInfo) error {
+	const wx = pe.ImageSectionCharacteristicsMemoryWrite | pe.ImageSectionCharacteristicsMemoryExecute
+	for _, section := range info.Module.Sections {
+		if section.Characteristics&wx == wx {
+			return fmt.Errorf("section %q is writable and executable", section.SectionName())
+		}
+	}
+	return nil
+}
+
+// DenyImports returns a policy that vetoes images that import any symbol
+// from the specified modules. Module names are compared case-insensitively.
+func DenyImports(modules ...string) Policy {
+	return func(info *ImageInfo) error {
+		for _, imp := range info.Imports {
+			for _, module := range modules {
+				if strings.EqualFold(imp.Module, module) {
+					return fmt.Errorf("import from module %q is not allowed", imp.Module)
+				}
+			}
+		}
+		return nil
+	}
+}
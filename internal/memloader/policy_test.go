@@ -0,0 +1,90 @@
+package memloader
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loadertest"
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+func TestLoadPolicyVeto(t *testing.T) {
+	errDenied := errors.New("denied")
+	data := loadTiny(t)
+	var info *ImageInfo
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 1}}}
+	ldr := New(Options{Machine: machine, Policies: []Policy{
+		func(i *ImageInfo) error {
+			info = i
+			return nil
+		},
+		func(*ImageInfo) error { return errDenied },
+	}})
+	if _, err := ldr.LoadMem(data); err != errDenied {
+		t.Fatalf("expected %v got %v", errDenied, err)
+	}
+	if info == nil || info.Module == nil || len(info.Data) != len(data) {
+		t.Fatalf("expected policy to receive image info, got %+v", info)
+	}
+	if len(machine.Calls) != 0 {
+		t.Errorf("expected no calls, got %v", machine.Calls)
+	}
+	if !machine.Allocs[0].Freed() {
+		t.Error("expected image memory to be freed")
+	}
+}
+
+// TestLoadPolicyBeforeImports checks that policies are called before the
+// dependencies of an image are loaded, which runs their code.
+func TestLoadPolicyBeforeImports(t *testing.T) {
+	next := &loadertest.TrackingLoader{Next: loadertest.Loader{"evil.dll": {"Run": 0x1234}}}
+	image := buildImportImage(t, map[string][]string{"evil.dll": {"Run"}})
+	var imports []pe.Import
+	ldr := New(Options{Next: next, Machine: &loadertest.Machine{}, Policies: []Policy{
+		func(i *ImageInfo) error {
+			imports = i.Imports
+			return nil
+		},
+		DenyImports("EVIL.dll"),
+	}})
+	if _, err := ldr.LoadMem(image); err == nil {
+		t.Fatal("expected DenyImports to veto image")
+	}
+	if len(next.Loaded) != 0 {
+		t.Errorf("expected no libraries to be loaded got %v", next.Loaded)
+	}
+	if len(imports) != 1 || imports[0].Module != "evil.dll" || imports[0].Name != "Run" {
+		t.Errorf("unexpected imports %+v", imports)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	m := &pe.Module{Sections: []pe.ImageSectionHeader{{
+		Name:            [8]byte{'.', 't', 'e', 'x', 't'},
+		Characteristics: pe.ImageSectionCharacteristicsMemoryExecute | pe.ImageSectionCharacteristicsMemoryRead,
+	}}}
+	info := &ImageInfo{Module: m, Imports: []pe.Import{{Module: "KERNEL32.dll", Name: "CreateProcessW"}}}
+
+	if err := RequireNXCompat(info); err == nil {
+		t.Error("expected RequireNXCompat to veto image without NX compat")
+	}
+	m.Header.OptionalHeader.DllCharacteristics = pe.ImageDLLCharacteristicsNXCompat
+	if err := RequireNXCompat(info); err != nil {
+		t.Errorf("expected nil error got %v", err)
+	}
+
+	if err := DenyWritableExecutableSections(info); err != nil {
+		t.Errorf("expected nil error got %v", err)
+	}
+	m.Sections[0].Characteristics |= pe.ImageSectionCharacteristicsMemoryWrite
+	if err := DenyWritableExecutableSections(info); err == nil {
+		t.Error("expected DenyWritableExecutableSections to veto RWX section")
+	}
+
+	if err := DenyImports("user32.dll")(info); err != nil {
+		t.Errorf("expected nil error got %v", err)
+	}
+	if err := DenyImports("kernel32.dll")(info); err == nil {
+		t.Error("expected DenyImports to veto import from kernel32.dll")
+	}
+}
@@ -0,0 +1,70 @@
+package memloader
+
+import (
+	"fmt"
+
+	"github.com/jchv/go-winloader/internal/pe"
+	"github.com/jchv/go-winloader/internal/vmem"
+)
+
+// protect sets the final memory protection of the module. The headers are
+// made read-only, each section is protected according to its
+// characteristics over its full virtual extent, and discardable sections are
+// decommitted. If strict is set, sections that would be both writable and
+// executable are refused. This must be done after everything that reads from
+// or writes to the image during loading.
+func (m *module) protect(strict bool) error {
+	opt := m.pemod.Header.OptionalHeader
+	pageSize := m.machine.GetPageSize()
+	imageSize := vmem.RoundUp(uint64(opt.SizeOfImage), pageSize)
+
+	// Images with a section alignment smaller than the page size can not be
+	// protected per section, so the whole image is left accessible.
+	if m.pemod.MappedFlat() {
+		if strict {
+			return fmt.Errorf("image with section alignment %#x can not be mapped without writable and executable memory", opt.SectionAlignment)
+		}
+		return m.memory.Protect(0, imageSize, vmem.PageExecuteReadWrite)
+	}
+
+	hdrsize := vmem.RoundUp(uint64(opt.SizeOfHeaders), pageSize)
+	if err := m.memory.Protect(0, hdrsize, vmem.PageReadOnly); err != nil {
+		return err
+	}
+
+	for _, section := range m.pemod.Sections {
+		size := uint64(section.PhysicalAddressOrVirtualSize)
+		if uint64(section.SizeOfRawData) > size {
+			size = uint64(section.SizeOfRawData)
+		}
+		if size == 0 {
+			continue
+		}
+		addr := uint64(section.VirtualAddress)
+		if addr >= imageSize {
+			return fmt.Errorf("section %q is outside of the image", section.SectionName())
+		}
+		size = vmem.RoundUp(size, uint64(opt.SectionAlignment))
+		if addr+size > imageSize {
+			size = imageSize - addr
+		}
+
+		if section.Characteristics&pe.ImageSectionCharacteristicsMemoryDiscardable != 0 {
+			if err := m.memory.Decommit(addr, size); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// NX compatibility is only enforced by the RequireNXCompat policy.
+		protect := vmem.SectionProtection(section.Characteristics)
+		if strict && protect == vmem.PageExecuteReadWrite {
+			return fmt.Errorf("section %q would be writable and executable", section.SectionName())
+		}
+		if err := m.memory.Protect(addr, size, protect); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
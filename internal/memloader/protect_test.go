@@ -0,0 +1,98 @@
+package memloader
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loadertest"
+	"github.com/jchv/go-winloader/internal/pe"
+	"github.com/jchv/go-winloader/internal/vmem"
+)
+
+const (
+	sectionRX = pe.ImageSectionCharacteristicsMemoryRead | pe.ImageSectionCharacteristicsMemoryExecute
+	sectionRW = pe.ImageSectionCharacteristicsMemoryRead | pe.ImageSectionCharacteristicsMemoryWrite
+)
+
+func makeProtectImage(dllCharacteristics uint16, extra ...testSection) []byte {
+	sections := append([]testSection{
+		{name: ".text", rva: 0x1000, virtualSize: 0x10, data: []byte{0xC3}, characteristics: sectionRX},
+		{name: ".data", rva: 0x2000, virtualSize: 0x1800, data: []byte{1}, characteristics: sectionRW},
+		{name: ".wo", rva: 0x4000, virtualSize: 0x10, data: []byte{1}, characteristics: pe.ImageSectionCharacteristicsMemoryWrite},
+		{name: ".reloc", rva: 0x5000, virtualSize: 0x10, data: []byte{1}, characteristics: pe.ImageSectionCharacteristicsMemoryRead | pe.ImageSectionCharacteristicsMemoryDiscardable},
+	}, extra...)
+	image := makeImage(0, nil, sections)
+	binary.LittleEndian.PutUint16(image[offsetOfDllCharacteristics32:], dllCharacteristics)
+	return image
+}
+
+func TestLoadProtection(t *testing.T) {
+	machine := &loadertest.Machine{}
+	ldr := New(Options{Machine: machine, StrictWX: true})
+	if _, err := ldr.LoadMem(makeProtectImage(pe.ImageDLLCharacteristicsNXCompat)); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	mem := machine.Allocs[0]
+
+	tests := []struct {
+		addr     uint64
+		expected int
+	}{
+		{0x0000, vmem.PageReadOnly},
+		{0x1000, vmem.PageExecuteRead},
+		{0x2000, vmem.PageReadWrite},
+		{0x3000, vmem.PageReadWrite},
+		{0x4000, vmem.PageReadWrite},
+		{0x5000, 0},
+	}
+	for _, test := range tests {
+		if actual := mem.Protection(mem.Addr() + test.addr); actual != test.expected {
+			t.Errorf("page %#x: expected protection %#x got %#x", test.addr, test.expected, actual)
+		}
+	}
+}
+
+func TestLoadProtectionNotNXCompat(t *testing.T) {
+	machine := &loadertest.Machine{}
+	ldr := New(Options{Machine: machine})
+	if _, err := ldr.LoadMem(makeProtectImage(0)); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	mem := machine.Allocs[0]
+	if actual := mem.Protection(mem.Addr() + 0x2000); actual != vmem.PageReadWrite {
+		t.Errorf("expected data section to stay non-executable, got protection %#x", actual)
+	}
+
+	// Strict mode only depends on the section characteristics.
+	machine = &loadertest.Machine{}
+	ldr = New(Options{Machine: machine, StrictWX: true})
+	if _, err := ldr.LoadMem(makeProtectImage(0)); err != nil {
+		t.Errorf("expected nil error got %v", err)
+	}
+	ldr = New(Options{Machine: &loadertest.Machine{}, Policies: []Policy{RequireNXCompat}})
+	if _, err := ldr.LoadMem(makeProtectImage(0)); err == nil {
+		t.Error("expected RequireNXCompat to refuse image that is not NX compatible")
+	}
+}
+
+func TestLoadProtectionStrict(t *testing.T) {
+	rwx := testSection{name: ".rwx", rva: 0x6000, virtualSize: 0x10, data: []byte{0xC3}, characteristics: sectionRX | sectionRW}
+
+	machine := &loadertest.Machine{}
+	ldr := New(Options{Machine: machine, StrictWX: true})
+	if _, err := ldr.LoadMem(makeProtectImage(pe.ImageDLLCharacteristicsNXCompat, rwx)); err == nil {
+		t.Fatal("expected strict mode to refuse RWX section")
+	}
+	if !machine.Allocs[0].Freed() {
+		t.Error("expected image memory to be freed")
+	}
+
+	machine = &loadertest.Machine{}
+	ldr = New(Options{Machine: machine})
+	if _, err := ldr.LoadMem(makeProtectImage(pe.ImageDLLCharacteristicsNXCompat, rwx)); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if actual := machine.Allocs[0].Protection(machine.Allocs[0].Addr() + 0x6000); actual != vmem.PageExecuteReadWrite {
+		t.Errorf("expected RWX section, got protection %#x", actual)
+	}
+}
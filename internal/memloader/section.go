@@ -0,0 +1,81 @@
+package memloader
+
+import (
+	"fmt"
+
+	"github.com/jchv/go-winloader/internal/loader"
+	"github.com/jchv/go-winloader/internal/pe"
+	"github.com/jchv/go-winloader/internal/vmem"
+)
+
+// minRawDataAlignment is the alignment that Windows rounds PointerToRawData
+// down to, for images with a file alignment of at least this value.
+const minRawDataAlignment = 0x200
+
+// mapImage copies the headers and sections of an image from its raw file data
+// into memory, following the rules of the Windows image loader. The memory
+// must be zero-filled, as it is after allocation, so that uninitialized data
+// is left as zero.
+func mapImage(bin *pe.Module, data []byte, mem loader.Memory) error {
+	opt := bin.Header.OptionalHeader
+	imageSize := uint64(opt.SizeOfImage)
+
+	// Images with a section alignment smaller than the page size are mapped
+	// flat, with each section at the same offset as in the file.
+	if bin.MappedFlat() {
+		if opt.FileAlignment != opt.SectionAlignment {
+			return fmt.Errorf("image with section alignment %#x has mismatched file alignment %#x", opt.SectionAlignment, opt.FileAlignment)
+		}
+		for _, section := range bin.Sections {
+			if section.VirtualAddress != section.PointerToRawData {
+				return fmt.Errorf("section %q of image with section alignment %#x is not mapped flat", section.SectionName(), opt.SectionAlignment)
+			}
+		}
+		size := uint64(len(data))
+		if size > imageSize {
+			size = imageSize
+		}
+		_, err := mem.WriteAt(data[:size], 0)
+		return err
+	}
+
+	hdrsize := uint64(opt.SizeOfHeaders)
+	if hdrsize > uint64(len(data)) {
+		hdrsize = uint64(len(data))
+	}
+	if _, err := mem.WriteAt(data[:hdrsize], 0); err != nil {
+		return err
+	}
+
+	for _, section := range bin.Sections {
+		virtualSize := uint64(section.PhysicalAddressOrVirtualSize)
+		rawSize := vmem.RoundUp(uint64(section.SizeOfRawData), uint64(opt.FileAlignment))
+		if virtualSize == 0 {
+			virtualSize = rawSize
+		}
+		if uint64(section.VirtualAddress)+virtualSize > imageSize {
+			return fmt.Errorf("section %q extends past the end of the image", section.SectionName())
+		}
+
+		// Raw data beyond the virtual size is not mapped, and raw data beyond
+		// the end of the file is treated as uninitialized.
+		if rawSize > virtualSize {
+			rawSize = virtualSize
+		}
+		offset := uint64(section.PointerToRawData)
+		if opt.FileAlignment >= minRawDataAlignment {
+			offset = vmem.RoundDown(offset, minRawDataAlignment)
+		}
+		if rawSize == 0 || offset >= uint64(len(data)) {
+			continue
+		}
+		if offset+rawSize > uint64(len(data)) {
+			rawSize = uint64(len(data)) - offset
+		}
+		if _, err := mem.WriteAt(data[offset:offset+rawSize], int64(section.VirtualAddress)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
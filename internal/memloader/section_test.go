@@ -0,0 +1,114 @@
+package memloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loadertest"
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// offsetOfOptionalHeader32 is the file offset of the optional header in
+// images built by makeImage.
+const offsetOfOptionalHeader32 = pe.SizeOfImageDOSHeader + 4 + pe.SizeOfImageFileHeader
+
+func fill(b byte, n int) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+func TestMapSections(t *testing.T) {
+	image := makeImage(0, nil, []testSection{
+		// BSS tail: the virtual size is larger than the raw data.
+		{name: ".bss1", rva: 0x1000, virtualSize: 0x1800, data: fill(0xAA, 0x10), characteristics: sectionRW},
+		// Uninitialized data only.
+		{name: ".bss2", rva: 0x3000, virtualSize: 0x3000, characteristics: sectionRW},
+		// Raw data larger than the virtual size.
+		{name: ".short", rva: 0x6000, virtualSize: 0x10, data: fill(0xBB, 0x200), characteristics: sectionRW},
+		// SizeOfRawData not aligned to the file alignment.
+		{name: ".unalign", rva: 0x7000, virtualSize: 0x100, data: fill(0xCC, 0x100), characteristics: sectionRW, rawSize: 0x10},
+		// No virtual size.
+		{name: ".novsize", rva: 0x8000, data: fill(0xDD, 0x200), characteristics: sectionRW},
+	})
+
+	machine := &loadertest.Machine{}
+	if _, err := New(Options{Machine: machine}).LoadMem(image); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	mem := machine.Allocs[0].Data
+
+	tests := []struct {
+		name     string
+		start    int
+		expected []byte
+	}{
+		{"headers", 0, image[:0x200]},
+		{".bss1", 0x1000, append(fill(0xAA, 0x10), make([]byte, 0x17F0)...)},
+		{".bss2", 0x3000, make([]byte, 0x3000)},
+		{".short", 0x6000, append(fill(0xBB, 0x10), make([]byte, 0x1F0)...)},
+		{".unalign", 0x7000, append(fill(0xCC, 0x100), make([]byte, 0x100)...)},
+		{".novsize", 0x8000, fill(0xDD, 0x200)},
+	}
+	for _, test := range tests {
+		actual := mem[test.start : test.start+len(test.expected)]
+		if !bytes.Equal(actual, test.expected) {
+			t.Errorf("%s: unexpected contents", test.name)
+		}
+	}
+}
+
+func TestMapLowAlignment(t *testing.T) {
+	image := makeImage(0, nil, []testSection{
+		{name: ".text", rva: 0x200, virtualSize: 0x10, data: fill(0xC3, 0x10), characteristics: sectionRX},
+		{name: ".data", rva: 0x400, virtualSize: 0x10, data: fill(0xAA, 0x10), characteristics: sectionRW},
+	})
+	binary.LittleEndian.PutUint32(image[offsetOfOptionalHeader32+32:], 0x200)
+	binary.LittleEndian.PutUint32(image[offsetOfOptionalHeader32+56:], 0x600)
+
+	machine := &loadertest.Machine{}
+	if _, err := New(Options{Machine: machine}).LoadMem(image); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if mem := machine.Allocs[0].Data; !bytes.Equal(mem[:len(image)], image) {
+		t.Error("expected image to be mapped flat")
+	}
+
+	// Low alignment images require writable and executable memory.
+	machine = &loadertest.Machine{}
+	if _, err := New(Options{Machine: machine, StrictWX: true}).LoadMem(image); err == nil {
+		t.Error("expected strict mode to refuse low alignment image")
+	}
+
+	// The file alignment must match the section alignment.
+	binary.LittleEndian.PutUint32(image[offsetOfOptionalHeader32+36:], 0x100)
+	machine = &loadertest.Machine{}
+	if _, err := New(Options{Machine: machine}).LoadMem(image); err == nil {
+		t.Error("expected error for mismatched file alignment")
+	}
+}
+
+// TestMapBuiltLowAlignment checks that a low alignment image from pe.Builder
+// with empty sections is mapped flat.
+func TestMapBuiltLowAlignment(t *testing.T) {
+	b := &pe.Builder{
+		Machine:          pe.ImageFileMachinei386,
+		SectionAlignment: 0x200,
+		FileAlignment:    0x200,
+		Sections: []*pe.BuilderSection{
+			{Name: ".empty"},
+			{Name: ".text", Characteristics: pe.ImageSectionCharacteristicsContainsCode, Data: []byte{0xC3}},
+		},
+	}
+	image, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	machine := &loadertest.Machine{}
+	if _, err := New(Options{Machine: machine}).LoadMem(image); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if mem := machine.Allocs[0].Data; !bytes.Equal(mem[:len(image)], image) {
+		t.Error("expected image to be mapped flat")
+	}
+}
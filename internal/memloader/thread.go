@@ -0,0 +1,149 @@
+package memloader
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/jchv/go-winloader/internal/loader"
+)
+
+// Reason values passed to DLL entrypoints and TLS callbacks for threads.
+const (
+	dllThreadAttach = 2
+	dllThreadDetach = 3
+)
+
+var (
+	// attachedMu guards attached and the threadCalls flag of modules.
+	attachedMu sync.Mutex
+
+	// attached contains the modules that have been attached to the process,
+	// in load order. These receive thread attach and detach notifications.
+	attached []*module
+)
+
+// registerAttached adds a module to the list of attached modules, if it is
+// not already present.
+func registerAttached(m *module) {
+	attachedMu.Lock()
+	defer attachedMu.Unlock()
+	for _, i := range attached {
+		if i == m {
+			return
+		}
+	}
+	attached = append(attached, m)
+}
+
+// unregisterAttached removes a module from the list of attached modules.
+func unregisterAttached(m *module) {
+	attachedMu.Lock()
+	defer attachedMu.Unlock()
+	for n, i := range attached {
+		if i == m {
+			attached = append(attached[:n:n], attached[n+1:]...)
+			return
+		}
+	}
+}
+
+// threadNotify sends a thread attach or detach notification to a module. The
+// lock of the module must be held.
+func (m *module) threadNotify(reason uint64) {
+	if m.tlsAttached {
+		m.callTLSCallbacks(reason)
+	}
+	if m.entryAttached {
+		m.callEntryPoint(reason)
+	}
+}
+
+// RunThread runs fn on a locked OS thread. Before fn is called, each memory
+// module attached to the process has its static TLS data set up for the
+// thread and receives DLL_THREAD_ATTACH in load order, via its TLS callbacks
+// and entrypoint. After fn returns, the modules that have not been freed in
+// the meantime receive DLL_THREAD_DETACH in reverse order and their TLS data
+// is freed. RunThread blocks until fn returns. If fn panics, the panic is
+// raised again on the calling goroutine, after the modules are detached.
+//
+// The OS thread is not unlocked when fn returns, so it is terminated instead
+// of being reused for goroutines that have not been attached.
+func RunThread(fn func()) {
+	var (
+		panicked bool
+		perr     interface{}
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+
+		attachedMu.Lock()
+		mods := append([]*module{}, attached...)
+		notify := make([]bool, len(mods))
+		for i, m := range mods {
+			notify[i] = !m.noThreadCalls
+		}
+		attachedMu.Unlock()
+
+		// The lock of each module is held across its notifications, so that
+		// it can not be freed meanwhile. Modules freed before the thread
+		// attaches are skipped.
+		live := make([]bool, len(mods))
+		for i, m := range mods {
+			m.mu.Lock()
+			if !m.unloaded {
+				live[i] = true
+				m.attachTLSData()
+				if notify[i] {
+					m.threadNotify(dllThreadAttach)
+				}
+			}
+			m.mu.Unlock()
+		}
+		defer func() {
+			for i := len(mods) - 1; i >= 0; i-- {
+				m := mods[i]
+				m.mu.Lock()
+				if live[i] && !m.unloaded {
+					if notify[i] {
+						m.threadNotify(dllThreadDetach)
+					}
+					m.detachTLSData()
+				}
+				m.mu.Unlock()
+			}
+		}()
+
+		defer func() {
+			if panicked {
+				perr = recover()
+			}
+		}()
+		panicked = true
+		fn()
+		panicked = false
+	}()
+	<-done
+	if panicked {
+		panic(perr)
+	}
+}
+
+// DisableThreadLibraryCalls disables DLL_THREAD_ATTACH and DLL_THREAD_DETACH
+// notifications for a memory module, like the Windows function of the same
+// name. Like on Windows, this fails for modules that use static TLS.
+func DisableThreadLibraryCalls(mod loader.Module) error {
+	m, ok := mod.(*module)
+	if !ok {
+		return errors.New("module was not loaded by the memory loader")
+	}
+	if m.tls != nil {
+		return errors.New("module uses static thread-local storage")
+	}
+	attachedMu.Lock()
+	defer attachedMu.Unlock()
+	m.noThreadCalls = true
+	return nil
+}
@@ -0,0 +1,98 @@
+package memloader
+
+import (
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loadertest"
+)
+
+func TestRunThread(t *testing.T) {
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 1}}}
+	ldr := New(Options{Machine: machine})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	defer mod.Free()
+
+	var during []loadertest.Call
+	RunThread(func() {
+		during = append(during, machine.Calls[1:]...)
+	})
+	checkCalls(t, during, []loadertest.Call{{Addr: tinyEntry, Args: []uint64{tinyBase, dllThreadAttach, 0}}})
+	checkCalls(t, machine.Calls[1:], []loadertest.Call{
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllThreadAttach, 0}},
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllThreadDetach, 0}},
+	})
+}
+
+func TestRunThreadDisableThreadLibraryCalls(t *testing.T) {
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 1}}}
+	ldr := New(Options{Machine: machine})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	defer mod.Free()
+
+	if err := DisableThreadLibraryCalls(mod); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	RunThread(func() {})
+	checkCalls(t, machine.Calls[1:], nil)
+}
+
+func TestRunThreadUninitialized(t *testing.T) {
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 1}}}
+	ldr := New(Options{Machine: machine, SkipTLSCallbacks: true, SkipEntryPoint: true})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	defer mod.Free()
+
+	RunThread(func() {})
+	checkCalls(t, machine.Calls, nil)
+}
+
+func TestRunThreadFreed(t *testing.T) {
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 1}}}
+	ldr := New(Options{Machine: machine})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+
+	RunThread(func() {
+		mod.Free()
+	})
+	checkCalls(t, machine.Calls[1:], []loadertest.Call{
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllThreadAttach, 0}},
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllProcessDetach, 0}},
+	})
+}
+
+func TestRunThreadPanic(t *testing.T) {
+	machine := &loadertest.Machine{Results: map[uint64]loadertest.Result{tinyEntry: {R1: 1}}}
+	ldr := New(Options{Machine: machine})
+	mod, err := ldr.LoadMem(loadTiny(t))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	defer mod.Free()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expected panic %q got %v", "boom", r)
+			}
+		}()
+		RunThread(func() {
+			panic("boom")
+		})
+	}()
+	checkCalls(t, machine.Calls[1:], []loadertest.Call{
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllThreadAttach, 0}},
+		{Addr: tinyEntry, Args: []uint64{tinyBase, dllThreadDetach, 0}},
+	})
+}
@@ -0,0 +1,126 @@
+package memloader
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// errInvalidTLSDirectory is returned when the TLS directory is malformed.
+var errInvalidTLSDirectory = errors.New("invalid TLS directory")
+
+// tlsInfo contains the thread-local storage state of a module.
+type tlsInfo struct {
+	// index is the static TLS index allocated for the module.
+	index uint32
+
+	// template contains the initial contents of the TLS data for each
+	// thread, including the zero fill.
+	template []byte
+}
+
+// loadTLS loads the TLS directory of the module. It collects the TLS
+// callbacks, allocates a static TLS index, writes it into the module and
+// captures the TLS data template. This must be done after relocation, but
+// before the memory protection is set.
+func (m *module) loadTLS() error {
+	tlsdir := m.pemod.Header.OptionalHeader.DataDirectory[pe.ImageDirectoryEntryTLS]
+	if tlsdir.Size == 0 {
+		return nil
+	}
+
+	mem := m.memory
+	realBase := mem.Addr()
+
+	mem.Seek(int64(tlsdir.VirtualAddress), io.SeekStart)
+	dir := pe.ImageTLSDirectory64{}
+	b := [8]byte{}
+	psize := 4
+	if m.pemod.IsPE64 {
+		psize = 8
+		if err := binary.Read(mem, binary.LittleEndian, &dir); err != nil {
+			return err
+		}
+	} else {
+		dir32 := pe.ImageTLSDirectory32{}
+		if err := binary.Read(mem, binary.LittleEndian, &dir32); err != nil {
+			return err
+		}
+		dir = dir32.To64()
+	}
+
+	// Load TLS callbacks.
+	if dir.AddressOfCallBacks != 0 {
+		off := int64(dir.AddressOfCallBacks - realBase)
+		for {
+			if _, err := mem.ReadAt(b[:psize], off); err != nil {
+				return err
+			}
+			addr := binary.LittleEndian.Uint64(b[:])
+			if addr == 0 {
+				break
+			}
+			m.tlsCallbacks = append(m.tlsCallbacks, addr)
+			off += int64(psize)
+		}
+	}
+
+	// Capture the TLS data template. The data must be inside the image.
+	imageSize := uint64(m.pemod.Header.OptionalHeader.SizeOfImage)
+	if (dir.EndAddressOfRawData > dir.StartAddressOfRawData && dir.EndAddressOfRawData-dir.StartAddressOfRawData > imageSize) || uint64(dir.SizeOfZeroFill) > imageSize {
+		return errInvalidTLSDirectory
+	}
+	tls := &tlsInfo{}
+	if dir.EndAddressOfRawData > dir.StartAddressOfRawData {
+		tls.template = make([]byte, dir.EndAddressOfRawData-dir.StartAddressOfRawData)
+		if _, err := mem.ReadAt(tls.template, int64(dir.StartAddressOfRawData-realBase)); err != nil {
+			return err
+		}
+	}
+	tls.template = append(tls.template, make([]byte, dir.SizeOfZeroFill)...)
+
+	// Allocate the TLS index and write it into the module.
+	index, err := m.machine.AllocTLSIndex()
+	if err != nil {
+		return err
+	}
+	tls.index = index
+	if dir.AddressOfIndex != 0 {
+		binary.LittleEndian.PutUint32(b[:4], index)
+		if _, err := mem.WriteAt(b[:4], int64(dir.AddressOfIndex-realBase)); err != nil {
+			m.machine.FreeTLSIndex(index)
+			return err
+		}
+	}
+	m.tls = tls
+
+	return nil
+}
+
+// attachTLSData sets up the TLS data of the module for the current thread.
+func (m *module) attachTLSData() error {
+	if m.tls == nil {
+		return nil
+	}
+	return m.machine.SetTLSData(m.tls.index, m.tls.template)
+}
+
+// detachTLSData frees the TLS data of the module for the current thread.
+func (m *module) detachTLSData() {
+	if m.tls == nil {
+		return
+	}
+	m.machine.FreeTLSData(m.tls.index)
+}
+
+// freeTLS frees the TLS data for the current thread and the TLS index.
+func (m *module) freeTLS() {
+	if m.tls == nil {
+		return
+	}
+	m.machine.FreeTLSData(m.tls.index)
+	m.machine.FreeTLSIndex(m.tls.index)
+	m.tls = nil
+}
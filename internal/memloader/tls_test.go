@@ -0,0 +1,109 @@
+package memloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loadertest"
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+func makeTLSImage(entry uint32) []byte {
+	data := make([]byte, 0x200)
+	copy(data[0x00:], "TLSDATA!")
+	binary.LittleEndian.PutUint32(data[0x10:], 0xFFFFFFFF)
+	dir := &bytes.Buffer{}
+	binary.Write(dir, binary.LittleEndian, pe.ImageTLSDirectory32{
+		StartAddressOfRawData: testBase + 0x1000,
+		EndAddressOfRawData:   testBase + 0x1008,
+		AddressOfIndex:        testBase + 0x1010,
+		AddressOfCallBacks:    testBase + 0x1040,
+		SizeOfZeroFill:        8,
+	})
+	copy(data[0x20:], dir.Bytes())
+	binary.LittleEndian.PutUint32(data[0x40:], testBase+0x1100)
+	return makeImage(entry, map[int]pe.ImageDataDirectory{
+		pe.ImageDirectoryEntryTLS: {VirtualAddress: 0x1020, Size: 24},
+	}, []testSection{{
+		name:            ".tls",
+		rva:             0x1000,
+		virtualSize:     0x200,
+		data:            data,
+		characteristics: pe.ImageSectionCharacteristicsMemoryRead | pe.ImageSectionCharacteristicsMemoryWrite,
+	}})
+}
+
+func TestLoadTLS(t *testing.T) {
+	machine := &loadertest.Machine{}
+	ldr := New(Options{Machine: machine})
+	mod, err := ldr.LoadMem(makeTLSImage(0))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+
+	if len(machine.TLSIndices) != 1 {
+		t.Fatalf("expected 1 TLS index, got %d", len(machine.TLSIndices))
+	}
+	index := machine.TLSIndices[0]
+
+	b := [4]byte{}
+	machine.Allocs[0].ReadAt(b[:], 0x1010)
+	if actual := binary.LittleEndian.Uint32(b[:]); actual != index {
+		t.Errorf("expected TLS index %d to be written, got %d", index, actual)
+	}
+
+	expected := []byte("TLSDATA!\x00\x00\x00\x00\x00\x00\x00\x00")
+	if !bytes.Equal(machine.TLSData[index], expected) {
+		t.Errorf("expected TLS data %q, got %q", expected, machine.TLSData[index])
+	}
+	checkCalls(t, machine.Calls, []loadertest.Call{{Addr: testBase + 0x1100, Args: []uint64{testBase, dllProcessAttach, 0}}})
+
+	if err := DisableThreadLibraryCalls(mod); err == nil {
+		t.Error("expected DisableThreadLibraryCalls to fail for module with static TLS")
+	}
+
+	machine.TLSEvents = nil
+	RunThread(func() {})
+	checkCalls(t, machine.Calls[1:], []loadertest.Call{
+		{Addr: testBase + 0x1100, Args: []uint64{testBase, dllThreadAttach, 0}},
+		{Addr: testBase + 0x1100, Args: []uint64{testBase, dllThreadDetach, 0}},
+	})
+
+	mod.Free()
+	expectedEvents := []string{"set data 1", "free data 1", "free index 1"}
+	if len(machine.TLSEvents) != len(expectedEvents) {
+		t.Fatalf("expected TLS events %v, got %v", expectedEvents, machine.TLSEvents)
+	}
+	for i := range expectedEvents {
+		if machine.TLSEvents[i] != expectedEvents[i] {
+			t.Fatalf("expected TLS events %v, got %v", expectedEvents, machine.TLSEvents)
+		}
+	}
+}
+
+func TestInitializeTLSData(t *testing.T) {
+	machine := &loadertest.Machine{}
+	ldr := New(Options{Machine: machine, SkipTLSCallbacks: true, SkipEntryPoint: true})
+	mod, err := ldr.LoadMem(makeTLSImage(0))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	defer mod.Free()
+
+	machine.TLSEvents = nil
+	if err := mod.Initialize(); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(machine.TLSEvents) != 1 || machine.TLSEvents[0] != "set data 1" {
+		t.Errorf("expected TLS data to be set on initialization, got %v", machine.TLSEvents)
+	}
+
+	machine.TLSEvents = nil
+	if err := mod.Initialize(); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(machine.TLSEvents) != 0 {
+		t.Errorf("expected no TLS events once initialized, got %v", machine.TLSEvents)
+	}
+}
@@ -0,0 +1,480 @@
+// Package objloader implements a loader for COFF object files. Object files
+// are linked in memory: their sections are mapped, their relocations are
+// applied, and their external symbols are resolved against a symbol map and
+// a set of libraries.
+package objloader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jchv/go-winloader/internal/loader"
+	"github.com/jchv/go-winloader/internal/pe"
+	"github.com/jchv/go-winloader/internal/vmem"
+)
+
+// importPrefix is the prefix of symbols that refer to the import address
+// table entry of a symbol rather than to the symbol itself.
+const importPrefix = "__imp_"
+
+// stubSize is the size of an external symbol stub. Each stub consists of an
+// 8-byte pointer to the symbol, followed by code that jumps to it.
+const stubSize = 16
+
+// commonAlignment is the alignment of common symbols.
+const commonAlignment = 16
+
+// maxImageSize is the maximum size of a loaded object. It keeps every
+// address in the object within reach of 32-bit relative relocations.
+const maxImageSize = 1 << 31
+
+// module implements a module for the object loader.
+type module struct {
+	machine loader.Machine
+	memory  loader.Memory
+	i386    bool
+	symbols map[string]uint64
+
+	// libs contains the libraries loaded to resolve symbols, in load order.
+	// They are freed along with the module.
+	libs []loader.Module
+}
+
+// Proc implements loader.Module. On i386, names are also looked up with the
+// leading underscore of the C calling convention.
+func (m *module) Proc(name string) loader.Proc {
+	addr, ok := m.symbols[name]
+	if !ok && m.i386 {
+		addr, ok = m.symbols["_"+name]
+	}
+	if !ok {
+		return nil
+	}
+	return m.machine.MemProc(addr)
+}
+
+// Ordinal implements loader.Module. Object files have no ordinals, so it
+// always returns nil.
+func (m *module) Ordinal(ordinal uint64) loader.Proc {
+	return nil
+}
+
+// Initialize implements loader.Module. Object files have no entrypoint, so
+// it does nothing.
+func (m *module) Initialize() error {
+	return nil
+}
+
+// Free implements loader.Module
+func (m *module) Free() error {
+	m.memory.Free()
+	freeModules(m.libs)
+	m.libs = nil
+	return nil
+}
+
+// freeModules frees modules in reverse load order.
+func freeModules(mods []loader.Module) {
+	for i := len(mods) - 1; i >= 0; i-- {
+		mods[i].Free()
+	}
+}
+
+// Loader implements a memory loader for COFF object files.
+type Loader struct {
+	next      loader.Loader
+	machine   loader.Machine
+	libraries []string
+	symbols   map[string]uint64
+}
+
+// Options contains the options for creating a new object loader.
+type Options struct {
+	// Next specifies the loader to use to load Libraries.
+	Next loader.Loader
+
+	// Machine specifies the machine the object should be loaded into.
+	Machine loader.Machine
+
+	// Libraries specifies the names of the libraries that undefined symbols
+	// are looked up in, in order, if they are not found in Symbols. On
+	// i386, the name decoration of the C and stdcall calling conventions is
+	// removed before looking up symbols in libraries. The libraries are
+	// loaded when a symbol is first looked up in them, which fails if any of
+	// them can not be loaded, and are freed along with the module.
+	Libraries []string
+
+	// Symbols specifies the addresses of undefined symbols by name. It is
+	// checked before Libraries.
+	Symbols map[string]uint64
+}
+
+// New creates a new object loader with the specified options.
+func New(opts Options) loader.MemLoader {
+	return &Loader{
+		next:      opts.Next,
+		machine:   opts.Machine,
+		libraries: opts.Libraries,
+		symbols:   opts.Symbols,
+	}
+}
+
+// section is a section of an object file that is mapped into memory.
+type section struct {
+	header *pe.ImageSectionHeader
+	name   string
+	offset uint64
+	size   uint64
+}
+
+// link contains the state of linking an object file into memory.
+type link struct {
+	l       *Loader
+	obj     *pe.Object
+	data    []byte
+	machine int
+	mem     loader.Memory
+	base    uint64
+
+	// sections contains the mapped sections, by section number minus one.
+	// Sections that are not mapped are nil.
+	sections []*section
+
+	// addrs contains the addresses of symbols that are not defined in a
+	// section, by symbol table index.
+	addrs map[uint32]uint64
+
+	// stubs contains the addresses of the stubs of external symbols, by
+	// symbol table index.
+	stubs map[uint32]uint64
+
+	// libs contains the libraries loaded for resolving symbols. libsLoaded
+	// specifies whether they have been loaded.
+	libs       []loader.Module
+	libsLoaded bool
+}
+
+// LoadMem implements the loader.MemLoader interface.
+func (l *Loader) LoadMem(data []byte) (loader.Module, error) {
+	obj, err := pe.LoadObject(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	machine := int(obj.FileHeader.Machine)
+	switch machine {
+	case pe.ImageFileMachinei386, pe.ImageFileMachineAMD64, pe.ImageFileMachineARM64:
+	default:
+		return nil, fmt.Errorf("object architecture %04x not supported by the object loader", machine)
+	}
+	if !l.machine.IsArchitectureSupported(machine) {
+		return nil, fmt.Errorf("object architecture %04x not supported by this machine", machine)
+	}
+
+	k := &link{
+		l:        l,
+		obj:      obj,
+		data:     data,
+		machine:  machine,
+		sections: make([]*section, len(obj.Sections)),
+		addrs:    map[uint32]uint64{},
+		stubs:    map[uint32]uint64{},
+	}
+	m, err := k.link()
+	if err != nil {
+		if k.mem != nil {
+			k.mem.Free()
+		}
+		freeModules(k.libs)
+		return nil, err
+	}
+	return m, nil
+}
+
+// link maps the object into memory, resolves its symbols and applies its
+// relocations.
+func (k *link) link() (*module, error) {
+	pageSize := k.l.machine.GetPageSize()
+
+	// Lay out the sections, each starting on its own page so that it can be
+	// protected separately.
+	size := uint64(0)
+	for i := range k.obj.Sections {
+		hdr := &k.obj.Sections[i]
+		if hdr.Characteristics&(pe.ImageSectionCharacteristicsLinkRemove|pe.ImageSectionCharacteristicsLinkInfo) != 0 {
+			continue
+		}
+		if hdr.Characteristics&pe.ImageSectionCharacteristicsMemoryDiscardable != 0 {
+			continue
+		}
+		name, err := k.obj.SectionName(hdr)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Characteristics&pe.ImageSectionCharacteristicsContainsUninitailizedData == 0 && hdr.SizeOfRawData != 0 {
+			if uint64(hdr.PointerToRawData)+uint64(hdr.SizeOfRawData) > uint64(len(k.data)) {
+				return nil, fmt.Errorf("section %q is outside of the object", name)
+			}
+		}
+		size = vmem.RoundUp(size, pageSize)
+		k.sections[i] = &section{header: hdr, name: name, offset: size, size: uint64(hdr.SizeOfRawData)}
+		size += uint64(hdr.SizeOfRawData)
+	}
+
+	// Lay out the common symbols, which are uninitialized data that is not
+	// in any section.
+	commonStart := vmem.RoundUp(size, pageSize)
+	size = commonStart
+	common := map[uint32]uint64{}
+	externs := []*pe.Symbol{}
+	for i := range k.obj.Symbols {
+		sym := &k.obj.Symbols[i]
+		if sym.StorageClass != pe.ImageSymClassExternal && sym.StorageClass != pe.ImageSymClassWeakExternal {
+			continue
+		}
+		if sym.SectionNumber != pe.ImageSymUndefined {
+			continue
+		}
+		if sym.StorageClass == pe.ImageSymClassExternal && sym.Value != 0 {
+			size = vmem.RoundUp(size, commonAlignment)
+			common[sym.Index] = size
+			size += uint64(sym.Value)
+			continue
+		}
+		externs = append(externs, sym)
+	}
+	commonEnd := size
+
+	// Lay out a stub for each external symbol.
+	stubStart := vmem.RoundUp(size, pageSize)
+	size = stubStart + uint64(len(externs))*stubSize
+	size = vmem.RoundUp(size, pageSize)
+	if size == 0 {
+		size = pageSize
+	}
+	if size > maxImageSize {
+		return nil, fmt.Errorf("object of %d bytes is too large", size)
+	}
+
+	k.mem = k.l.machine.Alloc(0, size, vmem.MemCommit|vmem.MemReserve, vmem.PageReadWrite)
+	if k.mem == nil {
+		return nil, fmt.Errorf("allocation of %d bytes failed", size)
+	}
+	k.base = k.mem.Addr()
+
+	// Map the sections.
+	for _, s := range k.sections {
+		if s == nil || s.header.Characteristics&pe.ImageSectionCharacteristicsContainsUninitailizedData != 0 || s.size == 0 {
+			continue
+		}
+		raw := k.data[s.header.PointerToRawData : uint64(s.header.PointerToRawData)+s.size]
+		if _, err := k.mem.WriteAt(raw, int64(s.offset)); err != nil {
+			return nil, err
+		}
+	}
+
+	for index, off := range common {
+		k.addrs[index] = k.base + off
+	}
+
+	// Resolve external symbols and write their stubs.
+	for i, sym := range externs {
+		stub := k.base + stubStart + uint64(i)*stubSize
+		addr, err := k.resolve(sym, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := k.writeStub(stub, addr); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(sym.Name, importPrefix) {
+			// The symbol refers to the pointer at the start of the stub.
+			k.addrs[sym.Index] = stub
+		} else {
+			k.addrs[sym.Index] = addr
+			k.stubs[sym.Index] = stub + 8
+		}
+	}
+
+	// Apply relocations.
+	for _, s := range k.sections {
+		if s == nil {
+			continue
+		}
+		relocs, err := pe.LoadRelocations(s.header, bytes.NewReader(k.data))
+		if err != nil {
+			return nil, err
+		}
+		for _, rel := range relocs {
+			if err := k.relocate(s, rel); err != nil {
+				return nil, fmt.Errorf("section %q: %w", s.name, err)
+			}
+		}
+	}
+
+	// Collect the global symbols, including common symbols.
+	m := &module{
+		machine: k.l.machine,
+		memory:  k.mem,
+		i386:    k.machine == pe.ImageFileMachinei386,
+		symbols: map[string]uint64{},
+		libs:    k.libs,
+	}
+	for i := range k.obj.Symbols {
+		sym := &k.obj.Symbols[i]
+		if sym.StorageClass != pe.ImageSymClassExternal {
+			continue
+		}
+		if _, ok := common[sym.Index]; !ok && sym.SectionNumber <= 0 {
+			continue
+		}
+		if addr, err := k.symbolAddr(sym.Index); err == nil {
+			m.symbols[sym.Name] = addr
+		}
+	}
+
+	// Set access flags.
+	for _, s := range k.sections {
+		if s == nil || s.size == 0 {
+			continue
+		}
+		if err := k.mem.Protect(s.offset, vmem.RoundUp(s.size, pageSize), vmem.SectionProtection(s.header.Characteristics)); err != nil {
+			return nil, err
+		}
+	}
+	if commonEnd > commonStart {
+		if err := k.mem.Protect(commonStart, vmem.RoundUp(commonEnd-commonStart, pageSize), vmem.PageReadWrite); err != nil {
+			return nil, err
+		}
+	}
+	if len(externs) > 0 {
+		if err := k.mem.Protect(stubStart, vmem.RoundUp(uint64(len(externs))*stubSize, pageSize), vmem.PageExecuteRead); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// maxWeakDepth is the maximum length of a chain of weak externals.
+const maxWeakDepth = 16
+
+// resolve returns the address of an undefined external symbol. Symbols with
+// the import prefix resolve to the address of the symbol without it. Weak
+// externals that are not found resolve to their default symbol.
+func (k *link) resolve(sym *pe.Symbol, depth int) (uint64, error) {
+	name := strings.TrimPrefix(sym.Name, importPrefix)
+	if addr, ok, err := k.lookup(name); err != nil {
+		return 0, err
+	} else if ok {
+		return addr, nil
+	}
+	if sym.WeakExternal != nil && depth < maxWeakDepth {
+		def := k.obj.Symbol(sym.WeakExternal.TagIndex)
+		if def != nil && def.Index != sym.Index {
+			if def.SectionNumber == pe.ImageSymUndefined && def.Value == 0 {
+				return k.resolve(def, depth+1)
+			}
+			if addr, ok := k.addrs[def.Index]; ok {
+				return addr, nil
+			}
+			return k.symbolAddr(def.Index)
+		}
+	}
+	return 0, fmt.Errorf("unresolved external symbol %s", sym.Name)
+}
+
+// lookup looks up an external symbol by name in the symbol map, then in the
+// libraries, which are loaded the first time they are needed. It fails if a
+// library can not be loaded.
+func (k *link) lookup(name string) (uint64, bool, error) {
+	if addr, ok := k.l.symbols[name]; ok {
+		return addr, true, nil
+	}
+	if k.machine == pe.ImageFileMachinei386 {
+		name = undecorate(name)
+		if addr, ok := k.l.symbols[name]; ok {
+			return addr, true, nil
+		}
+	}
+	if !k.libsLoaded && k.l.next != nil {
+		k.libsLoaded = true
+		for _, lib := range k.l.libraries {
+			mod, err := k.l.next.Load(lib)
+			if err != nil {
+				return 0, false, fmt.Errorf("loading library %s: %w", lib, err)
+			}
+			k.libs = append(k.libs, mod)
+		}
+	}
+	for _, mod := range k.libs {
+		if proc := mod.Proc(name); proc != nil {
+			return proc.Addr(), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// undecorate removes the name decoration of the C and stdcall calling
+// conventions on i386: a leading underscore and, for stdcall, a trailing @
+// followed by the size of the arguments.
+func undecorate(name string) string {
+	if !strings.HasPrefix(name, "_") {
+		return name
+	}
+	name = name[1:]
+	if i := strings.LastIndexByte(name, '@'); i > 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			name = name[:i]
+		}
+	}
+	return name
+}
+
+// symbolAddr returns the address of the symbol at index.
+func (k *link) symbolAddr(index uint32) (uint64, error) {
+	if addr, ok := k.addrs[index]; ok {
+		return addr, nil
+	}
+	sym := k.obj.Symbol(index)
+	if sym == nil {
+		return 0, fmt.Errorf("invalid symbol index %d", index)
+	}
+	switch {
+	case sym.SectionNumber == pe.ImageSymAbsolute:
+		return uint64(sym.Value), nil
+	case sym.SectionNumber > 0 && int(sym.SectionNumber) <= len(k.sections):
+		s := k.sections[sym.SectionNumber-1]
+		if s == nil {
+			return 0, fmt.Errorf("symbol %s is in a section that is not loaded", sym.Name)
+		}
+		return k.base + s.offset + uint64(sym.Value), nil
+	}
+	return 0, fmt.Errorf("symbol %s is not defined", sym.Name)
+}
+
+// writeStub writes the stub of an external symbol at addr.
+func (k *link) writeStub(addr, target uint64) error {
+	stub := make([]byte, stubSize)
+	binary.LittleEndian.PutUint64(stub[0:8], target)
+	code := stub[8:]
+	switch k.machine {
+	case pe.ImageFileMachinei386:
+		// jmp dword ptr [addr]
+		code[0], code[1] = 0xFF, 0x25
+		binary.LittleEndian.PutUint32(code[2:6], uint32(addr))
+	case pe.ImageFileMachineAMD64:
+		// jmp qword ptr [rip-14]
+		code[0], code[1] = 0xFF, 0x25
+		binary.LittleEndian.PutUint32(code[2:6], 0xFFFFFFF2)
+	case pe.ImageFileMachineARM64:
+		// ldr x16, #-8; br x16
+		binary.LittleEndian.PutUint32(code[0:4], 0x58FFFFD0)
+		binary.LittleEndian.PutUint32(code[4:8], 0xD61F0200)
+	}
+	_, err := k.mem.WriteAt(stub, int64(addr-k.base))
+	return err
+}
@@ -0,0 +1,286 @@
+package objloader
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loader"
+	"github.com/jchv/go-winloader/internal/loadertest"
+	"github.com/jchv/go-winloader/internal/pe"
+	"github.com/jchv/go-winloader/internal/vmem"
+)
+
+// load loads an object from testdata with the specified options.
+func load(t *testing.T, name string, opts Options) (loader.Module, *loadertest.Memory) {
+	t.Helper()
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine := opts.Machine.(*loadertest.Machine)
+	mod, err := New(opts).LoadMem(data)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	return mod, machine.Allocs[0]
+}
+
+// proc returns the address of a global symbol of a module.
+func proc(t *testing.T, mod loader.Module, name string) uint64 {
+	t.Helper()
+	p := mod.Proc(name)
+	if p == nil {
+		t.Fatalf("symbol %s not found", name)
+	}
+	return p.Addr()
+}
+
+// testdata/amd64.obj is generated from testdata/amd64.s; see the comment in
+// that file.
+func TestLoadAMD64(t *testing.T) {
+	const extAdd = 0x7FF000001000
+	const extMul = 0x20100000
+	next := &loadertest.TrackingLoader{Next: loadertest.Loader{"other.dll": {}, "ext.dll": {"ext_mul": extMul}}}
+	mod, mem := load(t, "amd64.obj", Options{
+		Next:      next,
+		Machine:   &loadertest.Machine{Arch: pe.ImageFileMachineAMD64},
+		Libraries: []string{"other.dll", "ext.dll"},
+		Symbols:   map[string]uint64{"ext_add": extAdd},
+	})
+
+	// The target of the jump is out of range, so it goes through a stub that
+	// jumps to the address after it.
+	callExternal := proc(t, mod, "CallExternal")
+	stub := callExternal + 5 + uint64(int32(mem.Uint32(callExternal+1)))
+	if mem.Uint64(stub-8) != extAdd {
+		t.Errorf("expected stub for %#x got %#x", uint64(extAdd), mem.Uint64(stub-8))
+	}
+	if code := mem.Data[stub-mem.Addr() : stub-mem.Addr()+6]; string(code) != "\xFF\x25\xF2\xFF\xFF\xFF" {
+		t.Errorf("unexpected stub code % x", code)
+	}
+	if mem.Protection(stub) != vmem.PageExecuteRead {
+		t.Errorf("expected executable stub, got protection %#x", mem.Protection(stub))
+	}
+
+	// The import symbol refers to a pointer to the symbol.
+	callImport := proc(t, mod, "CallImport")
+	slot := callImport + 6 + uint64(int32(mem.Uint32(callImport+2)))
+	if mem.Uint64(slot) != extMul {
+		t.Errorf("expected pointer to %#x got %#x", uint64(extMul), mem.Uint64(slot))
+	}
+
+	counter := proc(t, mod, "counter")
+	counterAddr := proc(t, mod, "CounterAddr")
+	if addr := counterAddr + 7 + uint64(int32(mem.Uint32(counterAddr+3))); addr != counter {
+		t.Errorf("expected reference to counter at %#x got %#x", counter, addr)
+	}
+	if mem.Uint32(counter) != 42 {
+		t.Errorf("expected counter to be initialized")
+	}
+
+	// Common symbols are allocated in a writable area.
+	sharedAddr := proc(t, mod, "SharedAddr")
+	shared := sharedAddr + 7 + uint64(int32(mem.Uint32(sharedAddr+3)))
+	if shared != proc(t, mod, "shared") || shared%16 != 0 || mem.Protection(shared) != vmem.PageReadWrite {
+		t.Errorf("unexpected common symbol at %#x", shared)
+	}
+
+	// The weak external falls back to the static function, which is not
+	// exported.
+	table := proc(t, mod, "table")
+	if mem.Uint64(table) != counterAddr {
+		t.Errorf("expected %#x got %#x", counterAddr, mem.Uint64(table))
+	}
+	if fallback := mem.Uint64(table + 8); fallback != sharedAddr+8 {
+		t.Errorf("expected weak external to resolve to %#x got %#x", sharedAddr+8, fallback)
+	}
+	if mod.Proc("fallback") != nil {
+		t.Errorf("expected static symbol not to be found")
+	}
+	if rva := mem.Uint32(table + 16); uint64(rva) != counter-mem.Addr() {
+		t.Errorf("expected image relative address %#x got %#x", counter-mem.Addr(), rva)
+	}
+	if secrel := mem.Uint32(table + 20); secrel != 0 {
+		t.Errorf("expected section relative address 0 got %#x", secrel)
+	}
+
+	if mem.Protection(callExternal) != vmem.PageExecuteRead || mem.Protection(counter) != vmem.PageReadWrite {
+		t.Errorf("unexpected section protection")
+	}
+
+	mod.Free()
+	if !mem.Freed() {
+		t.Errorf("expected memory to be freed")
+	}
+	if !reflect.DeepEqual(next.Freed, []string{"ext.dll", "other.dll"}) {
+		t.Errorf("expected libraries to be freed in reverse order got %v", next.Freed)
+	}
+}
+
+func TestLoadAMD64WeakExternal(t *testing.T) {
+	const optional = 0x20200000
+	mod, mem := load(t, "amd64.obj", Options{
+		Machine: &loadertest.Machine{Arch: pe.ImageFileMachineAMD64},
+		Symbols: map[string]uint64{"ext_add": 1, "ext_mul": 2, "optional": optional},
+	})
+	defer mod.Free()
+	if addr := mem.Uint64(proc(t, mod, "table") + 8); addr != optional {
+		t.Errorf("expected weak external to resolve to %#x got %#x", uint64(optional), addr)
+	}
+}
+
+// testdata/i386.obj is generated from testdata/i386.s; see the comment in
+// that file.
+func TestLoadI386(t *testing.T) {
+	const extAdd = 0x30001000
+	const extMul = 0x30002000
+	mod, mem := load(t, "i386.obj", Options{
+		Next:      loadertest.Loader{"ext.dll": {"ext_add": extAdd}},
+		Machine:   &loadertest.Machine{Arch: pe.ImageFileMachinei386},
+		Libraries: []string{"ext.dll"},
+		Symbols:   map[string]uint64{"ext_mul": extMul},
+	})
+	defer mod.Free()
+
+	// Symbols are found with and without their leading underscore.
+	callExternal := proc(t, mod, "CallExternal")
+	if callExternal != proc(t, mod, "_CallExternal") {
+		t.Errorf("expected decorated and undecorated names to match")
+	}
+	if addr := uint32(callExternal) + 5 + mem.Uint32(callExternal+1); addr != extAdd {
+		t.Errorf("expected call to %#x got %#x", extAdd, addr)
+	}
+
+	callImport := proc(t, mod, "CallImport")
+	slot := uint64(mem.Uint32(callImport + 2))
+	if mem.Uint32(slot) != extMul {
+		t.Errorf("expected pointer to %#x got %#x", extMul, mem.Uint32(slot))
+	}
+
+	counter := proc(t, mod, "counter")
+	counterAddr := proc(t, mod, "CounterAddr")
+	if addr := mem.Uint32(counterAddr + 1); uint64(addr) != counter {
+		t.Errorf("expected counter at %#x got %#x", counter, addr)
+	}
+	if rva := mem.Uint32(counter + 4); uint64(rva) != counterAddr-mem.Addr() {
+		t.Errorf("expected image relative address %#x got %#x", counterAddr-mem.Addr(), rva)
+	}
+}
+
+// arm64ADRP returns the page addressed by an ADRP instruction at addr.
+func arm64ADRP(mem *loadertest.Memory, addr uint64) uint64 {
+	insn := mem.Uint32(addr)
+	return addr&^0xFFF + uint64(adrImm(insn)<<12)
+}
+
+// arm64Imm12 returns the unsigned 12-bit immediate of an instruction at addr.
+func arm64Imm12(mem *loadertest.Memory, addr uint64) uint64 {
+	return uint64(mem.Uint32(addr)>>10) & 0xFFF
+}
+
+// testdata/arm64.obj is generated from testdata/arm64.s; see the comment in
+// that file.
+func TestLoadARM64(t *testing.T) {
+	const extAdd = 0x7FF000001000
+	const extMul = 0x7FF000002000
+	mod, mem := load(t, "arm64.obj", Options{
+		Machine: &loadertest.Machine{Arch: pe.ImageFileMachineARM64},
+		Symbols: map[string]uint64{"ext_add": extAdd, "ext_mul": extMul},
+	})
+	defer mod.Free()
+
+	callExternal := proc(t, mod, "CallExternal")
+	stub := callExternal + uint64(signExtend(uint64(mem.Uint32(callExternal)&0x3FFFFFF), 26)<<2)
+	if mem.Uint64(stub-8) != extAdd {
+		t.Errorf("expected stub for %#x got %#x", uint64(extAdd), mem.Uint64(stub-8))
+	}
+	if mem.Uint32(stub) != 0x58FFFFD0 || mem.Uint32(stub+4) != 0xD61F0200 {
+		t.Errorf("unexpected stub code %08x %08x", mem.Uint32(stub), mem.Uint32(stub+4))
+	}
+
+	// The 64-bit load offset is scaled by 8.
+	callImport := proc(t, mod, "CallImport")
+	slot := arm64ADRP(mem, callImport) + arm64Imm12(mem, callImport+4)*8
+	if mem.Uint64(slot) != extMul {
+		t.Errorf("expected pointer to %#x got %#x", uint64(extMul), mem.Uint64(slot))
+	}
+
+	counter := proc(t, mod, "counter")
+	counterAddr := proc(t, mod, "CounterAddr")
+	if addr := arm64ADRP(mem, counterAddr) + arm64Imm12(mem, counterAddr+4); addr != counter {
+		t.Errorf("expected counter at %#x got %#x", counter, addr)
+	}
+	loadCounter := proc(t, mod, "LoadCounter")
+	if addr := arm64ADRP(mem, loadCounter) + arm64Imm12(mem, loadCounter+4)*4; addr != counter {
+		t.Errorf("expected counter at %#x got %#x", counter, addr)
+	}
+	if addr := mem.Uint64(counter + 8); addr != counterAddr {
+		t.Errorf("expected %#x got %#x", counterAddr, addr)
+	}
+}
+
+func TestLoadUnresolved(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/amd64.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine := &loadertest.Machine{Arch: pe.ImageFileMachineAMD64}
+	_, err = New(Options{Machine: machine}).LoadMem(data)
+	if err == nil || !strings.Contains(err.Error(), "unresolved external symbol ext_add") {
+		t.Errorf("expected unresolved symbol error got %v", err)
+	}
+	if len(machine.Allocs) != 1 || !machine.Allocs[0].Freed() {
+		t.Errorf("expected memory to be freed")
+	}
+}
+
+func TestLoadMissingLibrary(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/amd64.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine := &loadertest.Machine{Arch: pe.ImageFileMachineAMD64}
+	next := &loadertest.TrackingLoader{Next: loadertest.Loader{"ext.dll": {"ext_add": 1, "ext_mul": 2}}}
+	_, err = New(Options{
+		Next:      next,
+		Machine:   machine,
+		Libraries: []string{"ext.dll", "missing.dll"},
+	}).LoadMem(data)
+	if err == nil || !strings.Contains(err.Error(), "missing.dll") {
+		t.Errorf("expected error loading missing.dll got %v", err)
+	}
+	if !reflect.DeepEqual(next.Freed, []string{"ext.dll"}) {
+		t.Errorf("expected loaded libraries to be freed got %v", next.Freed)
+	}
+	if len(machine.Allocs) != 1 || !machine.Allocs[0].Freed() {
+		t.Errorf("expected memory to be freed")
+	}
+}
+
+func TestLoadUnsupportedMachine(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/amd64.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine := &loadertest.Machine{Arch: pe.ImageFileMachinei386}
+	if _, err := New(Options{Machine: machine}).LoadMem(data); err == nil {
+		t.Errorf("expected error loading object for another machine")
+	}
+}
+
+func TestUndecorate(t *testing.T) {
+	tests := map[string]string{
+		"_Add":        "Add",
+		"_Add@8":      "Add",
+		"Add":         "Add",
+		"_Add@x":      "Add@x",
+		"@fastcall@8": "@fastcall@8",
+	}
+	for name, expected := range tests {
+		if actual := undecorate(name); actual != expected {
+			t.Errorf("undecorate(%q): expected %q got %q", name, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package objloader
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// relocation contains the values needed to apply a relocation.
+type relocation struct {
+	// typ is the type of the relocation, which depends on the machine.
+	typ uint16
+
+	// p is the address of the place that is relocated.
+	p uint64
+
+	// s is the address of the symbol that the relocation refers to.
+	s uint64
+
+	// index is the symbol table index of the symbol.
+	index uint32
+}
+
+// relocate applies a relocation to a section.
+func (k *link) relocate(s *section, rel pe.ImageRelocation) error {
+	width, err := k.relocationWidth(rel.Type)
+	if err != nil {
+		return err
+	}
+	if width == 0 {
+		return nil
+	}
+	if uint64(rel.VirtualAddress)+uint64(width) > s.size {
+		return fmt.Errorf("relocation at %#x is outside of the section", rel.VirtualAddress)
+	}
+	target, err := k.symbolAddr(rel.SymbolTableIndex)
+	if err != nil {
+		return err
+	}
+
+	off := int64(s.offset) + int64(rel.VirtualAddress)
+	b := make([]byte, width)
+	if _, err := k.mem.ReadAt(b, off); err != nil {
+		return err
+	}
+	r := relocation{typ: rel.Type, p: k.base + uint64(off), s: target, index: rel.SymbolTableIndex}
+	switch k.machine {
+	case pe.ImageFileMachinei386:
+		err = k.relocateI386(b, r)
+	case pe.ImageFileMachineAMD64:
+		err = k.relocateAMD64(b, r)
+	case pe.ImageFileMachineARM64:
+		err = k.relocateARM64(b, r)
+	}
+	if err != nil {
+		return fmt.Errorf("relocation at %#x: %w", rel.VirtualAddress, err)
+	}
+	_, err = k.mem.WriteAt(b, off)
+	return err
+}
+
+// relocationWidth returns the number of bytes modified by a relocation type,
+// or zero if the relocation does nothing.
+func (k *link) relocationWidth(typ uint16) (int, error) {
+	switch k.machine {
+	case pe.ImageFileMachinei386:
+		switch typ {
+		case pe.ImageRelI386Absolute:
+			return 0, nil
+		case pe.ImageRelI386Dir16, pe.ImageRelI386Rel16, pe.ImageRelI386Section:
+			return 2, nil
+		case pe.ImageRelI386Dir32, pe.ImageRelI386Dir32NB, pe.ImageRelI386SecRel, pe.ImageRelI386Rel32:
+			return 4, nil
+		}
+	case pe.ImageFileMachineAMD64:
+		switch typ {
+		case pe.ImageRelAMD64Absolute:
+			return 0, nil
+		case pe.ImageRelAMD64Section:
+			return 2, nil
+		case pe.ImageRelAMD64Addr32, pe.ImageRelAMD64Addr32NB, pe.ImageRelAMD64SecRel,
+			pe.ImageRelAMD64Rel32, pe.ImageRelAMD64Rel32_1, pe.ImageRelAMD64Rel32_2,
+			pe.ImageRelAMD64Rel32_3, pe.ImageRelAMD64Rel32_4, pe.ImageRelAMD64Rel32_5:
+			return 4, nil
+		case pe.ImageRelAMD64Addr64:
+			return 8, nil
+		}
+	case pe.ImageFileMachineARM64:
+		switch typ {
+		case pe.ImageRelARM64Absolute:
+			return 0, nil
+		case pe.ImageRelARM64Section:
+			return 2, nil
+		case pe.ImageRelARM64Addr32, pe.ImageRelARM64Addr32NB, pe.ImageRelARM64Branch26,
+			pe.ImageRelARM64PageBaseRel21, pe.ImageRelARM64Rel21, pe.ImageRelARM64PageOffset12A,
+			pe.ImageRelARM64PageOffset12L, pe.ImageRelARM64SecRel, pe.ImageRelARM64SecRelLow12A,
+			pe.ImageRelARM64SecRelHigh12A, pe.ImageRelARM64SecRelLow12L, pe.ImageRelARM64Branch19,
+			pe.ImageRelARM64Branch14, pe.ImageRelARM64Rel32:
+			return 4, nil
+		case pe.ImageRelARM64Addr64:
+			return 8, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported relocation type %#x on machine type %04x", typ, k.machine)
+}
+
+// relocateI386 applies an i386 relocation to b.
+func (k *link) relocateI386(b []byte, r relocation) error {
+	o := binary.LittleEndian
+	switch r.typ {
+	case pe.ImageRelI386Dir16:
+		v := uint64(o.Uint16(b)) + r.s
+		if v>>16 != 0 {
+			return errOutOfRange(r)
+		}
+		o.PutUint16(b, uint16(v))
+	case pe.ImageRelI386Rel16:
+		d, err := k.relative(r, int64(int16(o.Uint16(b))), 2, 16)
+		if err != nil {
+			return err
+		}
+		o.PutUint16(b, uint16(d))
+	case pe.ImageRelI386Dir32:
+		return k.addr32(b, r, 0)
+	case pe.ImageRelI386Dir32NB:
+		return k.addr32(b, r, k.base)
+	case pe.ImageRelI386Rel32:
+		// The address space is 32 bits, so every target is in range.
+		o.PutUint32(b, o.Uint32(b)+uint32(r.s-(r.p+4)))
+	case pe.ImageRelI386Section:
+		return k.sectionNumber(b, r)
+	case pe.ImageRelI386SecRel:
+		return k.secrel32(b, r)
+	}
+	return nil
+}
+
+// relocateAMD64 applies an x64 relocation to b.
+func (k *link) relocateAMD64(b []byte, r relocation) error {
+	o := binary.LittleEndian
+	switch r.typ {
+	case pe.ImageRelAMD64Addr64:
+		o.PutUint64(b, o.Uint64(b)+r.s)
+	case pe.ImageRelAMD64Addr32:
+		return k.addr32(b, r, 0)
+	case pe.ImageRelAMD64Addr32NB:
+		return k.addr32(b, r, k.base)
+	case pe.ImageRelAMD64Rel32, pe.ImageRelAMD64Rel32_1, pe.ImageRelAMD64Rel32_2,
+		pe.ImageRelAMD64Rel32_3, pe.ImageRelAMD64Rel32_4, pe.ImageRelAMD64Rel32_5:
+		// The displacement is relative to the end of the instruction, which
+		// is up to five bytes after the end of the displacement.
+		bias := uint64(4 + r.typ - pe.ImageRelAMD64Rel32)
+		d, err := k.relative(r, int64(int32(o.Uint32(b))), bias, 32)
+		if err != nil {
+			return err
+		}
+		o.PutUint32(b, uint32(d))
+	case pe.ImageRelAMD64Section:
+		return k.sectionNumber(b, r)
+	case pe.ImageRelAMD64SecRel:
+		return k.secrel32(b, r)
+	}
+	return nil
+}
+
+// relocateARM64 applies an ARM64 relocation to b.
+func (k *link) relocateARM64(b []byte, r relocation) error {
+	o := binary.LittleEndian
+	switch r.typ {
+	case pe.ImageRelARM64Addr64:
+		o.PutUint64(b, o.Uint64(b)+r.s)
+		return nil
+	case pe.ImageRelARM64Addr32:
+		return k.addr32(b, r, 0)
+	case pe.ImageRelARM64Addr32NB:
+		return k.addr32(b, r, k.base)
+	case pe.ImageRelARM64Section:
+		return k.sectionNumber(b, r)
+	case pe.ImageRelARM64SecRel:
+		return k.secrel32(b, r)
+	case pe.ImageRelARM64Rel32:
+		d, err := k.relative(r, int64(int32(o.Uint32(b))), 4, 32)
+		if err != nil {
+			return err
+		}
+		o.PutUint32(b, uint32(d))
+		return nil
+	}
+
+	insn := o.Uint32(b)
+	switch r.typ {
+	case pe.ImageRelARM64Branch26:
+		d, err := k.branch(r, insn, 0, 26)
+		if err != nil {
+			return err
+		}
+		insn = insn&^0x3FFFFFF | uint32(d>>2)&0x3FFFFFF
+	case pe.ImageRelARM64Branch19:
+		d, err := k.branch(r, insn, 5, 19)
+		if err != nil {
+			return err
+		}
+		insn = insn&^(0x7FFFF<<5) | (uint32(d>>2)&0x7FFFF)<<5
+	case pe.ImageRelARM64Branch14:
+		d, err := k.branch(r, insn, 5, 14)
+		if err != nil {
+			return err
+		}
+		insn = insn&^(0x3FFF<<5) | (uint32(d>>2)&0x3FFF)<<5
+	case pe.ImageRelARM64PageBaseRel21:
+		target := r.s + uint64(adrImm(insn))
+		d := int64(target&^0xFFF-r.p&^0xFFF) >> 12
+		if !fits(d, 21) {
+			return errOutOfRange(r)
+		}
+		insn = setADRImm(insn, d)
+	case pe.ImageRelARM64Rel21:
+		d := int64(r.s + uint64(adrImm(insn)) - r.p)
+		if !fits(d, 21) {
+			return errOutOfRange(r)
+		}
+		insn = setADRImm(insn, d)
+	case pe.ImageRelARM64PageOffset12A:
+		insn = addImm12(insn, uint32(r.s&0xFFF), 0)
+	case pe.ImageRelARM64PageOffset12L:
+		var err error
+		if insn, err = ldrImm12(insn, uint32(r.s&0xFFF)); err != nil {
+			return err
+		}
+	case pe.ImageRelARM64SecRelLow12A, pe.ImageRelARM64SecRelHigh12A, pe.ImageRelARM64SecRelLow12L:
+		_, base, err := k.symbolSection(r.index)
+		if err != nil {
+			return err
+		}
+		secrel := uint32(r.s - base)
+		switch r.typ {
+		case pe.ImageRelARM64SecRelLow12A:
+			insn = addImm12(insn, secrel&0xFFF, 0)
+		case pe.ImageRelARM64SecRelHigh12A:
+			insn = addImm12(insn, (secrel>>12)&0xFFF, 0)
+		case pe.ImageRelARM64SecRelLow12L:
+			if insn, err = ldrImm12(insn, secrel&0xFFF); err != nil {
+				return err
+			}
+		}
+	}
+	o.PutUint32(b, insn)
+	return nil
+}
+
+// addr32 adds the address of the symbol relative to base to a 32-bit field.
+func (k *link) addr32(b []byte, r relocation, base uint64) error {
+	o := binary.LittleEndian
+	v := uint64(o.Uint32(b)) + r.s - base
+	if v>>32 != 0 {
+		return errOutOfRange(r)
+	}
+	o.PutUint32(b, uint32(v))
+	return nil
+}
+
+// sectionNumber writes the section number of the symbol to a 16-bit field.
+func (k *link) sectionNumber(b []byte, r relocation) error {
+	number, _, err := k.symbolSection(r.index)
+	if err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint16(b, uint16(number))
+	return nil
+}
+
+// secrel32 adds the offset of the symbol from the start of its section to a
+// 32-bit field.
+func (k *link) secrel32(b []byte, r relocation) error {
+	_, base, err := k.symbolSection(r.index)
+	if err != nil {
+		return err
+	}
+	o := binary.LittleEndian
+	o.PutUint32(b, o.Uint32(b)+uint32(r.s-base))
+	return nil
+}
+
+// symbolSection returns the section number and the address of the section
+// of the symbol at index.
+func (k *link) symbolSection(index uint32) (int16, uint64, error) {
+	sym := k.obj.Symbol(index)
+	if sym == nil || sym.SectionNumber <= 0 || int(sym.SectionNumber) > len(k.sections) || k.sections[sym.SectionNumber-1] == nil {
+		return 0, 0, fmt.Errorf("symbol %d is not in a loaded section", index)
+	}
+	return sym.SectionNumber, k.base + k.sections[sym.SectionNumber-1].offset, nil
+}
+
+// relative returns the displacement of the symbol plus addend from the place
+// plus bias, which must fit in a signed integer of the specified number of
+// bits. If it does not and the symbol is external, the displacement of its
+// stub is returned instead.
+func (k *link) relative(r relocation, addend int64, bias uint64, bits uint) (int64, error) {
+	d := int64(r.s + uint64(addend) - (r.p + bias))
+	if !fits(d, bits) {
+		stub, ok := k.stubs[r.index]
+		if !ok {
+			return 0, errOutOfRange(r)
+		}
+		d = int64(stub + uint64(addend) - (r.p + bias))
+		if !fits(d, bits) {
+			return 0, errOutOfRange(r)
+		}
+	}
+	return d, nil
+}
+
+// branch returns the displacement for an ARM64 branch instruction, whose
+// immediate is a signed number of instructions with the specified number
+// of bits starting at bit shift.
+func (k *link) branch(r relocation, insn uint32, shift, bits uint) (int64, error) {
+	addend := signExtend(uint64(insn>>shift)&(1<<bits-1), bits) << 2
+	d, err := k.relative(r, addend, 0, bits+2)
+	if err != nil {
+		return 0, err
+	}
+	if d&3 != 0 {
+		return 0, fmt.Errorf("misaligned branch target %#x", r.s)
+	}
+	return d, nil
+}
+
+// adrImm returns the signed immediate of an ARM64 ADR or ADRP instruction.
+func adrImm(insn uint32) int64 {
+	return signExtend(uint64((insn>>29)&3|(insn>>3)&0x1FFFFC), 21)
+}
+
+// setADRImm sets the immediate of an ARM64 ADR or ADRP instruction.
+func setADRImm(insn uint32, imm int64) uint32 {
+	v := uint32(imm)
+	return insn&^(3<<29|0x7FFFF<<5) | (v&3)<<29 | ((v>>2)&0x7FFFF)<<5
+}
+
+// addImm12 adds imm to the unsigned 12-bit immediate of an ARM64 ADD or
+// load/store instruction, whose immediate is scaled down by shift.
+func addImm12(insn uint32, imm uint32, shift uint) uint32 {
+	imm += (insn >> 10) & 0xFFF
+	return insn&^(0xFFF<<10) | (imm&(0xFFF>>shift))<<10
+}
+
+// ldrImm12 adds the byte offset imm to the immediate of an ARM64 load/store
+// instruction, scaling it by the size of the access.
+func ldrImm12(insn uint32, imm uint32) (uint32, error) {
+	shift := uint(insn >> 30)
+	// 128-bit SIMD and floating point registers.
+	if insn&0x4800000 == 0x4800000 {
+		shift += 4
+	}
+	if imm&(1<<shift-1) != 0 {
+		return 0, fmt.Errorf("misaligned load/store offset %#x", imm)
+	}
+	return addImm12(insn, imm>>shift, shift), nil
+}
+
+// signExtend sign extends a value with the specified number of bits.
+func signExtend(v uint64, bits uint) int64 {
+	return int64(v<<(64-bits)) >> (64 - bits)
+}
+
+// fits returns whether v fits in a signed integer with the specified number
+// of bits.
+func fits(v int64, bits uint) bool {
+	return v >= -(1<<(bits-1)) && v < 1<<(bits-1)
+}
+
+// errOutOfRange returns an error for a relocation whose target can not be
+// encoded.
+func errOutOfRange(r relocation) error {
+	return fmt.Errorf("target %#x is out of range", r.s)
+}
@@ -0,0 +1,63 @@
+# amd64.obj is generated from this file with:
+#   llvm-mc -filetype=obj -triple=x86_64-pc-windows-gnu amd64.s -o amd64.obj
+	.text
+	.globl	CallExternal
+	.def	CallExternal;
+	.scl	2;
+	.type	32;
+	.endef
+CallExternal:
+	jmp	ext_add
+
+	.globl	CallImport
+	.def	CallImport;
+	.scl	2;
+	.type	32;
+	.endef
+CallImport:
+	jmpq	*__imp_ext_mul(%rip)
+
+	.globl	CounterAddr
+	.def	CounterAddr;
+	.scl	2;
+	.type	32;
+	.endef
+CounterAddr:
+	leaq	counter(%rip), %rax
+	ret
+
+	.globl	SharedAddr
+	.def	SharedAddr;
+	.scl	2;
+	.type	32;
+	.endef
+SharedAddr:
+	leaq	shared(%rip), %rax
+	ret
+
+	.def	fallback;
+	.scl	3;
+	.type	32;
+	.endef
+fallback:
+	xorl	%eax, %eax
+	ret
+
+	.weak	optional
+	.set	optional, fallback
+
+	.data
+	.globl	counter
+counter:
+	.long	42
+	.globl	table
+table:
+	.quad	CounterAddr
+	.quad	optional
+	.long	counter@IMGREL
+	.secrel32	counter
+
+	.comm	shared, 16, 4
+
+	.section	.drectve,"yn"
+	.ascii	" -export:CallExternal"
@@ -0,0 +1,48 @@
+// arm64.obj is generated from this file with:
+//   llvm-mc -filetype=obj -triple=aarch64-pc-windows-gnu arm64.s -o arm64.obj
+	.text
+	.globl	CallExternal
+	.def	CallExternal;
+	.scl	2;
+	.type	32;
+	.endef
+CallExternal:
+	b	ext_add
+
+	.globl	CallImport
+	.def	CallImport;
+	.scl	2;
+	.type	32;
+	.endef
+CallImport:
+	adrp	x16, __imp_ext_mul
+	ldr	x16, [x16, :lo12:__imp_ext_mul]
+	br	x16
+
+	.globl	CounterAddr
+	.def	CounterAddr;
+	.scl	2;
+	.type	32;
+	.endef
+CounterAddr:
+	adrp	x0, counter
+	add	x0, x0, :lo12:counter
+	ret
+
+	.globl	LoadCounter
+	.def	LoadCounter;
+	.scl	2;
+	.type	32;
+	.endef
+LoadCounter:
+	adrp	x8, counter
+	ldr	w0, [x8, :lo12:counter]
+	ret
+
+	.data
+	.globl	counter
+	.p2align	3
+counter:
+	.long	42
+	.long	0
+	.xword	CounterAddr
@@ -0,0 +1,33 @@
+# i386.obj is generated from this file with:
+#   llvm-mc -filetype=obj -triple=i686-pc-windows-gnu i386.s -o i386.obj
+	.text
+	.globl	_CallExternal
+	.def	_CallExternal;
+	.scl	2;
+	.type	32;
+	.endef
+_CallExternal:
+	jmp	_ext_add@8
+
+	.globl	_CallImport
+	.def	_CallImport;
+	.scl	2;
+	.type	32;
+	.endef
+_CallImport:
+	jmpl	*__imp__ext_mul
+
+	.globl	_CounterAddr
+	.def	_CounterAddr;
+	.scl	2;
+	.type	32;
+	.endef
+_CounterAddr:
+	movl	$_counter, %eax
+	ret
+
+	.data
+	.globl	_counter
+_counter:
+	.long	42
+	.long	_CounterAddr@IMGREL
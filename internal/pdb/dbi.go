@@ -0,0 +1,163 @@
+package pdb
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// dbiVersionSignature is the signature of the new DBI stream header.
+const dbiVersionSignature = 0xFFFFFFFF
+
+// dbiHeader is the header of the DBI stream.
+type dbiHeader struct {
+	VersionSignature        uint32
+	VersionHeader           uint32
+	Age                     uint32
+	GlobalStreamIndex       uint16
+	BuildNumber             uint16
+	PublicStreamIndex       uint16
+	PdbDllVersion           uint16
+	SymRecordStream         uint16
+	PdbDllRbld              uint16
+	ModInfoSize             int32
+	SectionContributionSize int32
+	SectionMapSize          int32
+	SourceInfoSize          int32
+	TypeServerMapSize       int32
+	MFCTypeServerIndex      uint32
+	OptionalDbgHeaderSize   int32
+	ECSubstreamSize         int32
+	Flags                   uint16
+	Machine                 uint16
+	Padding                 uint32
+}
+
+// sizeOfDBIHeader is the size of dbiHeader.
+const sizeOfDBIHeader = 64
+
+// Enumeration of indices into the optional debug header of the DBI stream.
+const (
+	dbgHeaderFPO            = 0
+	dbgHeaderException      = 1
+	dbgHeaderFixup          = 2
+	dbgHeaderOmapToSrc      = 3
+	dbgHeaderOmapFromSrc    = 4
+	dbgHeaderSectionHdr     = 5
+	dbgHeaderTokenRidMap    = 6
+	dbgHeaderXdata          = 7
+	dbgHeaderPdata          = 8
+	dbgHeaderNewFPO         = 9
+	dbgHeaderSectionHdrOrig = 10
+)
+
+// noStream is the stream index used when a stream is not present.
+const noStream = 0xFFFF
+
+// Module is a module, or object file, that was linked into the image.
+type Module struct {
+	// Name is the name of the module. For object files from static
+	// libraries, this is the name of the member.
+	Name string
+
+	// ObjFile is the name of the object file or static library the module
+	// came from.
+	ObjFile string
+
+	stream     uint16
+	symSize    uint32
+	c11Size    uint32
+	c13Size    uint32
+	numSources uint16
+}
+
+// readDBIStream reads the DBI stream, which contains the list of modules
+// and the indices of the other debug streams.
+func (f *File) readDBIStream() error {
+	data, err := f.msf.streamBytes(streamDBI)
+	if err != nil {
+		return err
+	}
+	hdr := dbiHeader{}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr); err != nil {
+		return ErrInvalidPDB
+	}
+	if hdr.VersionSignature != dbiVersionSignature {
+		return ErrUnsupportedVersion
+	}
+	f.Age = hdr.Age
+	f.Machine = hdr.Machine
+	f.symRecordStream = hdr.SymRecordStream
+
+	// Split the substreams that follow the header.
+	r := &byteReader{data: data, pos: sizeOfDBIHeader}
+	sizes := []int32{
+		hdr.ModInfoSize, hdr.SectionContributionSize, hdr.SectionMapSize,
+		hdr.SourceInfoSize, hdr.TypeServerMapSize, hdr.ECSubstreamSize,
+		hdr.OptionalDbgHeaderSize,
+	}
+	substreams := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		substreams[i] = r.take(int(size))
+	}
+	if r.bad {
+		return ErrInvalidPDB
+	}
+	modInfo, dbgHeader := substreams[0], substreams[6]
+
+	if err := f.readModules(modInfo); err != nil {
+		return err
+	}
+
+	// The optional debug header contains the stream indices of additional
+	// debug data, such as the section headers of the image.
+	if len(dbgHeader) >= 2*(dbgHeaderSectionHdr+1) {
+		index := binary.LittleEndian.Uint16(dbgHeader[2*dbgHeaderSectionHdr:])
+		if index != noStream {
+			if err := f.readSectionHeaders(uint32(index)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readModules reads the module info substream of the DBI stream.
+func (f *File) readModules(data []byte) error {
+	r := &byteReader{data: data}
+	for r.remaining() > 0 {
+		r.take(4)  // Unused
+		r.take(28) // SectionContr
+		r.u16()    // Flags
+		mod := Module{}
+		mod.stream = r.u16()
+		mod.symSize = r.u32()
+		mod.c11Size = r.u32()
+		mod.c13Size = r.u32()
+		mod.numSources = r.u16()
+		r.take(2)  // Padding
+		r.take(12) // Unused, SourceFileNameIndex, PdbFilePathNameIndex
+		mod.Name = r.cstring()
+		mod.ObjFile = r.cstring()
+		r.align(4)
+		if r.bad {
+			return ErrInvalidPDB
+		}
+		f.Modules = append(f.Modules, mod)
+	}
+	return nil
+}
+
+// readSectionHeaders reads the section headers of the image from a stream.
+func (f *File) readSectionHeaders(index uint32) error {
+	data, err := f.msf.streamBytes(index)
+	if err != nil {
+		return err
+	}
+	if len(data)/40 > pe.MaxNumSections {
+		return ErrInvalidPDB
+	}
+	f.Sections = make([]pe.ImageSectionHeader, len(data)/40)
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, f.Sections)
+}
@@ -0,0 +1,28 @@
+//go:build go1.18
+// +build go1.18
+
+package pdb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func FuzzOpen(f *testing.F) {
+	tiny, err := ioutil.ReadFile("testdata/tiny.pdb")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(tiny)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := Open(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		p.Lookup(1, 0x10)
+		p.LookupRVA(0x1000)
+		p.Publics()
+		p.Globals()
+	})
+}
@@ -0,0 +1,179 @@
+package pdb
+
+import (
+	"sort"
+)
+
+// Enumeration of C13 debug subsection kinds.
+const (
+	debugSSymbols       = 0xF1
+	debugSLines         = 0xF2
+	debugSStringTable   = 0xF3
+	debugSFileChecksums = 0xF4
+	debugSIgnore        = 0x80000000
+)
+
+// cvSignatureC13 is the signature of module streams with C13 line
+// information.
+const cvSignatureC13 = 4
+
+// linesHaveColumns is set in the flags of a lines subsection when it has
+// column information.
+const linesHaveColumns = 0x0001
+
+// Line maps an address to a line of a source file.
+type Line struct {
+	// Segment is the index of the section that contains the address,
+	// starting at 1, and Offset is its offset within the section.
+	Segment uint16
+	Offset  uint32
+
+	// File is the path of the source file, and Line is the line number.
+	File string
+	Line uint32
+
+	// Module is the name of the module the line belongs to.
+	Module string
+
+	// end is the end of the code covered by the block the line is in.
+	end uint32
+}
+
+// readModule reads the procedure symbols and line information of a module.
+func (f *File) readModule(mod *Module, names []byte) ([]Symbol, []Line, error) {
+	if mod.stream == noStream {
+		return nil, nil, nil
+	}
+	data, err := f.msf.streamBytes(uint32(mod.stream))
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &byteReader{data: data}
+	symbols := r.take(int(mod.symSize))
+	r.take(int(mod.c11Size))
+	c13 := r.take(int(mod.c13Size))
+	if r.bad || len(symbols) < 4 {
+		return nil, nil, ErrInvalidPDB
+	}
+	if (&byteReader{data: symbols}).u32() != cvSignatureC13 {
+		return nil, nil, ErrUnsupportedVersion
+	}
+
+	procs := []Symbol{}
+	bad := false
+	err = forEachSymbol(symbols[4:], func(kind uint16, r *byteReader) {
+		switch kind {
+		case SGProc32, SLProc32, SGProc32ID, SLProc32ID, SLProc32DPC:
+			sym, ok := procSymbol(kind, r)
+			if !ok {
+				bad = true
+			}
+			sym.Module = mod.Name
+			procs = append(procs, sym)
+		}
+	})
+	if err == nil && bad {
+		err = ErrInvalidPDB
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines, err := readLines(c13, names, mod.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return procs, lines, nil
+}
+
+// readLines reads the C13 line information of a module.
+func readLines(data []byte, names []byte, module string) ([]Line, error) {
+	// Split the data into subsections.
+	type subsection struct {
+		kind uint32
+		data []byte
+	}
+	subsections := []subsection{}
+	r := &byteReader{data: data}
+	for r.remaining() >= 8 {
+		kind, length := r.u32(), r.u32()
+		ss := subsection{kind: kind, data: r.take(int(length))}
+		r.align(4)
+		if r.bad || length > uint32(len(data)) {
+			return nil, ErrInvalidPDB
+		}
+		if kind&debugSIgnore == 0 {
+			subsections = append(subsections, ss)
+		}
+	}
+
+	// File checksums map offsets of entries to file names, which are
+	// offsets into the string table.
+	files := map[uint32]string{}
+	for _, ss := range subsections {
+		if ss.kind != debugSFileChecksums {
+			continue
+		}
+		r := &byteReader{data: ss.data}
+		for r.remaining() >= 6 {
+			off := uint32(r.pos)
+			nameOff := r.u32()
+			size := r.u8()
+			r.u8() // Kind
+			r.take(int(size))
+			r.align(4)
+			name, ok := cstringAt(names, nameOff)
+			if r.bad || !ok {
+				return nil, ErrInvalidPDB
+			}
+			files[off] = name
+		}
+	}
+
+	lines := []Line{}
+	for _, ss := range subsections {
+		if ss.kind != debugSLines {
+			continue
+		}
+		r := &byteReader{data: ss.data}
+		offset, segment, flags, size := r.u32(), r.u16(), r.u16(), r.u32()
+		end := offset + size
+		for r.remaining() >= 12 {
+			file, numLines, _ := r.u32(), r.u32(), r.u32()
+			name, ok := files[file]
+			if !ok || uint64(numLines)*8 > uint64(r.remaining()) {
+				return nil, ErrInvalidPDB
+			}
+			for i := uint32(0); i < numLines; i++ {
+				lineOff, lineFlags := r.u32(), r.u32()
+				lines = append(lines, Line{
+					Segment: segment,
+					Offset:  offset + lineOff,
+					File:    name,
+					Line:    lineFlags & 0xFFFFFF,
+					Module:  module,
+					end:     end,
+				})
+			}
+			if flags&linesHaveColumns != 0 {
+				r.take(int(numLines) * 4)
+			}
+		}
+		if r.bad {
+			return nil, ErrInvalidPDB
+		}
+	}
+	return lines, nil
+}
+
+// findLine returns the line of an address in a sorted list of lines.
+func findLine(lines []Line, segment uint16, offset uint32) *Line {
+	i := sort.Search(len(lines), func(i int) bool {
+		l := lines[i]
+		return l.Segment > segment || (l.Segment == segment && l.Offset > offset)
+	}) - 1
+	if i < 0 || lines[i].Segment != segment || offset >= lines[i].end {
+		return nil
+	}
+	return &lines[i]
+}
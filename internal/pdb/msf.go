@@ -0,0 +1,213 @@
+package pdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrBadMSFSignature is returned when the MSF superblock signature is
+	// invalid.
+	ErrBadMSFSignature = errors.New("pdb: bad msf signature")
+
+	// ErrInvalidMSF is returned when the MSF container is malformed.
+	ErrInvalidMSF = errors.New("pdb: invalid msf container")
+
+	// ErrNoStream is returned when a stream does not exist.
+	ErrNoStream = errors.New("pdb: stream does not exist")
+)
+
+// msfMagic is the signature at the start of an MSF 7.0 container.
+var msfMagic = []byte("Microsoft C/C++ MSF 7.00\r\n\x1aDS\x00\x00\x00")
+
+// Resource limits for parsing PDB files. Files that exceed these limits are
+// rejected, to bound the work done on crafted input.
+const (
+	// MaxStreams is the maximum number of streams in an MSF container.
+	MaxStreams = 0x10000
+
+	// MaxStreamSize is the maximum size of a stream that is read into
+	// memory.
+	MaxStreamSize = 1 << 28
+)
+
+// nilStreamSize is the size of a stream that does not exist.
+const nilStreamSize = 0xFFFFFFFF
+
+// msfSuperBlock is the header of an MSF container.
+type msfSuperBlock struct {
+	Magic             [32]byte
+	BlockSize         uint32
+	FreeBlockMapBlock uint32
+	NumBlocks         uint32
+	NumDirectoryBytes uint32
+	Unknown           uint32
+	BlockMapAddr      uint32
+}
+
+// msfFile is an MSF container, which is a simple file system made up of
+// numbered streams, each stored in a list of fixed-size blocks.
+type msfFile struct {
+	r         io.ReaderAt
+	blockSize uint32
+	numBlocks uint32
+	streams   []*stream
+}
+
+// stream is a stream of an MSF container.
+type stream struct {
+	msf    *msfFile
+	size   uint32
+	blocks []uint32
+}
+
+// ReadAt implements io.ReaderAt.
+func (s *stream) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, ErrInvalidMSF
+	}
+	n := 0
+	bs := int64(s.msf.blockSize)
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= int64(s.size) {
+			return n, io.EOF
+		}
+		block := s.blocks[pos/bs]
+		chunk := p[n:]
+		if rem := bs - pos%bs; int64(len(chunk)) > rem {
+			chunk = chunk[:rem]
+		}
+		if rem := int64(s.size) - pos; int64(len(chunk)) > rem {
+			chunk = chunk[:rem]
+		}
+		m, err := s.msf.r.ReadAt(chunk, int64(block)*bs+pos%bs)
+		n += m
+		if m < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = ErrInvalidMSF
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// bytes reads the entire stream into memory.
+func (s *stream) bytes() ([]byte, error) {
+	if s.size > MaxStreamSize {
+		return nil, ErrInvalidMSF
+	}
+
+	// Make sure the end of the stream is in the file before allocating.
+	if s.size > 0 {
+		if _, err := s.ReadAt(make([]byte, 1), int64(s.size-1)); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	data := make([]byte, s.size)
+	if _, err := s.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// blocksFor returns the number of blocks needed to hold size bytes.
+func (f *msfFile) blocksFor(size uint32) uint32 {
+	return uint32((uint64(size) + uint64(f.blockSize) - 1) / uint64(f.blockSize))
+}
+
+// readBlockList reads a list of n block indices from r, validating them.
+func (f *msfFile) readBlockList(r io.Reader, n uint32) ([]uint32, error) {
+	if n > f.numBlocks {
+		return nil, ErrInvalidMSF
+	}
+	blocks := make([]uint32, n)
+	if err := binary.Read(r, binary.LittleEndian, blocks); err != nil {
+		return nil, ErrInvalidMSF
+	}
+	for _, block := range blocks {
+		if block >= f.numBlocks {
+			return nil, ErrInvalidMSF
+		}
+	}
+	return blocks, nil
+}
+
+// openMSF parses the superblock and stream directory of an MSF container.
+func openMSF(r io.ReaderAt) (*msfFile, error) {
+	sb := msfSuperBlock{}
+	if err := binary.Read(io.NewSectionReader(r, 0, 56), binary.LittleEndian, &sb); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sb.Magic[:], msfMagic) {
+		return nil, ErrBadMSFSignature
+	}
+	switch sb.BlockSize {
+	case 512, 1024, 2048, 4096, 8192, 16384, 32768:
+	default:
+		return nil, ErrInvalidMSF
+	}
+	if sb.BlockMapAddr >= sb.NumBlocks || sb.NumDirectoryBytes > MaxStreamSize {
+		return nil, ErrInvalidMSF
+	}
+	f := &msfFile{r: r, blockSize: sb.BlockSize, numBlocks: sb.NumBlocks}
+
+	// The block map lists the blocks of the stream directory.
+	mapReader := io.NewSectionReader(r, int64(sb.BlockMapAddr)*int64(sb.BlockSize), int64(sb.BlockSize))
+	dirBlocks, err := f.readBlockList(mapReader, f.blocksFor(sb.NumDirectoryBytes))
+	if err != nil {
+		return nil, err
+	}
+	dir := &stream{msf: f, size: sb.NumDirectoryBytes, blocks: dirBlocks}
+	data, err := dir.bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory contains the number of streams, the size of each stream
+	// and then the blocks of each stream.
+	dr := bytes.NewReader(data)
+	numStreams := uint32(0)
+	if err := binary.Read(dr, binary.LittleEndian, &numStreams); err != nil {
+		return nil, ErrInvalidMSF
+	}
+	if numStreams > MaxStreams {
+		return nil, ErrInvalidMSF
+	}
+	sizes := make([]uint32, numStreams)
+	if err := binary.Read(dr, binary.LittleEndian, sizes); err != nil {
+		return nil, ErrInvalidMSF
+	}
+	f.streams = make([]*stream, numStreams)
+	for i, size := range sizes {
+		if size == nilStreamSize {
+			continue
+		}
+		blocks, err := f.readBlockList(dr, f.blocksFor(size))
+		if err != nil {
+			return nil, err
+		}
+		f.streams[i] = &stream{msf: f, size: size, blocks: blocks}
+	}
+	return f, nil
+}
+
+// stream returns a stream by index.
+func (f *msfFile) stream(index uint32) (*stream, error) {
+	if index >= uint32(len(f.streams)) || f.streams[index] == nil {
+		return nil, ErrNoStream
+	}
+	return f.streams[index], nil
+}
+
+// streamBytes reads a stream by index into memory.
+func (f *msfFile) streamBytes(index uint32) ([]byte, error) {
+	s, err := f.stream(index)
+	if err != nil {
+		return nil, err
+	}
+	return s.bytes()
+}
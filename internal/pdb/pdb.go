@@ -0,0 +1,305 @@
+// Package pdb implements a reader for Program Database (PDB) files, which
+// contain the debugging information for Windows images. It supports looking
+// up the function, source file and line of a code address.
+package pdb
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+var (
+	// ErrInvalidPDB is returned when a PDB stream is malformed.
+	ErrInvalidPDB = errors.New("pdb: invalid pdb")
+
+	// ErrUnsupportedVersion is returned when the PDB or DBI stream has an
+	// unsupported version.
+	ErrUnsupportedVersion = errors.New("pdb: unsupported version")
+)
+
+// Enumeration of fixed stream indices.
+const (
+	streamPDB = 1
+	streamTPI = 2
+	streamDBI = 3
+	streamIPI = 4
+)
+
+// pdbVersionVC70 is the PDB stream version written by all modern linkers.
+const pdbVersionVC70 = 20000404
+
+// File is a parsed PDB file. The headers and module list are parsed when the
+// file is opened, and symbols and line information are loaded on the first
+// lookup. A File is safe for concurrent use, provided the underlying
+// io.ReaderAt is.
+type File struct {
+	msf *msfFile
+
+	// Signature is the timestamp signature of the PDB, which identifies it
+	// in NB10 CodeView records.
+	Signature uint32
+
+	// Age is the age of the PDB, which is incremented each time it is
+	// updated.
+	Age uint32
+
+	// GUID is the signature of the PDB, which identifies it in RSDS
+	// CodeView records.
+	GUID pe.GUID
+
+	// Machine is the machine type of the image, one of the
+	// pe.ImageFileMachine* values.
+	Machine uint16
+
+	// Modules contains the modules, or object files, that were linked into
+	// the image.
+	Modules []Module
+
+	// Sections contains the section headers of the image, if the PDB has
+	// them.
+	Sections []pe.ImageSectionHeader
+
+	// streams maps the names of named streams to their indices.
+	streams map[string]uint32
+
+	// symRecordStream is the index of the symbol record stream, which holds
+	// the records of the public and global symbol streams.
+	symRecordStream uint16
+
+	indexOnce sync.Once
+	index     *symbolIndex
+	indexErr  error
+}
+
+// Open parses a PDB file.
+func Open(r io.ReaderAt) (*File, error) {
+	msf, err := openMSF(r)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{msf: msf}
+	if err := f.readInfoStream(); err != nil {
+		return nil, err
+	}
+	if err := f.readDBIStream(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// readInfoStream reads the PDB info stream, which contains the signature of
+// the PDB and the map of named streams.
+func (f *File) readInfoStream() error {
+	data, err := f.msf.streamBytes(streamPDB)
+	if err != nil {
+		return err
+	}
+	r := &byteReader{data: data}
+	version := r.u32()
+	f.Signature = r.u32()
+	f.Age = r.u32()
+	f.GUID.Data1 = r.u32()
+	f.GUID.Data2 = r.u16()
+	f.GUID.Data3 = r.u16()
+	copy(f.GUID.Data4[:], r.take(8))
+	if r.bad {
+		return ErrInvalidPDB
+	}
+	if version < pdbVersionVC70 {
+		return ErrUnsupportedVersion
+	}
+
+	// The named stream map is a string buffer followed by a hash table
+	// mapping offsets in the buffer to stream indices.
+	names := r.take(int(r.u32()))
+	size, capacity := r.u32(), r.u32()
+	present := readBitVector(r)
+	readBitVector(r)
+	if r.bad || size > capacity || capacity > MaxStreams {
+		return ErrInvalidPDB
+	}
+	f.streams = map[string]uint32{}
+	for i := uint32(0); i < capacity; i++ {
+		if !present.get(i) {
+			continue
+		}
+		key, value := r.u32(), r.u32()
+		name, ok := cstringAt(names, key)
+		if r.bad || !ok {
+			return ErrInvalidPDB
+		}
+		f.streams[name] = value
+	}
+	return nil
+}
+
+// bitVector is a serialized bit vector.
+type bitVector []uint32
+
+// readBitVector reads a bit vector, which is a word count followed by the
+// words.
+func readBitVector(r *byteReader) bitVector {
+	n := r.u32()
+	if n > MaxStreams {
+		r.bad = true
+		return nil
+	}
+	v := make(bitVector, n)
+	for i := range v {
+		v[i] = r.u32()
+	}
+	return v
+}
+
+func (v bitVector) get(i uint32) bool {
+	return i/32 < uint32(len(v)) && v[i/32]&(1<<(i%32)) != 0
+}
+
+// Matches returns whether the PDB is the one identified by a CodeView record.
+func (f *File) Matches(cv *pe.CodeViewInfo) bool {
+	switch cv.Signature {
+	case pe.CodeViewSignatureRSDS:
+		return cv.GUID == f.GUID && cv.Age == f.Age
+	case pe.CodeViewSignatureNB10:
+		return cv.Timestamp == f.Signature && cv.Age == f.Age
+	}
+	return false
+}
+
+// stringTable reads the /names stream, which is a table of strings referred
+// to by offset.
+func (f *File) stringTable() ([]byte, error) {
+	index, ok := f.streams["/names"]
+	if !ok {
+		return nil, nil
+	}
+	data, err := f.msf.streamBytes(index)
+	if err != nil {
+		return nil, err
+	}
+	r := &byteReader{data: data}
+	signature, _, size := r.u32(), r.u32(), r.u32()
+	names := r.take(int(size))
+	if r.bad || signature != 0xEFFEEFFE {
+		return nil, ErrInvalidPDB
+	}
+	return names, nil
+}
+
+// Location is the result of looking up an address.
+type Location struct {
+	// Function is the name of the function that contains the address, or
+	// the nearest preceding public symbol if no function contains it.
+	Function string
+
+	// Offset is the offset of the address from the start of Function.
+	Offset uint32
+
+	// Module is the name of the module, or object file, that contains the
+	// address, if it is known.
+	Module string
+
+	// File and Line are the source file and line of the address. They are
+	// empty if there is no line information for it.
+	File string
+	Line uint32
+}
+
+// Lookup returns the location of an address, given as a section index
+// starting at 1 and an offset within the section. It returns nil if there
+// is no symbol for the address.
+func (f *File) Lookup(segment uint16, offset uint32) (*Location, error) {
+	idx, err := f.symbolIndex()
+	if err != nil {
+		return nil, err
+	}
+	return idx.lookup(segment, offset), nil
+}
+
+// LookupRVA returns the location of an RVA, using the section headers in the
+// PDB to convert it to a section and offset. It returns nil if there is no
+// symbol for the address, and ErrNoStream if the PDB does not contain
+// section headers.
+func (f *File) LookupRVA(rva uint32) (*Location, error) {
+	if f.Sections == nil {
+		return nil, ErrNoStream
+	}
+	for i, s := range f.Sections {
+		size := s.PhysicalAddressOrVirtualSize
+		if size == 0 {
+			size = s.SizeOfRawData
+		}
+		if rva >= s.VirtualAddress && rva-s.VirtualAddress < size {
+			return f.Lookup(uint16(i+1), rva-s.VirtualAddress)
+		}
+	}
+	return nil, nil
+}
+
+// symbolIndex returns the symbol index, building it on first use.
+func (f *File) symbolIndex() (*symbolIndex, error) {
+	f.indexOnce.Do(func() {
+		f.index, f.indexErr = f.buildIndex()
+	})
+	return f.index, f.indexErr
+}
+
+// symbolIndex is a set of symbols and lines sorted by address.
+type symbolIndex struct {
+	procs   []Symbol
+	publics []Symbol
+	lines   []Line
+}
+
+// buildIndex loads the symbols and lines of every module, and the public
+// symbols.
+func (f *File) buildIndex() (*symbolIndex, error) {
+	idx := &symbolIndex{}
+	names, err := f.stringTable()
+	if err != nil {
+		return nil, err
+	}
+	for i := range f.Modules {
+		mod := &f.Modules[i]
+		procs, lines, err := f.readModule(mod, names)
+		if err != nil {
+			return nil, err
+		}
+		idx.procs = append(idx.procs, procs...)
+		idx.lines = append(idx.lines, lines...)
+	}
+	if idx.publics, err = f.Publics(); err != nil {
+		return nil, err
+	}
+	sortSymbols(idx.procs)
+	sortSymbols(idx.publics)
+	sort.SliceStable(idx.lines, func(i, j int) bool {
+		a, b := idx.lines[i], idx.lines[j]
+		return a.Segment < b.Segment || (a.Segment == b.Segment && a.Offset < b.Offset)
+	})
+	return idx, nil
+}
+
+// lookup returns the location of an address.
+func (idx *symbolIndex) lookup(segment uint16, offset uint32) *Location {
+	loc := &Location{}
+	if sym := findSymbol(idx.procs, segment, offset, true); sym != nil {
+		loc.Function, loc.Offset, loc.Module = sym.Name, offset-sym.Offset, sym.Module
+	} else if sym := findSymbol(idx.publics, segment, offset, false); sym != nil {
+		loc.Function, loc.Offset = sym.Name, offset-sym.Offset
+	}
+	if line := findLine(idx.lines, segment, offset); line != nil {
+		loc.File, loc.Line = line.File, line.Line
+		if loc.Module == "" {
+			loc.Module = line.Module
+		}
+	}
+	if loc.Function == "" && loc.File == "" {
+		return nil
+	}
+	return loc
+}
@@ -0,0 +1,130 @@
+package pdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/pe"
+)
+
+// testdata/tiny.pdb is generated from testdata/tiny.yaml; see the comment in
+// that file.
+func openTiny(t *testing.T) *File {
+	t.Helper()
+	r, err := os.Open("testdata/tiny.pdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	f, err := Open(r)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	return f
+}
+
+func TestOpen(t *testing.T) {
+	f := openTiny(t)
+	guid := pe.GUID{Data1: 0x12345678, Data2: 0x9ABC, Data3: 0xDEF0, Data4: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	if f.GUID != guid || f.Age != 3 || f.Signature != 1600000000 {
+		t.Errorf("unexpected signature %v age %d timestamp %d", f.GUID, f.Age, f.Signature)
+	}
+	if f.Machine != pe.ImageFileMachinei386 {
+		t.Errorf("unexpected machine %#x", f.Machine)
+	}
+	if len(f.Modules) != 2 || f.Modules[0].Name != `C:\build\tiny.obj` || f.Modules[1].ObjFile != `C:\build\util.obj` {
+		t.Errorf("unexpected modules %+v", f.Modules)
+	}
+	if !f.Matches(&pe.CodeViewInfo{Signature: pe.CodeViewSignatureRSDS, GUID: guid, Age: 3}) {
+		t.Error("expected PDB to match CodeView record")
+	}
+	if f.Matches(&pe.CodeViewInfo{Signature: pe.CodeViewSignatureRSDS, GUID: guid, Age: 4}) {
+		t.Error("expected PDB not to match CodeView record with different age")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	f := openTiny(t)
+	tests := []struct {
+		offset   uint32
+		function string
+		funcOff  uint32
+		file     string
+		line     uint32
+	}{
+		{0x00, "Add", 0, `C:\build\tiny.c`, 3},
+		{0x06, "Add", 6, `C:\build\tiny.c`, 4},
+		{0x14, "Sub", 4, `C:\build\tiny.c`, 9},
+		{0x22, "helper", 2, `C:\build\util.c`, 20},
+	}
+	for _, test := range tests {
+		loc, err := f.Lookup(1, test.offset)
+		if err != nil {
+			t.Fatalf("expected nil error got %v", err)
+		}
+		if loc == nil {
+			t.Errorf("%#x: expected location", test.offset)
+			continue
+		}
+		if loc.Function != test.function || loc.Offset != test.funcOff || loc.File != test.file || loc.Line != test.line {
+			t.Errorf("%#x: unexpected location %+v", test.offset, loc)
+		}
+	}
+
+	if loc, _ := f.Lookup(1, 0x28); loc != nil {
+		t.Errorf("expected no location past the end of code got %+v", loc)
+	}
+	if loc, _ := f.Lookup(2, 0); loc != nil {
+		t.Errorf("expected no location in other section got %+v", loc)
+	}
+	if _, err := f.LookupRVA(0x1000); err != ErrNoStream {
+		t.Errorf("expected ErrNoStream without section headers got %v", err)
+	}
+}
+
+func TestPublicSymbols(t *testing.T) {
+	records := &bytes.Buffer{}
+	for i, name := range []string{"_Add@8", "_Sub@8"} {
+		record := &bytes.Buffer{}
+		binary.Write(record, binary.LittleEndian, struct {
+			Kind    uint16
+			Flags   uint32
+			Offset  uint32
+			Segment uint16
+		}{SPub32, 2, uint32(i * 0x10), 1})
+		record.WriteString(name + "\x00")
+		binary.Write(records, binary.LittleEndian, uint16(record.Len()))
+		records.Write(record.Bytes())
+	}
+
+	syms := []Symbol{}
+	err := forEachSymbol(records.Bytes(), func(kind uint16, r *byteReader) {
+		if sym, ok := dataSymbol(kind, r); ok {
+			syms = append(syms, sym)
+		}
+	})
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(syms) != 2 || syms[1].Name != "_Sub@8" || syms[1].Offset != 0x10 || syms[1].Segment != 1 {
+		t.Fatalf("unexpected symbols %+v", syms)
+	}
+
+	// Public symbols are used for addresses without procedure symbols.
+	idx := &symbolIndex{publics: syms}
+	if loc := idx.lookup(1, 0x18); loc == nil || loc.Function != "_Sub@8" || loc.Offset != 8 {
+		t.Errorf("unexpected location %+v", loc)
+	}
+
+	if err := forEachSymbol([]byte{0xFF, 0x00, 0x0E, 0x11}, func(uint16, *byteReader) {}); err != ErrInvalidPDB {
+		t.Errorf("expected ErrInvalidPDB for truncated record got %v", err)
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	if _, err := Open(bytes.NewReader(make([]byte, 64))); err != ErrBadMSFSignature {
+		t.Errorf("expected ErrBadMSFSignature got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package pdb
+
+import (
+	"sort"
+)
+
+// Enumeration of CodeView symbol record kinds.
+const (
+	SEnd        = 0x0006
+	SLData32    = 0x110C
+	SGData32    = 0x110D
+	SPub32      = 0x110E
+	SLProc32    = 0x110F
+	SGProc32    = 0x1110
+	SLThread32  = 0x1112
+	SGThread32  = 0x1113
+	SProcRef    = 0x1125
+	SLProcRef   = 0x1127
+	SLProc32ID  = 0x1146
+	SGProc32ID  = 0x1147
+	SLProc32DPC = 0x1155
+)
+
+// Symbol is a named address in the image.
+type Symbol struct {
+	// Kind is the kind of the symbol record, one of the S* values.
+	Kind uint16
+
+	// Name is the name of the symbol. Public symbols have decorated names.
+	Name string
+
+	// Segment is the index of the section that contains the symbol,
+	// starting at 1, and Offset is its offset within the section.
+	Segment uint16
+	Offset  uint32
+
+	// Size is the size of the symbol, if it is known.
+	Size uint32
+
+	// Module is the name of the module that defines the symbol, if it is
+	// known.
+	Module string
+}
+
+// Contains returns whether the symbol contains an address.
+func (s *Symbol) Contains(segment uint16, offset uint32) bool {
+	return s.Segment == segment && offset >= s.Offset && offset-s.Offset < s.Size
+}
+
+// forEachSymbol calls fn for each symbol record in data. fn is passed a
+// reader over the record data that follows the kind.
+func forEachSymbol(data []byte, fn func(kind uint16, r *byteReader)) error {
+	r := &byteReader{data: data}
+	for r.remaining() >= 4 {
+		length := int(r.u16())
+		if length < 2 || length > r.remaining() {
+			return ErrInvalidPDB
+		}
+		record := r.take(length)
+		fn(uint16(record[0])|uint16(record[1])<<8, &byteReader{data: record[2:]})
+	}
+	return nil
+}
+
+// procSymbol decodes a procedure symbol record.
+func procSymbol(kind uint16, r *byteReader) (Symbol, bool) {
+	r.take(12) // Parent, End, Next
+	sym := Symbol{Kind: kind, Size: r.u32()}
+	r.take(12) // DbgStart, DbgEnd, FunctionType
+	sym.Offset = r.u32()
+	sym.Segment = r.u16()
+	r.u8() // Flags
+	sym.Name = r.cstring()
+	return sym, !r.bad
+}
+
+// dataSymbol decodes a public, data or thread storage symbol record.
+func dataSymbol(kind uint16, r *byteReader) (Symbol, bool) {
+	r.u32() // Flags or Type
+	sym := Symbol{Kind: kind, Offset: r.u32(), Segment: r.u16()}
+	sym.Name = r.cstring()
+	return sym, !r.bad
+}
+
+// symbolRecords returns the records of the symbol record stream with the
+// specified kinds. The symbol record stream holds the records of both the
+// public and global symbol streams.
+func (f *File) symbolRecords(kinds ...uint16) ([]Symbol, error) {
+	if f.symRecordStream == noStream {
+		return nil, nil
+	}
+	data, err := f.msf.streamBytes(uint32(f.symRecordStream))
+	if err != nil {
+		return nil, err
+	}
+	syms := []Symbol{}
+	bad := false
+	err = forEachSymbol(data, func(kind uint16, r *byteReader) {
+		for _, k := range kinds {
+			if k == kind {
+				sym, ok := dataSymbol(kind, r)
+				if !ok {
+					bad = true
+				}
+				syms = append(syms, sym)
+			}
+		}
+	})
+	if err == nil && bad {
+		err = ErrInvalidPDB
+	}
+	return syms, err
+}
+
+// Publics returns the public symbols, which are the symbols visible to the
+// linker, with decorated names.
+func (f *File) Publics() ([]Symbol, error) {
+	return f.symbolRecords(SPub32)
+}
+
+// Globals returns the global and file-static data symbols.
+func (f *File) Globals() ([]Symbol, error) {
+	return f.symbolRecords(SGData32, SLData32, SGThread32, SLThread32)
+}
+
+// sortSymbols sorts symbols by address.
+func sortSymbols(syms []Symbol) {
+	sort.SliceStable(syms, func(i, j int) bool {
+		a, b := syms[i], syms[j]
+		return a.Segment < b.Segment || (a.Segment == b.Segment && a.Offset < b.Offset)
+	})
+}
+
+// findSymbol returns the last symbol in a sorted list at or before an
+// address. If contain is set, the symbol must also contain the address.
+func findSymbol(syms []Symbol, segment uint16, offset uint32, contain bool) *Symbol {
+	i := sort.Search(len(syms), func(i int) bool {
+		s := syms[i]
+		return s.Segment > segment || (s.Segment == segment && s.Offset > offset)
+	}) - 1
+	if i < 0 || syms[i].Segment != segment {
+		return nil
+	}
+	if contain && !syms[i].Contains(segment, offset) {
+		return nil
+	}
+	return &syms[i]
+}
@@ -0,0 +1,93 @@
+package pdb
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// byteReader reads little-endian values from a byte slice. Reads past the
+// end of the data set a sticky error flag and return zero values, so that
+// parsers can check for errors once after reading a structure.
+type byteReader struct {
+	data []byte
+	pos  int
+	bad  bool
+}
+
+// take returns the next n bytes, or nil if there are not enough.
+func (r *byteReader) take(n int) []byte {
+	if r.bad || n < 0 || n > len(r.data)-r.pos {
+		r.bad = true
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *byteReader) u8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *byteReader) u16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *byteReader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+// cstring reads a null-terminated string.
+func (r *byteReader) cstring() string {
+	if r.bad {
+		return ""
+	}
+	n := bytes.IndexByte(r.data[r.pos:], 0)
+	if n < 0 {
+		r.bad = true
+		return ""
+	}
+	s := string(r.data[r.pos : r.pos+n])
+	r.pos += n + 1
+	return s
+}
+
+// align advances to the next multiple of n, or to the end of the data.
+func (r *byteReader) align(n int) {
+	if rem := r.pos % n; rem != 0 {
+		r.pos += n - rem
+		if r.pos > len(r.data) {
+			r.pos = len(r.data)
+		}
+	}
+}
+
+// remaining returns the number of unread bytes, or zero after a read past
+// the end.
+func (r *byteReader) remaining() int {
+	if r.bad {
+		return 0
+	}
+	return len(r.data) - r.pos
+}
+
+// cstringAt returns the null-terminated string at offset off of data.
+func cstringAt(data []byte, off uint32) (string, bool) {
+	if uint64(off) >= uint64(len(data)) {
+		return "", false
+	}
+	n := bytes.IndexByte(data[off:], 0)
+	if n < 0 {
+		return "", false
+	}
+	return string(data[off : off+uint32(n)]), true
+}
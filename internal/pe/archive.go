@@ -0,0 +1,429 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrBadArchiveSignature is returned when the archive signature is
+	// invalid.
+	ErrBadArchiveSignature = errors.New("pe: bad archive signature")
+
+	// ErrInvalidArchive is returned when an archive is malformed.
+	ErrInvalidArchive = errors.New("pe: invalid archive")
+
+	// ErrInvalidImportObject is returned when a short import object is
+	// malformed.
+	ErrInvalidImportObject = errors.New("pe: invalid import object")
+)
+
+// ArchiveMember is a member of an archive, such as an object file or a short
+// import object.
+type ArchiveMember struct {
+	// Name is the name of the member, resolved from the longnames member if
+	// it is a long name.
+	Name string
+
+	// Date is the modification time of the member in seconds since the Unix
+	// epoch.
+	Date int64
+
+	// Mode is the file mode of the member.
+	Mode uint32
+
+	// HeaderOffset is the offset of the member header in the archive. The
+	// linker members refer to members by this offset.
+	HeaderOffset int64
+
+	// Offset and Size specify the location of the member data in the
+	// archive.
+	Offset int64
+	Size   int64
+}
+
+// ArchiveSymbol is a public symbol listed in the linker members of an
+// archive.
+type ArchiveSymbol struct {
+	Name string
+
+	// Member is the index of the member that defines the symbol.
+	Member int
+}
+
+// Archive is a parsed archive (.lib) file, such as a static library or an
+// import library.
+type Archive struct {
+	// Members contains the members of the archive, excluding the linker and
+	// longnames members.
+	Members []ArchiveMember
+
+	// Symbols contains the public symbols of the archive, from the second
+	// linker member if there is one or the first one otherwise.
+	Symbols []ArchiveSymbol
+
+	r io.ReaderAt
+}
+
+// LoadArchive parses an archive file.
+func LoadArchive(r io.ReaderAt) (*Archive, error) {
+	sig := [8]byte{}
+	if _, err := r.ReadAt(sig[:], 0); err != nil {
+		return nil, err
+	}
+	if sig != ImageArchiveStart {
+		return nil, ErrBadArchiveSignature
+	}
+
+	a := &Archive{r: r}
+	var linker [][]byte
+	var longnames []byte
+	for off := int64(len(sig)); ; {
+		hdr := ImageArchiveMemberHeader{}
+		buf := [SizeOfImageArchiveMemberHeader]byte{}
+		n, err := r.ReadAt(buf[:], off)
+		if n == 0 && err == io.EOF {
+			break
+		}
+		if n < len(buf) {
+			return nil, ErrInvalidArchive
+		}
+		binary.Read(bytes.NewReader(buf[:]), binary.LittleEndian, &hdr)
+		if hdr.EndHeader != ImageArchiveEnd {
+			return nil, ErrInvalidArchive
+		}
+		size, err := strconv.ParseInt(archiveField(hdr.Size[:]), 10, 64)
+		if err != nil || size < 0 {
+			return nil, ErrInvalidArchive
+		}
+		member := ArchiveMember{
+			HeaderOffset: off,
+			Offset:       off + SizeOfImageArchiveMemberHeader,
+			Size:         size,
+		}
+		member.Date, _ = strconv.ParseInt(archiveField(hdr.Date[:]), 10, 64)
+		if mode, err := strconv.ParseUint(archiveField(hdr.Mode[:]), 8, 32); err == nil {
+			member.Mode = uint32(mode)
+		}
+
+		// Make sure the member data is in the file.
+		if size > 0 {
+			if _, err := r.ReadAt(make([]byte, 1), member.Offset+size-1); err != nil {
+				return nil, ErrInvalidArchive
+			}
+		}
+
+		name := archiveField(hdr.Name[:])
+		switch {
+		case name == ImageArchiveLinkerMember && len(a.Members) == 0 && len(linker) < 2:
+			data, err := a.readMember(&member)
+			if err != nil {
+				return nil, err
+			}
+			linker = append(linker, data)
+		case name == ImageArchiveLongnamesMember && len(a.Members) == 0 && longnames == nil:
+			if longnames, err = a.readMember(&member); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(name, "/<"):
+			// Special members, such as the symbol maps of hybrid ARM64EC
+			// libraries.
+		default:
+			if member.Name, err = archiveMemberName(name, longnames); err != nil {
+				return nil, err
+			}
+			if len(a.Members) >= MaxArchiveMembers {
+				return nil, ErrInvalidArchive
+			}
+			a.Members = append(a.Members, member)
+		}
+
+		off = member.Offset + size
+		off += off & 1
+	}
+
+	var err error
+	switch len(linker) {
+	case 1:
+		a.Symbols, err = a.parseFirstLinkerMember(linker[0])
+	case 2:
+		a.Symbols, err = a.parseSecondLinkerMember(linker[1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// archiveField returns the value of a text field of an archive member
+// header.
+func archiveField(b []byte) string {
+	return strings.TrimRight(string(b), " ")
+}
+
+// archiveMemberName returns the name of a member from the name field of its
+// header. Names of the form "/n" are at offset n in the longnames member,
+// terminated by a null byte or "/\n". Other names end with a slash.
+func archiveMemberName(name string, longnames []byte) (string, error) {
+	if strings.HasPrefix(name, "/") && len(name) > 1 {
+		off, err := strconv.ParseUint(name[1:], 10, 32)
+		if err != nil || off >= uint64(len(longnames)) {
+			return "", ErrInvalidArchive
+		}
+		s := longnames[off:]
+		if n := bytes.IndexAny(s, "\x00\n"); n >= 0 {
+			s = s[:n]
+		}
+		return strings.TrimSuffix(string(s), "/"), nil
+	}
+	return strings.TrimSuffix(name, "/"), nil
+}
+
+// readMember reads the data of a linker or longnames member.
+func (a *Archive) readMember(m *ArchiveMember) ([]byte, error) {
+	if m.Size > MaxStringTableSize {
+		return nil, ErrInvalidArchive
+	}
+	data := make([]byte, m.Size)
+	if _, err := a.r.ReadAt(data, m.Offset); err != nil {
+		return nil, ErrInvalidArchive
+	}
+	return data, nil
+}
+
+// memberIndex returns a map from header offsets to member indices.
+func (a *Archive) memberIndex() map[int64]int {
+	index := make(map[int64]int, len(a.Members))
+	for i, m := range a.Members {
+		index[m.HeaderOffset] = i
+	}
+	return index
+}
+
+// parseFirstLinkerMember parses the first linker member, which contains the
+// number of symbols, the header offset of the member defining each symbol
+// and the symbol names, with the numbers in big-endian byte order.
+func (a *Archive) parseFirstLinkerMember(data []byte) ([]ArchiveSymbol, error) {
+	if len(data) < 4 {
+		return nil, ErrInvalidArchive
+	}
+	count := binary.BigEndian.Uint32(data)
+	if count > MaxArchiveSymbols || uint64(count)*4 > uint64(len(data)-4) {
+		return nil, ErrInvalidArchive
+	}
+	offsets := data[4 : 4+count*4]
+	names := data[4+count*4:]
+	index := a.memberIndex()
+	syms := make([]ArchiveSymbol, 0, count)
+	for i := uint32(0); i < count; i++ {
+		member, ok := index[int64(binary.BigEndian.Uint32(offsets[i*4:]))]
+		if !ok {
+			return nil, ErrInvalidArchive
+		}
+		name, rest, err := archiveSymbolName(names)
+		if err != nil {
+			return nil, err
+		}
+		names = rest
+		syms = append(syms, ArchiveSymbol{Name: name, Member: member})
+	}
+	return syms, nil
+}
+
+// parseSecondLinkerMember parses the second linker member, which contains
+// the header offsets of the members, followed by the number of symbols, the
+// one-based index of the member defining each symbol and the sorted symbol
+// names, with the numbers in little-endian byte order.
+func (a *Archive) parseSecondLinkerMember(data []byte) ([]ArchiveSymbol, error) {
+	if len(data) < 4 {
+		return nil, ErrInvalidArchive
+	}
+	numMembers := binary.LittleEndian.Uint32(data)
+	if numMembers > MaxArchiveMembers || 8+uint64(numMembers)*4 > uint64(len(data)) {
+		return nil, ErrInvalidArchive
+	}
+	offsets := data[4 : 4+numMembers*4]
+	data = data[4+numMembers*4:]
+	count := binary.LittleEndian.Uint32(data)
+	if count > MaxArchiveSymbols || uint64(count)*2 > uint64(len(data)-4) {
+		return nil, ErrInvalidArchive
+	}
+	indices := data[4 : 4+count*2]
+	names := data[4+count*2:]
+	index := a.memberIndex()
+	syms := make([]ArchiveSymbol, 0, count)
+	for i := uint32(0); i < count; i++ {
+		n := uint32(binary.LittleEndian.Uint16(indices[i*2:]))
+		if n == 0 || n > numMembers {
+			return nil, ErrInvalidArchive
+		}
+		member, ok := index[int64(binary.LittleEndian.Uint32(offsets[(n-1)*4:]))]
+		if !ok {
+			return nil, ErrInvalidArchive
+		}
+		name, rest, err := archiveSymbolName(names)
+		if err != nil {
+			return nil, err
+		}
+		names = rest
+		syms = append(syms, ArchiveSymbol{Name: name, Member: member})
+	}
+	return syms, nil
+}
+
+// archiveSymbolName returns the null-terminated name at the start of b, and
+// the rest of b after it.
+func archiveSymbolName(b []byte) (string, []byte, error) {
+	n := bytes.IndexByte(b, 0)
+	if n < 0 {
+		return "", nil, ErrInvalidArchive
+	}
+	return string(b[:n]), b[n+1:], nil
+}
+
+// Open returns a reader for the data of a member.
+func (a *Archive) Open(m *ArchiveMember) *io.SectionReader {
+	return io.NewSectionReader(a.r, m.Offset, m.Size)
+}
+
+// IsImportObject returns whether a member is a short import object. Other
+// anonymous objects, such as bigobj and /GL objects, share the signature of
+// short import objects but have a nonzero version.
+func (a *Archive) IsImportObject(m *ArchiveMember) bool {
+	sig := [6]byte{}
+	if _, err := a.r.ReadAt(sig[:], m.Offset); err != nil {
+		return false
+	}
+	return sig == [6]byte{0x00, 0x00, 0xFF, 0xFF, 0x00, 0x00}
+}
+
+// ImportObject parses a member that is a short import object.
+func (a *Archive) ImportObject(m *ArchiveMember) (*ImportObject, error) {
+	return LoadImportObject(a.Open(m))
+}
+
+// Object parses a member that is a COFF object file.
+func (a *Archive) Object(m *ArchiveMember) (*Object, error) {
+	return LoadObject(a.Open(m))
+}
+
+// Imports returns the short import objects of an import library, which
+// describe the symbols imported from DLLs by linking against the library.
+// Other members, such as the import descriptors and other anonymous objects,
+// are skipped.
+func (a *Archive) Imports() ([]*ImportObject, error) {
+	imports := []*ImportObject{}
+	for i := range a.Members {
+		m := &a.Members[i]
+		if !a.IsImportObject(m) {
+			continue
+		}
+		imp, err := a.ImportObject(m)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, imp)
+	}
+	return imports, nil
+}
+
+// ImportObject is a decoded short import object.
+type ImportObject struct {
+	Header ImportObjectHeader
+
+	// Symbol is the public symbol defined by the import object, such as
+	// _Add@8. The import address table entry is defined as the symbol with
+	// the prefix __imp_.
+	Symbol string
+
+	// DLL is the name of the DLL that the symbol is imported from.
+	DLL string
+
+	// ExportName is the name the symbol is imported by, for the
+	// ImportObjectNameExportAs name type.
+	ExportName string
+}
+
+// LoadImportObject parses a short import object.
+func LoadImportObject(r io.ReaderAt) (*ImportObject, error) {
+	o := &ImportObject{}
+	if err := binary.Read(io.NewSectionReader(r, 0, SizeOfImportObjectHeader), binary.LittleEndian, &o.Header); err != nil {
+		return nil, err
+	}
+	if o.Header.Sig1 != ImageFileMachineUnknown || o.Header.Sig2 != 0xFFFF || o.Header.Version != 0 {
+		return nil, ErrInvalidImportObject
+	}
+	if o.Header.SizeOfData > 3*MaxNameLength {
+		return nil, ErrInvalidImportObject
+	}
+	data := make([]byte, o.Header.SizeOfData)
+	if _, err := r.ReadAt(data, SizeOfImportObjectHeader); err != nil {
+		return nil, ErrInvalidImportObject
+	}
+	strs := bytes.SplitN(data, []byte{0}, 4)
+	if len(strs) < 3 {
+		return nil, ErrInvalidImportObject
+	}
+	o.Symbol, o.DLL = string(strs[0]), string(strs[1])
+	if o.NameType() == ImportObjectNameExportAs {
+		if len(strs) < 4 {
+			return nil, ErrInvalidImportObject
+		}
+		o.ExportName = string(strs[2])
+	}
+	return o, nil
+}
+
+// Type returns the type of the import, one of the ImportObject* type values.
+func (o *ImportObject) Type() int {
+	return int(o.Header.TypeInfo & 3)
+}
+
+// NameType returns the name type of the import, one of the ImportObject*
+// name type values.
+func (o *ImportObject) NameType() int {
+	return int(o.Header.TypeInfo>>2) & 7
+}
+
+// ByOrdinal returns whether the symbol is imported by ordinal.
+func (o *ImportObject) ByOrdinal() bool {
+	return o.NameType() == ImportObjectOrdinal
+}
+
+// Ordinal returns the ordinal the symbol is imported by, if it is imported
+// by ordinal. Otherwise, it returns the hint into the export name table.
+func (o *ImportObject) Ordinal() uint16 {
+	return o.Header.OrdinalOrHint
+}
+
+// Name returns the name the symbol is imported by, or an empty string if it
+// is imported by ordinal.
+func (o *ImportObject) Name() string {
+	switch o.NameType() {
+	case ImportObjectOrdinal:
+		return ""
+	case ImportObjectNameNoPrefix:
+		return trimImportPrefix(o.Symbol)
+	case ImportObjectNameUndecorate:
+		name := trimImportPrefix(o.Symbol)
+		if i := strings.IndexByte(name, '@'); i >= 0 {
+			name = name[:i]
+		}
+		return name
+	case ImportObjectNameExportAs:
+		return o.ExportName
+	}
+	return o.Symbol
+}
+
+// trimImportPrefix removes a leading ?, @ or _ from a symbol name.
+func trimImportPrefix(name string) string {
+	if len(name) > 0 && strings.IndexByte("?@_", name[0]) >= 0 {
+		return name[1:]
+	}
+	return name
+}
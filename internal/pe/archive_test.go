@@ -0,0 +1,265 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// testdata/imports.lib is generated from testdata/imports.def; see the
+// comment in that file.
+func TestLoadArchive(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/imports.lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(a.Members) != 7 {
+		t.Fatalf("expected 7 members got %d", len(a.Members))
+	}
+	if a.Members[0].Name != "tiny.dll" {
+		t.Errorf("expected member name tiny.dll got %q", a.Members[0].Name)
+	}
+
+	// Long member names are resolved from the longnames member.
+	long := &a.Members[6]
+	if long.Name != "a_very_long_object_file_name.obj" {
+		t.Errorf("expected long member name got %q", long.Name)
+	}
+	obj, err := a.Object(long)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if obj.FileHeader.Machine != ImageFileMachinei386 {
+		t.Errorf("unexpected machine %04x", obj.FileHeader.Machine)
+	}
+
+	symbols := map[string]int{}
+	for _, sym := range a.Symbols {
+		symbols[sym.Name] = sym.Member
+	}
+	if m, ok := symbols["__imp__Add@8"]; !ok || !a.IsImportObject(&a.Members[m]) {
+		t.Errorf("expected __imp__Add@8 to be defined by an import object")
+	}
+	if m, ok := symbols["_CounterAddr"]; !ok || m != 6 {
+		t.Errorf("expected _CounterAddr to be defined by member 6, got %d", m)
+	}
+	if m, ok := symbols["__IMPORT_DESCRIPTOR_tiny"]; !ok || a.IsImportObject(&a.Members[m]) {
+		t.Errorf("expected import descriptor to be defined by an object")
+	}
+
+	imports, err := a.Imports()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	tests := []struct {
+		symbol    string
+		name      string
+		ordinal   uint16
+		byOrdinal bool
+		typ       int
+	}{
+		{"_Add@8", "Add", 1, false, ImportObjectCode},
+		{"_Counter", "Counter", 0, false, ImportObjectData},
+		{"_Hidden", "", 5, true, ImportObjectCode},
+	}
+	if len(imports) != len(tests) {
+		t.Fatalf("expected %d imports got %d", len(tests), len(imports))
+	}
+	for i, test := range tests {
+		imp := imports[i]
+		if imp.DLL != "tiny.dll" || imp.Symbol != test.symbol || imp.Name() != test.name ||
+			imp.Ordinal() != test.ordinal || imp.ByOrdinal() != test.byOrdinal || imp.Type() != test.typ {
+			t.Errorf("import %d: unexpected %+v with name %q", i, imp, imp.Name())
+		}
+	}
+}
+
+// TestImportLibraryExports checks the exports of tiny.dll against the imports
+// that the import library describes.
+func TestImportLibraryExports(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/imports.lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports, err := a.Imports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiny, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dll, err := NewFile(bytes.NewReader(tiny))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports, err := dll.Exports()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, imp := range imports {
+		if imp.Name() != "Add" {
+			continue
+		}
+		if exports.Proc(imp.Name()) == 0 {
+			t.Errorf("expected %s to be exported", imp.Name())
+		}
+	}
+}
+
+// archiveHeader returns an archive member header.
+func archiveHeader(name string, size int) []byte {
+	return []byte(fmt.Sprintf("%-16s%-12d%-6s%-6s%-8s%-10d`\n", name, 0, "", "", "644", size))
+}
+
+// TestLoadArchiveSecondLinkerMember checks that symbols are taken from the
+// second linker member, which MSVC writes in addition to the first one.
+func TestLoadArchiveSecondLinkerMember(t *testing.T) {
+	imp := &bytes.Buffer{}
+	binary.Write(imp, binary.LittleEndian, ImportObjectHeader{
+		Sig2:          0xFFFF,
+		Machine:       ImageFileMachineAMD64,
+		SizeOfData:    23,
+		OrdinalOrHint: 3,
+		TypeInfo:      ImportObjectNameExportAs << 2,
+	})
+	imp.WriteString("Alias\x00lib.dll\x00Exported\x00")
+
+	// The first linker member is left empty to check that it is not used.
+	first := make([]byte, 4)
+	names := "Alias\x00__imp_Alias\x00"
+	memberOffset := len(ImageArchiveStart) + 2*SizeOfImageArchiveMemberHeader + len(first) + 3*4 + 2*2 + len(names)
+	second := &bytes.Buffer{}
+	binary.Write(second, binary.LittleEndian, []uint32{1, uint32(memberOffset), 2})
+	binary.Write(second, binary.LittleEndian, []uint16{1, 1})
+	second.WriteString(names)
+
+	buf := &bytes.Buffer{}
+	buf.Write(ImageArchiveStart[:])
+	buf.Write(archiveHeader("/", len(first)))
+	buf.Write(first)
+	buf.Write(archiveHeader("/", second.Len()))
+	buf.Write(second.Bytes())
+	if buf.Len() != memberOffset {
+		t.Fatalf("expected member at %#x got %#x", memberOffset, buf.Len())
+	}
+	buf.Write(archiveHeader("lib.dll/", imp.Len()))
+	buf.Write(imp.Bytes())
+
+	a, err := LoadArchive(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(a.Members) != 1 || len(a.Symbols) != 2 || a.Symbols[1].Name != "__imp_Alias" || a.Symbols[1].Member != 0 {
+		t.Fatalf("unexpected archive %+v", a)
+	}
+	imports, err := a.Imports()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(imports) != 1 || imports[0].Name() != "Exported" || imports[0].DLL != "lib.dll" || imports[0].Ordinal() != 3 {
+		t.Errorf("unexpected imports %+v", imports)
+	}
+}
+
+// TestArchiveAnonymousObject checks that anonymous objects other than short
+// import objects, such as bigobj objects, are not taken for import objects.
+func TestArchiveAnonymousObject(t *testing.T) {
+	big := &bytes.Buffer{}
+	binary.Write(big, binary.LittleEndian, ImportObjectHeader{
+		Sig2:    0xFFFF,
+		Version: 2,
+		Machine: ImageFileMachineAMD64,
+	})
+	big.Write(make([]byte, 36))
+
+	imp := &bytes.Buffer{}
+	binary.Write(imp, binary.LittleEndian, ImportObjectHeader{
+		Sig2:       0xFFFF,
+		Machine:    ImageFileMachineAMD64,
+		SizeOfData: 12,
+		TypeInfo:   ImportObjectName << 2,
+	})
+	imp.WriteString("Add\x00lib.dll\x00")
+
+	buf := &bytes.Buffer{}
+	buf.Write(ImageArchiveStart[:])
+	buf.Write(archiveHeader("big.obj/", big.Len()))
+	buf.Write(big.Bytes())
+	buf.Write(archiveHeader("lib.dll/", imp.Len()))
+	buf.Write(imp.Bytes())
+
+	a, err := LoadArchive(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(a.Members) != 2 {
+		t.Fatalf("expected 2 members got %d", len(a.Members))
+	}
+	if a.IsImportObject(&a.Members[0]) {
+		t.Errorf("expected bigobj object not to be an import object")
+	}
+	if _, err := a.ImportObject(&a.Members[0]); err != ErrInvalidImportObject {
+		t.Errorf("expected ErrInvalidImportObject got %v", err)
+	}
+	imports, err := a.Imports()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(imports) != 1 || imports[0].Name() != "Add" || imports[0].DLL != "lib.dll" {
+		t.Errorf("unexpected imports %+v", imports)
+	}
+}
+
+func TestImportObjectName(t *testing.T) {
+	tests := []struct {
+		symbol   string
+		nameType int
+		name     string
+	}{
+		{"_Add@8", ImportObjectOrdinal, ""},
+		{"_Add@8", ImportObjectName, "_Add@8"},
+		{"_Add@8", ImportObjectNameNoPrefix, "Add@8"},
+		{"?Add@@YAHHH@Z", ImportObjectNameNoPrefix, "Add@@YAHHH@Z"},
+		{"_Add@8", ImportObjectNameUndecorate, "Add"},
+		{"@Add@8", ImportObjectNameUndecorate, "Add"},
+	}
+	for _, test := range tests {
+		o := &ImportObject{Symbol: test.symbol}
+		o.Header.TypeInfo = uint16(test.nameType << 2)
+		if name := o.Name(); name != test.name {
+			t.Errorf("%q with name type %d: expected %q got %q", test.symbol, test.nameType, test.name, name)
+		}
+	}
+}
+
+func TestLoadArchiveMalformed(t *testing.T) {
+	if _, err := LoadArchive(bytes.NewReader([]byte("!<arch>\r"))); err != ErrBadArchiveSignature {
+		t.Errorf("expected ErrBadArchiveSignature got %v", err)
+	}
+
+	// The member is larger than the archive.
+	buf := append(ImageArchiveStart[:], archiveHeader("a.obj/", 100)...)
+	if _, err := LoadArchive(bytes.NewReader(buf)); err != ErrInvalidArchive {
+		t.Errorf("expected ErrInvalidArchive got %v", err)
+	}
+
+	// The long name is outside of the longnames member.
+	buf = append(ImageArchiveStart[:], archiveHeader("//", 2)...)
+	buf = append(buf, "a\x00"...)
+	buf = append(buf, archiveHeader("/10", 0)...)
+	if _, err := LoadArchive(bytes.NewReader(buf)); err != ErrInvalidArchive {
+		t.Errorf("expected ErrInvalidArchive got %v", err)
+	}
+}
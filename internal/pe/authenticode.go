@@ -0,0 +1,464 @@
+package pe
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"sort"
+
+	// Register the hash functions used by Authenticode.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	// ErrNoSignature is returned when an image does not contain an
+	// Authenticode signature.
+	ErrNoSignature = errors.New("pe: image is not signed")
+
+	// ErrInvalidCertificateTable is returned when the attribute certificate
+	// table is malformed.
+	ErrInvalidCertificateTable = errors.New("pe: invalid certificate table")
+
+	// ErrInvalidSignature is returned when an Authenticode signature is
+	// malformed or does not verify.
+	ErrInvalidSignature = errors.New("pe: invalid authenticode signature")
+
+	// ErrUnsupportedDigestAlgorithm is returned when an Authenticode
+	// signature uses an unsupported digest algorithm.
+	ErrUnsupportedDigestAlgorithm = errors.New("pe: unsupported digest algorithm")
+
+	// ErrDigestMismatch is returned when the image hash does not match the
+	// hash in the Authenticode signature.
+	ErrDigestMismatch = errors.New("pe: image digest mismatch")
+)
+
+// Enumeration of WIN_CERTIFICATE revisions.
+const (
+	WinCertRevision1_0 = 0x0100
+	WinCertRevision2_0 = 0x0200
+)
+
+// Enumeration of WIN_CERTIFICATE types.
+const (
+	WinCertTypeX509           = 0x0001
+	WinCertTypePKCSSignedData = 0x0002
+	WinCertTypeReserved1      = 0x0003
+	WinCertTypeTSStackSigned  = 0x0004
+)
+
+const (
+	// SizeOfWinCertificateHeader is the on-disk size of the
+	// WinCertificateHeader structure.
+	SizeOfWinCertificateHeader = 8
+
+	// offsetOfCheckSum is the offset of the CheckSum field in the optional
+	// header, for both PE32 and PE32+.
+	offsetOfCheckSum = 64
+
+	// offsetOfDataDirectory32 and offsetOfDataDirectory64 are the offsets of
+	// the data directories in the optional header.
+	offsetOfDataDirectory32 = 96
+	offsetOfDataDirectory64 = 112
+)
+
+// WinCertificateHeader is the header of an entry in the attribute
+// certificate table.
+type WinCertificateHeader struct {
+	Length          uint32
+	Revision        uint16
+	CertificateType uint16
+}
+
+// WinCertificate is an entry in the attribute certificate table.
+type WinCertificate struct {
+	Revision        uint16
+	CertificateType uint16
+	Certificate     []byte
+}
+
+// LoadCertificates loads the attribute certificate table from the raw file
+// data of an image. Unlike other data directories, the security directory
+// is addressed by file offset and is not mapped into memory.
+func LoadCertificates(m *Module, data []byte) ([]WinCertificate, error) {
+	dir := m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntrySecurity]
+	if dir.Size == 0 || dir.VirtualAddress == 0 {
+		return nil, nil
+	}
+	if uint64(dir.VirtualAddress)+uint64(dir.Size) > uint64(len(data)) {
+		return nil, ErrInvalidCertificateTable
+	}
+
+	table := data[dir.VirtualAddress : dir.VirtualAddress+dir.Size]
+	certs := []WinCertificate{}
+	for len(table) >= SizeOfWinCertificateHeader {
+		hdr := WinCertificateHeader{}
+		binary.Read(bytes.NewReader(table), binary.LittleEndian, &hdr)
+		if hdr.Length < SizeOfWinCertificateHeader || uint64(hdr.Length) > uint64(len(table)) {
+			return nil, ErrInvalidCertificateTable
+		}
+		certs = append(certs, WinCertificate{
+			Revision:        hdr.Revision,
+			CertificateType: hdr.CertificateType,
+			Certificate:     table[SizeOfWinCertificateHeader:hdr.Length],
+		})
+
+		// Entries are aligned to 8 bytes.
+		next := (uint64(hdr.Length) + 7) &^ 7
+		if next >= uint64(len(table)) {
+			break
+		}
+		table = table[next:]
+	}
+	return certs, nil
+}
+
+// AuthenticodeDigest computes the Authenticode hash of the raw file data of
+// an image. The checksum, the security directory entry and the attribute
+// certificate table are excluded from the hash.
+func AuthenticodeDigest(m *Module, data []byte, hash crypto.Hash) ([]byte, error) {
+	if !hash.Available() {
+		return nil, ErrUnsupportedDigestAlgorithm
+	}
+
+	opt := uint64(m.DOSHeader.NewHeaderAddr) + OffsetOfOptionalHeaderFromNTHeader
+	checksum := opt + offsetOfCheckSum
+	secdir := opt + offsetOfDataDirectory32 + ImageDirectoryEntrySecurity*SizeOfImageDataDirectory
+	if m.IsPE64 {
+		secdir = opt + offsetOfDataDirectory64 + ImageDirectoryEntrySecurity*SizeOfImageDataDirectory
+	}
+	hdrsize := uint64(m.Header.OptionalHeader.SizeOfHeaders)
+	if hdrsize < secdir+SizeOfImageDataDirectory || hdrsize > uint64(len(data)) {
+		return nil, ErrInvalidCertificateTable
+	}
+
+	h := hash.New()
+	h.Write(data[:checksum])
+	h.Write(data[checksum+4 : secdir])
+	h.Write(data[secdir+SizeOfImageDataDirectory : hdrsize])
+
+	// Hash the sections in file order.
+	sections := append([]ImageSectionHeader{}, m.Sections...)
+	sort.Slice(sections, func(i, j int) bool {
+		return sections[i].PointerToRawData < sections[j].PointerToRawData
+	})
+	hashed := hdrsize
+	for _, section := range sections {
+		if section.SizeOfRawData == 0 {
+			continue
+		}
+		start, end := uint64(section.PointerToRawData), uint64(section.PointerToRawData)+uint64(section.SizeOfRawData)
+		if end > uint64(len(data)) {
+			return nil, ErrInvalidCertificateTable
+		}
+		h.Write(data[start:end])
+		if end > hashed {
+			hashed = end
+		}
+	}
+
+	// Hash any remaining data, except the attribute certificate table.
+	dir := m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntrySecurity]
+	certStart, certEnd := uint64(len(data)), uint64(len(data))
+	if dir.Size != 0 && dir.VirtualAddress != 0 {
+		certStart, certEnd = uint64(dir.VirtualAddress), uint64(dir.VirtualAddress)+uint64(dir.Size)
+		if certEnd > uint64(len(data)) {
+			return nil, ErrInvalidCertificateTable
+		}
+	}
+	if hashed < certStart {
+		h.Write(data[hashed:certStart])
+	}
+	if certEnd > hashed && certEnd < uint64(len(data)) {
+		h.Write(data[certEnd:])
+	}
+
+	return h.Sum(nil), nil
+}
+
+var (
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSpcIndirectDataContent = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	oidDigestSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7IssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type spcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"optional"`
+}
+
+type spcDigestInfo struct {
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	Digest          []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          spcAttributeTypeAndOptionalValue
+	MessageDigest spcDigestInfo
+}
+
+// digestAlgorithm returns the hash function for a digest algorithm
+// identifier.
+func digestAlgorithm(alg pkix.AlgorithmIdentifier) (crypto.Hash, error) {
+	switch {
+	case alg.Algorithm.Equal(oidDigestSHA1):
+		return crypto.SHA1, nil
+	case alg.Algorithm.Equal(oidDigestSHA256):
+		return crypto.SHA256, nil
+	case alg.Algorithm.Equal(oidDigestSHA384):
+		return crypto.SHA384, nil
+	case alg.Algorithm.Equal(oidDigestSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, ErrUnsupportedDigestAlgorithm
+}
+
+// AuthenticodeSignature contains a parsed Authenticode signature.
+type AuthenticodeSignature struct {
+	// DigestAlgorithm is the hash function used for the image hash.
+	DigestAlgorithm crypto.Hash
+
+	// Digest is the signed image hash.
+	Digest []byte
+
+	// Certificates contains the certificates embedded in the signature.
+	Certificates []*x509.Certificate
+
+	// Signer is the certificate of the signer.
+	Signer *x509.Certificate
+
+	// content contains the DER contents of the SpcIndirectDataContent,
+	// without its tag and length.
+	content []byte
+
+	signerInfo pkcs7SignerInfo
+}
+
+// ParseAuthenticode parses a PKCS#7 SignedData structure containing an
+// Authenticode signature.
+func ParseAuthenticode(der []byte) (*AuthenticodeSignature, error) {
+	// Signing tools include the padding of the certificate entry to 8 bytes
+	// in its length, so the DER value may be followed by zero bytes.
+	info := pkcs7ContentInfo{}
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(bytes.TrimLeft(rest, "\x00")) != 0 {
+		return nil, ErrInvalidSignature
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, ErrInvalidSignature
+	}
+
+	sd := pkcs7SignedData{}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, ErrInvalidSignature
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectDataContent) || len(sd.SignerInfos) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	indirect := spcIndirectDataContent{}
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &indirect); err != nil {
+		return nil, ErrInvalidSignature
+	}
+	hash, err := digestAlgorithm(indirect.MessageDigest.DigestAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	content := asn1.RawValue{}
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	sig := &AuthenticodeSignature{
+		DigestAlgorithm: hash,
+		Digest:          indirect.MessageDigest.Digest,
+		Certificates:    certs,
+		content:         content.Bytes,
+		signerInfo:      sd.SignerInfos[0],
+	}
+
+	issuer := sig.signerInfo.IssuerAndSerialNumber
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawIssuer, issuer.Issuer.FullBytes) && cert.SerialNumber.Cmp(issuer.SerialNumber) == 0 {
+			sig.Signer = cert
+			break
+		}
+	}
+	if sig.Signer == nil {
+		return nil, ErrInvalidSignature
+	}
+
+	return sig, nil
+}
+
+// LoadAuthenticode loads the Authenticode signature from the raw file data of
+// an image. If the image has multiple signatures, the first is returned.
+func LoadAuthenticode(m *Module, data []byte) (*AuthenticodeSignature, error) {
+	certs, err := LoadCertificates(m, data)
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range certs {
+		if cert.Revision == WinCertRevision2_0 && cert.CertificateType == WinCertTypePKCSSignedData {
+			return ParseAuthenticode(cert.Certificate)
+		}
+	}
+	return nil, ErrNoSignature
+}
+
+// Verify verifies the signature over the signed content and the certificate
+// chain of the signer. The chain must be valid for code signing. Any
+// certificates embedded in the signature are added to opts.Intermediates. The
+// image hash is not checked; use VerifyAuthenticode to verify an image.
+//
+// Timestamp countersignatures are not processed, so the signer certificate
+// must be valid at opts.CurrentTime.
+func (s *AuthenticodeSignature) Verify(opts x509.VerifyOptions) error {
+	si := s.signerInfo
+	hash, err := digestAlgorithm(si.DigestAlgorithm)
+	if err != nil {
+		return err
+	}
+	if len(si.AuthenticatedAttributes.FullBytes) == 0 {
+		return ErrInvalidSignature
+	}
+
+	// The authenticated attributes are signed with a SET OF tag instead of
+	// the implicit tag.
+	signed := append([]byte{}, si.AuthenticatedAttributes.FullBytes...)
+	signed[0] = 0x31
+
+	attrs := []pkcs7Attribute{}
+	if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+		return ErrInvalidSignature
+	}
+	var contentType asn1.ObjectIdentifier
+	var digest []byte
+	for _, attr := range attrs {
+		switch {
+		case attr.Type.Equal(oidAttributeContentType):
+			asn1.Unmarshal(attr.Values.Bytes, &contentType)
+		case attr.Type.Equal(oidAttributeMessageDigest):
+			asn1.Unmarshal(attr.Values.Bytes, &digest)
+		}
+	}
+	if !contentType.Equal(oidSpcIndirectDataContent) {
+		return ErrInvalidSignature
+	}
+	h := hash.New()
+	h.Write(s.content)
+	if !bytes.Equal(h.Sum(nil), digest) {
+		return ErrInvalidSignature
+	}
+
+	var alg x509.SignatureAlgorithm
+	switch s.Signer.PublicKey.(type) {
+	case *rsa.PublicKey:
+		alg = map[crypto.Hash]x509.SignatureAlgorithm{
+			crypto.SHA1:   x509.SHA1WithRSA,
+			crypto.SHA256: x509.SHA256WithRSA,
+			crypto.SHA384: x509.SHA384WithRSA,
+			crypto.SHA512: x509.SHA512WithRSA,
+		}[hash]
+	case *ecdsa.PublicKey:
+		alg = map[crypto.Hash]x509.SignatureAlgorithm{
+			crypto.SHA1:   x509.ECDSAWithSHA1,
+			crypto.SHA256: x509.ECDSAWithSHA256,
+			crypto.SHA384: x509.ECDSAWithSHA384,
+			crypto.SHA512: x509.ECDSAWithSHA512,
+		}[hash]
+	default:
+		return ErrInvalidSignature
+	}
+	if err := s.Signer.CheckSignature(alg, signed, si.EncryptedDigest); err != nil {
+		return err
+	}
+
+	if opts.Intermediates == nil {
+		opts.Intermediates = x509.NewCertPool()
+	}
+	for _, cert := range s.Certificates {
+		if cert != s.Signer {
+			opts.Intermediates.AddCert(cert)
+		}
+	}
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+	_, err = s.Signer.Verify(opts)
+	return err
+}
+
+// VerifyAuthenticode verifies the Authenticode signature of the raw file data
+// of an image against the specified root certificates. It checks that the
+// image hash matches the signed hash, that the signature is valid and that
+// the signer chains up to one of the roots.
+func VerifyAuthenticode(data []byte, roots *x509.CertPool) error {
+	m, err := LoadModule(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	sig, err := LoadAuthenticode(m, data)
+	if err != nil {
+		return err
+	}
+	digest, err := AuthenticodeDigest(m, data, sig.DigestAlgorithm)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(digest, sig.Digest) {
+		return ErrDigestMismatch
+	}
+	return sig.Verify(x509.VerifyOptions{Roots: roots})
+}
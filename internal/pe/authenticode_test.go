@@ -0,0 +1,172 @@
+package pe
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// makeTestCert creates a certificate signed by parent, or a self-signed
+// certificate if parent is nil.
+func makeTestCert(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// signTestImage appends an Authenticode signature to an image.
+func signTestImage(t *testing.T, data []byte, signer *x509.Certificate, key *ecdsa.PrivateKey, certs ...*x509.Certificate) []byte {
+	m, err := LoadModule(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pad the image to 8 bytes and point the security directory at the end.
+	data = append(data, make([]byte, (8-len(data)%8)%8)...)
+	secdir := m.DOSHeader.NewHeaderAddr + OffsetOfOptionalHeaderFromNTHeader + offsetOfDataDirectory32 + ImageDirectoryEntrySecurity*SizeOfImageDataDirectory
+	binary.LittleEndian.PutUint32(data[secdir:], uint32(len(data)))
+	m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntrySecurity].VirtualAddress = uint32(len(data))
+
+	digest, err := AuthenticodeDigest(m, data, crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sha256 := pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256}
+	indirect, _ := asn1.Marshal(spcIndirectDataContent{
+		Data:          spcAttributeTypeAndOptionalValue{Type: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}},
+		MessageDigest: spcDigestInfo{DigestAlgorithm: sha256, Digest: digest},
+	})
+	content := asn1.RawValue{}
+	asn1.Unmarshal(indirect, &content)
+	contentDigest := crypto.SHA256.New()
+	contentDigest.Write(content.Bytes)
+
+	contentTypeValue, _ := asn1.Marshal(oidSpcIndirectDataContent)
+	messageDigestValue, _ := asn1.Marshal(contentDigest.Sum(nil))
+	attrs, _ := asn1.MarshalWithParams([]pkcs7Attribute{
+		{Type: oidAttributeContentType, Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: contentTypeValue}},
+		{Type: oidAttributeMessageDigest, Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: messageDigestValue}},
+	}, "set")
+	attrsDigest := crypto.SHA256.New()
+	attrsDigest.Write(attrs)
+	r, s, err := ecdsa.Sign(rand.Reader, key, attrsDigest.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	attrs[0] = 0xA0
+
+	rawCerts := []byte{}
+	for _, cert := range append([]*x509.Certificate{signer}, certs...) {
+		rawCerts = append(rawCerts, cert.Raw...)
+	}
+	sd, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256},
+		ContentInfo: pkcs7ContentInfo{
+			ContentType: oidSpcIndirectDataContent,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: indirect},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: rawCerts},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version:                   1,
+			IssuerAndSerialNumber:     pkcs7IssuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: signer.RawIssuer}, SerialNumber: signer.SerialNumber},
+			DigestAlgorithm:           sha256,
+			AuthenticatedAttributes:   asn1.RawValue{FullBytes: attrs},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			EncryptedDigest:           sig,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := asn1.Marshal(pkcs7ContentInfo{ContentType: oidSignedData, Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd}})
+
+	// Like signtool, include the padding to 8 bytes in the entry length. Pad
+	// even if the entry is aligned already, so that there always is some.
+	cert := &bytes.Buffer{}
+	length := (SizeOfWinCertificateHeader + len(der) + 8) &^ 7
+	binary.Write(cert, binary.LittleEndian, WinCertificateHeader{Length: uint32(length), Revision: WinCertRevision2_0, CertificateType: WinCertTypePKCSSignedData})
+	cert.Write(der)
+	cert.Write(make([]byte, length-SizeOfWinCertificateHeader-len(der)))
+	binary.LittleEndian.PutUint32(data[secdir+4:], uint32(cert.Len()))
+	return append(data, cert.Bytes()...)
+}
+
+func TestVerifyAuthenticode(t *testing.T) {
+	tiny, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root, rootKey := makeTestCert(t, "Test Root", nil, nil)
+	leaf, leafKey := makeTestCert(t, "Test Signer", root, rootKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	if err := VerifyAuthenticode(tiny, roots); err != ErrNoSignature {
+		t.Errorf("expected ErrNoSignature got %v", err)
+	}
+
+	signed := signTestImage(t, tiny, leaf, leafKey, root)
+	if err := VerifyAuthenticode(signed, roots); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+
+	// Modifying the checksum does not affect the signature.
+	m, _ := LoadModule(bytes.NewReader(signed))
+	checksum := m.DOSHeader.NewHeaderAddr + OffsetOfOptionalHeaderFromNTHeader + offsetOfCheckSum
+	modified := append([]byte{}, signed...)
+	modified[checksum] ^= 0xFF
+	if err := VerifyAuthenticode(modified, roots); err != nil {
+		t.Errorf("expected nil error after checksum change got %v", err)
+	}
+
+	// Modifying the image does.
+	modified = append([]byte{}, signed...)
+	modified[m.Sections[0].PointerToRawData] ^= 0xFF
+	if err := VerifyAuthenticode(modified, roots); err != ErrDigestMismatch {
+		t.Errorf("expected ErrDigestMismatch got %v", err)
+	}
+
+	// A signer that does not chain up to the roots is rejected.
+	otherRoot, _ := makeTestCert(t, "Other Root", nil, nil)
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(otherRoot)
+	if err := VerifyAuthenticode(signed, otherRoots); err == nil {
+		t.Error("expected error for untrusted signer")
+	}
+}
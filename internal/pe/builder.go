@@ -0,0 +1,1035 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"unicode/utf16"
+)
+
+var (
+	// ErrInvalidSectionName is returned when a section name does not fit in
+	// a section header.
+	ErrInvalidSectionName = errors.New("pe: invalid section name")
+
+	// ErrInvalidRef is returned when a reference does not point into a
+	// section or import of the image being built.
+	ErrInvalidRef = errors.New("pe: invalid reference")
+
+	// ErrInvalidFixup is returned when a fixup has an unknown type or does
+	// not fit inside its section.
+	ErrInvalidFixup = errors.New("pe: invalid fixup")
+
+	// ErrDuplicateExport is returned when two exports share a name or an
+	// ordinal.
+	ErrDuplicateExport = errors.New("pe: duplicate export")
+
+	// ErrImageTooLarge is returned when the image being built does not fit
+	// in the 32-bit address space of an image.
+	ErrImageTooLarge = errors.New("pe: image too large")
+)
+
+// Enumeration of fixup types.
+const (
+	// FixupVA32 writes the 32-bit virtual address of the target and emits a
+	// HighLow base relocation for it.
+	FixupVA32 = iota + 1
+
+	// FixupVA64 writes the 64-bit virtual address of the target and emits a
+	// Dir64 base relocation for it.
+	FixupVA64
+
+	// FixupRVA32 writes the 32-bit relative virtual address of the target.
+	FixupRVA32
+
+	// FixupRel32 writes the 32-bit displacement of the target from the end
+	// of the field, as used by x86 branches and RIP-relative operands.
+	FixupRel32
+)
+
+// Ref refers to a location in an image being built.
+type Ref struct {
+	// Section is the section that contains the location.
+	Section *BuilderSection
+
+	// Offset is the offset of the location from the start of the section.
+	// It may be equal to the size of the section, to refer to its end.
+	Offset uint32
+
+	// Import, if not nil, refers to the import address table entry of an
+	// imported symbol instead; Section and Offset are then ignored.
+	Import *BuilderImportSymbol
+}
+
+// Fixup patches a field of a section with the address of a Ref once the
+// layout of the image is known.
+type Fixup struct {
+	// Offset is the offset of the field from the start of the section.
+	Offset uint32
+
+	// Type is the type of the fixup using the Fixup* constants.
+	Type int
+
+	// Target is the location whose address is written.
+	Target Ref
+}
+
+// BuilderSection is a section of an image being built.
+type BuilderSection struct {
+	// Name is the name of the section, of at most SectionNameLength bytes.
+	Name string
+
+	// Characteristics are the section characteristics using the
+	// ImageSectionCharacteristics* constants.
+	Characteristics uint32
+
+	// Data is the initialized data of the section. It is not modified by
+	// Build; fixups are applied to a copy.
+	Data []byte
+
+	// VirtualSize is the size of the section in memory. If it is smaller
+	// than Data, the size of Data is used; the rest is zero filled.
+	VirtualSize uint32
+
+	// Fixups are the fields of Data that refer to other locations.
+	Fixups []Fixup
+
+	// BaseRelocations are additional base relocations, with offsets relative
+	// to the start of the section. They are emitted as given, which allows
+	// machine-specific relocations that have no corresponding Fixup type.
+	BaseRelocations []BaseRelocation
+}
+
+// size returns the size of the section in memory.
+func (s *BuilderSection) size() uint32 {
+	if uint32(len(s.Data)) > s.VirtualSize {
+		return uint32(len(s.Data))
+	}
+	return s.VirtualSize
+}
+
+// BuilderImport is a module imported by an image being built.
+type BuilderImport struct {
+	// DLL is the name of the imported module.
+	DLL string
+
+	// Symbols are the symbols imported from the module.
+	Symbols []*BuilderImportSymbol
+}
+
+// BuilderImportSymbol is a symbol imported by an image being built.
+type BuilderImportSymbol struct {
+	// Name is the name of the symbol. If empty, the symbol is imported by
+	// ordinal.
+	Name string
+
+	// Hint is the index of the symbol in the export name table of the
+	// module, used by the loader to speed up the lookup.
+	Hint uint16
+
+	// Ordinal is the ordinal of the symbol, if it is imported by ordinal.
+	Ordinal uint16
+}
+
+// BuilderExport is a symbol exported by an image being built.
+type BuilderExport struct {
+	// Name is the name of the export. If empty, the symbol is only exported
+	// by ordinal.
+	Name string
+
+	// Ordinal is the ordinal of the export. If zero, the lowest ordinal not
+	// used by another export is assigned.
+	Ordinal uint16
+
+	// Target is the location of the exported symbol.
+	Target Ref
+
+	// Forwarder, if not empty, forwards the export to a symbol of another
+	// module, such as "kernel32.GetLastError"; Target is then ignored.
+	Forwarder string
+}
+
+// BuilderTLS describes the thread local storage of an image being built.
+type BuilderTLS struct {
+	// Data is the template that initializes the TLS block of each thread.
+	Data []byte
+
+	// SizeOfZeroFill is the number of zero bytes following Data in the TLS
+	// block.
+	SizeOfZeroFill uint32
+
+	// Index is where the loader stores the TLS index of the module. If nil,
+	// it is stored in the generated .tls section.
+	Index *Ref
+
+	// Callbacks are the TLS callbacks of the module.
+	Callbacks []Ref
+}
+
+// ResourceID identifies a resource type, name or language. Name takes
+// precedence over ID if it is not empty.
+type ResourceID struct {
+	Name string
+	ID   uint16
+}
+
+// BuilderResource is a resource of an image being built.
+type BuilderResource struct {
+	Type     ResourceID
+	Name     ResourceID
+	Language uint16
+	CodePage uint32
+	Data     []byte
+}
+
+// BuilderDirectory is a data directory entry of an image being built.
+type BuilderDirectory struct {
+	Target Ref
+	Size   uint32
+}
+
+// Builder assembles a PE image from sections and descriptions of its import,
+// export, TLS and resource directories. Build places the sections in order
+// after the headers, followed by generated .idata, .edata, .tls, .rsrc and
+// .reloc sections as needed.
+type Builder struct {
+	// Machine is the target machine using the ImageFileMachine* constants.
+	// Images for AMD64, ARM64 and IA64 are PE32+; others are PE32.
+	Machine uint16
+
+	// Characteristics are the file characteristics. If zero, the image is
+	// an executable DLL.
+	Characteristics uint16
+
+	// DllCharacteristics are the DLL characteristics.
+	DllCharacteristics uint16
+
+	// Subsystem is the subsystem of the image. If zero, it is
+	// ImageSubsystemWindowsGUI.
+	Subsystem uint16
+
+	// ImageBase is the preferred base address of the image. If zero, the
+	// default DLL base of the machine is used.
+	ImageBase uint64
+
+	// SectionAlignment and FileAlignment are the alignments of sections in
+	// memory and in the file. If zero, they are 0x1000 and 0x200. When they
+	// are equal, the file offset of each section is its RVA.
+	SectionAlignment uint32
+	FileAlignment    uint32
+
+	// DOSStub is placed between the DOS header and the NT headers, which
+	// start at the next multiple of 8 bytes.
+	DOSStub []byte
+
+	// TimeDateStamp is written to the file header and export directory.
+	TimeDateStamp uint32
+
+	// EntryPoint is the entry point of the image, if not nil.
+	EntryPoint *Ref
+
+	// Sections are the sections of the image, in order.
+	Sections []*BuilderSection
+
+	// Imports are the modules imported by the image.
+	Imports []*BuilderImport
+
+	// Name is the name of the module written to the export directory.
+	Name string
+
+	// Exports are the symbols exported by the image.
+	Exports []*BuilderExport
+
+	// TLS is the thread local storage of the image, if not nil.
+	TLS *BuilderTLS
+
+	// Resources are the resources of the image.
+	Resources []*BuilderResource
+
+	// Directories sets additional data directory entries, such as the load
+	// config or exception directories, by ImageDirectoryEntry* index.
+	Directories map[int]BuilderDirectory
+}
+
+// IsPE64 returns whether the image is built as PE32+.
+func (b *Builder) IsPE64() bool {
+	switch b.Machine {
+	case ImageFileMachineAMD64, ImageFileMachineARM64, ImageFileMachineIA64:
+		return true
+	}
+	return false
+}
+
+// build holds the state of a single call to Build.
+type build struct {
+	b        *Builder
+	pe64     bool
+	ptrSize  uint32
+	sections []*BuilderSection
+	rvas     map[*BuilderSection]uint32
+	imports  map[*BuilderImportSymbol]Ref
+	dirs     [NumDirectoryEntries]ImageDataDirectory
+	dirRefs  map[int]BuilderDirectory
+	relocs   []BaseRelocation
+}
+
+// Build lays out the image and returns its file contents, with the checksum
+// computed.
+func (b *Builder) Build() ([]byte, error) {
+	sectAlign, fileAlign := b.SectionAlignment, b.FileAlignment
+	if sectAlign == 0 {
+		sectAlign = PageSize
+	}
+	if fileAlign == 0 {
+		fileAlign = 0x200
+	}
+	if !isPowerOfTwo(sectAlign) || !isPowerOfTwo(fileAlign) || fileAlign > sectAlign {
+		return nil, ErrInvalidAlignment
+	}
+
+	l := &build{
+		b:       b,
+		pe64:    b.IsPE64(),
+		ptrSize: 4,
+		rvas:    map[*BuilderSection]uint32{},
+		imports: map[*BuilderImportSymbol]Ref{},
+		dirRefs: map[int]BuilderDirectory{},
+	}
+	if l.pe64 {
+		l.ptrSize = 8
+	}
+	imageBase := b.ImageBase
+	if imageBase == 0 {
+		imageBase = 0x10000000
+		if l.pe64 {
+			imageBase = 0x180000000
+		}
+	}
+
+	// Generated sections refer to themselves through fixups, so they can be
+	// built before the layout is known.
+	l.sections = append(l.sections, b.Sections...)
+	if len(b.Imports) > 0 {
+		l.sections = append(l.sections, l.buildImports())
+	}
+	if len(b.Exports) > 0 {
+		s, err := l.buildExports()
+		if err != nil {
+			return nil, err
+		}
+		l.sections = append(l.sections, s)
+	}
+	if b.TLS != nil {
+		l.sections = append(l.sections, l.buildTLS())
+	}
+	if len(b.Resources) > 0 {
+		l.sections = append(l.sections, l.buildResources())
+	}
+
+	hasRelocs := false
+	for _, s := range l.sections {
+		if len(s.Name) > SectionNameLength {
+			return nil, ErrInvalidSectionName
+		}
+		if len(s.BaseRelocations) > 0 {
+			hasRelocs = true
+		}
+		for _, f := range s.Fixups {
+			if f.Type == FixupVA32 || f.Type == FixupVA64 {
+				hasRelocs = true
+			}
+		}
+	}
+	numSections := len(l.sections)
+	if hasRelocs {
+		numSections++
+	}
+	if numSections > MaxNumSections {
+		return nil, ErrTooManySections
+	}
+
+	ntSize := uint32(SizeOfImageNTHeaders32)
+	if l.pe64 {
+		ntSize = SizeOfImageNTHeaders64
+	}
+	ntOffset := roundUp32(SizeOfImageDOSHeader+uint32(len(b.DOSStub)), 8)
+	sizeOfHeaders := roundUp32(ntOffset+ntSize+uint32(numSections)*SizeOfImageSectionHeader, fileAlign)
+
+	// Place the sections in memory.
+	next := uint64(roundUp32(sizeOfHeaders, sectAlign))
+	for _, s := range l.sections {
+		l.rvas[s] = uint32(next)
+		next += uint64(roundUp32(s.size(), sectAlign))
+		if s.size() == 0 {
+			// Empty sections still need an address of their own.
+			next += uint64(sectAlign)
+		}
+		if next > 0x80000000 {
+			return nil, ErrImageTooLarge
+		}
+	}
+
+	// Apply fixups to copies of the section data.
+	data := make([][]byte, len(l.sections))
+	for i, s := range l.sections {
+		d, err := l.apply(s, imageBase)
+		if err != nil {
+			return nil, err
+		}
+		data[i] = d
+	}
+
+	if hasRelocs {
+		s := l.buildRelocs()
+		l.rvas[s] = uint32(next)
+		next += uint64(roundUp32(s.size(), sectAlign))
+		if next > 0x80000000 {
+			return nil, ErrImageTooLarge
+		}
+		l.sections = append(l.sections, s)
+		data = append(data, s.Data)
+		l.dirs[ImageDirectoryEntryBaseReloc] = ImageDataDirectory{VirtualAddress: l.rvas[s], Size: uint32(len(s.Data))}
+	}
+
+	for i, dir := range b.Directories {
+		l.dirRefs[i] = dir
+	}
+	for i, dir := range l.dirRefs {
+		if i < 0 || i >= NumDirectoryEntries {
+			return nil, ErrInvalidDirectory
+		}
+		rva, err := l.resolve(dir.Target)
+		if err != nil {
+			return nil, err
+		}
+		l.dirs[i] = ImageDataDirectory{VirtualAddress: rva, Size: dir.Size}
+	}
+
+	nt := ImageNTHeaders64{Signature: PESignature}
+	nt.FileHeader = ImageFileHeader{
+		Machine:              b.Machine,
+		NumberOfSections:     uint16(len(l.sections)),
+		TimeDateStamp:        b.TimeDateStamp,
+		SizeOfOptionalHeader: uint16(ntSize - 4 - SizeOfImageFileHeader),
+		Characteristics:      b.Characteristics,
+	}
+	if nt.FileHeader.Characteristics == 0 {
+		nt.FileHeader.Characteristics = ImageFileExecutableImage | ImageFileDLL
+		if l.pe64 {
+			nt.FileHeader.Characteristics |= ImageFileLargeAddressAware
+		} else {
+			nt.FileHeader.Characteristics |= ImageFile32BitMachine
+		}
+	}
+	opt := &nt.OptionalHeader
+	opt.Magic = ImageNTOptionalHeader32Magic
+	if l.pe64 {
+		opt.Magic = ImageNTOptionalHeader64Magic
+	}
+	opt.ImageBase = imageBase
+	opt.SectionAlignment = sectAlign
+	opt.FileAlignment = fileAlign
+	opt.MajorOperatingSystemVersion = 6
+	opt.MajorSubsystemVersion = 6
+	opt.SizeOfImage = uint32(next)
+	opt.SizeOfHeaders = sizeOfHeaders
+	opt.Subsystem = b.Subsystem
+	if opt.Subsystem == 0 {
+		opt.Subsystem = ImageSubsystemWindowsGUI
+	}
+	opt.DllCharacteristics = b.DllCharacteristics
+	opt.SizeOfStackReserve = 0x100000
+	opt.SizeOfStackCommit = 0x1000
+	opt.SizeOfHeapReserve = 0x100000
+	opt.SizeOfHeapCommit = 0x1000
+	opt.NumberOfRvaAndSizes = NumDirectoryEntries
+	opt.DataDirectory = l.dirs
+	if b.EntryPoint != nil {
+		rva, err := l.resolve(*b.EntryPoint)
+		if err != nil {
+			return nil, err
+		}
+		opt.AddressOfEntryPoint = rva
+	}
+
+	// Place the sections in the file.
+	baseOfData := uint32(0)
+	headers := make([]ImageSectionHeader, len(l.sections))
+	offset := sizeOfHeaders
+	for i, s := range l.sections {
+		rawSize := uint32(len(data[i]))
+		if sectAlign == fileAlign {
+			rawSize = s.size()
+		}
+		h := &headers[i]
+		copy(h.Name[:], s.Name)
+		h.PhysicalAddressOrVirtualSize = s.size()
+		h.VirtualAddress = l.rvas[s]
+		h.SizeOfRawData = roundUp32(rawSize, fileAlign)
+		h.Characteristics = s.Characteristics
+		if h.SizeOfRawData != 0 {
+			h.PointerToRawData = offset
+			offset += h.SizeOfRawData
+		} else if sectAlign == fileAlign {
+			// Empty sections have an address of their own, which file
+			// offsets need to keep up with. Their file offset is set too,
+			// since flat images must have every file offset equal to the
+			// RVA.
+			h.PointerToRawData = offset
+			offset += fileAlign
+		}
+
+		switch {
+		case s.Characteristics&ImageSectionCharacteristicsContainsCode != 0:
+			opt.SizeOfCode += h.SizeOfRawData
+			if opt.BaseOfCode == 0 {
+				opt.BaseOfCode = h.VirtualAddress
+			}
+		case s.Characteristics&ImageSectionCharacteristicsContainsInitializedData != 0:
+			opt.SizeOfInitializedData += h.SizeOfRawData
+		case s.Characteristics&ImageSectionCharacteristicsContainsUninitailizedData != 0:
+			opt.SizeOfUninitializedData += roundUp32(h.PhysicalAddressOrVirtualSize, fileAlign)
+		}
+		if s.Characteristics&ImageSectionCharacteristicsContainsCode == 0 && baseOfData == 0 {
+			baseOfData = h.VirtualAddress
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, ImageDOSHeader{Signature: MZSignature, NewHeaderAddr: ntOffset})
+	buf.Write(b.DOSStub)
+	buf.Write(make([]byte, int(ntOffset)-buf.Len()))
+	if l.pe64 {
+		binary.Write(buf, binary.LittleEndian, nt)
+	} else {
+		binary.Write(buf, binary.LittleEndian, nt.to32(baseOfData))
+	}
+	binary.Write(buf, binary.LittleEndian, headers)
+	buf.Write(make([]byte, int(sizeOfHeaders)-buf.Len()))
+	for i, h := range headers {
+		if h.SizeOfRawData == 0 {
+			continue
+		}
+		buf.Write(make([]byte, int(h.PointerToRawData)-buf.Len()))
+		buf.Write(data[i])
+		buf.Write(make([]byte, int(h.SizeOfRawData)-len(data[i])))
+	}
+	buf.Write(make([]byte, int(offset)-buf.Len()))
+
+	image := buf.Bytes()
+	off := int(ntOffset) + OffsetOfOptionalHeaderFromNTHeader + offsetOfCheckSum
+	binary.LittleEndian.PutUint32(image[off:], checkSum(image, off))
+	return image, nil
+}
+
+// to32 converts the headers of a PE32 image being built to ImageNTHeaders32.
+func (i ImageNTHeaders64) to32(baseOfData uint32) ImageNTHeaders32 {
+	o := i.OptionalHeader
+	return ImageNTHeaders32{
+		Signature:  i.Signature,
+		FileHeader: i.FileHeader,
+		OptionalHeader: ImageOptionalHeader32{
+			Magic:                       o.Magic,
+			MajorLinkerVersion:          o.MajorLinkerVersion,
+			MinorLinkerVersion:          o.MinorLinkerVersion,
+			SizeOfCode:                  o.SizeOfCode,
+			SizeOfInitializedData:       o.SizeOfInitializedData,
+			SizeOfUninitializedData:     o.SizeOfUninitializedData,
+			AddressOfEntryPoint:         o.AddressOfEntryPoint,
+			BaseOfCode:                  o.BaseOfCode,
+			BaseOfData:                  baseOfData,
+			ImageBase:                   uint32(o.ImageBase),
+			SectionAlignment:            o.SectionAlignment,
+			FileAlignment:               o.FileAlignment,
+			MajorOperatingSystemVersion: o.MajorOperatingSystemVersion,
+			MinorOperatingSystemVersion: o.MinorOperatingSystemVersion,
+			MajorImageVersion:           o.MajorImageVersion,
+			MinorImageVersion:           o.MinorImageVersion,
+			MajorSubsystemVersion:       o.MajorSubsystemVersion,
+			MinorSubsystemVersion:       o.MinorSubsystemVersion,
+			Win32VersionValue:           o.Win32VersionValue,
+			SizeOfImage:                 o.SizeOfImage,
+			SizeOfHeaders:               o.SizeOfHeaders,
+			CheckSum:                    o.CheckSum,
+			Subsystem:                   o.Subsystem,
+			DllCharacteristics:          o.DllCharacteristics,
+			SizeOfStackReserve:          uint32(o.SizeOfStackReserve),
+			SizeOfStackCommit:           uint32(o.SizeOfStackCommit),
+			SizeOfHeapReserve:           uint32(o.SizeOfHeapReserve),
+			SizeOfHeapCommit:            uint32(o.SizeOfHeapCommit),
+			LoaderFlags:                 o.LoaderFlags,
+			NumberOfRvaAndSizes:         o.NumberOfRvaAndSizes,
+			DataDirectory:               o.DataDirectory,
+		},
+	}
+}
+
+// resolve returns the RVA of a reference.
+func (l *build) resolve(r Ref) (uint32, error) {
+	if r.Import != nil {
+		ref, ok := l.imports[r.Import]
+		if !ok {
+			return 0, ErrInvalidRef
+		}
+		r = ref
+	}
+	rva, ok := l.rvas[r.Section]
+	if !ok || r.Offset > r.Section.size() {
+		return 0, ErrInvalidRef
+	}
+	return rva + r.Offset, nil
+}
+
+// apply returns the data of a section with its fixups applied, and records
+// the base relocations of the section.
+func (l *build) apply(s *BuilderSection, imageBase uint64) ([]byte, error) {
+	rva := l.rvas[s]
+	data := append([]byte{}, s.Data...)
+	for _, f := range s.Fixups {
+		width := uint32(4)
+		if f.Type == FixupVA64 {
+			width = 8
+		}
+		if uint64(f.Offset)+uint64(width) > uint64(len(data)) {
+			return nil, ErrInvalidFixup
+		}
+		target, err := l.resolve(f.Target)
+		if err != nil {
+			return nil, err
+		}
+		field := data[f.Offset:]
+		switch f.Type {
+		case FixupVA32:
+			binary.LittleEndian.PutUint32(field, uint32(imageBase+uint64(target)))
+			l.relocs = append(l.relocs, BaseRelocation{Offset: uint64(rva + f.Offset), Type: ImageRelBasedHighLow})
+		case FixupVA64:
+			binary.LittleEndian.PutUint64(field, imageBase+uint64(target))
+			l.relocs = append(l.relocs, BaseRelocation{Offset: uint64(rva + f.Offset), Type: ImageRelBasedDir64})
+		case FixupRVA32:
+			binary.LittleEndian.PutUint32(field, target)
+		case FixupRel32:
+			binary.LittleEndian.PutUint32(field, target-(rva+f.Offset+4))
+		default:
+			return nil, ErrInvalidFixup
+		}
+	}
+	for _, r := range s.BaseRelocations {
+		l.relocs = append(l.relocs, BaseRelocation{Offset: uint64(rva) + r.Offset, Type: r.Type})
+	}
+	return data, nil
+}
+
+// buildImports builds the .idata section. The import address tables of all
+// modules are contiguous, so that they can be described by the IAT
+// directory.
+func (l *build) buildImports() *BuilderSection {
+	s := &BuilderSection{
+		Name:            ".idata",
+		Characteristics: ImageSectionCharacteristicsContainsInitializedData | ImageSectionCharacteristicsMemoryRead | ImageSectionCharacteristicsMemoryWrite,
+	}
+	imports := l.b.Imports
+	numThunks := uint32(0)
+	for _, m := range imports {
+		numThunks += uint32(len(m.Symbols)) + 1
+	}
+	descSize := uint32(len(imports)+1) * SizeOfImageImportDescriptor
+	ilt := descSize
+	iat := ilt + numThunks*l.ptrSize
+	strs := iat + numThunks*l.ptrSize
+
+	names := &bytes.Buffer{}
+	nameOffsets := map[*BuilderImportSymbol]uint32{}
+	for _, m := range imports {
+		for _, sym := range m.Symbols {
+			if sym.Name == "" {
+				continue
+			}
+			if names.Len()%2 != 0 {
+				names.WriteByte(0)
+			}
+			nameOffsets[sym] = strs + uint32(names.Len())
+			binary.Write(names, binary.LittleEndian, sym.Hint)
+			names.WriteString(sym.Name)
+			names.WriteByte(0)
+		}
+	}
+	dllOffsets := make([]uint32, len(imports))
+	for i, m := range imports {
+		dllOffsets[i] = strs + uint32(names.Len())
+		names.WriteString(m.DLL)
+		names.WriteByte(0)
+	}
+
+	s.Data = make([]byte, strs+uint32(names.Len()))
+	copy(s.Data[strs:], names.Bytes())
+	ordinalFlag := uint64(0x80000000)
+	if l.pe64 {
+		ordinalFlag = 0x8000000000000000
+	}
+	thunk := uint32(0)
+	for i, m := range imports {
+		desc := i * SizeOfImageImportDescriptor
+		s.Fixups = append(s.Fixups,
+			Fixup{Offset: uint32(desc), Type: FixupRVA32, Target: Ref{Section: s, Offset: ilt + thunk*l.ptrSize}},
+			Fixup{Offset: uint32(desc + 12), Type: FixupRVA32, Target: Ref{Section: s, Offset: dllOffsets[i]}},
+			Fixup{Offset: uint32(desc + 16), Type: FixupRVA32, Target: Ref{Section: s, Offset: iat + thunk*l.ptrSize}},
+		)
+		for _, sym := range m.Symbols {
+			for _, table := range []uint32{ilt, iat} {
+				off := table + thunk*l.ptrSize
+				if sym.Name == "" {
+					putPointer(s.Data[off:], l.pe64, ordinalFlag|uint64(sym.Ordinal))
+				} else {
+					s.Fixups = append(s.Fixups, Fixup{Offset: off, Type: FixupRVA32, Target: Ref{Section: s, Offset: nameOffsets[sym]}})
+				}
+			}
+			l.imports[sym] = Ref{Section: s, Offset: iat + thunk*l.ptrSize}
+			thunk++
+		}
+		thunk++
+	}
+
+	l.dirRefs[ImageDirectoryEntryImport] = BuilderDirectory{Target: Ref{Section: s}, Size: descSize}
+	l.dirRefs[ImageDirectoryEntryIAT] = BuilderDirectory{Target: Ref{Section: s, Offset: iat}, Size: numThunks * l.ptrSize}
+	return s
+}
+
+// putPointer writes a pointer-sized value.
+func putPointer(b []byte, pe64 bool, v uint64) {
+	if pe64 {
+		binary.LittleEndian.PutUint64(b, v)
+	} else {
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	}
+}
+
+// buildExports builds the .edata section. Forwarder strings are placed
+// inside the export directory, which is how the loader recognizes them.
+func (l *build) buildExports() (*BuilderSection, error) {
+	s := &BuilderSection{
+		Name:            ".edata",
+		Characteristics: ImageSectionCharacteristicsContainsInitializedData | ImageSectionCharacteristicsMemoryRead,
+	}
+
+	// Assign ordinals to the exports that do not have one.
+	exports := map[uint16]*BuilderExport{}
+	named := []*BuilderExport{}
+	seen := map[string]bool{}
+	for _, e := range l.b.Exports {
+		if e.Ordinal != 0 {
+			if exports[e.Ordinal] != nil {
+				return nil, ErrDuplicateExport
+			}
+			exports[e.Ordinal] = e
+		}
+		if e.Name != "" {
+			if seen[e.Name] {
+				return nil, ErrDuplicateExport
+			}
+			seen[e.Name] = true
+			named = append(named, e)
+		}
+	}
+	ordinals := map[*BuilderExport]uint16{}
+	next := uint16(1)
+	for _, e := range l.b.Exports {
+		ordinal := e.Ordinal
+		if ordinal == 0 {
+			for exports[next] != nil {
+				next++
+			}
+			if next == 0 {
+				return nil, ErrDuplicateExport
+			}
+			ordinal = next
+			exports[ordinal] = e
+		}
+		ordinals[e] = ordinal
+	}
+	base, last := uint16(0xFFFF), uint16(0)
+	for ordinal := range exports {
+		if ordinal < base {
+			base = ordinal
+		}
+		if ordinal > last {
+			last = ordinal
+		}
+	}
+	sort.Slice(named, func(i, j int) bool { return named[i].Name < named[j].Name })
+
+	numFunctions := uint32(last-base) + 1
+	functions := uint32(SizeOfImageExportDirectory)
+	names := functions + numFunctions*4
+	nameOrdinals := names + uint32(len(named))*4
+	strs := &bytes.Buffer{}
+	strBase := nameOrdinals + uint32(len(named))*2
+	addString := func(str string) uint32 {
+		off := strBase + uint32(strs.Len())
+		strs.WriteString(str)
+		strs.WriteByte(0)
+		return off
+	}
+
+	dir := ImageExportDirectory{
+		TimeDateStamp:     l.b.TimeDateStamp,
+		Base:              uint32(base),
+		NumberOfFunctions: numFunctions,
+		NumberOfNames:     uint32(len(named)),
+	}
+	s.Fixups = append(s.Fixups,
+		Fixup{Offset: 12, Type: FixupRVA32, Target: Ref{Section: s, Offset: addString(l.b.Name)}},
+		Fixup{Offset: 28, Type: FixupRVA32, Target: Ref{Section: s, Offset: functions}},
+		Fixup{Offset: 32, Type: FixupRVA32, Target: Ref{Section: s, Offset: names}},
+		Fixup{Offset: 36, Type: FixupRVA32, Target: Ref{Section: s, Offset: nameOrdinals}},
+	)
+	for i, e := range named {
+		s.Fixups = append(s.Fixups, Fixup{Offset: names + uint32(i)*4, Type: FixupRVA32, Target: Ref{Section: s, Offset: addString(e.Name)}})
+	}
+	for ordinal := uint32(base); ordinal <= uint32(last); ordinal++ {
+		e := exports[uint16(ordinal)]
+		if e == nil {
+			continue
+		}
+		off := functions + (ordinal-uint32(base))*4
+		target := e.Target
+		if e.Forwarder != "" {
+			target = Ref{Section: s, Offset: addString(e.Forwarder)}
+		}
+		s.Fixups = append(s.Fixups, Fixup{Offset: off, Type: FixupRVA32, Target: target})
+	}
+	sort.Slice(s.Fixups, func(i, j int) bool { return s.Fixups[i].Offset < s.Fixups[j].Offset })
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, dir)
+	buf.Write(make([]byte, nameOrdinals-uint32(buf.Len())))
+	for _, e := range named {
+		binary.Write(buf, binary.LittleEndian, ordinals[e]-base)
+	}
+	buf.Write(strs.Bytes())
+	s.Data = buf.Bytes()
+
+	l.dirRefs[ImageDirectoryEntryExport] = BuilderDirectory{Target: Ref{Section: s}, Size: uint32(len(s.Data))}
+	return s, nil
+}
+
+// buildTLS builds the .tls section, which holds the TLS template followed by
+// the TLS index, the TLS directory and the callback array.
+func (l *build) buildTLS() *BuilderSection {
+	tls := l.b.TLS
+	s := &BuilderSection{
+		Name:            ".tls",
+		Characteristics: ImageSectionCharacteristicsContainsInitializedData | ImageSectionCharacteristicsMemoryRead | ImageSectionCharacteristicsMemoryWrite,
+	}
+	va := FixupVA32
+	dirSize := uint32(SizeOfImageTLSDirectory32)
+	if l.pe64 {
+		va = FixupVA64
+		dirSize = SizeOfImageTLSDirectory64
+	}
+
+	end := uint32(len(tls.Data))
+	dir := roundUp32(end, l.ptrSize)
+	index := Ref{Section: s, Offset: dir}
+	if tls.Index != nil {
+		index = *tls.Index
+	} else {
+		dir += l.ptrSize
+	}
+	callbacks := dir + dirSize
+	s.Data = make([]byte, callbacks+uint32(len(tls.Callbacks)+1)*l.ptrSize)
+	copy(s.Data, tls.Data)
+
+	p := l.ptrSize
+	s.Fixups = append(s.Fixups,
+		Fixup{Offset: dir, Type: va, Target: Ref{Section: s}},
+		Fixup{Offset: dir + p, Type: va, Target: Ref{Section: s, Offset: end}},
+		Fixup{Offset: dir + 2*p, Type: va, Target: index},
+		Fixup{Offset: dir + 3*p, Type: va, Target: Ref{Section: s, Offset: callbacks}},
+	)
+	binary.LittleEndian.PutUint32(s.Data[dir+4*p:], tls.SizeOfZeroFill)
+	for i, cb := range tls.Callbacks {
+		s.Fixups = append(s.Fixups, Fixup{Offset: callbacks + uint32(i)*p, Type: va, Target: cb})
+	}
+
+	l.dirRefs[ImageDirectoryEntryTLS] = BuilderDirectory{Target: Ref{Section: s, Offset: dir}, Size: dirSize}
+	return s
+}
+
+// resourceKey returns the sort key of a resource ID. Entries with names sort
+// before entries with IDs, as required by the resource directory format.
+func resourceKey(id ResourceID) (bool, string, uint16) {
+	return id.Name == "", id.Name, id.ID
+}
+
+// resourceLess returns whether a resource ID sorts before another.
+func resourceLess(a, b ResourceID) bool {
+	an, as, ai := resourceKey(a)
+	bn, bs, bi := resourceKey(b)
+	if an != bn {
+		return !an
+	}
+	if as != bs {
+		return as < bs
+	}
+	return ai < bi
+}
+
+// resourceNode is a directory of the resource tree being built.
+type resourceNode struct {
+	ids      []ResourceID
+	children []*resourceNode
+	leaves   []*BuilderResource
+	offset   uint32
+}
+
+// add inserts a child under the given ID, keeping the entries sorted.
+func (n *resourceNode) add(id ResourceID, child *resourceNode, leaf *BuilderResource) {
+	i := sort.Search(len(n.ids), func(i int) bool { return !resourceLess(n.ids[i], id) })
+	n.ids = append(n.ids, ResourceID{})
+	copy(n.ids[i+1:], n.ids[i:])
+	n.ids[i] = id
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+	n.leaves = append(n.leaves, nil)
+	copy(n.leaves[i+1:], n.leaves[i:])
+	n.leaves[i] = leaf
+}
+
+// child returns the child directory with the given ID, creating it if needed.
+func (n *resourceNode) child(id ResourceID) *resourceNode {
+	for i := range n.ids {
+		if n.ids[i] == id {
+			return n.children[i]
+		}
+	}
+	c := &resourceNode{}
+	n.add(id, c, nil)
+	return c
+}
+
+// buildResources builds the .rsrc section. The tree has the usual three
+// levels of type, name and language. Directories come first, then data
+// entries, name strings and finally the resource data.
+func (l *build) buildResources() *BuilderSection {
+	s := &BuilderSection{
+		Name:            ".rsrc",
+		Characteristics: ImageSectionCharacteristicsContainsInitializedData | ImageSectionCharacteristicsMemoryRead,
+	}
+	root := &resourceNode{}
+	for _, r := range l.b.Resources {
+		root.child(r.Type).child(r.Name).add(ResourceID{ID: r.Language}, nil, r)
+	}
+
+	// Assign offsets to directories breadth first, then to the rest.
+	dirs := []*resourceNode{root}
+	size := uint32(0)
+	for i := 0; i < len(dirs); i++ {
+		dirs[i].offset = size
+		size += SizeOfImageResourceDirectory + uint32(len(dirs[i].ids))*SizeOfImageResourceDirectoryEntry
+		for _, c := range dirs[i].children {
+			if c != nil {
+				dirs = append(dirs, c)
+			}
+		}
+	}
+	entries := map[*BuilderResource]uint32{}
+	for _, d := range dirs {
+		for _, r := range d.leaves {
+			if r != nil {
+				entries[r] = size
+				size += SizeOfImageResourceDataEntry
+			}
+		}
+	}
+	strs := map[string]uint32{}
+	strData := &bytes.Buffer{}
+	for _, d := range dirs {
+		for _, id := range d.ids {
+			if _, ok := strs[id.Name]; ok || id.Name == "" {
+				continue
+			}
+			strs[id.Name] = size + uint32(strData.Len())
+			name := utf16.Encode([]rune(id.Name))
+			binary.Write(strData, binary.LittleEndian, uint16(len(name)))
+			binary.Write(strData, binary.LittleEndian, name)
+		}
+	}
+	size = roundUp32(size+uint32(strData.Len()), 8)
+
+	buf := &bytes.Buffer{}
+	for _, d := range dirs {
+		dir := ImageResourceDirectory{TimeDateStamp: l.b.TimeDateStamp}
+		for _, id := range d.ids {
+			if id.Name != "" {
+				dir.NumberOfNamedEntries++
+			} else {
+				dir.NumberOfIDEntries++
+			}
+		}
+		binary.Write(buf, binary.LittleEndian, dir)
+		for i, id := range d.ids {
+			entry := ImageResourceDirectoryEntry{Name: uint32(id.ID)}
+			if id.Name != "" {
+				entry.Name = ImageResourceNameIsString | strs[id.Name]
+			}
+			if c := d.children[i]; c != nil {
+				entry.OffsetToData = ImageResourceDataIsDirectory | c.offset
+			} else {
+				entry.OffsetToData = entries[d.leaves[i]]
+			}
+			binary.Write(buf, binary.LittleEndian, entry)
+		}
+	}
+	data := &bytes.Buffer{}
+	for _, d := range dirs {
+		for _, r := range d.leaves {
+			if r == nil {
+				continue
+			}
+			s.Fixups = append(s.Fixups, Fixup{Offset: uint32(buf.Len()), Type: FixupRVA32, Target: Ref{Section: s, Offset: size + uint32(data.Len())}})
+			binary.Write(buf, binary.LittleEndian, ImageResourceDataEntry{Size: uint32(len(r.Data)), CodePage: r.CodePage})
+			data.Write(r.Data)
+			data.Write(make([]byte, roundUp32(uint32(data.Len()), 8)-uint32(data.Len())))
+		}
+	}
+	buf.Write(strData.Bytes())
+	buf.Write(make([]byte, int(size)-buf.Len()))
+	buf.Write(data.Bytes())
+	s.Data = buf.Bytes()
+
+	l.dirRefs[ImageDirectoryEntryResource] = BuilderDirectory{Target: Ref{Section: s}, Size: uint32(len(s.Data))}
+	return s
+}
+
+// buildRelocs builds the .reloc section from the base relocations collected
+// while applying fixups. Each block covers a 4 KiB page and is padded to a
+// multiple of four bytes with an absolute relocation.
+func (l *build) buildRelocs() *BuilderSection {
+	s := &BuilderSection{
+		Name:            ".reloc",
+		Characteristics: ImageSectionCharacteristicsContainsInitializedData | ImageSectionCharacteristicsMemoryRead | ImageSectionCharacteristicsMemoryDiscardable,
+	}
+	relocs := l.relocs
+	sort.SliceStable(relocs, func(i, j int) bool { return relocs[i].Offset < relocs[j].Offset })
+
+	buf := &bytes.Buffer{}
+	for i := 0; i < len(relocs); {
+		page := relocs[i].Offset &^ 0xFFF
+		entries := []uint16{}
+		for ; i < len(relocs) && relocs[i].Offset&^0xFFF == page; i++ {
+			entries = append(entries, uint16(relocs[i].Type<<12)|uint16(relocs[i].Offset&0xFFF))
+		}
+		if len(entries)%2 != 0 {
+			entries = append(entries, ImageRelBasedAbsolute)
+		}
+		binary.Write(buf, binary.LittleEndian, ImageBaseRelocation{
+			VirtualAddress: uint32(page),
+			SizeOfBlock:    SizeOfImageBaseRelocation + uint32(len(entries))*2,
+		})
+		binary.Write(buf, binary.LittleEndian, entries)
+	}
+	s.Data = buf.Bytes()
+	return s
+}
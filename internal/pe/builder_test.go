@@ -0,0 +1,369 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"unicode/utf16"
+)
+
+// buildTestImage returns a builder for an image with code and data sections,
+// imports, exports, TLS and resources.
+func buildTestImage(machine uint16) (*Builder, *BuilderSection, *BuilderSection) {
+	text := &BuilderSection{
+		Name:            ".text",
+		Characteristics: ImageSectionCharacteristicsContainsCode | ImageSectionCharacteristicsMemoryExecute | ImageSectionCharacteristicsMemoryRead,
+		Data:            make([]byte, 0x20),
+	}
+	data := &BuilderSection{
+		Name:            ".data",
+		Characteristics: ImageSectionCharacteristicsContainsInitializedData | ImageSectionCharacteristicsMemoryRead | ImageSectionCharacteristicsMemoryWrite,
+		Data:            make([]byte, 0x10),
+		VirtualSize:     0x2000,
+	}
+	getLastError := &BuilderImportSymbol{Name: "GetLastError", Hint: 7}
+	b := &Builder{
+		Machine:       machine,
+		TimeDateStamp: 0x12345678,
+		EntryPoint:    &Ref{Section: text},
+		Sections:      []*BuilderSection{text, data},
+		Imports: []*BuilderImport{
+			{DLL: "kernel32.dll", Symbols: []*BuilderImportSymbol{getLastError, {Ordinal: 42}}},
+			{DLL: "user32.dll", Symbols: []*BuilderImportSymbol{{Name: "MessageBoxW"}}},
+		},
+		Name: "test.dll",
+		Exports: []*BuilderExport{
+			{Name: "Zeta", Target: Ref{Section: text, Offset: 0x10}},
+			{Name: "Alpha", Target: Ref{Section: text, Offset: 0x08}},
+			{Ordinal: 5, Target: Ref{Section: data, Offset: 4}},
+			{Name: "Forwarded", Forwarder: "kernel32.GetLastError"},
+		},
+		TLS: &BuilderTLS{
+			Data:           []byte("TLSDATA!"),
+			SizeOfZeroFill: 8,
+			Callbacks:      []Ref{{Section: text, Offset: 0x18}},
+		},
+		Resources: []*BuilderResource{
+			{Type: ResourceID{ID: 16}, Name: ResourceID{ID: 1}, Language: 0x409, Data: []byte("version")},
+			{Type: ResourceID{Name: "CUSTOM"}, Name: ResourceID{Name: "THING"}, Language: 0, Data: []byte("thing")},
+		},
+	}
+	if b.IsPE64() {
+		data.Fixups = []Fixup{{Offset: 0, Type: FixupVA64, Target: Ref{Section: text, Offset: 4}}}
+		text.Fixups = []Fixup{{Offset: 2, Type: FixupRel32, Target: Ref{Import: getLastError}}}
+	} else {
+		data.Fixups = []Fixup{{Offset: 0, Type: FixupVA32, Target: Ref{Section: text, Offset: 4}}}
+		text.Fixups = []Fixup{{Offset: 2, Type: FixupVA32, Target: Ref{Import: getLastError}}}
+	}
+	return b, text, data
+}
+
+func readImage(t *testing.T, f *File, rva uint32, v interface{}) {
+	t.Helper()
+	if err := binary.Read(io.NewSectionReader(f.Image(), int64(rva), 1<<20), binary.LittleEndian, v); err != nil {
+		t.Fatalf("reading RVA %#x: %v", rva, err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	for _, machine := range []uint16{ImageFileMachinei386, ImageFileMachineAMD64} {
+		b, text, data := buildTestImage(machine)
+		image, err := b.Build()
+		if err != nil {
+			t.Fatalf("%04x: expected nil error got %v", machine, err)
+		}
+		f, err := NewFile(bytes.NewReader(image))
+		if err != nil {
+			t.Fatalf("%04x: expected nil error got %v", machine, err)
+		}
+		if err := VerifyCheckSum(f.Module, image); err != nil {
+			t.Errorf("%04x: expected nil error got %v", machine, err)
+		}
+		opt := f.Header.OptionalHeader
+		if f.IsPE64 != b.IsPE64() || f.Header.FileHeader.NumberOfSections != 7 {
+			t.Fatalf("%04x: unexpected headers %+v", machine, f.Header)
+		}
+		names := []string{".text", ".data", ".idata", ".edata", ".tls", ".rsrc", ".reloc"}
+		for i, s := range f.Sections {
+			if name := string(bytes.TrimRight(s.Name[:], "\x00")); name != names[i] {
+				t.Errorf("%04x: expected section %s got %s", machine, names[i], name)
+			}
+			if s.VirtualAddress%opt.SectionAlignment != 0 || s.PointerToRawData%opt.FileAlignment != 0 {
+				t.Errorf("%04x: section %s is misaligned", machine, names[i])
+			}
+		}
+		textRVA, dataRVA := f.Sections[0].VirtualAddress, f.Sections[1].VirtualAddress
+		if opt.AddressOfEntryPoint != textRVA || opt.BaseOfCode != textRVA {
+			t.Errorf("%04x: unexpected entry point %#x", machine, opt.AddressOfEntryPoint)
+		}
+		if opt.SizeOfImage != f.Sections[6].VirtualAddress+opt.SectionAlignment {
+			t.Errorf("%04x: unexpected image size %#x", machine, opt.SizeOfImage)
+		}
+		if f.Sections[1].PhysicalAddressOrVirtualSize != 0x2000 || f.Sections[1].SizeOfRawData != 0x200 {
+			t.Errorf("%04x: unexpected data section %+v", machine, f.Sections[1])
+		}
+		if text.Data[2] != 0 || data.Data[0] != 0 {
+			t.Errorf("%04x: expected section data to be unmodified", machine)
+		}
+
+		imports, err := f.Imports()
+		if err != nil {
+			t.Fatalf("%04x: expected nil error got %v", machine, err)
+		}
+		expectedImports := []Import{
+			{Module: "kernel32.dll", Name: "GetLastError"},
+			{Module: "kernel32.dll", Ordinal: 42},
+			{Module: "user32.dll", Name: "MessageBoxW"},
+		}
+		if len(imports) != len(expectedImports) {
+			t.Fatalf("%04x: expected imports %+v got %+v", machine, expectedImports, imports)
+		}
+		for i := range imports {
+			if imports[i] != expectedImports[i] {
+				t.Errorf("%04x: expected import %+v got %+v", machine, expectedImports[i], imports[i])
+			}
+		}
+		iat := opt.DataDirectory[ImageDirectoryEntryIAT]
+		if iat.Size != 5*map[bool]uint32{false: 4, true: 8}[f.IsPE64] {
+			t.Errorf("%04x: unexpected IAT directory %+v", machine, iat)
+		}
+
+		exports, err := f.Exports()
+		if err != nil {
+			t.Fatalf("%04x: expected nil error got %v", machine, err)
+		}
+		base := opt.ImageBase
+		if addr := exports.Proc("Alpha"); addr != base+uint64(textRVA)+8 {
+			t.Errorf("%04x: unexpected Alpha address %#x", machine, addr)
+		}
+		if addr := exports.Proc("Zeta"); addr != base+uint64(textRVA)+0x10 {
+			t.Errorf("%04x: unexpected Zeta address %#x", machine, addr)
+		}
+		if addr := exports.Ordinal(5); addr != base+uint64(dataRVA)+4 {
+			t.Errorf("%04x: unexpected ordinal 5 address %#x", machine, addr)
+		}
+		if exports.Ordinal(1) != exports.Proc("Zeta") || exports.Ordinal(2) != exports.Proc("Alpha") {
+			t.Errorf("%04x: expected ordinals to be assigned in order", machine)
+		}
+
+		// The forwarder string lies inside the export directory.
+		edata := opt.DataDirectory[ImageDirectoryEntryExport]
+		forwarder := exports.Proc("Forwarded") - base
+		if forwarder < uint64(edata.VirtualAddress) || forwarder >= uint64(edata.VirtualAddress+edata.Size) {
+			t.Errorf("%04x: expected forwarder inside export directory", machine)
+		}
+		str := make([]byte, 22)
+		readImage(t, f, uint32(forwarder), str)
+		if string(str) != "kernel32.GetLastError\x00" {
+			t.Errorf("%04x: unexpected forwarder %q", machine, str)
+		}
+
+		relocs, err := f.BaseRelocs()
+		if err != nil {
+			t.Fatalf("%04x: expected nil error got %v", machine, err)
+		}
+		got := map[uint64]int{}
+		for _, r := range relocs {
+			if r.Type != ImageRelBasedAbsolute {
+				got[r.Offset] = r.Type
+			}
+		}
+		typ := ImageRelBasedHighLow
+		if f.IsPE64 {
+			typ = ImageRelBasedDir64
+		}
+		if got[uint64(dataRVA)] != typ {
+			t.Errorf("%04x: expected relocation of data section got %v", machine, got)
+		}
+
+		// Check fixups against the mapped image.
+		if f.IsPE64 {
+			var va uint64
+			readImage(t, f, dataRVA, &va)
+			if va != base+uint64(textRVA)+4 {
+				t.Errorf("%04x: unexpected VA %#x", machine, va)
+			}
+			var rel int32
+			readImage(t, f, textRVA+2, &rel)
+			if uint32(int32(textRVA+6)+rel) != iat.VirtualAddress {
+				t.Errorf("%04x: unexpected displacement %#x", machine, rel)
+			}
+		} else {
+			var va uint32
+			readImage(t, f, textRVA+2, &va)
+			if uint64(va) != base+uint64(iat.VirtualAddress) {
+				t.Errorf("%04x: unexpected VA %#x", machine, va)
+			}
+			if got[uint64(textRVA+2)] != typ {
+				t.Errorf("%04x: expected relocation of text section got %v", machine, got)
+			}
+		}
+
+		checkBuiltTLS(t, f, textRVA)
+		checkBuiltResources(t, f)
+	}
+}
+
+func checkBuiltTLS(t *testing.T, f *File, textRVA uint32) {
+	t.Helper()
+	opt := f.Header.OptionalHeader
+	dir := opt.DataDirectory[ImageDirectoryEntryTLS]
+	tls := ImageTLSDirectory64{}
+	if f.IsPE64 {
+		readImage(t, f, dir.VirtualAddress, &tls)
+	} else {
+		tls32 := ImageTLSDirectory32{}
+		readImage(t, f, dir.VirtualAddress, &tls32)
+		tls = tls32.To64()
+	}
+	if tls.EndAddressOfRawData-tls.StartAddressOfRawData != 8 || tls.SizeOfZeroFill != 8 {
+		t.Errorf("unexpected TLS directory %+v", tls)
+	}
+	str := make([]byte, 8)
+	readImage(t, f, uint32(tls.StartAddressOfRawData-opt.ImageBase), str)
+	if string(str) != "TLSDATA!" {
+		t.Errorf("unexpected TLS data %q", str)
+	}
+	callbacks := [2]uint64{}
+	if f.IsPE64 {
+		readImage(t, f, uint32(tls.AddressOfCallBacks-opt.ImageBase), &callbacks)
+	} else {
+		callbacks32 := [2]uint32{}
+		readImage(t, f, uint32(tls.AddressOfCallBacks-opt.ImageBase), &callbacks32)
+		callbacks = [2]uint64{uint64(callbacks32[0]), uint64(callbacks32[1])}
+	}
+	if callbacks != [2]uint64{opt.ImageBase + uint64(textRVA) + 0x18, 0} {
+		t.Errorf("unexpected TLS callbacks %#x", callbacks)
+	}
+}
+
+// checkBuiltResources walks the resource tree of the image built by
+// buildTestImage.
+func checkBuiltResources(t *testing.T, f *File) {
+	t.Helper()
+	dir := f.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryResource]
+	entries := func(off uint32) (ImageResourceDirectory, []ImageResourceDirectoryEntry) {
+		d := ImageResourceDirectory{}
+		readImage(t, f, dir.VirtualAddress+off, &d)
+		e := make([]ImageResourceDirectoryEntry, d.NumberOfNamedEntries+d.NumberOfIDEntries)
+		readImage(t, f, dir.VirtualAddress+off+SizeOfImageResourceDirectory, e)
+		return d, e
+	}
+	name := func(off uint32) string {
+		n := uint16(0)
+		readImage(t, f, dir.VirtualAddress+off, &n)
+		s := make([]uint16, n)
+		readImage(t, f, dir.VirtualAddress+off+2, s)
+		return string(utf16.Decode(s))
+	}
+
+	root, types := entries(0)
+	if root.NumberOfNamedEntries != 1 || root.NumberOfIDEntries != 1 {
+		t.Fatalf("unexpected root directory %+v", root)
+	}
+	// Named entries come first.
+	if types[0].Name&ImageResourceNameIsString == 0 || name(types[0].Name&^ImageResourceNameIsString) != "CUSTOM" {
+		t.Errorf("expected CUSTOM type first got %+v", types[0])
+	}
+	if types[1].Name != 16 || types[1].OffsetToData&ImageResourceDataIsDirectory == 0 {
+		t.Fatalf("unexpected type entry %+v", types[1])
+	}
+	_, names := entries(types[1].OffsetToData &^ ImageResourceDataIsDirectory)
+	if len(names) != 1 || names[0].Name != 1 {
+		t.Fatalf("unexpected name entries %+v", names)
+	}
+	_, langs := entries(names[0].OffsetToData &^ ImageResourceDataIsDirectory)
+	if len(langs) != 1 || langs[0].Name != 0x409 || langs[0].OffsetToData&ImageResourceDataIsDirectory != 0 {
+		t.Fatalf("unexpected language entries %+v", langs)
+	}
+	leaf := ImageResourceDataEntry{}
+	readImage(t, f, dir.VirtualAddress+langs[0].OffsetToData, &leaf)
+	data := make([]byte, leaf.Size)
+	readImage(t, f, leaf.OffsetToData, data)
+	if string(data) != "version" {
+		t.Errorf("unexpected resource data %q", data)
+	}
+}
+
+func TestBuildLowAlignment(t *testing.T) {
+	b := &Builder{
+		Machine:          ImageFileMachinei386,
+		SectionAlignment: 0x200,
+		FileAlignment:    0x200,
+		Sections: []*BuilderSection{
+			{Name: ".bss", Characteristics: ImageSectionCharacteristicsContainsUninitailizedData, VirtualSize: 0x300},
+			{Name: ".empty"},
+			{Name: ".text", Characteristics: ImageSectionCharacteristicsContainsCode, Data: []byte{0xC3}},
+			{Name: ".last"},
+		},
+	}
+	image, err := b.Build()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	f, err := NewFile(bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if !f.MappedFlat() {
+		t.Errorf("expected image to be mapped flat")
+	}
+	for _, s := range f.Sections {
+		if s.PointerToRawData != s.VirtualAddress {
+			t.Errorf("expected file offset %#x to equal RVA %#x", s.PointerToRawData, s.VirtualAddress)
+		}
+	}
+	if f.Header.OptionalHeader.SizeOfImage != uint32(len(image)) {
+		t.Errorf("expected image size %#x got %#x", len(image), f.Header.OptionalHeader.SizeOfImage)
+	}
+}
+
+func TestBuildDeterministic(t *testing.T) {
+	b := &Builder{Machine: ImageFileMachineAMD64, Name: "fwd.dll"}
+	for i := 0; i < 8; i++ {
+		b.Exports = append(b.Exports, &BuilderExport{
+			Name:      fmt.Sprintf("F%d", i),
+			Forwarder: fmt.Sprintf("other.F%d", i),
+		})
+	}
+	first, err := b.Build()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		image, err := b.Build()
+		if err != nil {
+			t.Fatalf("expected nil error got %v", err)
+		}
+		if !bytes.Equal(image, first) {
+			t.Fatalf("expected identical images from the same builder")
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	other := &BuilderSection{Name: ".other"}
+	tests := []struct {
+		name string
+		b    Builder
+		err  error
+	}{
+		{"Alignment", Builder{SectionAlignment: 0x200, FileAlignment: 0x400}, ErrInvalidAlignment},
+		{"SectionName", Builder{Sections: []*BuilderSection{{Name: ".toolongname"}}}, ErrInvalidSectionName},
+		{"EntryPoint", Builder{EntryPoint: &Ref{Section: other}}, ErrInvalidRef},
+		{"RefOffset", Builder{Sections: []*BuilderSection{other}, EntryPoint: &Ref{Section: other, Offset: 1}}, ErrInvalidRef},
+		{"Fixup", Builder{Sections: []*BuilderSection{{Data: make([]byte, 4), Fixups: []Fixup{{Offset: 2, Type: FixupVA32}}}}}, ErrInvalidFixup},
+		{"FixupType", Builder{Sections: []*BuilderSection{{Data: make([]byte, 4), Fixups: []Fixup{{Type: 99, Target: Ref{Section: other}}}}, other}}, ErrInvalidFixup},
+		{"ExportName", Builder{Sections: []*BuilderSection{other}, Exports: []*BuilderExport{{Name: "A", Target: Ref{Section: other}}, {Name: "A", Target: Ref{Section: other}}}}, ErrDuplicateExport},
+		{"ExportOrdinal", Builder{Sections: []*BuilderSection{other}, Exports: []*BuilderExport{{Ordinal: 2, Target: Ref{Section: other}}, {Ordinal: 2, Target: Ref{Section: other}}}}, ErrDuplicateExport},
+		{"Import", Builder{Sections: []*BuilderSection{{Data: make([]byte, 4), Fixups: []Fixup{{Type: FixupRVA32, Target: Ref{Import: &BuilderImportSymbol{}}}}}}}, ErrInvalidRef},
+	}
+	for _, test := range tests {
+		test.b.Machine = ImageFileMachinei386
+		if _, err := test.b.Build(); err != test.err {
+			t.Errorf("%s: expected %v got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package pe
+
+import "errors"
+
+// ErrCheckSumMismatch is returned when the CheckSum field of an image does
+// not match the checksum of its file data.
+var ErrCheckSumMismatch = errors.New("pe: checksum mismatch")
+
+// CheckSum computes the image checksum of the raw file data of an image,
+// using the same algorithm as CheckSumMappedFile: the 16-bit words of the
+// file are summed with end-around carry, skipping the CheckSum field, and the
+// file size is added to the result.
+func CheckSum(m *Module, data []byte) uint32 {
+	return checkSum(data, m.checkSumOffset())
+}
+
+// VerifyCheckSum checks the CheckSum field of an image against its file
+// data. An image with a CheckSum of zero has no checksum and fails
+// verification.
+func VerifyCheckSum(m *Module, data []byte) error {
+	if m.Header.OptionalHeader.CheckSum != CheckSum(m, data) {
+		return ErrCheckSumMismatch
+	}
+	return nil
+}
+
+// checkSum computes the image checksum of data, treating the four bytes of
+// the CheckSum field at offset off as zero.
+func checkSum(data []byte, off int) uint32 {
+	sum := uint32(0)
+	for i := 0; i < len(data); i += 2 {
+		word := uint32(0)
+		if i < off || i >= off+4 {
+			word = uint32(data[i])
+		}
+		if i+1 < len(data) && (i+1 < off || i+1 >= off+4) {
+			word |= uint32(data[i+1]) << 8
+		}
+		sum += word
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+	sum = (sum & 0xFFFF) + (sum >> 16)
+	return sum + uint32(len(data))
+}
+
+// checkSumOffset returns the file offset of the CheckSum field of a module.
+func (m *Module) checkSumOffset() int {
+	return int(m.DOSHeader.NewHeaderAddr) + OffsetOfOptionalHeaderFromNTHeader + offsetOfCheckSum
+}
@@ -0,0 +1,82 @@
+package pe
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCheckSumAlgorithm(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		off      int
+		expected uint32
+	}{
+		{"Empty", nil, 100, 0},
+		{"OddLength", []byte{0x01, 0x02, 0x03, 0x04, 0x05}, 100, 0x0201 + 0x0403 + 0x0005 + 5},
+		{"Carry", []byte{0xFF, 0xFF, 0x02, 0x00}, 100, 0x0002 + 4},
+		{"FoldEachWord", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x01, 0x00}, 100, 0x0001 + 6},
+		{"SkipsField", []byte{0x01, 0x00, 0xAA, 0xBB, 0xCC, 0xDD, 0x02, 0x00}, 2, 0x0003 + 8},
+		{"SkipsUnalignedField", []byte{0x01, 0xAA, 0xBB, 0xCC, 0xDD, 0x02}, 1, 0x0001 + 0x0200 + 6},
+	}
+	for _, test := range tests {
+		if actual := checkSum(test.data, test.off); actual != test.expected {
+			t.Errorf("%s: expected %#x got %#x", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestVerifyCheckSum(t *testing.T) {
+	data, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadModule(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tiny.dll is linked without a checksum.
+	if err := VerifyCheckSum(m, data); err != ErrCheckSumMismatch {
+		t.Errorf("expected ErrCheckSumMismatch got %v", err)
+	}
+
+	// The CheckSum field is excluded from the checksum, so storing the
+	// checksum makes the image verify.
+	sum := CheckSum(m, data)
+	data = append([]byte{}, data...)
+	off := m.checkSumOffset()
+	data[off], data[off+1], data[off+2], data[off+3] = byte(sum), byte(sum>>8), byte(sum>>16), byte(sum>>24)
+	m.Header.OptionalHeader.CheckSum = sum
+	if CheckSum(m, data) != sum {
+		t.Errorf("expected checksum to be unchanged by storing it")
+	}
+	if err := VerifyCheckSum(m, data); err != nil {
+		t.Errorf("expected nil error got %v", err)
+	}
+
+	data[len(data)-1]++
+	if err := VerifyCheckSum(m, data); err != ErrCheckSumMismatch {
+		t.Errorf("expected ErrCheckSumMismatch got %v", err)
+	}
+}
+
+// TestVerifyCheckSumLinker checks the checksum that a linker wrote to
+// testdata/checksum.dll; see the comment in testdata/checksum.s.
+func TestVerifyCheckSumLinker(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/checksum.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadModule(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header.OptionalHeader.CheckSum == 0 {
+		t.Fatal("expected a nonzero checksum")
+	}
+	if err := VerifyCheckSum(m, data); err != nil {
+		t.Errorf("expected nil error got %v", err)
+	}
+}
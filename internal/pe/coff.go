@@ -0,0 +1,212 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidSymbolTable is returned when the COFF symbol table is
+	// malformed.
+	ErrInvalidSymbolTable = errors.New("pe: invalid symbol table")
+
+	// ErrInvalidStringTable is returned when the COFF string table is
+	// malformed, or a name refers outside of it.
+	ErrInvalidStringTable = errors.New("pe: invalid string table")
+)
+
+// StringTable is a COFF string table. It contains the whole table,
+// including the leading size field, so that offsets into it can be used
+// directly.
+type StringTable []byte
+
+// String returns the null-terminated string at offset off.
+func (t StringTable) String(off uint32) (string, error) {
+	if off < 4 || uint64(off) >= uint64(len(t)) {
+		return "", ErrInvalidStringTable
+	}
+	n := bytes.IndexByte(t[off:], 0)
+	if n < 0 {
+		return "", ErrInvalidStringTable
+	}
+	return string(t[off : off+uint32(n)]), nil
+}
+
+// SectionName returns the name of a section, resolving long names. Section
+// names longer than eight bytes are stored in the string table, and the
+// name field contains a slash followed by the decimal offset.
+func (t StringTable) SectionName(s *ImageSectionHeader) (string, error) {
+	name := s.SectionName()
+	if !strings.HasPrefix(name, "/") {
+		return name, nil
+	}
+	off, err := strconv.ParseUint(name[1:], 10, 32)
+	if err != nil {
+		return "", ErrInvalidStringTable
+	}
+	return t.String(uint32(off))
+}
+
+// Symbol is a decoded record of the COFF symbol table, along with its
+// auxiliary records.
+type Symbol struct {
+	// Index is the index of the record in the symbol table. Auxiliary
+	// records count towards the index.
+	Index uint32
+
+	// Name is the name of the symbol, resolved from the string table if it
+	// is a long name.
+	Name string
+
+	Value         uint32
+	SectionNumber int16
+	Type          uint16
+	StorageClass  uint8
+
+	// Aux contains the raw auxiliary records of the symbol.
+	Aux []byte
+
+	// Function is set for function definitions.
+	Function *ImageAuxSymbolFunction
+
+	// Section is set for section definitions.
+	Section *ImageAuxSymbolSection
+
+	// WeakExternal is set for weak externals.
+	WeakExternal *ImageAuxSymbolWeakExternal
+
+	// FileName is set for file records. It is the name of the source file.
+	FileName string
+}
+
+// IsFunction returns whether the symbol has a function type.
+func (s *Symbol) IsFunction() bool {
+	return (s.Type>>4)&3 == ImageSymDTypeFunction
+}
+
+// LoadStringTable loads the COFF string table, which immediately follows
+// the symbol table. It returns an empty table if there is no symbol table.
+func LoadStringTable(hdr *ImageFileHeader, r io.ReaderAt) (StringTable, error) {
+	if hdr.PointerToSymbolTable == 0 {
+		return StringTable{}, nil
+	}
+	if hdr.NumberOfSymbols > MaxSymbols {
+		return nil, ErrInvalidSymbolTable
+	}
+	off := int64(hdr.PointerToSymbolTable) + int64(hdr.NumberOfSymbols)*SizeOfImageSymbol
+	b := [4]byte{}
+	if _, err := r.ReadAt(b[:], off); err != nil {
+		// Some linkers omit the string table when it would be empty.
+		if err == io.EOF {
+			return StringTable{}, nil
+		}
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(b[:])
+	if size < 4 {
+		return StringTable{}, nil
+	}
+	if size > MaxStringTableSize {
+		return nil, ErrInvalidStringTable
+	}
+	table := make(StringTable, size)
+	if _, err := r.ReadAt(table, off); err != nil {
+		return nil, ErrInvalidStringTable
+	}
+	return table, nil
+}
+
+// LoadSymbols loads the COFF symbol table and decodes the auxiliary records
+// of section definitions, function definitions, weak externals and files.
+// It also returns the string table.
+func LoadSymbols(hdr *ImageFileHeader, r io.ReaderAt) ([]Symbol, StringTable, error) {
+	strtab, err := LoadStringTable(hdr, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hdr.PointerToSymbolTable == 0 || hdr.NumberOfSymbols == 0 {
+		return nil, strtab, nil
+	}
+
+	// Make sure the end of the table is in the file before allocating.
+	size := int64(hdr.NumberOfSymbols) * SizeOfImageSymbol
+	if _, err := r.ReadAt(make([]byte, 1), int64(hdr.PointerToSymbolTable)+size-1); err != nil {
+		return nil, nil, ErrInvalidSymbolTable
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, int64(hdr.PointerToSymbolTable)); err != nil {
+		return nil, nil, ErrInvalidSymbolTable
+	}
+
+	syms := []Symbol{}
+	for i := uint32(0); i < hdr.NumberOfSymbols; {
+		rec := ImageSymbol{}
+		binary.Read(bytes.NewReader(data[i*SizeOfImageSymbol:]), binary.LittleEndian, &rec)
+		if uint32(rec.NumberOfAuxSymbols) >= hdr.NumberOfSymbols-i {
+			return nil, nil, ErrInvalidSymbolTable
+		}
+		sym := Symbol{
+			Index:         i,
+			Value:         rec.Value,
+			SectionNumber: rec.SectionNumber,
+			Type:          rec.Type,
+			StorageClass:  rec.StorageClass,
+		}
+		if sym.Name, err = symbolName(rec.Name, strtab); err != nil {
+			return nil, nil, err
+		}
+		start := (i + 1) * SizeOfImageSymbol
+		sym.Aux = data[start : start+uint32(rec.NumberOfAuxSymbols)*SizeOfImageSymbol]
+		decodeAuxSymbol(&sym)
+		syms = append(syms, sym)
+		i += 1 + uint32(rec.NumberOfAuxSymbols)
+	}
+	return syms, strtab, nil
+}
+
+// symbolName returns the name of a symbol record.
+func symbolName(name [8]byte, strtab StringTable) (string, error) {
+	if binary.LittleEndian.Uint32(name[:4]) == 0 {
+		return strtab.String(binary.LittleEndian.Uint32(name[4:]))
+	}
+	if n := bytes.IndexByte(name[:], 0); n >= 0 {
+		return string(name[:n]), nil
+	}
+	return string(name[:]), nil
+}
+
+// decodeAuxSymbol decodes the first auxiliary record of a symbol, if its
+// format is known.
+func decodeAuxSymbol(sym *Symbol) {
+	if len(sym.Aux) == 0 {
+		return
+	}
+	aux := bytes.NewReader(sym.Aux)
+	switch {
+	case sym.StorageClass == ImageSymClassFile:
+		sym.FileName = strings.TrimRight(string(sym.Aux), "\x00")
+	case sym.StorageClass == ImageSymClassStatic && sym.Value == 0 && sym.SectionNumber > 0 && !sym.IsFunction():
+		sym.Section = &ImageAuxSymbolSection{}
+		binary.Read(aux, binary.LittleEndian, sym.Section)
+	case sym.StorageClass == ImageSymClassWeakExternal,
+		sym.StorageClass == ImageSymClassExternal && sym.SectionNumber == ImageSymUndefined && sym.Value == 0:
+		sym.WeakExternal = &ImageAuxSymbolWeakExternal{}
+		binary.Read(aux, binary.LittleEndian, sym.WeakExternal)
+	case sym.StorageClass == ImageSymClassExternal && sym.SectionNumber > 0 && sym.IsFunction():
+		sym.Function = &ImageAuxSymbolFunction{}
+		binary.Read(aux, binary.LittleEndian, sym.Function)
+	}
+}
+
+// SymbolRVA returns the RVA of a symbol defined in a section of the image.
+// It returns false for symbols that are not defined in a section.
+func (m *Module) SymbolRVA(sym *Symbol) (uint32, bool) {
+	if sym.SectionNumber <= 0 || int(sym.SectionNumber) > len(m.Sections) {
+		return 0, false
+	}
+	return m.Sections[sym.SectionNumber-1].VirtualAddress + sym.Value, true
+}
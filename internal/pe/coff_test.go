@@ -0,0 +1,101 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// testdata/symbols.obj is generated from testdata/symbols.s; see the comment
+// in that file.
+func TestLoadSymbols(t *testing.T) {
+	obj, err := ioutil.ReadFile("testdata/symbols.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := ImageFileHeader{}
+	binary.Read(bytes.NewReader(obj), binary.LittleEndian, &hdr)
+
+	syms, strtab, err := LoadSymbols(&hdr, bytes.NewReader(obj))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	byName := map[string]*Symbol{}
+	for i := range syms {
+		byName[syms[i].Name] = &syms[i]
+	}
+
+	text := byName[".text"]
+	if text == nil || text.Section == nil || text.Section.Length != 5 || text.Section.Number != 1 {
+		t.Errorf("unexpected .text section definition %+v", text)
+	}
+
+	// Long names are resolved from the string table.
+	helper := byName["static_helper_with_a_long_name"]
+	if helper == nil || helper.StorageClass != ImageSymClassStatic || !helper.IsFunction() || helper.Section != nil {
+		t.Errorf("unexpected static function %+v", helper)
+	}
+	if len(strtab) <= 4 {
+		t.Errorf("expected string table")
+	}
+
+	add := byName["Add"]
+	if add == nil || add.StorageClass != ImageSymClassExternal || add.SectionNumber != 1 || add.Value != 1 {
+		t.Fatalf("unexpected Add symbol %+v", add)
+	}
+
+	weak := byName["WeakAdd"]
+	if weak == nil || weak.WeakExternal == nil || weak.WeakExternal.TagIndex != add.Index || weak.WeakExternal.Characteristics != ImageWeakExternSearchAlias {
+		t.Errorf("unexpected weak external %+v", weak)
+	}
+
+	file := byName[".file"]
+	if file == nil || file.FileName != "symbols.c" {
+		t.Errorf("unexpected file record %+v", file)
+	}
+}
+
+func TestLoadSymbolsFunctionDefinition(t *testing.T) {
+	table := &bytes.Buffer{}
+	sym := ImageSymbol{Value: 0x10, SectionNumber: 1, Type: ImageSymDTypeFunction << 4, StorageClass: ImageSymClassExternal, NumberOfAuxSymbols: 1}
+	binary.LittleEndian.PutUint32(sym.Name[4:], 4)
+	binary.Write(table, binary.LittleEndian, sym)
+	binary.Write(table, binary.LittleEndian, ImageAuxSymbolFunction{TotalSize: 0x20})
+	binary.Write(table, binary.LittleEndian, uint32(4+len("function\x00")))
+	table.WriteString("function\x00")
+
+	hdr := ImageFileHeader{PointerToSymbolTable: 0x10, NumberOfSymbols: 2}
+	data := append(make([]byte, 0x10), table.Bytes()...)
+	syms, _, err := LoadSymbols(&hdr, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(syms) != 1 || syms[0].Name != "function" || syms[0].Function == nil || syms[0].Function.TotalSize != 0x20 {
+		t.Fatalf("unexpected symbols %+v", syms)
+	}
+
+	m := &Module{Sections: []ImageSectionHeader{{VirtualAddress: 0x1000}}}
+	if rva, ok := m.SymbolRVA(&syms[0]); !ok || rva != 0x1010 {
+		t.Errorf("expected rva 0x1010 got %#x, %v", rva, ok)
+	}
+
+	// Auxiliary records must not run past the end of the table.
+	hdr.NumberOfSymbols = 1
+	if _, _, err := LoadSymbols(&hdr, bytes.NewReader(data)); err == nil {
+		t.Error("expected error for truncated auxiliary record")
+	}
+}
+
+func TestStringTableSectionName(t *testing.T) {
+	strtab := StringTable("\x14\x00\x00\x00.debug_info\x00x\x00")
+	s := ImageSectionHeader{}
+	copy(s.Name[:], "/4")
+	if name, err := strtab.SectionName(&s); err != nil || name != ".debug_info" {
+		t.Errorf("expected .debug_info got %q, %v", name, err)
+	}
+	copy(s.Name[:], "/99")
+	if _, err := strtab.SectionName(&s); err != ErrInvalidStringTable {
+		t.Errorf("expected ErrInvalidStringTable got %v", err)
+	}
+}
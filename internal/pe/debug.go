@@ -0,0 +1,311 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrInvalidDebugDirectory is returned when the debug directory or the
+	// data of one of its entries is malformed.
+	ErrInvalidDebugDirectory = errors.New("pe: invalid debug directory")
+
+	// ErrUnknownCodeViewSignature is returned when a CodeView record has a
+	// signature other than RSDS or NB10.
+	ErrUnknownCodeViewSignature = errors.New("pe: unknown codeview signature")
+)
+
+// Enumeration of CodeView record signatures.
+const (
+	CodeViewSignatureRSDS = 0x53445352 // "RSDS", PDB 7.0
+	CodeViewSignatureNB10 = 0x3031424E // "NB10", PDB 2.0
+)
+
+// GUID is a Windows globally unique identifier.
+type GUID struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// String returns the GUID in registry format, e.g.
+// {00000000-0000-0000-0000-000000000000}.
+func (g GUID) String() string {
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", g.Data1, g.Data2, g.Data3, g.Data4[:2], g.Data4[2:])
+}
+
+// DebugEntry is an entry of the debug directory along with its data. Data is
+// nil if the data could not be located.
+type DebugEntry struct {
+	ImageDebugDirectory
+	Data []byte
+}
+
+// CodeViewInfo is a decoded CodeView record, which identifies the PDB file
+// that contains the symbols of an image.
+type CodeViewInfo struct {
+	// Signature is one of the CodeViewSignature* constants.
+	Signature uint32
+
+	// GUID is the signature of the PDB. It is only set for RSDS records.
+	GUID GUID
+
+	// Timestamp is the signature of the PDB. It is only set for NB10
+	// records.
+	Timestamp uint32
+
+	// Age is the age of the PDB.
+	Age uint32
+
+	// Path is the path of the PDB at link time.
+	Path string
+}
+
+// PDBName returns the base name of the PDB path.
+func (c *CodeViewInfo) PDBName() string {
+	name := c.Path
+	if i := strings.LastIndexAny(name, `\/`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// SymbolServerKey returns the key that identifies the PDB on a symbol
+// server, which is the signature followed by the age, in hex.
+func (c *CodeViewInfo) SymbolServerKey() string {
+	if c.Signature == CodeViewSignatureNB10 {
+		return fmt.Sprintf("%08X%X", c.Timestamp, c.Age)
+	}
+	g := c.GUID
+	return fmt.Sprintf("%08X%04X%04X%X%X", g.Data1, g.Data2, g.Data3, g.Data4[:], c.Age)
+}
+
+// SymbolServerPath returns the path of the PDB relative to the root of a
+// symbol server, such as "tiny.pdb/<key>/tiny.pdb".
+func (c *CodeViewInfo) SymbolServerPath() string {
+	name := c.PDBName()
+	return name + "/" + c.SymbolServerKey() + "/" + name
+}
+
+// POGOEntry is an entry of a POGO record, describing a contribution to the
+// image by the linker.
+type POGOEntry struct {
+	RVA  uint32
+	Size uint32
+	Name string
+}
+
+// POGOInfo is a decoded POGO (profile guided optimization) record.
+type POGOInfo struct {
+	Signature uint32
+	Entries   []POGOEntry
+}
+
+// VCFeatureInfo is a decoded VC_FEATURE record, which counts the object
+// files linked into the image that were built with various features.
+type VCFeatureInfo struct {
+	PreVCPP11 uint32
+	CCPP      uint32
+	GS        uint32
+	SDL       uint32
+	GuardN    uint32
+}
+
+// DebugInfo contains the debug directory of an image, and the decoded
+// contents of its known entry types.
+type DebugInfo struct {
+	Entries []DebugEntry
+
+	// CodeView is the CodeView record, or nil if there is none.
+	CodeView *CodeViewInfo
+
+	// POGO is the POGO record, or nil if there is none.
+	POGO *POGOInfo
+
+	// VCFeature is the VC_FEATURE record, or nil if there is none.
+	VCFeature *VCFeatureInfo
+
+	// Repro is set if the image was built deterministically. ReproHash
+	// contains the hash of the build inputs, if the record has one.
+	Repro     bool
+	ReproHash []byte
+
+	// ExDLLCharacteristics contains the extended DLL characteristics, which
+	// are the ImageDLLCharacteristicsEx* values.
+	ExDLLCharacteristics uint32
+}
+
+// ParseCodeView decodes a CodeView debug record.
+func ParseCodeView(data []byte) (*CodeViewInfo, error) {
+	if len(data) < 4 {
+		return nil, ErrInvalidDebugDirectory
+	}
+	info := &CodeViewInfo{Signature: binary.LittleEndian.Uint32(data)}
+	var path []byte
+	switch info.Signature {
+	case CodeViewSignatureRSDS:
+		if len(data) < 24 {
+			return nil, ErrInvalidDebugDirectory
+		}
+		binary.Read(bytes.NewReader(data[4:20]), binary.LittleEndian, &info.GUID)
+		info.Age = binary.LittleEndian.Uint32(data[20:])
+		path = data[24:]
+	case CodeViewSignatureNB10:
+		if len(data) < 16 {
+			return nil, ErrInvalidDebugDirectory
+		}
+		info.Timestamp = binary.LittleEndian.Uint32(data[8:])
+		info.Age = binary.LittleEndian.Uint32(data[12:])
+		path = data[16:]
+	default:
+		return nil, ErrUnknownCodeViewSignature
+	}
+	if i := bytes.IndexByte(path, 0); i >= 0 {
+		path = path[:i]
+	}
+	info.Path = string(path)
+	return info, nil
+}
+
+// ParsePOGO decodes a POGO debug record.
+func ParsePOGO(data []byte) (*POGOInfo, error) {
+	if len(data) < 4 {
+		return nil, ErrInvalidDebugDirectory
+	}
+	info := &POGOInfo{Signature: binary.LittleEndian.Uint32(data)}
+	for data = data[4:]; len(data) >= 8; {
+		entry := POGOEntry{
+			RVA:  binary.LittleEndian.Uint32(data[0:]),
+			Size: binary.LittleEndian.Uint32(data[4:]),
+		}
+		name := data[8:]
+		n := bytes.IndexByte(name, 0)
+		if n < 0 {
+			return nil, ErrInvalidDebugDirectory
+		}
+		entry.Name = string(name[:n])
+		info.Entries = append(info.Entries, entry)
+
+		// Names are padded to a multiple of 4 bytes, including the null.
+		next := 8 + int(roundUp32(uint32(n+1), 4))
+		if next > len(data) {
+			break
+		}
+		data = data[next:]
+	}
+	return info, nil
+}
+
+// ParseVCFeature decodes a VC_FEATURE debug record.
+func ParseVCFeature(data []byte) (*VCFeatureInfo, error) {
+	info := &VCFeatureInfo{}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, info); err != nil {
+		return nil, ErrInvalidDebugDirectory
+	}
+	return info, nil
+}
+
+// LoadDebugDirectory loads the entries of the debug directory and their data
+// from a mapped image. Data that is not mapped into memory is not loaded.
+func LoadDebugDirectory(m *Module, mem io.ReaderAt) ([]DebugEntry, error) {
+	return loadDebugDirectory(m, mem, nil)
+}
+
+// loadDebugDirectory loads the entries of the debug directory from a mapped
+// image. If file is not nil, the data of the entries is read from it by file
+// offset; otherwise, it is read from mem by RVA.
+func loadDebugDirectory(m *Module, mem io.ReaderAt, file io.ReaderAt) ([]DebugEntry, error) {
+	dir := m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryDebug]
+	if dir.Size == 0 {
+		return nil, nil
+	}
+	if err := m.checkDirectory(dir); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, dir.Size/SizeOfImageDebugDirectory*SizeOfImageDebugDirectory)
+	if _, err := mem.ReadAt(data, int64(dir.VirtualAddress)); err != nil {
+		return nil, err
+	}
+	dirs := make([]ImageDebugDirectory, len(data)/SizeOfImageDebugDirectory)
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, dirs); err != nil {
+		return nil, err
+	}
+	entries := make([]DebugEntry, len(dirs))
+	for i := range entries {
+		entry := &entries[i]
+		entry.ImageDebugDirectory = dirs[i]
+		if entry.SizeOfData == 0 {
+			continue
+		}
+		if entry.SizeOfData > MaxDebugDataSize {
+			return nil, ErrInvalidDebugDirectory
+		}
+		r, off := mem, int64(entry.AddressOfRawData)
+		if file != nil {
+			r, off = file, int64(entry.PointerToRawData)
+		}
+		if off == 0 {
+			continue
+		}
+		entry.Data = make([]byte, entry.SizeOfData)
+		if _, err := r.ReadAt(entry.Data, off); err != nil {
+			return nil, ErrInvalidDebugDirectory
+		}
+	}
+	return entries, nil
+}
+
+// LoadDebugInfo loads and decodes the debug directory of a mapped image.
+func LoadDebugInfo(m *Module, mem io.ReaderAt) (*DebugInfo, error) {
+	entries, err := LoadDebugDirectory(m, mem)
+	if err != nil {
+		return nil, err
+	}
+	return newDebugInfo(entries)
+}
+
+// newDebugInfo decodes the known entries of a debug directory.
+func newDebugInfo(entries []DebugEntry) (*DebugInfo, error) {
+	info := &DebugInfo{Entries: entries}
+	for _, entry := range entries {
+		var err error
+		switch entry.Type {
+		case ImageDebugTypeCodeView:
+			if entry.Data != nil && info.CodeView == nil {
+				info.CodeView, err = ParseCodeView(entry.Data)
+			}
+		case ImageDebugTypePOGO:
+			if entry.Data != nil {
+				info.POGO, err = ParsePOGO(entry.Data)
+			}
+		case ImageDebugTypeVCFeature:
+			if entry.Data != nil {
+				info.VCFeature, err = ParseVCFeature(entry.Data)
+			}
+		case ImageDebugTypeRepro:
+			info.Repro = true
+			if len(entry.Data) >= 4 {
+				n := binary.LittleEndian.Uint32(entry.Data)
+				if uint64(n) > uint64(len(entry.Data)-4) {
+					return nil, ErrInvalidDebugDirectory
+				}
+				info.ReproHash = entry.Data[4 : 4+n]
+			}
+		case ImageDebugTypeExDLLCharacteristics:
+			if len(entry.Data) < 4 {
+				return nil, ErrInvalidDebugDirectory
+			}
+			info.ExDLLCharacteristics = binary.LittleEndian.Uint32(entry.Data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,56 @@
+package pe
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseCodeViewNB10(t *testing.T) {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint32(data[0:], CodeViewSignatureNB10)
+	binary.LittleEndian.PutUint32(data[8:], 0x5F5E1000)
+	binary.LittleEndian.PutUint32(data[12:], 2)
+	data = append(data, "old.pdb\x00"...)
+
+	info, err := ParseCodeView(data)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if info.Timestamp != 0x5F5E1000 || info.Age != 2 || info.Path != "old.pdb" {
+		t.Errorf("unexpected CodeView record %+v", info)
+	}
+	if key := info.SymbolServerKey(); key != "5F5E10002" {
+		t.Errorf("unexpected symbol server key %q", key)
+	}
+
+	if _, err := ParseCodeView([]byte("XXXX0000")); err != ErrUnknownCodeViewSignature {
+		t.Errorf("expected ErrUnknownCodeViewSignature got %v", err)
+	}
+	if _, err := ParseCodeView([]byte("RSDS")); err != ErrInvalidDebugDirectory {
+		t.Errorf("expected ErrInvalidDebugDirectory got %v", err)
+	}
+}
+
+func TestParseVCFeature(t *testing.T) {
+	data := make([]byte, 20)
+	for i := range data {
+		data[i] = byte(i / 4)
+	}
+	info, err := ParseVCFeature(data)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if *info != (VCFeatureInfo{0, 0x01010101, 0x02020202, 0x03030303, 0x04040404}) {
+		t.Errorf("unexpected VC_FEATURE record %+v", info)
+	}
+	if _, err := ParseVCFeature(data[:16]); err != ErrInvalidDebugDirectory {
+		t.Errorf("expected ErrInvalidDebugDirectory got %v", err)
+	}
+}
+
+func TestGUIDString(t *testing.T) {
+	g := GUID{0x12345678, 0x9ABC, 0xDEF0, [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	if s := g.String(); s != "{12345678-9ABC-DEF0-0102-030405060708}" {
+		t.Errorf("unexpected GUID string %q", s)
+	}
+}
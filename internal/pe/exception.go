@@ -0,0 +1,227 @@
+package pe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnsupportedExceptionData is returned when the exception directory
+// format of the machine type is not supported.
+var ErrUnsupportedExceptionData = errors.New("pe: unsupported exception data format")
+
+// RuntimeFunction is a parsed runtime function entry.
+type RuntimeFunction struct {
+	// BeginAddress is the RVA of the start of the function.
+	BeginAddress uint32
+
+	// EndAddress is the RVA of the end of the function.
+	EndAddress uint32
+
+	// UnwindInfoAddress is the RVA of the unwind information. For ARM64
+	// functions with packed unwind data, this is zero.
+	UnwindInfoAddress uint32
+
+	// PackedUnwindData contains the packed unwind data for ARM64 functions
+	// that have it.
+	PackedUnwindData uint32
+}
+
+// Contains returns whether or not the function contains rva.
+func (f RuntimeFunction) Contains(rva uint32) bool {
+	return rva >= f.BeginAddress && rva < f.EndAddress
+}
+
+// RuntimeFunctionEntrySize returns the size of a runtime function entry for
+// the machine type, or 0 if the exception directory of the machine type is
+// not supported.
+func RuntimeFunctionEntrySize(machine int) int {
+	switch machine {
+	case ImageFileMachineAMD64:
+		return 12
+	case ImageFileMachineARM64:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// LoadRuntimeFunctions loads the runtime function entries of the exception
+// directory from a mapped image.
+func LoadRuntimeFunctions(m *Module, mem io.ReaderAt) ([]RuntimeFunction, error) {
+	dir := m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryException]
+	if dir.Size == 0 {
+		return nil, nil
+	}
+	if err := m.checkDirectory(dir); err != nil {
+		return nil, err
+	}
+
+	machine := int(m.Header.FileHeader.Machine)
+	size := RuntimeFunctionEntrySize(machine)
+	if size == 0 {
+		return nil, ErrUnsupportedExceptionData
+	}
+
+	data := make([]byte, dir.Size/uint32(size)*uint32(size))
+	if _, err := mem.ReadAt(data, int64(dir.VirtualAddress)); err != nil {
+		return nil, err
+	}
+
+	funcs := make([]RuntimeFunction, 0, len(data)/size)
+	for i := 0; i < len(data); i += size {
+		entry := data[i : i+size]
+		switch machine {
+		case ImageFileMachineAMD64:
+			funcs = append(funcs, RuntimeFunction{
+				BeginAddress:      binary.LittleEndian.Uint32(entry[0:4]),
+				EndAddress:        binary.LittleEndian.Uint32(entry[4:8]),
+				UnwindInfoAddress: binary.LittleEndian.Uint32(entry[8:12]),
+			})
+		case ImageFileMachineARM64:
+			fn, err := loadARM64RuntimeFunction(mem, binary.LittleEndian.Uint32(entry[0:4]), binary.LittleEndian.Uint32(entry[4:8]))
+			if err != nil {
+				return nil, err
+			}
+			funcs = append(funcs, fn)
+		}
+	}
+
+	return funcs, nil
+}
+
+// loadARM64RuntimeFunction decodes an ARM64 runtime function entry. The
+// function length is stored in the packed unwind data or in the header of
+// the unwind data.
+func loadARM64RuntimeFunction(mem io.ReaderAt, begin, unwind uint32) (RuntimeFunction, error) {
+	fn := RuntimeFunction{BeginAddress: begin}
+	if unwind&3 != 0 {
+		fn.PackedUnwindData = unwind
+		fn.EndAddress = begin + (unwind>>2&0x7FF)*4
+		return fn, nil
+	}
+	b := [4]byte{}
+	if _, err := mem.ReadAt(b[:], int64(unwind)); err != nil {
+		return fn, err
+	}
+	fn.UnwindInfoAddress = unwind
+	fn.EndAddress = begin + (binary.LittleEndian.Uint32(b[:])&0x3FFFF)*4
+	return fn, nil
+}
+
+// LookupRuntimeFunction finds the runtime function containing rva. funcs
+// must be sorted by address, as they are in the exception directory.
+func LookupRuntimeFunction(funcs []RuntimeFunction, rva uint32) *RuntimeFunction {
+	lo, hi := 0, len(funcs)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch {
+		case rva < funcs[mid].BeginAddress:
+			hi = mid
+		case rva >= funcs[mid].EndAddress:
+			lo = mid + 1
+		default:
+			return &funcs[mid]
+		}
+	}
+	return nil
+}
+
+// UnwindCode is a single slot of the unwind code array of x64 unwind
+// information. Some operations use one or two additional slots to hold
+// their operands.
+type UnwindCode uint16
+
+// CodeOffset returns the offset from the start of the prolog of the end of
+// the instruction that performs this operation.
+func (c UnwindCode) CodeOffset() uint8 {
+	return uint8(c)
+}
+
+// Op returns the unwind operation code.
+func (c UnwindCode) Op() uint8 {
+	return uint8(c>>8) & 0xF
+}
+
+// OpInfo returns the operation info, whose meaning depends on the operation.
+func (c UnwindCode) OpInfo() uint8 {
+	return uint8(c >> 12)
+}
+
+// UnwindInfo contains parsed x64 unwind information.
+type UnwindInfo struct {
+	Version       uint8
+	Flags         uint8
+	SizeOfProlog  uint8
+	FrameRegister uint8
+	FrameOffset   uint8
+
+	// Codes contains the unwind code slots.
+	Codes []UnwindCode
+
+	// ExceptionHandler is the RVA of the language-specific exception
+	// handler, if Flags contains UnwFlagEHandler or UnwFlagUHandler.
+	ExceptionHandler uint32
+
+	// HandlerData is the RVA of the language-specific handler data, which
+	// immediately follows the handler address. Its format is specific to the
+	// handler.
+	HandlerData uint32
+
+	// Chained is the chained runtime function entry, if Flags contains
+	// UnwFlagChainInfo.
+	Chained *RuntimeFunction
+}
+
+// LoadUnwindInfo loads x64 unwind information at rva from a mapped image.
+func LoadUnwindInfo(mem io.ReaderAt, rva uint32) (*UnwindInfo, error) {
+	hdr := [4]byte{}
+	if _, err := mem.ReadAt(hdr[:], int64(rva)); err != nil {
+		return nil, err
+	}
+
+	info := &UnwindInfo{
+		Version:       hdr[0] & 0x7,
+		Flags:         hdr[0] >> 3,
+		SizeOfProlog:  hdr[1],
+		FrameRegister: hdr[3] & 0xF,
+		FrameOffset:   hdr[3] >> 4,
+	}
+	if info.Version != 1 && info.Version != 2 {
+		return nil, fmt.Errorf("pe: unknown unwind info version %d", info.Version)
+	}
+
+	// The unwind code array is always padded to an even number of slots.
+	count := int(hdr[2])
+	slots := make([]byte, (count+count&1)*2)
+	if _, err := mem.ReadAt(slots, int64(rva)+4); err != nil {
+		return nil, err
+	}
+	info.Codes = make([]UnwindCode, count)
+	for i := range info.Codes {
+		info.Codes[i] = UnwindCode(binary.LittleEndian.Uint16(slots[i*2:]))
+	}
+
+	next := rva + 4 + uint32(len(slots))
+	b := [12]byte{}
+	switch {
+	case info.Flags&UnwFlagChainInfo != 0:
+		if _, err := mem.ReadAt(b[:12], int64(next)); err != nil {
+			return nil, err
+		}
+		info.Chained = &RuntimeFunction{
+			BeginAddress:      binary.LittleEndian.Uint32(b[0:4]),
+			EndAddress:        binary.LittleEndian.Uint32(b[4:8]),
+			UnwindInfoAddress: binary.LittleEndian.Uint32(b[8:12]),
+		}
+	case info.Flags&(UnwFlagEHandler|UnwFlagUHandler) != 0:
+		if _, err := mem.ReadAt(b[:4], int64(next)); err != nil {
+			return nil, err
+		}
+		info.ExceptionHandler = binary.LittleEndian.Uint32(b[0:4])
+		info.HandlerData = next + 4
+	}
+
+	return info, nil
+}
@@ -0,0 +1,76 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestLoadRuntimeFunctions(t *testing.T) {
+	image := make([]byte, 0x200)
+	funcs := []ImageRuntimeFunctionEntry{
+		{BeginAddress: 0x1000, EndAddress: 0x1010, UnwindInfoAddress: 0x100},
+		{BeginAddress: 0x1010, EndAddress: 0x1040, UnwindInfoAddress: 0x120},
+	}
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, funcs)
+	copy(image[0x80:], buf.Bytes())
+
+	// Unwind info with an exception handler: push rbp; sub rsp, 0x20.
+	copy(image[0x100:], []byte{
+		0x01 | UnwFlagEHandler<<3, 0x08, 0x02, 0x00,
+		0x08, 0x32, // offset 8: UWOP_ALLOC_SMALL, (0x20-8)/8 = 3
+		0x01, 0x50, // offset 1: UWOP_PUSH_NONVOL, rbp
+		0x00, 0x20, 0x00, 0x00, // handler RVA 0x2000
+		0xAA, 0xBB, // handler data
+	})
+
+	// Chained unwind info.
+	copy(image[0x120:], []byte{
+		0x01 | UnwFlagChainInfo<<3, 0x00, 0x01, 0x00,
+		0x00, 0x00, 0x00, 0x00, // one code, padded to two slots
+		0x00, 0x10, 0x00, 0x00, 0x10, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00,
+	})
+
+	m := &Module{IsPE64: true}
+	m.Header.FileHeader.Machine = ImageFileMachineAMD64
+	m.Header.OptionalHeader.SizeOfImage = uint32(len(image))
+	m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryException] = ImageDataDirectory{VirtualAddress: 0x80, Size: 24}
+
+	r := bytes.NewReader(image)
+	loaded, err := LoadRuntimeFunctions(m, r)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(loaded) != 2 || loaded[1].BeginAddress != 0x1010 || loaded[1].UnwindInfoAddress != 0x120 {
+		t.Fatalf("unexpected runtime functions %+v", loaded)
+	}
+	if fn := LookupRuntimeFunction(loaded, 0x1020); fn == nil || fn.BeginAddress != 0x1010 {
+		t.Errorf("expected lookup of 0x1020 to find second function, got %+v", fn)
+	}
+	if fn := LookupRuntimeFunction(loaded, 0x1040); fn != nil {
+		t.Errorf("expected lookup of 0x1040 to find nothing, got %+v", fn)
+	}
+
+	info, err := LoadUnwindInfo(r, loaded[0].UnwindInfoAddress)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if info.Flags != UnwFlagEHandler || info.SizeOfProlog != 8 || len(info.Codes) != 2 {
+		t.Errorf("unexpected unwind info %+v", info)
+	}
+	if info.Codes[0].CodeOffset() != 8 || info.Codes[0].Op() != 2 || info.Codes[0].OpInfo() != 3 {
+		t.Errorf("unexpected unwind code %04x", info.Codes[0])
+	}
+	if info.ExceptionHandler != 0x2000 || info.HandlerData != 0x10C {
+		t.Errorf("unexpected handler %#x data %#x", info.ExceptionHandler, info.HandlerData)
+	}
+
+	chained, err := LoadUnwindInfo(r, loaded[1].UnwindInfoAddress)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if chained.Chained == nil || *chained.Chained != (RuntimeFunction{0x1000, 0x1010, 0x100, 0}) {
+		t.Errorf("unexpected chained function %+v", chained.Chained)
+	}
+}
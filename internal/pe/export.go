@@ -2,9 +2,14 @@ package pe
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 )
 
+// ErrInvalidExportDirectory is returned when the export directory is
+// malformed.
+var ErrInvalidExportDirectory = errors.New("pe: invalid export directory")
+
 // ExportTable is a table of module exports.
 type ExportTable struct {
 	symbols  map[string]uint64
@@ -22,7 +27,7 @@ func (t *ExportTable) Ordinal(ordinal uint16) (addr uint64) {
 }
 
 // LoadExports returns a symbol table.
-func LoadExports(m *Module, mem io.ReadWriteSeeker, base uint64) (*ExportTable, error) {
+func LoadExports(m *Module, mem io.ReadSeeker, base uint64) (*ExportTable, error) {
 	table := &ExportTable{
 		symbols:  map[string]uint64{},
 		ordinals: map[uint16]uint64{},
@@ -33,43 +38,61 @@ func LoadExports(m *Module, mem io.ReadWriteSeeker, base uint64) (*ExportTable,
 		return table, nil
 	}
 
+	if err := m.checkDirectory(dir); err != nil {
+		return nil, err
+	}
+
 	// Load export directory header
 	header := ImageExportDirectory{}
 	mem.Seek(int64(dir.VirtualAddress), io.SeekStart)
-	binary.Read(mem, binary.LittleEndian, &header)
+	if err := binary.Read(mem, binary.LittleEndian, &header); err != nil {
+		return nil, err
+	}
+	if header.NumberOfFunctions > MaxExports || header.NumberOfNames > MaxExports {
+		return nil, ErrInvalidExportDirectory
+	}
 
 	// Load addresses
 	addresses := make([]uint32, header.NumberOfFunctions)
 	mem.Seek(int64(header.AddressOfFunctions), io.SeekStart)
-	for i := range addresses {
-		b := [4]byte{}
-		mem.Read(b[:])
-		addresses[i] = binary.LittleEndian.Uint32(b[:])
-		table.ordinals[uint16(i)] = base + uint64(addresses[i])
+	if err := binary.Read(mem, binary.LittleEndian, addresses); err != nil {
+		return nil, err
+	}
+	// Ordinals are biased by the ordinal base of the directory. Entries
+	// past the largest ordinal can only be exported by name.
+	for i, addr := range addresses {
+		ordinal := uint64(header.Base) + uint64(i)
+		if ordinal > 0xFFFF {
+			break
+		}
+		table.ordinals[uint16(ordinal)] = base + uint64(addr)
 	}
 
 	// Load name ordinals
 	nameords := make([]uint16, header.NumberOfNames)
 	mem.Seek(int64(header.AddressOfNameOrdinals), io.SeekStart)
-	for i := range nameords {
-		b := [2]byte{}
-		mem.Read(b[:])
-		nameords[i] = binary.LittleEndian.Uint16(b[:])
+	if err := binary.Read(mem, binary.LittleEndian, nameords); err != nil {
+		return nil, err
 	}
 
 	// Load name addresses
 	nameaddrs := make([]uint32, header.NumberOfNames)
 	mem.Seek(int64(header.AddressOfNames), io.SeekStart)
-	for i := range nameaddrs {
-		b := [4]byte{}
-		mem.Read(b[:])
-		nameaddrs[i] = binary.LittleEndian.Uint32(b[:])
+	if err := binary.Read(mem, binary.LittleEndian, nameaddrs); err != nil {
+		return nil, err
 	}
 
 	// Load names
 	for i, nameaddr := range nameaddrs {
+		if int(nameords[i]) >= len(addresses) {
+			return nil, ErrInvalidExportDirectory
+		}
 		mem.Seek(int64(nameaddr), io.SeekStart)
-		table.symbols[readsz(mem)] = base + uint64(addresses[nameords[i]])
+		name, err := readsz(mem)
+		if err != nil {
+			return nil, err
+		}
+		table.symbols[name] = base + uint64(addresses[nameords[i]])
 	}
 
 	return table, nil
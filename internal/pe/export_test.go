@@ -0,0 +1,68 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// TestExportsOrdinalBase checks that export ordinals are biased by the
+// ordinal base of the export directory. tiny.dll exports Add as ordinal 1,
+// with an ordinal base of 1.
+func TestExportsOrdinalBase(t *testing.T) {
+	tiny, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFile(bytes.NewReader(tiny))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports, err := f.Exports()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if addr := exports.Proc("Add"); addr == 0 || exports.Ordinal(1) != addr {
+		t.Errorf("expected ordinal 1 to be Add at %#x got %#x", addr, exports.Ordinal(1))
+	}
+	if addr := exports.Ordinal(0); addr != 0 {
+		t.Errorf("expected no export for ordinal 0 got %#x", addr)
+	}
+}
+
+// TestExportsOrdinalOverflow checks that exports whose biased ordinal does
+// not fit in 16 bits are only exported by name, instead of wrapping around
+// to lower ordinals.
+func TestExportsOrdinalOverflow(t *testing.T) {
+	tiny, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewFile(bytes.NewReader(tiny))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := f.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryExport]
+	off, ok := f.RVAToOffset(dir.VirtualAddress)
+	if !ok {
+		t.Fatal("export directory is not in the file")
+	}
+	patched := append([]byte{}, tiny...)
+	binary.LittleEndian.PutUint32(patched[off+16:], 0x10000)
+
+	f, err = NewFile(bytes.NewReader(patched))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exports, err := f.Exports()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if addr := exports.Ordinal(0); addr != 0 {
+		t.Errorf("expected no export for ordinal 0 got %#x", addr)
+	}
+	if addr := exports.Proc("Add"); addr == 0 {
+		t.Error("expected Add to be exported by name")
+	}
+}
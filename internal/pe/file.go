@@ -0,0 +1,244 @@
+package pe
+
+import (
+	"io"
+	"sync"
+)
+
+// lazy holds a lazily loaded value. It is safe for concurrent use.
+type lazy struct {
+	once  sync.Once
+	value interface{}
+	err   error
+}
+
+// get returns the value, calling load the first time it is called.
+func (l *lazy) get(load func() (interface{}, error)) (interface{}, error) {
+	l.once.Do(func() {
+		l.value, l.err = load()
+	})
+	return l.value, l.err
+}
+
+// File is a PE file parsed from an io.ReaderAt. The headers are parsed when
+// the file is opened, and data directories are parsed on first use, reading
+// only the parts of the file they occupy. A File is safe for concurrent use,
+// provided the underlying io.ReaderAt is.
+type File struct {
+	*Module
+
+	r io.ReaderAt
+
+	exports     lazy
+	imports     lazy
+	relocs      lazy
+	loadConfig  lazy
+	guard       lazy
+	runtimeFunc lazy
+	debug       lazy
+	symbols     lazy
+	rich        lazy
+}
+
+// NewFile parses the headers of a PE file.
+func NewFile(r io.ReaderAt) (*File, error) {
+	m, err := LoadModule(io.NewSectionReader(r, 0, 1<<63-1))
+	if err != nil {
+		return nil, err
+	}
+	return &File{Module: m, r: r}, nil
+}
+
+// Image returns a reader for the file as it would be mapped in memory, with
+// offsets being RVAs. Areas of the image that are not backed by the file are
+// read as zeros.
+func (f *File) Image() io.ReaderAt {
+	return f.ImageReader(f.r)
+}
+
+// image returns a seekable reader over the mapped image.
+func (f *File) image() io.ReadSeeker {
+	return io.NewSectionReader(f.Image(), 0, int64(f.Header.OptionalHeader.SizeOfImage))
+}
+
+// Exports returns the export table of the file. Addresses are relative to
+// the preferred image base.
+func (f *File) Exports() (*ExportTable, error) {
+	v, err := f.exports.get(func() (interface{}, error) {
+		return LoadExports(f.Module, f.image(), f.Header.OptionalHeader.ImageBase)
+	})
+	table, _ := v.(*ExportTable)
+	return table, err
+}
+
+// Imports returns the unresolved imports of the file.
+func (f *File) Imports() ([]Import, error) {
+	v, err := f.imports.get(func() (interface{}, error) {
+		return LoadImports(f.Module, f.image())
+	})
+	imports, _ := v.([]Import)
+	return imports, err
+}
+
+// BaseRelocs returns the base relocations of the file.
+func (f *File) BaseRelocs() ([]BaseRelocation, error) {
+	v, err := f.relocs.get(func() (interface{}, error) {
+		return LoadBaseRelocs(f.Module, f.image())
+	})
+	relocs, _ := v.([]BaseRelocation)
+	return relocs, err
+}
+
+// LoadConfig returns the load configuration directory of the file, or nil if
+// it has none.
+func (f *File) LoadConfig() (*ImageLoadConfigDirectory64, error) {
+	v, err := f.loadConfig.get(func() (interface{}, error) {
+		return LoadConfigDirectory(f.Module, f.Image())
+	})
+	cfg, _ := v.(*ImageLoadConfigDirectory64)
+	return cfg, err
+}
+
+// GuardInfo returns the Control Flow Guard tables of the file.
+func (f *File) GuardInfo() (*GuardInfo, error) {
+	v, err := f.guard.get(func() (interface{}, error) {
+		return LoadGuardInfo(f.Module, f.Image(), f.Header.OptionalHeader.ImageBase)
+	})
+	guard, _ := v.(*GuardInfo)
+	return guard, err
+}
+
+// RuntimeFunctions returns the runtime function entries of the exception
+// directory of the file.
+func (f *File) RuntimeFunctions() ([]RuntimeFunction, error) {
+	v, err := f.runtimeFunc.get(func() (interface{}, error) {
+		return LoadRuntimeFunctions(f.Module, f.Image())
+	})
+	funcs, _ := v.([]RuntimeFunction)
+	return funcs, err
+}
+
+// DebugInfo returns the decoded debug directory of the file. The data of
+// debug entries is read by file offset, so entries that are not mapped into
+// memory are included.
+func (f *File) DebugInfo() (*DebugInfo, error) {
+	v, err := f.debug.get(func() (interface{}, error) {
+		entries, err := loadDebugDirectory(f.Module, f.Image(), f.r)
+		if err != nil {
+			return nil, err
+		}
+		return newDebugInfo(entries)
+	})
+	info, _ := v.(*DebugInfo)
+	return info, err
+}
+
+// Symbols returns the COFF symbol table of the file, which is read by file
+// offset. It returns nil if the file has no symbol table.
+func (f *File) Symbols() ([]Symbol, error) {
+	v, err := f.symbols.get(func() (interface{}, error) {
+		syms, _, err := LoadSymbols(&f.Header.FileHeader, f.r)
+		return syms, err
+	})
+	syms, _ := v.([]Symbol)
+	return syms, err
+}
+
+// RichHeader returns the decoded Rich header of the file, or nil if it has
+// none.
+func (f *File) RichHeader() (*RichHeader, error) {
+	v, err := f.rich.get(func() (interface{}, error) {
+		return LoadRichHeader(f.Module, f.r)
+	})
+	rich, _ := v.(*RichHeader)
+	return rich, err
+}
+
+// imageReader reads a PE file as though it were mapped in memory, following
+// the same rules as the Windows image loader.
+type imageReader struct {
+	m *Module
+	r io.ReaderAt
+}
+
+// ReadAt implements io.ReaderAt.
+func (ir imageReader) ReadAt(p []byte, off int64) (int, error) {
+	size := int64(ir.m.Header.OptionalHeader.SizeOfImage)
+	if off < 0 || off >= size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && off < size {
+		// Find the file range backing off, up to the next boundary.
+		chunk := p[n:]
+		if rem := size - off; int64(len(chunk)) > rem {
+			chunk = chunk[:rem]
+		}
+		foff, avail, next := ir.m.fileRange(uint32(off))
+		if int64(len(chunk)) > int64(next) {
+			chunk = chunk[:next]
+		}
+		read := 0
+		if avail > 0 {
+			c := chunk
+			if int64(len(c)) > int64(avail) {
+				c = c[:avail]
+			}
+			m, err := ir.r.ReadAt(c, int64(foff))
+			if err != nil && err != io.EOF {
+				return n + m, err
+			}
+			read = m
+		}
+		for i := read; i < len(chunk); i++ {
+			chunk[i] = 0
+		}
+		n += len(chunk)
+		off += int64(len(chunk))
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fileRange returns the file offset backing rva and the number of bytes
+// available there. next is the number of bytes until the next region of the
+// image starts, or until the end of the image. Bytes beyond avail, up to
+// next, are not backed by the file and read as zero.
+func (m *Module) fileRange(rva uint32) (offset, avail, next uint32) {
+	hdr := m.Header.OptionalHeader
+	next = hdr.SizeOfImage - rva
+	if m.MappedFlat() {
+		return rva, next, next
+	}
+	for _, s := range m.Sections {
+		if s.VirtualAddress > rva && s.VirtualAddress-rva < next {
+			next = s.VirtualAddress - rva
+		}
+	}
+	if rva < hdr.SizeOfHeaders {
+		avail = hdr.SizeOfHeaders - rva
+		return rva, min32(avail, next), next
+	}
+	for _, s := range m.Sections {
+		rawSize := roundUp32(s.SizeOfRawData, hdr.FileAlignment)
+		virtSize := s.PhysicalAddressOrVirtualSize
+		if virtSize == 0 {
+			virtSize = rawSize
+		}
+		if rva < s.VirtualAddress || rva-s.VirtualAddress >= m.virtualExtent(&s) {
+			continue
+		}
+		ptr := s.PointerToRawData
+		if hdr.FileAlignment >= 0x200 {
+			ptr &^= 0x1FF
+		}
+		delta := rva - s.VirtualAddress
+		if copySize := min32(rawSize, virtSize); delta < copySize {
+			avail = copySize - delta
+		}
+		return ptr + delta, min32(avail, next), next
+	}
+	return 0, 0, next
+}
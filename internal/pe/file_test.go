@@ -0,0 +1,89 @@
+package pe
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// countingReaderAt records the extent of the reads made from a reader.
+type countingReaderAt struct {
+	mu  sync.Mutex
+	r   io.ReaderAt
+	end int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	c.mu.Lock()
+	if end := off + int64(len(p)); end > c.end {
+		c.end = end
+	}
+	c.mu.Unlock()
+	return c.r.ReadAt(p, off)
+}
+
+func TestFile(t *testing.T) {
+	tiny, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &countingReaderAt{r: bytes.NewReader(tiny)}
+
+	f, err := NewFile(r)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if r.end > int64(f.Header.OptionalHeader.SizeOfHeaders) {
+		t.Errorf("expected only headers to be read, read up to %#x", r.end)
+	}
+
+	// The mapped image contains the section data at its RVA.
+	section := f.Sections[0]
+	data := make([]byte, section.SizeOfRawData)
+	if _, err := f.Image().ReadAt(data, int64(section.VirtualAddress)); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if !bytes.Equal(data, tiny[section.PointerToRawData:section.PointerToRawData+section.SizeOfRawData]) {
+		t.Error("section data does not match file")
+	}
+
+	// Space between sections is zero.
+	gap := make([]byte, 0x10)
+	if _, err := f.Image().ReadAt(gap, int64(section.VirtualAddress+0x800)); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if !bytes.Equal(gap, make([]byte, len(gap))) {
+		t.Errorf("expected zeros past section data got % x", gap)
+	}
+
+	// Directories are loaded once, even with concurrent callers.
+	tables := make([]*ExportTable, 8)
+	wg := sync.WaitGroup{}
+	for i := range tables {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tables[i], _ = f.Exports()
+		}(i)
+	}
+	wg.Wait()
+	for _, table := range tables {
+		if table == nil || table != tables[0] {
+			t.Fatal("expected the same export table from every caller")
+		}
+	}
+	if addr := tables[0].Proc("Add"); addr == 0 || addr < f.Header.OptionalHeader.ImageBase {
+		t.Errorf("unexpected address for Add: %#x", addr)
+	}
+
+	_, err = f.BaseRelocs()
+	if err != nil {
+		t.Errorf("expected nil error got %v", err)
+	}
+	_, err = f.Imports()
+	if err != nil {
+		t.Errorf("expected nil error got %v", err)
+	}
+}
@@ -50,6 +50,70 @@ const (
 	// SizeOfImageDataDirectory is the on-disk size of the ImageDataDirectory
 	// structure.
 	SizeOfImageDataDirectory = 8
+
+	// SizeOfImageSectionHeader is the on-disk size of the
+	// ImageSectionHeader structure.
+	SizeOfImageSectionHeader = 40
+
+	// SizeOfImageBaseRelocation is the on-disk size of the
+	// ImageBaseRelocation structure.
+	SizeOfImageBaseRelocation = 8
+
+	// SizeOfImageImportDescriptor is the on-disk size of the
+	// ImageImportDescriptor structure.
+	SizeOfImageImportDescriptor = 20
+
+	// SizeOfImageExportDirectory is the on-disk size of the
+	// ImageExportDirectory structure.
+	SizeOfImageExportDirectory = 40
+
+	// SizeOfImageTLSDirectory32 is the on-disk size of the
+	// ImageTLSDirectory32 structure.
+	SizeOfImageTLSDirectory32 = 24
+
+	// SizeOfImageTLSDirectory64 is the on-disk size of the
+	// ImageTLSDirectory64 structure.
+	SizeOfImageTLSDirectory64 = 40
+
+	// SizeOfImageLoadConfigDirectory32 is the on-disk size of the newest
+	// known version of the ImageLoadConfigDirectory32 structure.
+	SizeOfImageLoadConfigDirectory32 = 192
+
+	// SizeOfImageLoadConfigDirectory64 is the on-disk size of the newest
+	// known version of the ImageLoadConfigDirectory64 structure.
+	SizeOfImageLoadConfigDirectory64 = 320
+
+	// SizeOfImageDebugDirectory is the on-disk size of the
+	// ImageDebugDirectory structure.
+	SizeOfImageDebugDirectory = 28
+
+	// SizeOfImageSymbol is the on-disk size of the ImageSymbol structure,
+	// and of each auxiliary symbol record.
+	SizeOfImageSymbol = 18
+
+	// SizeOfImageRelocation is the on-disk size of the ImageRelocation
+	// structure.
+	SizeOfImageRelocation = 10
+
+	// SizeOfImageArchiveMemberHeader is the on-disk size of the
+	// ImageArchiveMemberHeader structure.
+	SizeOfImageArchiveMemberHeader = 60
+
+	// SizeOfImportObjectHeader is the on-disk size of the
+	// ImportObjectHeader structure.
+	SizeOfImportObjectHeader = 20
+
+	// SizeOfImageResourceDirectory is the on-disk size of the
+	// ImageResourceDirectory structure.
+	SizeOfImageResourceDirectory = 16
+
+	// SizeOfImageResourceDirectoryEntry is the on-disk size of the
+	// ImageResourceDirectoryEntry structure.
+	SizeOfImageResourceDirectoryEntry = 8
+
+	// SizeOfImageResourceDataEntry is the on-disk size of the
+	// ImageResourceDataEntry structure.
+	SizeOfImageResourceDataEntry = 16
 )
 
 // Enumeration of useful field offsets.
@@ -520,3 +584,547 @@ func (i ImageTLSDirectory32) To64() ImageTLSDirectory64 {
 		Characteristics:       i.Characteristics,
 	}
 }
+
+// Enumeration of resource directory entry flags.
+const (
+	// ImageResourceNameIsString is set in the Name field of a resource
+	// directory entry when the low bits are the offset of a name string
+	// rather than an ID.
+	ImageResourceNameIsString = 0x80000000
+
+	// ImageResourceDataIsDirectory is set in the OffsetToData field of a
+	// resource directory entry when the low bits are the offset of another
+	// resource directory rather than a data entry.
+	ImageResourceDataIsDirectory = 0x80000000
+)
+
+// ImageResourceDirectory is the header of a table in the resource tree. It
+// is followed by NumberOfNamedEntries entries with string names, then by
+// NumberOfIDEntries entries with integer IDs.
+type ImageResourceDirectory struct {
+	Characteristics      uint32
+	TimeDateStamp        uint32
+	MajorVersion         uint16
+	MinorVersion         uint16
+	NumberOfNamedEntries uint16
+	NumberOfIDEntries    uint16
+}
+
+// ImageResourceDirectoryEntry is an entry of a resource directory table.
+// Offsets are relative to the start of the resource directory.
+type ImageResourceDirectoryEntry struct {
+	Name         uint32
+	OffsetToData uint32
+}
+
+// ImageResourceDataEntry describes a leaf of the resource tree.
+type ImageResourceDataEntry struct {
+	OffsetToData uint32
+	Size         uint32
+	CodePage     uint32
+	Reserved     uint32
+}
+
+// Enumeration of x64 unwind info flags.
+const (
+	UnwFlagNHandler  = 0x0
+	UnwFlagEHandler  = 0x1
+	UnwFlagUHandler  = 0x2
+	UnwFlagChainInfo = 0x4
+)
+
+// ImageRuntimeFunctionEntry is an entry in the exception directory of x64
+// images. Each entry describes the unwind information of a single function.
+type ImageRuntimeFunctionEntry struct {
+	BeginAddress      uint32
+	EndAddress        uint32
+	UnwindInfoAddress uint32
+}
+
+// ImageARM64RuntimeFunctionEntry is an entry in the exception directory of
+// ARM64 images. UnwindData contains either the RVA of the unwind data, or the
+// packed unwind data itself if the lower two bits are nonzero.
+type ImageARM64RuntimeFunctionEntry struct {
+	BeginAddress uint32
+	UnwindData   uint32
+}
+
+// Enumeration of Control Flow Guard flags in the load configuration.
+const (
+	ImageGuardCFInstrumented                 = 0x00000100
+	ImageGuardCFWInstrumented                = 0x00000200
+	ImageGuardCFFunctionTablePresent         = 0x00000400
+	ImageGuardSecurityCookieUnused           = 0x00000800
+	ImageGuardProtectDelayLoadIAT            = 0x00001000
+	ImageGuardDelayLoadIATInItsOwnSection    = 0x00002000
+	ImageGuardCFExportSuppressionInfoPresent = 0x00004000
+	ImageGuardCFEnableExportSuppression      = 0x00008000
+	ImageGuardCFLongJumpTablePresent         = 0x00010000
+	ImageGuardRFInstrumented                 = 0x00020000
+	ImageGuardRFEnable                       = 0x00040000
+	ImageGuardRFStrict                       = 0x00080000
+	ImageGuardRetpolinePresent               = 0x00100000
+	ImageGuardEHContinuationTablePresent     = 0x00400000
+	ImageGuardXFGEnabled                     = 0x00800000
+	ImageGuardCastGuardPresent               = 0x01000000
+	ImageGuardMemcpyPresent                  = 0x02000000
+	ImageGuardCFFunctionTableSizeMask        = 0xF0000000
+	ImageGuardCFFunctionTableSizeShift       = 28
+)
+
+// Enumeration of debug directory entry types.
+const (
+	ImageDebugTypeUnknown              = 0
+	ImageDebugTypeCOFF                 = 1
+	ImageDebugTypeCodeView             = 2
+	ImageDebugTypeFPO                  = 3
+	ImageDebugTypeMisc                 = 4
+	ImageDebugTypeException            = 5
+	ImageDebugTypeFixup                = 6
+	ImageDebugTypeOMAPToSrc            = 7
+	ImageDebugTypeOMAPFromSrc          = 8
+	ImageDebugTypeBorland              = 9
+	ImageDebugTypeReserved10           = 10
+	ImageDebugTypeCLSID                = 11
+	ImageDebugTypeVCFeature            = 12
+	ImageDebugTypePOGO                 = 13
+	ImageDebugTypeILTCG                = 14
+	ImageDebugTypeMPX                  = 15
+	ImageDebugTypeRepro                = 16
+	ImageDebugTypeEmbeddedPortablePDB  = 17
+	ImageDebugTypeSPGO                 = 18
+	ImageDebugTypePDBChecksum          = 19
+	ImageDebugTypeExDLLCharacteristics = 20
+)
+
+// Enumeration of extended DLL characteristics values, found in the debug
+// directory.
+const (
+	ImageDLLCharacteristicsExCETCompat                        = 0x01
+	ImageDLLCharacteristicsExCETCompatStrictMode              = 0x02
+	ImageDLLCharacteristicsExCETSetContextIPValidationRelaxed = 0x04
+	ImageDLLCharacteristicsExCETDynamicAPIsAllowInProc        = 0x08
+	ImageDLLCharacteristicsExForwardCFICompat                 = 0x40
+	ImageDLLCharacteristicsExHotpatchCompatible               = 0x80
+)
+
+// Enumeration of special COFF symbol section numbers.
+const (
+	ImageSymUndefined = 0
+	ImageSymAbsolute  = -1
+	ImageSymDebug     = -2
+)
+
+// Enumeration of COFF symbol complex types. The complex type is stored in
+// bits 4-5 of the symbol type.
+const (
+	ImageSymDTypeNull     = 0
+	ImageSymDTypePointer  = 1
+	ImageSymDTypeFunction = 2
+	ImageSymDTypeArray    = 3
+)
+
+// Enumeration of COFF symbol storage classes.
+const (
+	ImageSymClassEndOfFunction   = 0xFF
+	ImageSymClassNull            = 0
+	ImageSymClassAutomatic       = 1
+	ImageSymClassExternal        = 2
+	ImageSymClassStatic          = 3
+	ImageSymClassRegister        = 4
+	ImageSymClassExternalDef     = 5
+	ImageSymClassLabel           = 6
+	ImageSymClassUndefinedLabel  = 7
+	ImageSymClassMemberOfStruct  = 8
+	ImageSymClassArgument        = 9
+	ImageSymClassStructTag       = 10
+	ImageSymClassMemberOfUnion   = 11
+	ImageSymClassUnionTag        = 12
+	ImageSymClassTypeDefinition  = 13
+	ImageSymClassUndefinedStatic = 14
+	ImageSymClassEnumTag         = 15
+	ImageSymClassMemberOfEnum    = 16
+	ImageSymClassRegisterParam   = 17
+	ImageSymClassBitField        = 18
+	ImageSymClassBlock           = 100
+	ImageSymClassFunction        = 101
+	ImageSymClassEndOfStruct     = 102
+	ImageSymClassFile            = 103
+	ImageSymClassSection         = 104
+	ImageSymClassWeakExternal    = 105
+	ImageSymClassCLRToken        = 107
+)
+
+// Enumeration of weak external search characteristics.
+const (
+	ImageWeakExternSearchNoLibrary = 1
+	ImageWeakExternSearchLibrary   = 2
+	ImageWeakExternSearchAlias     = 3
+	ImageWeakExternAntiDependency  = 4
+)
+
+// Enumeration of COMDAT selection values in section definitions.
+const (
+	ImageCOMDATSelectNoDuplicates = 1
+	ImageCOMDATSelectAny          = 2
+	ImageCOMDATSelectSameSize     = 3
+	ImageCOMDATSelectExactMatch   = 4
+	ImageCOMDATSelectAssociative  = 5
+	ImageCOMDATSelectLargest      = 6
+)
+
+// Enumeration of i386 COFF relocation types.
+const (
+	ImageRelI386Absolute = 0x0000
+	ImageRelI386Dir16    = 0x0001
+	ImageRelI386Rel16    = 0x0002
+	ImageRelI386Dir32    = 0x0006
+	ImageRelI386Dir32NB  = 0x0007
+	ImageRelI386Seg12    = 0x0009
+	ImageRelI386Section  = 0x000A
+	ImageRelI386SecRel   = 0x000B
+	ImageRelI386Token    = 0x000C
+	ImageRelI386SecRel7  = 0x000D
+	ImageRelI386Rel32    = 0x0014
+)
+
+// Enumeration of x64 COFF relocation types.
+const (
+	ImageRelAMD64Absolute = 0x0000
+	ImageRelAMD64Addr64   = 0x0001
+	ImageRelAMD64Addr32   = 0x0002
+	ImageRelAMD64Addr32NB = 0x0003
+	ImageRelAMD64Rel32    = 0x0004
+	ImageRelAMD64Rel32_1  = 0x0005
+	ImageRelAMD64Rel32_2  = 0x0006
+	ImageRelAMD64Rel32_3  = 0x0007
+	ImageRelAMD64Rel32_4  = 0x0008
+	ImageRelAMD64Rel32_5  = 0x0009
+	ImageRelAMD64Section  = 0x000A
+	ImageRelAMD64SecRel   = 0x000B
+	ImageRelAMD64SecRel7  = 0x000C
+	ImageRelAMD64Token    = 0x000D
+	ImageRelAMD64SRel32   = 0x000E
+	ImageRelAMD64Pair     = 0x000F
+	ImageRelAMD64SSpan32  = 0x0010
+)
+
+// Enumeration of ARM64 COFF relocation types.
+const (
+	ImageRelARM64Absolute      = 0x0000
+	ImageRelARM64Addr32        = 0x0001
+	ImageRelARM64Addr32NB      = 0x0002
+	ImageRelARM64Branch26      = 0x0003
+	ImageRelARM64PageBaseRel21 = 0x0004
+	ImageRelARM64Rel21         = 0x0005
+	ImageRelARM64PageOffset12A = 0x0006
+	ImageRelARM64PageOffset12L = 0x0007
+	ImageRelARM64SecRel        = 0x0008
+	ImageRelARM64SecRelLow12A  = 0x0009
+	ImageRelARM64SecRelHigh12A = 0x000A
+	ImageRelARM64SecRelLow12L  = 0x000B
+	ImageRelARM64Token         = 0x000C
+	ImageRelARM64Section       = 0x000D
+	ImageRelARM64Addr64        = 0x000E
+	ImageRelARM64Branch19      = 0x000F
+	ImageRelARM64Branch14      = 0x0010
+	ImageRelARM64Rel32         = 0x0011
+)
+
+// ImageRelocation is a COFF relocation of an object file section.
+// VirtualAddress is relative to the start of the section, and
+// SymbolTableIndex is the index of the symbol the relocation refers to.
+type ImageRelocation struct {
+	VirtualAddress   uint32
+	SymbolTableIndex uint32
+	Type             uint16
+}
+
+// ImageSymbol is a record of the COFF symbol table. If the first four bytes
+// of Name are zero, the last four are an offset into the string table.
+type ImageSymbol struct {
+	Name               [8]byte
+	Value              uint32
+	SectionNumber      int16
+	Type               uint16
+	StorageClass       uint8
+	NumberOfAuxSymbols uint8
+}
+
+// ImageAuxSymbolFunction is the auxiliary record of a function definition.
+type ImageAuxSymbolFunction struct {
+	TagIndex              uint32
+	TotalSize             uint32
+	PointerToLinenumber   uint32
+	PointerToNextFunction uint32
+	Unused                [2]byte
+}
+
+// ImageAuxSymbolWeakExternal is the auxiliary record of a weak external.
+// TagIndex is the index of the symbol to use if the weak external is not
+// otherwise defined.
+type ImageAuxSymbolWeakExternal struct {
+	TagIndex        uint32
+	Characteristics uint32
+	Unused          [10]byte
+}
+
+// ImageAuxSymbolSection is the auxiliary record of a section definition.
+// For COMDAT sections, Selection is one of the ImageCOMDATSelect* values and
+// Number is the associated section, for associative COMDATs.
+type ImageAuxSymbolSection struct {
+	Length              uint32
+	NumberOfRelocations uint16
+	NumberOfLinenumbers uint16
+	CheckSum            uint32
+	Number              uint16
+	Selection           uint8
+	Reserved            uint8
+	HighNumber          uint16
+}
+
+// ImageArchiveStart is the signature of an archive (.lib) file.
+var ImageArchiveStart = [8]byte{'!', '<', 'a', 'r', 'c', 'h', '>', '\n'}
+
+// ImageArchiveEnd is the value of the EndHeader field of an archive member
+// header.
+var ImageArchiveEnd = [2]byte{'`', '\n'}
+
+// Enumeration of special archive member names.
+const (
+	ImageArchiveLinkerMember    = "/"
+	ImageArchiveLongnamesMember = "//"
+)
+
+// ImageArchiveMemberHeader is the header of an archive member. The fields are
+// ASCII text padded with spaces; Size and Date are decimal and Mode is octal.
+type ImageArchiveMemberHeader struct {
+	Name      [16]byte
+	Date      [12]byte
+	UserID    [6]byte
+	GroupID   [6]byte
+	Mode      [8]byte
+	Size      [10]byte
+	EndHeader [2]byte
+}
+
+// Enumeration of import object types.
+const (
+	ImportObjectCode  = 0
+	ImportObjectData  = 1
+	ImportObjectConst = 2
+)
+
+// Enumeration of import object name types, which specify how the name a
+// symbol is imported by is derived from the symbol name.
+const (
+	ImportObjectOrdinal        = 0
+	ImportObjectName           = 1
+	ImportObjectNameNoPrefix   = 2
+	ImportObjectNameUndecorate = 3
+	ImportObjectNameExportAs   = 4
+)
+
+// ImportObjectHeader is the header of a short import object, which describes
+// a single symbol imported from a DLL. The header is followed by SizeOfData
+// bytes, holding the null-terminated symbol and DLL names. TypeInfo contains
+// the type in bits 0-1 and the name type in bits 2-4.
+type ImportObjectHeader struct {
+	Sig1          uint16
+	Sig2          uint16
+	Version       uint16
+	Machine       uint16
+	TimeDateStamp uint32
+	SizeOfData    uint32
+	OrdinalOrHint uint16
+	TypeInfo      uint16
+}
+
+// ImageDebugDirectory is an entry in the debug directory. The debug data it
+// describes may or may not be mapped into memory; AddressOfRawData is zero if
+// it is not.
+type ImageDebugDirectory struct {
+	Characteristics  uint32
+	TimeDateStamp    uint32
+	MajorVersion     uint16
+	MinorVersion     uint16
+	Type             uint32
+	SizeOfData       uint32
+	AddressOfRawData uint32
+	PointerToRawData uint32
+}
+
+// ImageLoadConfigCodeIntegrity contains code integrity information in the
+// load configuration.
+type ImageLoadConfigCodeIntegrity struct {
+	Flags         uint16
+	Catalog       uint16
+	CatalogOffset uint32
+	Reserved      uint32
+}
+
+// ImageLoadConfigDirectory32 contains the load configuration of PE32
+// images. The structure has grown over time; the Size field specifies how
+// much of it is present.
+type ImageLoadConfigDirectory32 struct {
+	Size                                     uint32
+	TimeDateStamp                            uint32
+	MajorVersion                             uint16
+	MinorVersion                             uint16
+	GlobalFlagsClear                         uint32
+	GlobalFlagsSet                           uint32
+	CriticalSectionDefaultTimeout            uint32
+	DeCommitFreeBlockThreshold               uint32
+	DeCommitTotalFreeThreshold               uint32
+	LockPrefixTable                          uint32
+	MaximumAllocationSize                    uint32
+	VirtualMemoryThreshold                   uint32
+	ProcessHeapFlags                         uint32
+	ProcessAffinityMask                      uint32
+	CSDVersion                               uint16
+	DependentLoadFlags                       uint16
+	EditList                                 uint32
+	SecurityCookie                           uint32
+	SEHandlerTable                           uint32
+	SEHandlerCount                           uint32
+	GuardCFCheckFunctionPointer              uint32
+	GuardCFDispatchFunctionPointer           uint32
+	GuardCFFunctionTable                     uint32
+	GuardCFFunctionCount                     uint32
+	GuardFlags                               uint32
+	CodeIntegrity                            ImageLoadConfigCodeIntegrity
+	GuardAddressTakenIATEntryTable           uint32
+	GuardAddressTakenIATEntryCount           uint32
+	GuardLongJumpTargetTable                 uint32
+	GuardLongJumpTargetCount                 uint32
+	DynamicValueRelocTable                   uint32
+	CHPEMetadataPointer                      uint32
+	GuardRFFailureRoutine                    uint32
+	GuardRFFailureRoutineFunctionPointer     uint32
+	DynamicValueRelocTableOffset             uint32
+	DynamicValueRelocTableSection            uint16
+	Reserved2                                uint16
+	GuardRFVerifyStackPointerFunctionPointer uint32
+	HotPatchTableOffset                      uint32
+	Reserved3                                uint32
+	EnclaveConfigurationPointer              uint32
+	VolatileMetadataPointer                  uint32
+	GuardEHContinuationTable                 uint32
+	GuardEHContinuationCount                 uint32
+	GuardXFGCheckFunctionPointer             uint32
+	GuardXFGDispatchFunctionPointer          uint32
+	GuardXFGTableDispatchFunctionPointer     uint32
+	CastGuardOSDeterminedFailureMode         uint32
+	GuardMemcpyFunctionPointer               uint32
+}
+
+// ImageLoadConfigDirectory64 contains the load configuration of PE64
+// images. The structure has grown over time; the Size field specifies how
+// much of it is present.
+type ImageLoadConfigDirectory64 struct {
+	Size                                     uint32
+	TimeDateStamp                            uint32
+	MajorVersion                             uint16
+	MinorVersion                             uint16
+	GlobalFlagsClear                         uint32
+	GlobalFlagsSet                           uint32
+	CriticalSectionDefaultTimeout            uint32
+	DeCommitFreeBlockThreshold               uint64
+	DeCommitTotalFreeThreshold               uint64
+	LockPrefixTable                          uint64
+	MaximumAllocationSize                    uint64
+	VirtualMemoryThreshold                   uint64
+	ProcessAffinityMask                      uint64
+	ProcessHeapFlags                         uint32
+	CSDVersion                               uint16
+	DependentLoadFlags                       uint16
+	EditList                                 uint64
+	SecurityCookie                           uint64
+	SEHandlerTable                           uint64
+	SEHandlerCount                           uint64
+	GuardCFCheckFunctionPointer              uint64
+	GuardCFDispatchFunctionPointer           uint64
+	GuardCFFunctionTable                     uint64
+	GuardCFFunctionCount                     uint64
+	GuardFlags                               uint32
+	CodeIntegrity                            ImageLoadConfigCodeIntegrity
+	GuardAddressTakenIATEntryTable           uint64
+	GuardAddressTakenIATEntryCount           uint64
+	GuardLongJumpTargetTable                 uint64
+	GuardLongJumpTargetCount                 uint64
+	DynamicValueRelocTable                   uint64
+	CHPEMetadataPointer                      uint64
+	GuardRFFailureRoutine                    uint64
+	GuardRFFailureRoutineFunctionPointer     uint64
+	DynamicValueRelocTableOffset             uint32
+	DynamicValueRelocTableSection            uint16
+	Reserved2                                uint16
+	GuardRFVerifyStackPointerFunctionPointer uint64
+	HotPatchTableOffset                      uint32
+	Reserved3                                uint32
+	EnclaveConfigurationPointer              uint64
+	VolatileMetadataPointer                  uint64
+	GuardEHContinuationTable                 uint64
+	GuardEHContinuationCount                 uint64
+	GuardXFGCheckFunctionPointer             uint64
+	GuardXFGDispatchFunctionPointer          uint64
+	GuardXFGTableDispatchFunctionPointer     uint64
+	CastGuardOSDeterminedFailureMode         uint64
+	GuardMemcpyFunctionPointer               uint64
+}
+
+// To64 converts the ImageLoadConfigDirectory32 to an
+// ImageLoadConfigDirectory64.
+func (i ImageLoadConfigDirectory32) To64() ImageLoadConfigDirectory64 {
+	return ImageLoadConfigDirectory64{
+		Size:                                     i.Size,
+		TimeDateStamp:                            i.TimeDateStamp,
+		MajorVersion:                             i.MajorVersion,
+		MinorVersion:                             i.MinorVersion,
+		GlobalFlagsClear:                         i.GlobalFlagsClear,
+		GlobalFlagsSet:                           i.GlobalFlagsSet,
+		CriticalSectionDefaultTimeout:            i.CriticalSectionDefaultTimeout,
+		DeCommitFreeBlockThreshold:               uint64(i.DeCommitFreeBlockThreshold),
+		DeCommitTotalFreeThreshold:               uint64(i.DeCommitTotalFreeThreshold),
+		LockPrefixTable:                          uint64(i.LockPrefixTable),
+		MaximumAllocationSize:                    uint64(i.MaximumAllocationSize),
+		VirtualMemoryThreshold:                   uint64(i.VirtualMemoryThreshold),
+		ProcessAffinityMask:                      uint64(i.ProcessAffinityMask),
+		ProcessHeapFlags:                         i.ProcessHeapFlags,
+		CSDVersion:                               i.CSDVersion,
+		DependentLoadFlags:                       i.DependentLoadFlags,
+		EditList:                                 uint64(i.EditList),
+		SecurityCookie:                           uint64(i.SecurityCookie),
+		SEHandlerTable:                           uint64(i.SEHandlerTable),
+		SEHandlerCount:                           uint64(i.SEHandlerCount),
+		GuardCFCheckFunctionPointer:              uint64(i.GuardCFCheckFunctionPointer),
+		GuardCFDispatchFunctionPointer:           uint64(i.GuardCFDispatchFunctionPointer),
+		GuardCFFunctionTable:                     uint64(i.GuardCFFunctionTable),
+		GuardCFFunctionCount:                     uint64(i.GuardCFFunctionCount),
+		GuardFlags:                               i.GuardFlags,
+		CodeIntegrity:                            i.CodeIntegrity,
+		GuardAddressTakenIATEntryTable:           uint64(i.GuardAddressTakenIATEntryTable),
+		GuardAddressTakenIATEntryCount:           uint64(i.GuardAddressTakenIATEntryCount),
+		GuardLongJumpTargetTable:                 uint64(i.GuardLongJumpTargetTable),
+		GuardLongJumpTargetCount:                 uint64(i.GuardLongJumpTargetCount),
+		DynamicValueRelocTable:                   uint64(i.DynamicValueRelocTable),
+		CHPEMetadataPointer:                      uint64(i.CHPEMetadataPointer),
+		GuardRFFailureRoutine:                    uint64(i.GuardRFFailureRoutine),
+		GuardRFFailureRoutineFunctionPointer:     uint64(i.GuardRFFailureRoutineFunctionPointer),
+		DynamicValueRelocTableOffset:             i.DynamicValueRelocTableOffset,
+		DynamicValueRelocTableSection:            i.DynamicValueRelocTableSection,
+		Reserved2:                                i.Reserved2,
+		GuardRFVerifyStackPointerFunctionPointer: uint64(i.GuardRFVerifyStackPointerFunctionPointer),
+		HotPatchTableOffset:                      i.HotPatchTableOffset,
+		Reserved3:                                i.Reserved3,
+		EnclaveConfigurationPointer:              uint64(i.EnclaveConfigurationPointer),
+		VolatileMetadataPointer:                  uint64(i.VolatileMetadataPointer),
+		GuardEHContinuationTable:                 uint64(i.GuardEHContinuationTable),
+		GuardEHContinuationCount:                 uint64(i.GuardEHContinuationCount),
+		GuardXFGCheckFunctionPointer:             uint64(i.GuardXFGCheckFunctionPointer),
+		GuardXFGDispatchFunctionPointer:          uint64(i.GuardXFGDispatchFunctionPointer),
+		GuardXFGTableDispatchFunctionPointer:     uint64(i.GuardXFGTableDispatchFunctionPointer),
+		CastGuardOSDeterminedFailureMode:         uint64(i.CastGuardOSDeterminedFailureMode),
+		GuardMemcpyFunctionPointer:               uint64(i.GuardMemcpyFunctionPointer),
+	}
+}
@@ -19,6 +19,25 @@ func TestSizeValues(t *testing.T) {
 		{ImageNTHeaders32{}, SizeOfImageNTHeaders32},
 		{ImageNTHeaders64{}, SizeOfImageNTHeaders64},
 		{ImageDataDirectory{}, SizeOfImageDataDirectory},
+		{ImageSectionHeader{}, SizeOfImageSectionHeader},
+		{ImageBaseRelocation{}, SizeOfImageBaseRelocation},
+		{ImageImportDescriptor{}, SizeOfImageImportDescriptor},
+		{ImageExportDirectory{}, SizeOfImageExportDirectory},
+		{ImageTLSDirectory32{}, SizeOfImageTLSDirectory32},
+		{ImageTLSDirectory64{}, SizeOfImageTLSDirectory64},
+		{ImageLoadConfigDirectory32{}, SizeOfImageLoadConfigDirectory32},
+		{ImageLoadConfigDirectory64{}, SizeOfImageLoadConfigDirectory64},
+		{ImageDebugDirectory{}, SizeOfImageDebugDirectory},
+		{ImageSymbol{}, SizeOfImageSymbol},
+		{ImageAuxSymbolFunction{}, SizeOfImageSymbol},
+		{ImageAuxSymbolWeakExternal{}, SizeOfImageSymbol},
+		{ImageAuxSymbolSection{}, SizeOfImageSymbol},
+		{ImageRelocation{}, SizeOfImageRelocation},
+		{ImageArchiveMemberHeader{}, SizeOfImageArchiveMemberHeader},
+		{ImportObjectHeader{}, SizeOfImportObjectHeader},
+		{ImageResourceDirectory{}, SizeOfImageResourceDirectory},
+		{ImageResourceDirectoryEntry{}, SizeOfImageResourceDirectoryEntry},
+		{ImageResourceDataEntry{}, SizeOfImageResourceDataEntry},
 	}
 
 	for _, test := range tests {
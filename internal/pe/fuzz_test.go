@@ -0,0 +1,292 @@
+//go:build go1.18
+// +build go1.18
+
+package pe
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/loader"
+)
+
+// fuzzMemory is a fixed-size in-memory image used as the mapped memory of a
+// fuzzed module. Writes past the end fail rather than growing the buffer.
+type fuzzMemory struct {
+	data []byte
+	pos  int64
+}
+
+func (m *fuzzMemory) Read(p []byte) (int, error) {
+	n, err := m.ReadAt(p, m.pos)
+	m.pos += int64(n)
+	return n, err
+}
+
+func (m *fuzzMemory) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *fuzzMemory) Write(p []byte) (int, error) {
+	if m.pos < 0 || m.pos+int64(len(p)) > int64(len(m.data)) {
+		return 0, io.ErrShortWrite
+	}
+	n := copy(m.data[m.pos:], p)
+	m.pos += int64(n)
+	return n, nil
+}
+
+func (m *fuzzMemory) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		m.pos = offset
+	case io.SeekCurrent:
+		m.pos += offset
+	case io.SeekEnd:
+		m.pos = int64(len(m.data)) + offset
+	}
+	if m.pos < 0 {
+		return 0, errors.New("negative seek")
+	}
+	return m.pos, nil
+}
+
+// fuzzProc is a procedure returned by fuzzLoader.
+type fuzzProc uint64
+
+func (p fuzzProc) Call(a ...uint64) (r1, r2 uint64, lastErr error) { return 0, 0, nil }
+func (p fuzzProc) Addr() uint64                                    { return uint64(p) }
+
+// fuzzLoader resolves every import to a dummy procedure.
+type fuzzLoader struct{}
+
+func (fuzzLoader) Load(libname string) (loader.Module, error) { return fuzzModule{}, nil }
+
+type fuzzModule struct{}
+
+func (fuzzModule) Proc(name string) loader.Proc       { return fuzzProc(0x1000) }
+func (fuzzModule) Ordinal(ordinal uint64) loader.Proc { return fuzzProc(ordinal) }
+func (fuzzModule) Initialize() error                  { return nil }
+func (fuzzModule) Free() error                        { return nil }
+
+// addFuzzSeeds adds the test images to the fuzz corpus.
+func addFuzzSeeds(f *testing.F) {
+	tiny, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(tiny)
+}
+
+// loadFuzzModule parses data and maps it flat into a buffer the size of the
+// image. It returns nil if the data is not a valid module or is too large.
+func loadFuzzModule(data []byte) (*Module, *fuzzMemory) {
+	m, err := LoadModule(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil
+	}
+	size := m.Header.OptionalHeader.SizeOfImage
+	if size > 1<<24 {
+		return nil, nil
+	}
+	mem := &fuzzMemory{data: make([]byte, size)}
+	copy(mem.data, data)
+	return m, mem
+}
+
+func FuzzLoadModule(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		LoadModule(bytes.NewReader(data))
+	})
+}
+
+func FuzzLoadExports(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if m, mem := loadFuzzModule(data); m != nil {
+			LoadExports(m, mem, 0x10000000)
+		}
+	})
+}
+
+func FuzzLinkModule(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if m, mem := loadFuzzModule(data); m != nil {
+			LinkModule(m, mem, fuzzLoader{})
+		}
+	})
+}
+
+func FuzzLoadBaseRelocs(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, mem := loadFuzzModule(data)
+		if m == nil {
+			return
+		}
+		rels, err := LoadBaseRelocs(m, mem)
+		if err != nil {
+			return
+		}
+		Relocate(int(m.Header.FileHeader.Machine), rels, 0x20000000, 0x10000000, mem, binary.LittleEndian)
+	})
+}
+
+func FuzzRebase(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Rebase(data, 0x20000000, RebaseOptions{StripRelocations: true})
+	})
+}
+
+func FuzzLoadRichHeader(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if m, err := LoadModule(bytes.NewReader(data)); err == nil {
+			LoadRichHeader(m, bytes.NewReader(data))
+		}
+	})
+}
+
+func FuzzOverlay(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := LoadModule(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		if o, err := m.Overlay(bytes.NewReader(data), int64(len(data))); err == nil && o != nil {
+			ioutil.ReadAll(o.NewReader(bytes.NewReader(data)))
+		}
+	})
+}
+
+func FuzzLoadConfigDirectory(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if m, mem := loadFuzzModule(data); m != nil {
+			LoadConfigDirectory(m, mem)
+			LoadGuardInfo(m, mem, 0x10000000)
+		}
+	})
+}
+
+func FuzzLoadRuntimeFunctions(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, mem := loadFuzzModule(data)
+		if m == nil {
+			return
+		}
+		funcs, err := LoadRuntimeFunctions(m, mem)
+		if err != nil {
+			return
+		}
+		for _, fn := range funcs {
+			LoadUnwindInfo(mem, fn.UnwindInfoAddress)
+		}
+	})
+}
+
+func FuzzLoadAuthenticode(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := LoadModule(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		LoadCertificates(m, data)
+		LoadAuthenticode(m, data)
+		AuthenticodeDigest(m, data, crypto.SHA256)
+	})
+}
+
+func FuzzParseAuthenticode(f *testing.F) {
+	f.Add([]byte{0x30, 0x00})
+	f.Fuzz(func(t *testing.T, der []byte) {
+		ParseAuthenticode(der)
+	})
+}
+
+func FuzzFile(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		file, err := NewFile(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		file.Exports()
+		file.Imports()
+		file.BaseRelocs()
+		file.LoadConfig()
+		file.GuardInfo()
+		file.RuntimeFunctions()
+		file.DebugInfo()
+		file.Symbols()
+	})
+}
+
+func FuzzLoadSymbols(f *testing.F) {
+	obj, err := ioutil.ReadFile("testdata/symbols.obj")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(obj)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		hdr := ImageFileHeader{}
+		if binary.Read(bytes.NewReader(data), binary.LittleEndian, &hdr) != nil {
+			return
+		}
+		LoadSymbols(&hdr, bytes.NewReader(data))
+	})
+}
+
+func FuzzLoadObject(f *testing.F) {
+	obj, err := ioutil.ReadFile("testdata/symbols.obj")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(obj)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		o, err := LoadObject(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		for i := range o.Sections {
+			o.SectionName(&o.Sections[i])
+			LoadRelocations(&o.Sections[i], bytes.NewReader(data))
+		}
+	})
+}
+
+func FuzzLoadArchive(f *testing.F) {
+	lib, err := ioutil.ReadFile("testdata/imports.lib")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(lib)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		a, err := LoadArchive(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		a.Imports()
+		for i := range a.Members {
+			a.Object(&a.Members[i])
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package pe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// ErrInvalidGuardTable is returned when a Control Flow Guard table does not
+// fit inside the image.
+var ErrInvalidGuardTable = errors.New("pe: invalid control flow guard table")
+
+// Enumeration of Control Flow Guard table entry flags.
+const (
+	ImageGuardFlagFIDSuppressed       = 0x01
+	ImageGuardFlagExportSuppressed    = 0x02
+	ImageGuardFlagFIDLangExcptHandler = 0x04
+	ImageGuardFlagFIDXFG              = 0x08
+)
+
+// GuardTableEntry is an entry of a Control Flow Guard table.
+type GuardTableEntry struct {
+	// RVA is the relative virtual address of the target.
+	RVA uint32
+
+	// Flags contains the first metadata byte of the entry, if the table has
+	// per-entry metadata.
+	Flags uint8
+}
+
+// GuardInfo contains the Control Flow Guard metadata of an image.
+type GuardInfo struct {
+	// Flags contains the guard flags from the load configuration.
+	Flags uint32
+
+	// Functions contains the valid indirect call targets, sorted by RVA.
+	Functions []GuardTableEntry
+
+	// AddressTakenIATEntries contains the IAT entries whose addresses are
+	// taken.
+	AddressTakenIATEntries []GuardTableEntry
+
+	// LongJumpTargets contains the valid longjmp targets.
+	LongJumpTargets []GuardTableEntry
+
+	// EHContinuationTargets contains the valid exception handling
+	// continuation targets.
+	EHContinuationTargets []GuardTableEntry
+}
+
+// Instrumented returns whether the image was compiled with Control Flow
+// Guard instrumentation.
+func (g *GuardInfo) Instrumented() bool {
+	return g.Flags&ImageGuardCFInstrumented != 0
+}
+
+// EntryStride returns the number of metadata bytes following the RVA of
+// each table entry.
+func (g *GuardInfo) EntryStride() int {
+	return int(g.Flags&ImageGuardCFFunctionTableSizeMask) >> ImageGuardCFFunctionTableSizeShift
+}
+
+// IsValidCallTarget returns whether the specified RVA is a valid indirect
+// call target according to the function table. Suppressed functions are not
+// valid call targets. If the image has no function table, all addresses are
+// considered valid.
+func (g *GuardInfo) IsValidCallTarget(rva uint32) bool {
+	if g.Flags&ImageGuardCFFunctionTablePresent == 0 {
+		return true
+	}
+	i := sort.Search(len(g.Functions), func(i int) bool {
+		return g.Functions[i].RVA >= rva
+	})
+	if i == len(g.Functions) || g.Functions[i].RVA != rva {
+		return false
+	}
+	return g.Functions[i].Flags&(ImageGuardFlagFIDSuppressed|ImageGuardFlagExportSuppressed) == 0
+}
+
+// LoadGuardInfo loads the Control Flow Guard metadata from a mapped image
+// loaded at base. It returns nil if the image does not have a load
+// configuration directory.
+func LoadGuardInfo(m *Module, mem io.ReaderAt, base uint64) (*GuardInfo, error) {
+	cfg, err := LoadConfigDirectory(m, mem)
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+
+	g := &GuardInfo{Flags: cfg.GuardFlags}
+	stride := g.EntryStride()
+	if g.Functions, err = loadGuardTable(m, mem, base, cfg.GuardCFFunctionTable, cfg.GuardCFFunctionCount, stride); err != nil {
+		return nil, err
+	}
+	if g.AddressTakenIATEntries, err = loadGuardTable(m, mem, base, cfg.GuardAddressTakenIATEntryTable, cfg.GuardAddressTakenIATEntryCount, stride); err != nil {
+		return nil, err
+	}
+	if g.LongJumpTargets, err = loadGuardTable(m, mem, base, cfg.GuardLongJumpTargetTable, cfg.GuardLongJumpTargetCount, stride); err != nil {
+		return nil, err
+	}
+	if g.EHContinuationTargets, err = loadGuardTable(m, mem, base, cfg.GuardEHContinuationTable, cfg.GuardEHContinuationCount, stride); err != nil {
+		return nil, err
+	}
+	sort.Slice(g.Functions, func(i, j int) bool {
+		return g.Functions[i].RVA < g.Functions[j].RVA
+	})
+	return g, nil
+}
+
+// loadGuardTable loads a Control Flow Guard table at the specified virtual
+// address.
+func loadGuardTable(m *Module, mem io.ReaderAt, base, va, count uint64, stride int) ([]GuardTableEntry, error) {
+	if va == 0 || count == 0 {
+		return nil, nil
+	}
+	entrySize := uint64(4 + stride)
+	imageSize := uint64(m.Header.OptionalHeader.SizeOfImage)
+	if va < base || count > imageSize/entrySize || va-base > imageSize-count*entrySize {
+		return nil, ErrInvalidGuardTable
+	}
+
+	data := make([]byte, count*entrySize)
+	if _, err := mem.ReadAt(data, int64(va-base)); err != nil {
+		return nil, err
+	}
+
+	entries := make([]GuardTableEntry, count)
+	for i := range entries {
+		entry := data[uint64(i)*entrySize:]
+		entries[i].RVA = binary.LittleEndian.Uint32(entry[0:4])
+		if stride > 0 {
+			entries[i].Flags = entry[4]
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,79 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestLoadGuardInfo(t *testing.T) {
+	const base = 0x10000000
+
+	image := make([]byte, 0x400)
+	copy(image[0x200:], []byte{
+		0x00, 0x11, 0x00, 0x00, 0x00,
+		0x00, 0x10, 0x00, 0x00, 0x00,
+		0x20, 0x10, 0x00, 0x00, ImageGuardFlagFIDSuppressed,
+	})
+	copy(image[0x240:], []byte{0x00, 0x30, 0x00, 0x00, 0x00})
+	copy(image[0x260:], []byte{0x40, 0x10, 0x00, 0x00, 0x00})
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, ImageLoadConfigDirectory32{
+		Size:                           SizeOfImageLoadConfigDirectory32,
+		GuardCFFunctionTable:           base + 0x200,
+		GuardCFFunctionCount:           3,
+		GuardFlags:                     ImageGuardCFInstrumented | ImageGuardCFFunctionTablePresent | ImageGuardCFLongJumpTablePresent | 1<<ImageGuardCFFunctionTableSizeShift,
+		GuardAddressTakenIATEntryTable: base + 0x240,
+		GuardAddressTakenIATEntryCount: 1,
+		GuardLongJumpTargetTable:       base + 0x260,
+		GuardLongJumpTargetCount:       1,
+	})
+	copy(image[0x100:], buf.Bytes())
+
+	m := &Module{}
+	m.Header.OptionalHeader.SizeOfImage = uint32(len(image))
+	m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryLoadConfig] = ImageDataDirectory{VirtualAddress: 0x100, Size: 0x40}
+
+	g, err := LoadGuardInfo(m, bytes.NewReader(image), base)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if !g.Instrumented() || g.EntryStride() != 1 {
+		t.Errorf("unexpected instrumented %v stride %d", g.Instrumented(), g.EntryStride())
+	}
+	if len(g.Functions) != 3 || g.Functions[0].RVA != 0x1000 || g.Functions[2].RVA != 0x1100 {
+		t.Errorf("unexpected functions %v", g.Functions)
+	}
+	if len(g.AddressTakenIATEntries) != 1 || g.AddressTakenIATEntries[0].RVA != 0x3000 {
+		t.Errorf("unexpected address-taken IAT entries %v", g.AddressTakenIATEntries)
+	}
+	if len(g.LongJumpTargets) != 1 || g.LongJumpTargets[0].RVA != 0x1040 {
+		t.Errorf("unexpected long jump targets %v", g.LongJumpTargets)
+	}
+	if len(g.EHContinuationTargets) != 0 {
+		t.Errorf("unexpected EH continuation targets %v", g.EHContinuationTargets)
+	}
+
+	tests := []struct {
+		rva      uint32
+		expected bool
+	}{
+		{0x1000, true},
+		{0x1100, true},
+		{0x1020, false},
+		{0x1001, false},
+		{0x2000, false},
+	}
+	for _, test := range tests {
+		if actual := g.IsValidCallTarget(test.rva); actual != test.expected {
+			t.Errorf("IsValidCallTarget(%#x): expected %v got %v", test.rva, test.expected, actual)
+		}
+	}
+
+	// A table that extends past the end of the image is rejected.
+	binary.LittleEndian.PutUint32(image[0x100+84:], 0x1000)
+	if _, err := LoadGuardInfo(m, bytes.NewReader(image), base); err != ErrInvalidGuardTable {
+		t.Errorf("expected ErrInvalidGuardTable got %v", err)
+	}
+}
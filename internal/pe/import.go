@@ -2,17 +2,55 @@ package pe
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 
 	"github.com/jchv/go-winloader/internal/loader"
 )
 
-// LinkModule links a PE module in-memory.
-func LinkModule(m *Module, mem io.ReadWriteSeeker, ldr loader.Loader) error {
+// ErrInvalidImportDirectory is returned when the import directory is
+// malformed.
+var ErrInvalidImportDirectory = errors.New("pe: invalid import directory")
+
+// Import contains a resolved import of a module.
+type Import struct {
+	// Module is the name of the module the symbol is imported from.
+	Module string
+
+	// Name is the name of the imported symbol, or empty if it is imported
+	// by ordinal.
+	Name string
+
+	// Ordinal is the ordinal of the imported symbol, if it is imported by
+	// ordinal.
+	Ordinal uint16
+
+	// Addr is the address the import was resolved to.
+	Addr uint64
+}
+
+// importModule is a parsed import descriptor and the thunks it imports.
+type importModule struct {
+	name   string
+	iat    int64
+	thunks []uint64
+}
+
+// isOrdinal returns whether an import thunk imports by ordinal.
+func (m *Module) isOrdinal(thunk uint64) bool {
+	return (m.IsPE64 && thunk&0x8000000000000000 != 0) || (!m.IsPE64 && thunk&0x80000000 != 0)
+}
+
+// loadImportModules reads the import descriptors of a module, along with
+// the names of the imported modules and their import thunks.
+func loadImportModules(m *Module, mem io.ReadSeeker) ([]importModule, error) {
 	dir := m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryImport]
 	if dir.Size == 0 {
-		return nil
+		return nil, nil
+	}
+	if err := m.checkDirectory(dir); err != nil {
+		return nil, err
 	}
 
 	// Determine pointer size based on whether we're PE32 or PE64.
@@ -26,16 +64,21 @@ func LinkModule(m *Module, mem io.ReadWriteSeeker, ldr loader.Loader) error {
 	mem.Seek(int64(dir.VirtualAddress), io.SeekStart)
 	for {
 		desc := ImageImportDescriptor{}
-		binary.Read(mem, binary.LittleEndian, &desc)
+		if err := binary.Read(mem, binary.LittleEndian, &desc); err != nil {
+			return nil, err
+		}
 
 		if desc.Name == 0 {
 			break
 		}
+		if len(descs) >= MaxImportModules {
+			return nil, ErrInvalidImportDirectory
+		}
 
 		descs = append(descs, desc)
 	}
 
-	// Load modules.
+	mods := []importModule{}
 	for _, desc := range descs {
 		thunk := int64(desc.OriginalFirstThunk)
 		iat := int64(desc.FirstThunk)
@@ -45,12 +88,9 @@ func LinkModule(m *Module, mem io.ReadWriteSeeker, ldr loader.Loader) error {
 
 		// Read module name
 		mem.Seek(int64(desc.Name), io.SeekStart)
-
-		// Load library
-		libname := readsz(mem)
-		lib, err := ldr.Load(libname)
+		libname, err := readsz(mem)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		// Read thunk addrs
@@ -58,49 +98,113 @@ func LinkModule(m *Module, mem io.ReadWriteSeeker, ldr loader.Loader) error {
 		thunks := []uint64{}
 		mem.Seek(thunk, io.SeekStart)
 		for {
-			mem.Read(b[:psize])
+			if err := readfully(mem, b[:psize]); err != nil {
+				return nil, err
+			}
 			thunk := binary.LittleEndian.Uint64(b[:])
 			if thunk == 0 {
 				break
 			}
+			if len(thunks) >= MaxImportsPerModule {
+				return nil, ErrInvalidImportDirectory
+			}
 			thunks = append(thunks, thunk)
 		}
 
+		mods = append(mods, importModule{name: libname, iat: iat, thunks: thunks})
+	}
+	return mods, nil
+}
+
+// LoadImports returns the imports of a module without resolving them. The
+// Addr field of each import is zero.
+func LoadImports(m *Module, mem io.ReadSeeker) ([]Import, error) {
+	mods, err := loadImportModules(m, mem)
+	if err != nil {
+		return nil, err
+	}
+	imports := []Import{}
+	for _, mod := range mods {
+		for _, thunk := range mod.thunks {
+			if m.isOrdinal(thunk) {
+				imports = append(imports, Import{Module: mod.name, Ordinal: uint16(thunk & 0xFFFF)})
+				continue
+			}
+			mem.Seek(int64(thunk+2), io.SeekStart)
+			fnname, err := readsz(mem)
+			if err != nil {
+				return nil, err
+			}
+			imports = append(imports, Import{Module: mod.name, Name: fnname})
+		}
+	}
+	return imports, nil
+}
+
+// LinkModule links a PE module in-memory. It returns the resolved imports.
+func LinkModule(m *Module, mem io.ReadWriteSeeker, ldr loader.Loader) ([]Import, error) {
+	mods, err := loadImportModules(m, mem)
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine pointer size based on whether we're PE32 or PE64.
+	psize := 4
+	if m.IsPE64 {
+		psize = 8
+	}
+
+	// Load modules.
+	imports := []Import{}
+	for _, mod := range mods {
+		libname := mod.name
+
+		// Load library
+		lib, err := ldr.Load(libname)
+		if err != nil {
+			return nil, err
+		}
+
 		// Resolve thunks
 		resolved := []uint64{}
-		for _, thunk := range thunks {
-			thunkord := int64(-1)
-			if (m.IsPE64 && thunk&0x8000000000000000 != 0) || (!m.IsPE64 && thunk&0x80000000 != 0) {
-				thunkord = int64(thunk & 0xFFFF)
-			}
-			if thunkord != -1 {
+		for _, thunk := range mod.thunks {
+			if m.isOrdinal(thunk) {
 				// Import by ordinal
-				if proc := lib.Ordinal(uint64(thunkord)); proc != nil {
+				thunkord := thunk & 0xFFFF
+				if proc := lib.Ordinal(thunkord); proc != nil {
 					resolved = append(resolved, proc.Addr())
+					imports = append(imports, Import{Module: libname, Ordinal: uint16(thunkord), Addr: proc.Addr()})
 				} else {
-					return fmt.Errorf("could not resolve ordinal %d in module %q", thunkord, libname)
+					return nil, fmt.Errorf("could not resolve ordinal %d in module %q", thunkord, libname)
 				}
 			} else {
 				// Read name
 				mem.Seek(int64(thunk+2), io.SeekStart)
-				fnname := readsz(mem)
+				fnname, err := readsz(mem)
+				if err != nil {
+					return nil, err
+				}
 
 				// Import by name
 				if proc := lib.Proc(fnname); proc != nil {
 					resolved = append(resolved, proc.Addr())
+					imports = append(imports, Import{Module: libname, Name: fnname, Addr: proc.Addr()})
 				} else {
-					return fmt.Errorf("could not resolve symbol %q in module %q", fnname, libname)
+					return nil, fmt.Errorf("could not resolve symbol %q in module %q", fnname, libname)
 				}
 			}
 		}
 
 		// Write resolved IAT
-		mem.Seek(iat, io.SeekStart)
+		b := [8]byte{}
+		mem.Seek(mod.iat, io.SeekStart)
 		for _, fn := range resolved {
 			binary.LittleEndian.PutUint64(b[:], uint64(fn))
-			mem.Write(b[:psize])
+			if _, err := mem.Write(b[:psize]); err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	return nil
+	return imports, nil
 }
@@ -0,0 +1,47 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// LoadConfigDirectory loads the load configuration directory from a mapped
+// image. Fields that are not present in the image's version of the structure,
+// as specified by its Size field, are zero. It returns nil if the image does
+// not have a load configuration directory.
+func LoadConfigDirectory(m *Module, mem io.ReaderAt) (*ImageLoadConfigDirectory64, error) {
+	dir := m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryLoadConfig]
+	if dir.Size == 0 || dir.VirtualAddress == 0 {
+		return nil, nil
+	}
+
+	b := [4]byte{}
+	if _, err := mem.ReadAt(b[:], int64(dir.VirtualAddress)); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(b[:])
+
+	maxSize := uint32(SizeOfImageLoadConfigDirectory32)
+	if m.IsPE64 {
+		maxSize = SizeOfImageLoadConfigDirectory64
+	}
+	if size > maxSize {
+		size = maxSize
+	}
+
+	data := make([]byte, maxSize)
+	if _, err := mem.ReadAt(data[:size], int64(dir.VirtualAddress)); err != nil {
+		return nil, err
+	}
+
+	cfg := ImageLoadConfigDirectory64{}
+	if m.IsPE64 {
+		binary.Read(bytes.NewReader(data), binary.LittleEndian, &cfg)
+	} else {
+		cfg32 := ImageLoadConfigDirectory32{}
+		binary.Read(bytes.NewReader(data), binary.LittleEndian, &cfg32)
+		cfg = cfg32.To64()
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,60 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestLoadConfigDirectory(t *testing.T) {
+	image := make([]byte, 0x400)
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, ImageLoadConfigDirectory32{
+		Size:                0x48,
+		ProcessHeapFlags:    0x11,
+		ProcessAffinityMask: 0x22,
+		SecurityCookie:      0x10001000,
+		SEHandlerTable:      0x10002000,
+		SEHandlerCount:      3,
+		GuardFlags:          ImageGuardCFInstrumented,
+	})
+	copy(image[0x100:], buf.Bytes())
+
+	m := &Module{}
+	m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryLoadConfig] = ImageDataDirectory{VirtualAddress: 0x100, Size: 0x40}
+
+	cfg, err := LoadConfigDirectory(m, bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if cfg.ProcessHeapFlags != 0x11 || cfg.ProcessAffinityMask != 0x22 {
+		t.Errorf("unexpected heap flags %#x affinity mask %#x", cfg.ProcessHeapFlags, cfg.ProcessAffinityMask)
+	}
+	if cfg.SecurityCookie != 0x10001000 || cfg.SEHandlerTable != 0x10002000 || cfg.SEHandlerCount != 3 {
+		t.Errorf("unexpected cookie %#x handler table %#x count %d", cfg.SecurityCookie, cfg.SEHandlerTable, cfg.SEHandlerCount)
+	}
+	if cfg.GuardFlags != 0 {
+		t.Errorf("expected fields beyond size to be zero, got guard flags %#x", cfg.GuardFlags)
+	}
+
+	m.IsPE64 = true
+	buf.Reset()
+	binary.Write(buf, binary.LittleEndian, ImageLoadConfigDirectory64{
+		Size:                     SizeOfImageLoadConfigDirectory64 + 0x10,
+		SecurityCookie:           0x180001000,
+		GuardEHContinuationCount: 5,
+	})
+	copy(image[0x100:], buf.Bytes())
+	cfg, err = LoadConfigDirectory(m, bytes.NewReader(image))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if cfg.SecurityCookie != 0x180001000 || cfg.GuardEHContinuationCount != 5 {
+		t.Errorf("unexpected cookie %#x EH continuation count %d", cfg.SecurityCookie, cfg.GuardEHContinuationCount)
+	}
+
+	m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryLoadConfig] = ImageDataDirectory{}
+	if cfg, err = LoadConfigDirectory(m, bytes.NewReader(image)); cfg != nil || err != nil {
+		t.Errorf("expected nil directory and error, got %v, %v", cfg, err)
+	}
+}
@@ -16,8 +16,25 @@ var (
 	// ErrUnknownOptionalHeaderMagic is returned when the optional header
 	// magic field has an unknown value.
 	ErrUnknownOptionalHeaderMagic = errors.New("pe: unknown optional header magic")
+
+	// ErrTooManySections is returned when the number of sections exceeds
+	// MaxNumSections.
+	ErrTooManySections = errors.New("pe: too many sections")
+
+	// ErrInvalidAlignment is returned when the section or file alignment is
+	// not a power of two.
+	ErrInvalidAlignment = errors.New("pe: invalid alignment")
+
+	// ErrInvalidSection is returned when a section extends past the end of
+	// the image.
+	ErrInvalidSection = errors.New("pe: invalid section")
 )
 
+// isPowerOfTwo returns whether n is a non-zero power of two.
+func isPowerOfTwo(n uint32) bool {
+	return n != 0 && n&(n-1) == 0
+}
+
 // Module contains a parsed and loaded PE file.
 type Module struct {
 	IsPE64    bool
@@ -76,6 +93,20 @@ func LoadModule(r io.ReadSeeker) (*Module, error) {
 		return nil, ErrUnknownOptionalHeaderMagic
 	}
 
+	opt := &m.Header.OptionalHeader
+	if !isPowerOfTwo(opt.SectionAlignment) || !isPowerOfTwo(opt.FileAlignment) {
+		return nil, ErrInvalidAlignment
+	}
+
+	// Data directories past NumberOfRvaAndSizes are not present.
+	for i := int(opt.NumberOfRvaAndSizes); i < len(opt.DataDirectory); i++ {
+		opt.DataDirectory[i] = ImageDataDirectory{}
+	}
+
+	if m.Header.FileHeader.NumberOfSections > MaxNumSections {
+		return nil, ErrTooManySections
+	}
+
 	// Seek past end of optional headers.
 	r.Seek(int64(dos.NewHeaderAddr)+OffsetOfOptionalHeaderFromNTHeader+int64(m.Header.FileHeader.SizeOfOptionalHeader), io.SeekStart)
 
@@ -84,6 +115,13 @@ func LoadModule(r io.ReadSeeker) (*Module, error) {
 		if err := binary.Read(r, binary.LittleEndian, &section); err != nil {
 			return nil, err
 		}
+		size := section.PhysicalAddressOrVirtualSize
+		if size == 0 {
+			size = section.SizeOfRawData
+		}
+		if uint64(section.VirtualAddress)+uint64(size) > uint64(opt.SizeOfImage) {
+			return nil, ErrInvalidSection
+		}
 		m.Sections = append(m.Sections, section)
 	}
 
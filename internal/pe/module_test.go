@@ -2,7 +2,9 @@ package pe
 
 import (
 	"bytes"
+	"encoding/binary"
 	"io"
+	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
@@ -38,3 +40,53 @@ func TestLoadTinyPE32(t *testing.T) {
 		t.Fatalf("expected nil error got %v", err)
 	}
 }
+
+func TestLoadMalformedHeaders(t *testing.T) {
+	tiny, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nt := binary.LittleEndian.Uint32(tiny[0x3C:])
+	opt := nt + OffsetOfOptionalHeaderFromNTHeader
+
+	tests := []struct {
+		name   string
+		offset uint32
+		value  uint32
+		err    error
+	}{
+		{"TooManySections", nt + 6, MaxNumSections + 1, ErrTooManySections},
+		{"SectionAlignment", opt + 32, 0x1001, ErrInvalidAlignment},
+		{"SectionOutsideImage", opt + 56, 0x1000, ErrInvalidSection},
+	}
+	for _, test := range tests {
+		data := append([]byte{}, tiny...)
+		if test.offset == nt+6 {
+			binary.LittleEndian.PutUint16(data[test.offset:], uint16(test.value))
+		} else {
+			binary.LittleEndian.PutUint32(data[test.offset:], test.value)
+		}
+		if _, err := LoadModule(bytes.NewReader(data)); err != test.err {
+			t.Errorf("%s: expected %v got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestLoadBaseRelocsZeroBlock(t *testing.T) {
+	image := make([]byte, 0x100)
+	binary.LittleEndian.PutUint32(image[0x80:], 0x1000)
+	binary.LittleEndian.PutUint32(image[0x84:], 0)
+
+	m := &Module{}
+	m.Header.OptionalHeader.SizeOfImage = uint32(len(image))
+	m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryBaseReloc] = ImageDataDirectory{VirtualAddress: 0x80, Size: 0x40}
+	rels, err := LoadBaseRelocs(m, bytes.NewReader(image))
+	if err != nil || len(rels) != 0 {
+		t.Errorf("expected no relocations got %v, %v", rels, err)
+	}
+
+	binary.LittleEndian.PutUint32(image[0x84:], 4)
+	if _, err := LoadBaseRelocs(m, bytes.NewReader(image)); err != ErrInvalidRelocations {
+		t.Errorf("expected ErrInvalidRelocations got %v", err)
+	}
+}
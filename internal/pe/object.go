@@ -0,0 +1,120 @@
+package pe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrInvalidObject is returned when a COFF object file is malformed, or
+	// is in a format that is not supported, such as a short import object
+	// or a big object file.
+	ErrInvalidObject = errors.New("pe: invalid object file")
+
+	// ErrInvalidRelocation is returned when the COFF relocations of a
+	// section are malformed.
+	ErrInvalidRelocation = errors.New("pe: invalid relocation")
+)
+
+// Object contains a parsed COFF object file.
+type Object struct {
+	FileHeader ImageFileHeader
+	Sections   []ImageSectionHeader
+	Symbols    []Symbol
+	Strings    StringTable
+
+	// symbols maps symbol table indices to indices into Symbols.
+	symbols map[uint32]int
+}
+
+// LoadObject parses a COFF object file, including its section headers and
+// symbol table.
+func LoadObject(r io.ReaderAt) (*Object, error) {
+	o := &Object{}
+	hr := io.NewSectionReader(r, 0, 1<<63-1)
+	if err := binary.Read(hr, binary.LittleEndian, &o.FileHeader); err != nil {
+		return nil, err
+	}
+	hdr := &o.FileHeader
+	if hdr.Machine == ImageFileMachineUnknown && hdr.NumberOfSections == 0xFFFF {
+		return nil, ErrInvalidObject
+	}
+	if hdr.NumberOfSections > MaxNumSections {
+		return nil, ErrTooManySections
+	}
+
+	hr.Seek(SizeOfImageFileHeader+int64(hdr.SizeOfOptionalHeader), io.SeekStart)
+	o.Sections = make([]ImageSectionHeader, hdr.NumberOfSections)
+	if err := binary.Read(hr, binary.LittleEndian, o.Sections); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if o.Symbols, o.Strings, err = LoadSymbols(hdr, r); err != nil {
+		return nil, err
+	}
+	o.symbols = make(map[uint32]int, len(o.Symbols))
+	for i := range o.Symbols {
+		o.symbols[o.Symbols[i].Index] = i
+	}
+	return o, nil
+}
+
+// Symbol returns the symbol at index in the symbol table, or nil if there is
+// no symbol record at index.
+func (o *Object) Symbol(index uint32) *Symbol {
+	i, ok := o.symbols[index]
+	if !ok {
+		return nil
+	}
+	return &o.Symbols[i]
+}
+
+// SectionName returns the name of a section, resolving long names.
+func (o *Object) SectionName(s *ImageSectionHeader) (string, error) {
+	return o.Strings.SectionName(s)
+}
+
+// SectionAlignment returns the alignment of a section of an object file, or
+// 1 if the section does not specify one.
+func SectionAlignment(s *ImageSectionHeader) uint32 {
+	align := (s.Characteristics & ImageSectionCharacteristicsAlignMask) >> 20
+	if align == 0 {
+		return 1
+	}
+	return 1 << (align - 1)
+}
+
+// LoadRelocations loads the COFF relocations of a section of an object file.
+// If the section has more than 0xFFFF relocations, the real count is stored
+// in the first relocation, which is skipped.
+func LoadRelocations(s *ImageSectionHeader, r io.ReaderAt) ([]ImageRelocation, error) {
+	count := uint32(s.NumberOfRelocations)
+	off := int64(s.PointerToRelocations)
+	if count == 0 {
+		return nil, nil
+	}
+	if s.Characteristics&ImageSectionCharacteristicsLinkNumRelocOverflow != 0 && count == 0xFFFF {
+		first := ImageRelocation{}
+		if err := binary.Read(io.NewSectionReader(r, off, SizeOfImageRelocation), binary.LittleEndian, &first); err != nil {
+			return nil, ErrInvalidRelocation
+		}
+		if first.VirtualAddress == 0 || first.VirtualAddress > MaxRelocations {
+			return nil, ErrInvalidRelocation
+		}
+		count = first.VirtualAddress - 1
+		off += SizeOfImageRelocation
+	}
+
+	// Make sure the end of the relocations is in the file before allocating.
+	size := int64(count) * SizeOfImageRelocation
+	if _, err := r.ReadAt(make([]byte, 1), off+size-1); err != nil {
+		return nil, ErrInvalidRelocation
+	}
+	relocs := make([]ImageRelocation, count)
+	if err := binary.Read(io.NewSectionReader(r, off, size), binary.LittleEndian, relocs); err != nil {
+		return nil, ErrInvalidRelocation
+	}
+	return relocs, nil
+}
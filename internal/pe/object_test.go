@@ -0,0 +1,73 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLoadObject(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/symbols.obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := LoadObject(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if obj.FileHeader.Machine != ImageFileMachineAMD64 || len(obj.Sections) != 3 {
+		t.Fatalf("unexpected header %+v", obj.FileHeader)
+	}
+	if name, err := obj.SectionName(&obj.Sections[0]); err != nil || name != ".text" {
+		t.Errorf("expected .text got %q, %v", name, err)
+	}
+	if align := SectionAlignment(&obj.Sections[0]); align != 4 {
+		t.Errorf("expected alignment 4 got %d", align)
+	}
+	for _, sym := range obj.Symbols {
+		if got := obj.Symbol(sym.Index); got == nil || got.Name != sym.Name {
+			t.Errorf("symbol %d: expected %q got %+v", sym.Index, sym.Name, got)
+		}
+	}
+	// Auxiliary records are not symbols.
+	if obj.Symbol(obj.Symbols[0].Index+1) != nil {
+		t.Errorf("expected no symbol for auxiliary record")
+	}
+
+	// Short import objects start with a machine of zero and 0xFFFF sections.
+	imp := make([]byte, SizeOfImageFileHeader)
+	binary.LittleEndian.PutUint16(imp[2:], 0xFFFF)
+	if _, err := LoadObject(bytes.NewReader(imp)); err != ErrInvalidObject {
+		t.Errorf("expected ErrInvalidObject got %v", err)
+	}
+}
+
+func TestLoadRelocationsOverflow(t *testing.T) {
+	buf := &bytes.Buffer{}
+	relocs := []ImageRelocation{
+		{VirtualAddress: 3},
+		{VirtualAddress: 0x10, SymbolTableIndex: 1, Type: ImageRelAMD64Addr64},
+		{VirtualAddress: 0x20, SymbolTableIndex: 2, Type: ImageRelAMD64Rel32},
+	}
+	binary.Write(buf, binary.LittleEndian, relocs)
+
+	s := &ImageSectionHeader{
+		NumberOfRelocations: 0xFFFF,
+		Characteristics:     ImageSectionCharacteristicsLinkNumRelocOverflow,
+	}
+	got, err := LoadRelocations(s, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(got) != 2 || got[0] != relocs[1] || got[1] != relocs[2] {
+		t.Errorf("unexpected relocations %+v", got)
+	}
+
+	// The relocations must be in the file.
+	s.NumberOfRelocations = 4
+	s.Characteristics = 0
+	if _, err := LoadRelocations(s, bytes.NewReader(buf.Bytes())); err != ErrInvalidRelocation {
+		t.Errorf("expected ErrInvalidRelocation got %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package pe
+
+import "io"
+
+// Overlay is the data appended to an image file after the raw data of its
+// sections, which is not mapped into memory. It may contain the attribute
+// certificate table and the COFF symbol table, which are reported separately
+// from the payload.
+type Overlay struct {
+	// Offset is the file offset where the overlay starts.
+	Offset int64
+
+	// Size is the size of the overlay, up to the end of the file, including
+	// the certificate and symbol tables.
+	Size int64
+
+	// CertificateOffset and CertificateSize locate the attribute
+	// certificate table inside the overlay. CertificateSize is zero if the
+	// image is not signed.
+	CertificateOffset int64
+	CertificateSize   int64
+
+	// SymbolTableOffset and SymbolTableSize locate the COFF symbol table
+	// and the string table that follows it inside the overlay, which some
+	// linkers such as MinGW ld write after the sections. SymbolTableSize is
+	// zero if the image has no symbol table.
+	SymbolTableOffset int64
+	SymbolTableSize   int64
+}
+
+// PayloadSize returns the size of the overlay, excluding the certificate and
+// symbol tables.
+func (o *Overlay) PayloadSize() int64 {
+	return o.Size - o.CertificateSize - o.SymbolTableSize
+}
+
+// NewReader returns a reader for the payload of the overlay from the file r,
+// which skips the certificate and symbol tables.
+func (o *Overlay) NewReader(r io.ReaderAt) io.Reader {
+	skip := [][2]int64{
+		{o.CertificateOffset, o.CertificateSize},
+		{o.SymbolTableOffset, o.SymbolTableSize},
+	}
+	if skip[0][0] > skip[1][0] {
+		skip[0], skip[1] = skip[1], skip[0]
+	}
+	readers := []io.Reader{}
+	off, end := o.Offset, o.Offset+o.Size
+	for _, s := range skip {
+		if s[1] == 0 {
+			continue
+		}
+		readers = append(readers, io.NewSectionReader(r, off, s[0]-off))
+		off = s[0] + s[1]
+	}
+	readers = append(readers, io.NewSectionReader(r, off, end-off))
+	return io.MultiReader(readers...)
+}
+
+// EndOfSections returns the file offset where the headers and the raw data
+// of the sections end.
+func (m *Module) EndOfSections() int64 {
+	end := int64(m.Header.OptionalHeader.SizeOfHeaders)
+	for _, s := range m.Sections {
+		if s.SizeOfRawData == 0 {
+			continue
+		}
+		if e := int64(s.PointerToRawData) + int64(s.SizeOfRawData); e > end {
+			end = e
+		}
+	}
+	return end
+}
+
+// Overlay returns the overlay of an image from the raw file data r of the
+// specified size, or nil if there is no data after the sections.
+func (m *Module) Overlay(r io.ReaderAt, size int64) (*Overlay, error) {
+	end := m.EndOfSections()
+	if end >= size {
+		return nil, nil
+	}
+	o := &Overlay{Offset: end, Size: size - end}
+	dir := m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntrySecurity]
+	if dir.Size != 0 && dir.VirtualAddress != 0 {
+		off, n := int64(dir.VirtualAddress), int64(dir.Size)
+		if off < end || off+n > size {
+			return nil, ErrInvalidCertificateTable
+		}
+		o.CertificateOffset, o.CertificateSize = off, n
+	}
+
+	// A symbol table that starts inside of the sections is left alone, since
+	// it is part of their data.
+	hdr := &m.Header.FileHeader
+	if off := int64(hdr.PointerToSymbolTable); off != 0 && off >= end {
+		strtab, err := LoadStringTable(hdr, io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, err
+		}
+		n := int64(hdr.NumberOfSymbols)*SizeOfImageSymbol + int64(len(strtab))
+		if off+n > size {
+			return nil, ErrInvalidSymbolTable
+		}
+		if o.CertificateSize != 0 && off < o.CertificateOffset+o.CertificateSize && o.CertificateOffset < off+n {
+			return nil, ErrInvalidSymbolTable
+		}
+		o.SymbolTableOffset, o.SymbolTableSize = off, n
+	}
+	return o, nil
+}
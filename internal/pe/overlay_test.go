@@ -0,0 +1,154 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// appendOverlay appends a payload and a certificate table to an image, in
+// the specified order, and points the security directory at the table.
+func appendOverlay(t *testing.T, image []byte, payload, certs []byte, certsFirst bool) []byte {
+	m, err := LoadModule(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append([]byte{}, image...)
+	if !certsFirst {
+		out = append(out, payload...)
+	}
+	certOffset := len(out)
+	out = append(out, certs...)
+	if certsFirst {
+		out = append(out, payload...)
+	}
+	if len(certs) > 0 {
+		dir := int(m.DOSHeader.NewHeaderAddr) + OffsetOfOptionalHeaderFromNTHeader + offsetOfDataDirectory32 + ImageDirectoryEntrySecurity*SizeOfImageDataDirectory
+		binary.LittleEndian.PutUint32(out[dir:], uint32(certOffset))
+		binary.LittleEndian.PutUint32(out[dir+4:], uint32(len(certs)))
+	}
+	return out
+}
+
+func TestOverlay(t *testing.T) {
+	b, _, _ := buildTestImage(ImageFileMachinei386)
+	image := mustBuild(t, b)
+	payload := []byte("appended payload")
+	certs := make([]byte, 0x20)
+	binary.LittleEndian.PutUint32(certs, uint32(len(certs)))
+
+	tests := []struct {
+		name       string
+		payload    []byte
+		certs      []byte
+		certsFirst bool
+	}{
+		{"Payload", payload, nil, false},
+		{"PayloadBeforeCertificates", payload, certs, false},
+		{"PayloadAfterCertificates", payload, certs, true},
+		{"CertificatesOnly", nil, certs, false},
+	}
+	for _, test := range tests {
+		data := appendOverlay(t, image, test.payload, test.certs, test.certsFirst)
+		m, err := LoadModule(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := m.Overlay(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: expected nil error got %v", test.name, err)
+		}
+		if o == nil {
+			t.Fatalf("%s: expected overlay", test.name)
+		}
+		if o.Offset != int64(len(image)) || o.Size != int64(len(test.payload)+len(test.certs)) {
+			t.Errorf("%s: unexpected overlay %+v", test.name, o)
+		}
+		if o.CertificateSize != int64(len(test.certs)) || o.PayloadSize() != int64(len(test.payload)) {
+			t.Errorf("%s: unexpected certificate table %+v", test.name, o)
+		}
+		got, err := ioutil.ReadAll(o.NewReader(bytes.NewReader(data)))
+		if err != nil || !bytes.Equal(got, test.payload) {
+			t.Errorf("%s: expected payload %q got %q, %v", test.name, test.payload, got, err)
+		}
+	}
+}
+
+func TestOverlayMissing(t *testing.T) {
+	b, _, _ := buildTestImage(ImageFileMachinei386)
+	image := mustBuild(t, b)
+	m, err := LoadModule(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.EndOfSections() != int64(len(image)) {
+		t.Errorf("expected sections to end at %#x got %#x", len(image), m.EndOfSections())
+	}
+	if o, err := m.Overlay(bytes.NewReader(image), int64(len(image))); o != nil || err != nil {
+		t.Errorf("expected no overlay got %+v, %v", o, err)
+	}
+
+	// The certificate table must be inside the overlay.
+	data := appendOverlay(t, image, []byte("payload"), make([]byte, 8), false)
+	m, err = LoadModule(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Overlay(bytes.NewReader(data), int64(len(data)-1)); err != ErrInvalidCertificateTable {
+		t.Errorf("expected ErrInvalidCertificateTable got %v", err)
+	}
+}
+
+// TestOverlaySymbolTable checks that a COFF symbol table written after the
+// sections, as MinGW ld does, is not reported as payload.
+func TestOverlaySymbolTable(t *testing.T) {
+	b, _, _ := buildTestImage(ImageFileMachinei386)
+	image := mustBuild(t, b)
+
+	symtab := make([]byte, 2*SizeOfImageSymbol)
+	copy(symtab, "_main")
+	strtab := []byte("\x00\x00\x00\x00a_long_name\x00")
+	binary.LittleEndian.PutUint32(strtab, uint32(len(strtab)))
+	payload := []byte("appended payload")
+	certs := make([]byte, 0x20)
+	binary.LittleEndian.PutUint32(certs, uint32(len(certs)))
+
+	fileHeader := int(binary.LittleEndian.Uint32(image[0x3C:])) + 4
+	withSymbols := append(append(append([]byte{}, image...), symtab...), strtab...)
+	binary.LittleEndian.PutUint32(withSymbols[fileHeader+8:], uint32(len(image)))
+	binary.LittleEndian.PutUint32(withSymbols[fileHeader+12:], 2)
+	data := appendOverlay(t, withSymbols, payload, certs, false)
+
+	m, err := LoadModule(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := m.Overlay(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if o == nil || o.Offset != int64(len(image)) {
+		t.Fatalf("unexpected overlay %+v", o)
+	}
+	if o.SymbolTableOffset != int64(len(image)) || o.SymbolTableSize != int64(len(symtab)+len(strtab)) {
+		t.Errorf("unexpected symbol table %+v", o)
+	}
+	if o.PayloadSize() != int64(len(payload)) {
+		t.Errorf("expected payload size %d got %d", len(payload), o.PayloadSize())
+	}
+	got, err := ioutil.ReadAll(o.NewReader(bytes.NewReader(data)))
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("expected payload %q got %q, %v", payload, got, err)
+	}
+
+	// The symbol table must be inside the file.
+	truncated := withSymbols[:len(image)+len(symtab)-1]
+	m, err = LoadModule(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Overlay(bytes.NewReader(truncated), int64(len(truncated))); err != ErrInvalidSymbolTable {
+		t.Errorf("expected ErrInvalidSymbolTable got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrInvalidImageBase is returned when an image base is not aligned to
+	// 64 KiB or does not fit in the address space of the image.
+	ErrInvalidImageBase = errors.New("pe: invalid image base")
+
+	// ErrRelocsStripped is returned when an image that has no base
+	// relocations is rebased.
+	ErrRelocsStripped = errors.New("pe: base relocations stripped")
+)
+
+// Enumeration of header field offsets used when writing images back.
+const (
+	// offsetOfCharacteristics is the offset of the Characteristics field of
+	// the file header from the start of the NT headers.
+	offsetOfCharacteristics = 22
+
+	// offsetOfImageBase32 and offsetOfImageBase64 are the offsets of the
+	// ImageBase field in the optional header.
+	offsetOfImageBase32 = 28
+	offsetOfImageBase64 = 24
+
+	// offsetOfDllCharacteristics is the offset of the DllCharacteristics
+	// field in the optional header, for both PE32 and PE32+.
+	offsetOfDllCharacteristics = 70
+)
+
+// RebaseOptions specifies how an image is rebased.
+type RebaseOptions struct {
+	// StripRelocations removes the base relocation directory and marks the
+	// image with ImageFileRelocsStripped, so that it can only be loaded at
+	// its new base. The .reloc section itself is left in place.
+	StripRelocations bool
+}
+
+// Rebase returns a copy of the raw file data of an image with its base
+// relocations applied for a preferred base address of base. The checksum of
+// the result is recomputed.
+func Rebase(data []byte, base uint64, opts RebaseOptions) ([]byte, error) {
+	m, err := LoadModule(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	opt := &m.Header.OptionalHeader
+	if base&0xFFFF != 0 || base+uint64(opt.SizeOfImage) < base || (!m.IsPE64 && base+uint64(opt.SizeOfImage) > 1<<32) {
+		return nil, ErrInvalidImageBase
+	}
+	if m.Header.FileHeader.Characteristics&ImageFileRelocsStripped != 0 && base != opt.ImageBase {
+		return nil, ErrRelocsStripped
+	}
+
+	out := append([]byte{}, data...)
+	img := &imageWriter{m: m, data: out}
+	relocs, err := LoadBaseRelocs(m, img)
+	if err != nil {
+		return nil, err
+	}
+	if err := Relocate(int(m.Header.FileHeader.Machine), relocs, base, opt.ImageBase, img, binary.LittleEndian); err != nil {
+		return nil, err
+	}
+
+	nt := int(m.DOSHeader.NewHeaderAddr)
+	hdr := nt + OffsetOfOptionalHeaderFromNTHeader
+	dirs := hdr + offsetOfDataDirectory32
+	if m.IsPE64 {
+		dirs = hdr + offsetOfDataDirectory64
+	}
+	if uint64(dirs)+NumDirectoryEntries*SizeOfImageDataDirectory > uint64(len(out)) {
+		return nil, ErrInvalidDirectory
+	}
+	if m.IsPE64 {
+		binary.LittleEndian.PutUint64(out[hdr+offsetOfImageBase64:], base)
+	} else {
+		binary.LittleEndian.PutUint32(out[hdr+offsetOfImageBase32:], uint32(base))
+	}
+	if opts.StripRelocations {
+		characteristics := m.Header.FileHeader.Characteristics | ImageFileRelocsStripped
+		binary.LittleEndian.PutUint16(out[nt+offsetOfCharacteristics:], characteristics)
+		dllCharacteristics := opt.DllCharacteristics &^ ImageDLLCharacteristicsDynamicBase
+		binary.LittleEndian.PutUint16(out[hdr+offsetOfDllCharacteristics:], dllCharacteristics)
+		if opt.NumberOfRvaAndSizes > ImageDirectoryEntryBaseReloc {
+			dir := out[dirs+ImageDirectoryEntryBaseReloc*SizeOfImageDataDirectory:]
+			binary.LittleEndian.PutUint64(dir, 0)
+		}
+	}
+	binary.LittleEndian.PutUint32(out[m.checkSumOffset():], CheckSum(m, out))
+	return out, nil
+}
+
+// imageWriter is an io.ReadWriteSeeker over the raw file data of an image,
+// with offsets being RVAs. Writes to areas that are not backed by the file
+// fail with ErrNotInFile.
+type imageWriter struct {
+	m    *Module
+	data []byte
+	off  int64
+}
+
+// Read implements io.Reader.
+func (w *imageWriter) Read(p []byte) (int, error) {
+	n, err := w.m.ImageReader(bytes.NewReader(w.data)).ReadAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// Write implements io.Writer.
+func (w *imageWriter) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if w.off < 0 || w.off >= int64(w.m.Header.OptionalHeader.SizeOfImage) {
+			return n, ErrNotInFile
+		}
+		foff, avail, _ := w.m.fileRange(uint32(w.off))
+		chunk := p[n:]
+		if uint32(len(chunk)) > avail {
+			chunk = chunk[:avail]
+		}
+		if len(chunk) == 0 || uint64(foff)+uint64(len(chunk)) > uint64(len(w.data)) {
+			return n, ErrNotInFile
+		}
+		copy(w.data[foff:], chunk)
+		n += len(chunk)
+		w.off += int64(len(chunk))
+	}
+	return n, nil
+}
+
+// Seek implements io.Seeker.
+func (w *imageWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += w.off
+	case io.SeekEnd:
+		offset += int64(w.m.Header.OptionalHeader.SizeOfImage)
+	default:
+		return 0, errors.New("pe: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("pe: negative position")
+	}
+	w.off = offset
+	return offset, nil
+}
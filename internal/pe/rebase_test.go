@@ -0,0 +1,138 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRebase(t *testing.T) {
+	for _, machine := range []uint16{ImageFileMachinei386, ImageFileMachineAMD64} {
+		b, _, _ := buildTestImage(machine)
+		b.DllCharacteristics = ImageDLLCharacteristicsDynamicBase
+		image, err := b.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig, err := NewFile(bytes.NewReader(image))
+		if err != nil {
+			t.Fatal(err)
+		}
+		const base = 0x50000000
+		delta := base - orig.Header.OptionalHeader.ImageBase
+
+		rebased, err := Rebase(image, base, RebaseOptions{})
+		if err != nil {
+			t.Fatalf("%04x: expected nil error got %v", machine, err)
+		}
+		f, err := NewFile(bytes.NewReader(rebased))
+		if err != nil {
+			t.Fatalf("%04x: expected nil error got %v", machine, err)
+		}
+		opt := f.Header.OptionalHeader
+		if opt.ImageBase != base {
+			t.Errorf("%04x: expected image base %#x got %#x", machine, base, opt.ImageBase)
+		}
+		if sum := CheckSum(f.Module, rebased); opt.CheckSum != sum || sum == 0 {
+			t.Errorf("%04x: expected checksum %#x got %#x", machine, sum, opt.CheckSum)
+		}
+
+		// Each relocated field moves by the difference between the bases.
+		relocs, err := f.BaseRelocs()
+		if err != nil || len(relocs) == 0 {
+			t.Fatalf("%04x: expected relocations got %v, %v", machine, relocs, err)
+		}
+		for _, r := range relocs {
+			switch r.Type {
+			case ImageRelBasedHighLow:
+				var before, after uint32
+				readImage(t, orig, uint32(r.Offset), &before)
+				readImage(t, f, uint32(r.Offset), &after)
+				if after != before+uint32(delta) {
+					t.Errorf("%04x: %#x: expected %#x got %#x", machine, r.Offset, before+uint32(delta), after)
+				}
+			case ImageRelBasedDir64:
+				var before, after uint64
+				readImage(t, orig, uint32(r.Offset), &before)
+				readImage(t, f, uint32(r.Offset), &after)
+				if after != before+delta {
+					t.Errorf("%04x: %#x: expected %#x got %#x", machine, r.Offset, before+delta, after)
+				}
+			}
+		}
+		if !bytes.Equal(image, mustBuild(t, b)) {
+			t.Errorf("%04x: expected input to be unmodified", machine)
+		}
+
+		// Stripping relocations pins the image to its new base.
+		stripped, err := Rebase(image, base, RebaseOptions{StripRelocations: true})
+		if err != nil {
+			t.Fatalf("%04x: expected nil error got %v", machine, err)
+		}
+		f, err = NewFile(bytes.NewReader(stripped))
+		if err != nil {
+			t.Fatal(err)
+		}
+		opt = f.Header.OptionalHeader
+		if f.Header.FileHeader.Characteristics&ImageFileRelocsStripped == 0 ||
+			opt.DllCharacteristics&ImageDLLCharacteristicsDynamicBase != 0 ||
+			opt.DataDirectory[ImageDirectoryEntryBaseReloc] != (ImageDataDirectory{}) {
+			t.Errorf("%04x: expected relocations to be stripped got %+v", machine, f.Header)
+		}
+		if !bytes.Equal(stripped[f.Sections[0].PointerToRawData:], rebased[f.Sections[0].PointerToRawData:]) {
+			t.Errorf("%04x: expected sections to match the rebased image", machine)
+		}
+		if _, err := Rebase(stripped, base+0x10000, RebaseOptions{}); err != ErrRelocsStripped {
+			t.Errorf("%04x: expected ErrRelocsStripped got %v", machine, err)
+		}
+		if _, err := Rebase(stripped, base, RebaseOptions{}); err != nil {
+			t.Errorf("%04x: expected nil error got %v", machine, err)
+		}
+	}
+}
+
+func mustBuild(t *testing.T, b *Builder) []byte {
+	t.Helper()
+	image, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image
+}
+
+func TestRebaseInvalid(t *testing.T) {
+	b, _, _ := buildTestImage(ImageFileMachinei386)
+	image := mustBuild(t, b)
+	for _, base := range []uint64{0x50001000, 0x100000000} {
+		if _, err := Rebase(image, base, RebaseOptions{}); err != ErrInvalidImageBase {
+			t.Errorf("%#x: expected ErrInvalidImageBase got %v", base, err)
+		}
+	}
+
+	// The relocations must be backed by the file.
+	data := &BuilderSection{Name: ".bss", VirtualSize: 0x10, BaseRelocations: []BaseRelocation{{Offset: 4, Type: ImageRelBasedHighLow}}}
+	b = &Builder{Machine: ImageFileMachinei386, Sections: []*BuilderSection{data}}
+	if _, err := Rebase(mustBuild(t, b), 0x50000000, RebaseOptions{}); err != ErrNotInFile {
+		t.Errorf("expected ErrNotInFile got %v", err)
+	}
+
+	tiny, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rebase(tiny, 0x50000000, RebaseOptions{}); err != ErrRelocsStripped {
+		t.Errorf("expected ErrRelocsStripped got %v", err)
+	}
+	rebased, err := Rebase(tiny, 0x400000, RebaseOptions{})
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	m, err := LoadModule(bytes.NewReader(rebased))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := binary.LittleEndian.Uint32(rebased[m.checkSumOffset():]); sum == 0 || sum != m.Header.OptionalHeader.CheckSum {
+		t.Errorf("expected checksum to be set got %#x", sum)
+	}
+}
@@ -7,6 +7,16 @@ import (
 	"io"
 )
 
+var (
+	// ErrInvalidRelocations is returned when the base relocation directory
+	// is malformed.
+	ErrInvalidRelocations = errors.New("pe: invalid base relocations")
+
+	// ErrTooManyRelocations is returned when the number of base relocations
+	// exceeds MaxRelocations.
+	ErrTooManyRelocations = errors.New("pe: too many base relocations")
+)
+
 // BaseRelocation is a parsed PE base relocation.
 type BaseRelocation struct {
 	// Relative virtual address of offset (that is, 0 == first byte of PE.)
@@ -16,20 +26,37 @@ type BaseRelocation struct {
 	Type int
 }
 
-// LoadBaseRelocs loads relocations from memory.
-func LoadBaseRelocs(m *Module, mem io.ReadSeeker) []BaseRelocation {
+// LoadBaseRelocs loads relocations from memory. A block with a size of zero
+// ends the relocations, like in the Windows loader.
+func LoadBaseRelocs(m *Module, mem io.ReadSeeker) ([]BaseRelocation, error) {
 	relocs := []BaseRelocation{}
 	dir := m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryBaseReloc]
 	if dir.Size == 0 {
-		return relocs
+		return relocs, nil
+	}
+	if err := m.checkDirectory(dir); err != nil {
+		return nil, err
 	}
 	n := uint32(0)
-	mem.Seek(int64(dir.VirtualAddress), io.SeekStart)
-	for n < dir.Size {
+	for dir.Size-n >= SizeOfImageBaseRelocation {
+		mem.Seek(int64(dir.VirtualAddress+n), io.SeekStart)
 		hdr := ImageBaseRelocation{}
-		binary.Read(mem, binary.LittleEndian, &hdr)
-		data := make([]uint16, hdr.SizeOfBlock/2-4)
-		binary.Read(mem, binary.LittleEndian, &data)
+		if err := binary.Read(mem, binary.LittleEndian, &hdr); err != nil {
+			return nil, err
+		}
+		if hdr.SizeOfBlock == 0 {
+			break
+		}
+		if hdr.SizeOfBlock < SizeOfImageBaseRelocation || hdr.SizeOfBlock > dir.Size-n {
+			return nil, ErrInvalidRelocations
+		}
+		data := make([]uint16, (hdr.SizeOfBlock-SizeOfImageBaseRelocation)/2)
+		if len(relocs)+len(data) > MaxRelocations {
+			return nil, ErrTooManyRelocations
+		}
+		if err := binary.Read(mem, binary.LittleEndian, &data); err != nil {
+			return nil, err
+		}
 		for _, i := range data {
 			relocs = append(relocs, BaseRelocation{
 				Offset: uint64(hdr.VirtualAddress) + uint64(i&0xFFF),
@@ -38,7 +65,7 @@ func LoadBaseRelocs(m *Module, mem io.ReadSeeker) []BaseRelocation {
 		}
 		n += hdr.SizeOfBlock
 	}
-	return relocs
+	return relocs, nil
 }
 
 // Relocate performs a series of relocations on m, where address is the load
@@ -0,0 +1,140 @@
+package pe
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// ErrInvalidRichHeader is returned when the Rich header of an image has no
+// start marker or a malformed list of entries.
+var ErrInvalidRichHeader = errors.New("pe: invalid rich header")
+
+// Enumeration of Rich header markers.
+const (
+	// RichSignature marks the end of the Rich header. It is followed by
+	// the key that the rest of the header is XORed with.
+	RichSignature = 0x68636952 // "Rich"
+
+	// DanSSignature marks the start of the Rich header once decoded.
+	DanSSignature = 0x536E6144 // "DanS"
+)
+
+// RichEntry is an entry of the Rich header, counting the objects built by a
+// version of a tool that were linked into the image.
+type RichEntry struct {
+	// ProductID identifies the tool, such as a compiler or linker.
+	ProductID uint16
+
+	// Build is the build number of the tool.
+	Build uint16
+
+	// Count is the number of objects built by the tool.
+	Count uint32
+}
+
+// CompID returns the comp.id of the entry, which combines the product ID
+// and the build number.
+func (e RichEntry) CompID() uint32 {
+	return uint32(e.ProductID)<<16 | uint32(e.Build)
+}
+
+// RichHeader is the decoded Rich header that the Microsoft linker writes
+// between the DOS stub and the NT headers.
+type RichHeader struct {
+	// Offset is the file offset of the start of the header.
+	Offset uint32
+
+	// Key is the key that the header is XORed with, which the linker sets
+	// to the checksum of the header.
+	Key uint32
+
+	// CheckSum is the checksum computed from the DOS header, the DOS stub
+	// and the entries.
+	CheckSum uint32
+
+	// Entries are the decoded entries, in order.
+	Entries []RichEntry
+}
+
+// Valid returns whether the key of the header matches its checksum. A
+// mismatch means that the header or the DOS stub was modified after
+// linking.
+func (h *RichHeader) Valid() bool {
+	return h.Key == h.CheckSum
+}
+
+// LoadRichHeader decodes the Rich header of an image from the raw file data
+// r. It returns nil if the image has no Rich header.
+func LoadRichHeader(m *Module, r io.ReaderAt) (*RichHeader, error) {
+	size := m.DOSHeader.NewHeaderAddr
+	if size > MaxDOSStubSize {
+		size = MaxDOSStubSize
+	}
+	size &^= 3
+	if size < SizeOfImageDOSHeader {
+		return nil, nil
+	}
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 0); err != nil {
+		return nil, err
+	}
+
+	// The header ends with the Rich signature followed by the key, and is
+	// usually followed by padding up to the NT headers.
+	end := -1
+	for i := len(data) - 8; i >= SizeOfImageDOSHeader; i -= 4 {
+		if binary.LittleEndian.Uint32(data[i:]) == RichSignature {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, nil
+	}
+	h := &RichHeader{Key: binary.LittleEndian.Uint32(data[end+4:])}
+
+	// The start marker is followed by three zero words, then by pairs of
+	// comp.id and count words.
+	start := -1
+	for i := end - 4; i >= SizeOfImageDOSHeader; i -= 4 {
+		if binary.LittleEndian.Uint32(data[i:])^h.Key == DanSSignature {
+			start = i
+			break
+		}
+	}
+	if start < 0 || (end-start)%8 != 0 || end-start < 16 {
+		return nil, ErrInvalidRichHeader
+	}
+	for i := start + 4; i < start+16; i += 4 {
+		if binary.LittleEndian.Uint32(data[i:])^h.Key != 0 {
+			return nil, ErrInvalidRichHeader
+		}
+	}
+	h.Offset = uint32(start)
+	for i := start + 16; i < end; i += 8 {
+		id := binary.LittleEndian.Uint32(data[i:]) ^ h.Key
+		count := binary.LittleEndian.Uint32(data[i+4:]) ^ h.Key
+		h.Entries = append(h.Entries, RichEntry{ProductID: uint16(id >> 16), Build: uint16(id), Count: count})
+	}
+	h.CheckSum = richCheckSum(data[:start], h.Entries)
+	return h, nil
+}
+
+// richCheckSum computes the checksum of a Rich header, from the data that
+// precedes it and its entries. The e_lfanew field of the DOS header is
+// excluded, since the linker writes it afterwards.
+func richCheckSum(stub []byte, entries []RichEntry) uint32 {
+	sum := uint32(len(stub))
+	for i, b := range stub {
+		if i >= 0x3C && i < 0x40 {
+			continue
+		}
+		sum += bits.RotateLeft32(uint32(b), i)
+	}
+	for _, e := range entries {
+		sum += bits.RotateLeft32(e.CompID(), int(e.Count&31))
+	}
+	return sum
+}
@@ -0,0 +1,122 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+var testRichEntries = []RichEntry{
+	{ProductID: 0x0104, Build: 0x7809, Count: 3},
+	{ProductID: 0x0102, Build: 0x7809, Count: 1},
+	{ProductID: 0x0000, Build: 0x0000, Count: 40},
+}
+
+// makeRichStub returns a DOS stub followed by a Rich header with the
+// specified entries and key, and the offset of the header in the image.
+func makeRichStub(entries []RichEntry, key uint32) ([]byte, uint32) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("This program cannot be run in DOS mode.\r\r\n$\x00\x00\x00\x00\x00")
+	start := SizeOfImageDOSHeader + uint32(buf.Len())
+	words := []uint32{DanSSignature, 0, 0, 0}
+	for _, e := range entries {
+		words = append(words, e.CompID(), e.Count)
+	}
+	for _, w := range words {
+		binary.Write(buf, binary.LittleEndian, w^key)
+	}
+	binary.Write(buf, binary.LittleEndian, []uint32{RichSignature, key, 0, 0})
+	return buf.Bytes(), start
+}
+
+// buildRichImage returns an image with a Rich header. The key is set to the
+// checksum of the header unless it is tampered with.
+func buildRichImage(t *testing.T, tamper bool) []byte {
+	b := &Builder{Machine: ImageFileMachinei386}
+	stub, start := makeRichStub(testRichEntries, 0)
+	b.DOSStub = stub
+	image := mustBuild(t, b)
+	key := richCheckSum(image[:start], testRichEntries)
+	if tamper {
+		key++
+	}
+	b.DOSStub, _ = makeRichStub(testRichEntries, key)
+	return mustBuild(t, b)
+}
+
+func TestLoadRichHeader(t *testing.T) {
+	image := buildRichImage(t, false)
+	f, err := NewFile(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := f.RichHeader()
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if h == nil {
+		t.Fatal("expected rich header")
+	}
+	if h.Offset != 0x70 || !h.Valid() {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if len(h.Entries) != len(testRichEntries) {
+		t.Fatalf("expected entries %+v got %+v", testRichEntries, h.Entries)
+	}
+	for i := range h.Entries {
+		if h.Entries[i] != testRichEntries[i] {
+			t.Errorf("expected entry %+v got %+v", testRichEntries[i], h.Entries[i])
+		}
+	}
+
+	// Modifying the DOS stub invalidates the header.
+	image[0x50] ^= 1
+	m, err := LoadModule(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, err := LoadRichHeader(m, bytes.NewReader(image)); err != nil || h.Valid() {
+		t.Errorf("expected invalid header got %+v, %v", h, err)
+	}
+
+	image = buildRichImage(t, true)
+	m, err = LoadModule(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, err := LoadRichHeader(m, bytes.NewReader(image)); err != nil || h.Valid() {
+		t.Errorf("expected invalid header got %+v, %v", h, err)
+	}
+}
+
+func TestLoadRichHeaderMissing(t *testing.T) {
+	image := mustBuild(t, &Builder{Machine: ImageFileMachinei386, DOSStub: make([]byte, 0x40)})
+	m, err := LoadModule(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, err := LoadRichHeader(m, bytes.NewReader(image)); h != nil || err != nil {
+		t.Errorf("expected no header got %+v, %v", h, err)
+	}
+
+	// The Rich signature without a start marker is malformed.
+	stub := make([]byte, 0x40)
+	binary.LittleEndian.PutUint32(stub[0x20:], RichSignature)
+	binary.LittleEndian.PutUint32(stub[0x24:], 0x12345678)
+	image = mustBuild(t, &Builder{Machine: ImageFileMachinei386, DOSStub: stub})
+	if _, err := LoadRichHeader(m, bytes.NewReader(image)); err != ErrInvalidRichHeader {
+		t.Errorf("expected ErrInvalidRichHeader got %v", err)
+	}
+}
+
+func TestRichCheckSum(t *testing.T) {
+	stub := make([]byte, SizeOfImageDOSHeader)
+	copy(stub, "MZ")
+	stub[0x3C] = 0xFF
+	if sum := richCheckSum(stub, nil); sum != 0x40+0x4D+0x5A<<1 {
+		t.Errorf("unexpected checksum %#x", sum)
+	}
+	if sum := richCheckSum(nil, testRichEntries[:1]); sum != 0x0823C048 {
+		t.Errorf("unexpected checksum %#x", sum)
+	}
+}
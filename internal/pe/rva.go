@@ -0,0 +1,181 @@
+package pe
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// ErrNotInFile is returned when data is requested that is not backed by the
+// file, such as uninitialized data or an address outside of the image.
+var ErrNotInFile = errors.New("pe: address not backed by file")
+
+// PageSize is the page size that the Windows image loader maps images with.
+// Images with a smaller section alignment are mapped flat, with the headers
+// and each section at the same offset as in the file.
+const PageSize = 0x1000
+
+// MappedFlat returns whether the image is mapped flat, because its section
+// alignment is smaller than PageSize.
+func (m *Module) MappedFlat() bool {
+	return m.Header.OptionalHeader.SectionAlignment < PageSize
+}
+
+// SectionName returns the name of a section as a string.
+func (s *ImageSectionHeader) SectionName() string {
+	return strings.TrimRight(string(s.Name[:]), "\x00")
+}
+
+// virtualExtent returns the size of a section once mapped, which is its
+// virtual size rounded up to the section alignment.
+func (m *Module) virtualExtent(s *ImageSectionHeader) uint32 {
+	hdr := m.Header.OptionalHeader
+	size := s.PhysicalAddressOrVirtualSize
+	if size == 0 {
+		size = roundUp32(s.SizeOfRawData, hdr.FileAlignment)
+	}
+	return roundUp32(size, hdr.SectionAlignment)
+}
+
+// SectionByName returns the first section with the specified name, or nil
+// if there is none.
+func (m *Module) SectionByName(name string) *ImageSectionHeader {
+	for i := range m.Sections {
+		if m.Sections[i].SectionName() == name {
+			return &m.Sections[i]
+		}
+	}
+	return nil
+}
+
+// SectionByRVA returns the section that contains rva once mapped, or nil if
+// it is not inside of a section.
+func (m *Module) SectionByRVA(rva uint32) *ImageSectionHeader {
+	for i := range m.Sections {
+		s := &m.Sections[i]
+		if rva >= s.VirtualAddress && rva-s.VirtualAddress < m.virtualExtent(s) {
+			return s
+		}
+	}
+	return nil
+}
+
+// SectionByOffset returns the section whose raw data contains the file
+// offset off, or nil if it is not inside of a section.
+func (m *Module) SectionByOffset(off uint32) *ImageSectionHeader {
+	for i := range m.Sections {
+		s := &m.Sections[i]
+		if s.SizeOfRawData != 0 && off >= s.PointerToRawData && off-s.PointerToRawData < s.SizeOfRawData {
+			return s
+		}
+	}
+	return nil
+}
+
+// RVAToOffset returns the file offset of the data that is mapped at rva. It
+// returns false if rva is not backed by the file.
+func (m *Module) RVAToOffset(rva uint32) (uint32, bool) {
+	if rva >= m.Header.OptionalHeader.SizeOfImage {
+		return 0, false
+	}
+	off, avail, _ := m.fileRange(rva)
+	return off, avail > 0
+}
+
+// OffsetToRVA returns the RVA that the data at file offset off is mapped to.
+// It returns false if the data at off is not mapped.
+func (m *Module) OffsetToRVA(off uint32) (uint32, bool) {
+	hdr := m.Header.OptionalHeader
+	if m.MappedFlat() {
+		return off, off < hdr.SizeOfImage
+	}
+	if off < hdr.SizeOfHeaders {
+		return off, true
+	}
+	for i := range m.Sections {
+		s := &m.Sections[i]
+		ptr := s.PointerToRawData
+		if hdr.FileAlignment >= 0x200 {
+			ptr &^= 0x1FF
+		}
+		if off < ptr {
+			continue
+		}
+		rva := s.VirtualAddress + (off - ptr)
+		if o, ok := m.RVAToOffset(rva); ok && o == off {
+			return rva, true
+		}
+	}
+	return 0, false
+}
+
+// RVAToVA returns the virtual address of rva when the image is loaded at
+// its preferred base address.
+func (m *Module) RVAToVA(rva uint32) uint64 {
+	return m.Header.OptionalHeader.ImageBase + uint64(rva)
+}
+
+// VAToRVA returns the RVA of the virtual address va, assuming the image is
+// loaded at its preferred base address. It returns false if va is not
+// inside of the image.
+func (m *Module) VAToRVA(va uint64) (uint32, bool) {
+	base := m.Header.OptionalHeader.ImageBase
+	if va < base || va-base >= uint64(m.Header.OptionalHeader.SizeOfImage) {
+		return 0, false
+	}
+	return uint32(va - base), true
+}
+
+// ImageReader returns a reader for the unmapped file r as though the image
+// were mapped in memory, with offsets being RVAs. This allows parsers that
+// operate on mapped images to operate on files as well. Areas of the image
+// that are not backed by the file are read as zeros.
+func (m *Module) ImageReader(r io.ReaderAt) io.ReaderAt {
+	return imageReader{m, r}
+}
+
+// ReadRVA reads data at rva from the unmapped file r, as though the image
+// were mapped. Data that is inside the image but not backed by the file is
+// read as zeros.
+func (m *Module) ReadRVA(r io.ReaderAt, rva uint32, size uint32) ([]byte, error) {
+	if uint64(rva)+uint64(size) > uint64(m.Header.OptionalHeader.SizeOfImage) {
+		return nil, ErrNotInFile
+	}
+	data := make([]byte, size)
+	if _, err := m.ImageReader(r).ReadAt(data, int64(rva)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ReadDirectory reads the contents of a data directory from the unmapped
+// file r. The security directory is located by file offset rather than RVA,
+// and is read accordingly; it is limited to MaxCertificateTableSize. It
+// returns nil if the directory is empty.
+func (m *Module) ReadDirectory(r io.ReaderAt, index int) ([]byte, error) {
+	if index < 0 || index >= NumDirectoryEntries {
+		return nil, ErrInvalidDirectory
+	}
+	dir := m.Header.OptionalHeader.DataDirectory[index]
+	if dir.Size == 0 {
+		return nil, nil
+	}
+	if index == ImageDirectoryEntrySecurity {
+		// Make sure the end of the table is in the file before allocating.
+		if dir.Size > MaxCertificateTableSize {
+			return nil, ErrInvalidCertificateTable
+		}
+		if _, err := r.ReadAt(make([]byte, 1), int64(dir.VirtualAddress)+int64(dir.Size)-1); err != nil {
+			return nil, ErrInvalidCertificateTable
+		}
+		data := make([]byte, dir.Size)
+		if _, err := r.ReadAt(data, int64(dir.VirtualAddress)); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	if err := m.checkDirectory(dir); err != nil {
+		return nil, err
+	}
+	return m.ReadRVA(r, dir.VirtualAddress, dir.Size)
+}
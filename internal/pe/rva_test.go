@@ -0,0 +1,84 @@
+package pe
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRVATranslation(t *testing.T) {
+	tiny, err := ioutil.ReadFile("../../tinydll/tiny.dll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadModule(bytes.NewReader(tiny))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auto := m.SectionByName("AUTO")
+	edata := m.SectionByName(".edata")
+	if auto == nil || edata == nil {
+		t.Fatal("expected AUTO and .edata sections")
+	}
+	if m.SectionByName(".text") != nil {
+		t.Error("expected no .text section")
+	}
+	if s := m.SectionByRVA(auto.VirtualAddress + 0x10); s != auto {
+		t.Errorf("expected AUTO section for rva got %v", s)
+	}
+	if s := m.SectionByOffset(edata.PointerToRawData); s != edata {
+		t.Errorf("expected .edata section for offset got %v", s)
+	}
+
+	// Section data is found at the section's raw data.
+	if off, ok := m.RVAToOffset(auto.VirtualAddress + 4); !ok || off != auto.PointerToRawData+4 {
+		t.Errorf("expected offset %#x got %#x, %v", auto.PointerToRawData+4, off, ok)
+	}
+	if rva, ok := m.OffsetToRVA(auto.PointerToRawData + 4); !ok || rva != auto.VirtualAddress+4 {
+		t.Errorf("expected rva %#x got %#x, %v", auto.VirtualAddress+4, rva, ok)
+	}
+
+	// Headers are mapped at their file offset.
+	if off, ok := m.RVAToOffset(0x40); !ok || off != 0x40 {
+		t.Errorf("expected header offset 0x40 got %#x, %v", off, ok)
+	}
+
+	// Space past the end of the section data is not in the file.
+	if _, ok := m.RVAToOffset(auto.VirtualAddress + 0x800); ok {
+		t.Error("expected rva past section data to not be in file")
+	}
+	if _, ok := m.RVAToOffset(m.Header.OptionalHeader.SizeOfImage); ok {
+		t.Error("expected rva past image to not be in file")
+	}
+
+	va := m.RVAToVA(auto.VirtualAddress)
+	if rva, ok := m.VAToRVA(va); !ok || rva != auto.VirtualAddress {
+		t.Errorf("expected rva %#x got %#x, %v", auto.VirtualAddress, rva, ok)
+	}
+	if _, ok := m.VAToRVA(m.Header.OptionalHeader.ImageBase - 1); ok {
+		t.Error("expected va below image base to be outside image")
+	}
+
+	// The export directory read from the file matches the section data.
+	dir := m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntryExport]
+	data, err := m.ReadDirectory(bytes.NewReader(tiny), ImageDirectoryEntryExport)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	off := edata.PointerToRawData + dir.VirtualAddress - edata.VirtualAddress
+	if !bytes.Equal(data, tiny[off:off+dir.Size]) {
+		t.Error("export directory does not match file contents")
+	}
+	if data, err := m.ReadDirectory(bytes.NewReader(tiny), ImageDirectoryEntryTLS); data != nil || err != nil {
+		t.Errorf("expected empty TLS directory got %v, %v", data, err)
+	}
+
+	// A certificate table size is checked before anything is allocated.
+	for _, size := range []uint32{0xFFFFFFF0, MaxCertificateTableSize} {
+		m.Header.OptionalHeader.DataDirectory[ImageDirectoryEntrySecurity] = ImageDataDirectory{VirtualAddress: 0x400, Size: size}
+		if _, err := m.ReadDirectory(bytes.NewReader(tiny), ImageDirectoryEntrySecurity); err != ErrInvalidCertificateTable {
+			t.Errorf("size %#x: expected ErrInvalidCertificateTable got %v", size, err)
+		}
+	}
+}
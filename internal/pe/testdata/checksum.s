@@ -0,0 +1,15 @@
+# checksum.dll is linked from this file by GNU ld, which writes the image
+# checksum, with:
+#   llvm-mc -filetype=obj -triple=x86_64-pc-windows-gnu checksum.s -o checksum.obj
+#   ld -m i386pep --shared --no-insert-timestamp --entry DllMain -o checksum.dll checksum.obj
+	.text
+	.globl	DllMain
+DllMain:
+	movl	$1, %eax
+	ret
+	.globl	Add
+Add:
+	leal	(%rcx,%rdx), %eax
+	ret
+	.section	.drectve,"yn"
+	.ascii	" -export:Add"
@@ -0,0 +1,24 @@
+# symbols.obj is generated from this file with:
+#   llvm-mc -filetype=obj -triple=x86_64-pc-windows-gnu symbols.s -o symbols.obj
+	.file	"symbols.c"
+	.text
+	.def	static_helper_with_a_long_name;
+	.scl	3;
+	.type	32;
+	.endef
+static_helper_with_a_long_name:
+	ret
+	.globl	Add
+	.def	Add;
+	.scl	2;
+	.type	32;
+	.endef
+Add:
+	leal	(%rcx,%rdx), %eax
+	ret
+	.weak	WeakAdd
+	.set	WeakAdd, Add
+	.data
+	.globl	counter
+counter:
+	.long	42
@@ -0,0 +1,511 @@
+package pe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Enumeration of x64 unwind operation codes.
+const (
+	UwopPushNonvol    = 0
+	UwopAllocLarge    = 1
+	UwopAllocSmall    = 2
+	UwopSetFPReg      = 3
+	UwopSaveNonvol    = 4
+	UwopSaveNonvolFar = 5
+	UwopEpilog        = 6
+	UwopSpareCode     = 7
+	UwopSaveXMM128    = 8
+	UwopSaveXMM128Far = 9
+	UwopPushMachFrame = 10
+
+	// Version 1 unwind info uses the operation codes of UwopEpilog and
+	// UwopSpareCode to save the low 64 bits of an XMM register.
+	UwopSaveXMM    = 6
+	UwopSaveXMMFar = 7
+)
+
+// maxUnwindChainSize is the maximum number of chained unwind info entries
+// that are followed.
+const maxUnwindChainSize = 32
+
+// Enumeration of x64 integer register numbers, as used in unwind codes.
+const (
+	RegRAX = 0
+	RegRCX = 1
+	RegRDX = 2
+	RegRBX = 3
+	RegRSP = 4
+	RegRBP = 5
+	RegRSI = 6
+	RegRDI = 7
+	RegR8  = 8
+	RegR9  = 9
+	RegR10 = 10
+	RegR11 = 11
+	RegR12 = 12
+	RegR13 = 13
+	RegR14 = 14
+	RegR15 = 15
+)
+
+// ErrUnwindChainTooLong is returned when a chain of unwind information is
+// too long, which usually means that it is circular.
+var ErrUnwindChainTooLong = errors.New("pe: unwind chain too long")
+
+// AMD64Context contains the x64 register state used for unwinding.
+type AMD64Context struct {
+	// Rip is the instruction pointer.
+	Rip uint64
+
+	// Regs contains the integer registers, indexed by the Reg* constants.
+	Regs [16]uint64
+
+	// Xmm contains the XMM registers.
+	Xmm [16][16]byte
+}
+
+// UnwindModule describes a mapped image for unwinding.
+type UnwindModule struct {
+	// Base is the address the image is loaded at.
+	Base uint64
+
+	// Size is the size of the mapped image.
+	Size uint64
+
+	// Image reads from the mapped image, with offsets relative to Base.
+	Image io.ReaderAt
+
+	// Functions contains the runtime functions of the image, sorted by
+	// address.
+	Functions []RuntimeFunction
+}
+
+// NewUnwindModule creates an UnwindModule for a module mapped at base.
+func NewUnwindModule(m *Module, mem io.ReaderAt, base uint64) (*UnwindModule, error) {
+	funcs, err := LoadRuntimeFunctions(m, mem)
+	if err != nil {
+		return nil, err
+	}
+	return &UnwindModule{
+		Base:      base,
+		Size:      uint64(m.Header.OptionalHeader.SizeOfImage),
+		Image:     mem,
+		Functions: funcs,
+	}, nil
+}
+
+// Contains returns whether or not addr is inside of the module.
+func (u *UnwindModule) Contains(addr uint64) bool {
+	return addr >= u.Base && addr-u.Base < u.Size
+}
+
+// readUint64 reads a little endian 64-bit integer at addr.
+func readUint64(mem io.ReaderAt, addr uint64) (uint64, error) {
+	b := [8]byte{}
+	if _, err := mem.ReadAt(b[:], int64(addr)); err != nil {
+		return 0, fmt.Errorf("pe: reading stack at %#x: %w", addr, err)
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+// VirtualUnwindAMD64 unwinds ctx by one frame, like RtlVirtualUnwind. mem
+// reads from the virtual address space of the process, and is used for the
+// stack and for code. On return, ctx contains the register state of the
+// caller.
+func (u *UnwindModule) VirtualUnwindAMD64(ctx *AMD64Context, mem io.ReaderAt) error {
+	rva := uint32(ctx.Rip - u.Base)
+	fn := LookupRuntimeFunction(u.Functions, rva)
+
+	// Leaf functions do not have function entries and do not modify the
+	// stack pointer, so the return address is at the top of the stack.
+	if fn == nil {
+		return unwindReturn(ctx, mem)
+	}
+
+	info, err := LoadUnwindInfo(u.Image, fn.UnwindInfoAddress)
+	if err != nil {
+		return err
+	}
+
+	// If we are in an epilog, the prolog has been partially undone already;
+	// emulate the rest of the epilog instead.
+	if inEpilog, err := u.unwindEpilog(ctx, mem, fn, info); inEpilog || err != nil {
+		return err
+	}
+
+	offset := rva - fn.BeginAddress
+	for i := 0; ; i++ {
+		if i >= maxUnwindChainSize {
+			return ErrUnwindChainTooLong
+		}
+		done, err := unwindCodes(ctx, mem, info, offset)
+		if err != nil || done {
+			return err
+		}
+		if info.Chained == nil {
+			break
+		}
+
+		// Chained unwind info is always applied in full.
+		if info, err = LoadUnwindInfo(u.Image, info.Chained.UnwindInfoAddress); err != nil {
+			return err
+		}
+		offset = ^uint32(0)
+	}
+
+	return unwindReturn(ctx, mem)
+}
+
+// unwindReturn pops the return address into Rip.
+func unwindReturn(ctx *AMD64Context, mem io.ReaderAt) error {
+	rip, err := readUint64(mem, ctx.Regs[RegRSP])
+	if err != nil {
+		return err
+	}
+	ctx.Rip = rip
+	ctx.Regs[RegRSP] += 8
+	return nil
+}
+
+// unwindCodes applies the unwind codes of info whose prolog offsets are not
+// greater than offset. It returns true if the frame was fully unwound by a
+// machine frame.
+func unwindCodes(ctx *AMD64Context, mem io.ReaderAt, info *UnwindInfo, offset uint32) (bool, error) {
+	codes := info.Codes
+	slot := func(i int) (uint32, error) {
+		if i >= len(codes) {
+			return 0, errors.New("pe: truncated unwind code")
+		}
+		return uint32(codes[i]), nil
+	}
+
+	// Save operations are relative to the frame base, which is computed from
+	// the frame register if it has been set up by the prolog already.
+	frame := ctx.Regs[RegRSP]
+	if info.FrameRegister != 0 {
+		for _, code := range codes {
+			if code.Op() == UwopSetFPReg && uint32(code.CodeOffset()) <= offset {
+				frame = ctx.Regs[info.FrameRegister] - uint64(info.FrameOffset)*16
+				break
+			}
+		}
+	}
+
+	for i := 0; i < len(codes); {
+		code := codes[i]
+		op, opinfo := code.Op(), code.OpInfo()
+
+		// Unwind codes for epilogs describe locations, not operations.
+		if info.Version >= 2 && op == UwopEpilog {
+			i++
+			continue
+		}
+
+		size := unwindCodeSlots(code, info.Version)
+		if size == 0 {
+			return false, fmt.Errorf("pe: unknown unwind operation %d", op)
+		}
+
+		// Skip operations that have not been executed yet by the prolog.
+		if uint32(code.CodeOffset()) > offset {
+			i += size
+			continue
+		}
+
+		switch op {
+		case UwopPushNonvol:
+			value, err := readUint64(mem, ctx.Regs[RegRSP])
+			if err != nil {
+				return false, err
+			}
+			ctx.Regs[opinfo] = value
+			ctx.Regs[RegRSP] += 8
+
+		case UwopAllocLarge:
+			n, err := slot(i + 1)
+			if err != nil {
+				return false, err
+			}
+			if opinfo == 0 {
+				n *= 8
+			} else {
+				hi, err := slot(i + 2)
+				if err != nil {
+					return false, err
+				}
+				n |= hi << 16
+			}
+			ctx.Regs[RegRSP] += uint64(n)
+
+		case UwopAllocSmall:
+			ctx.Regs[RegRSP] += uint64(opinfo)*8 + 8
+
+		case UwopSetFPReg:
+			ctx.Regs[RegRSP] = ctx.Regs[info.FrameRegister] - uint64(info.FrameOffset)*16
+
+		case UwopSaveNonvol, UwopSaveNonvolFar:
+			off, err := unwindCodeOffset(codes, i, op == UwopSaveNonvolFar, 8)
+			if err != nil {
+				return false, err
+			}
+			value, err := readUint64(mem, frame+off)
+			if err != nil {
+				return false, err
+			}
+			ctx.Regs[opinfo] = value
+
+		case UwopSaveXMM128, UwopSaveXMM128Far:
+			off, err := unwindCodeOffset(codes, i, op == UwopSaveXMM128Far, 16)
+			if err != nil {
+				return false, err
+			}
+			if _, err := mem.ReadAt(ctx.Xmm[opinfo][:], int64(frame+off)); err != nil {
+				return false, fmt.Errorf("pe: reading stack at %#x: %w", frame+off, err)
+			}
+
+		case UwopSaveXMM, UwopSaveXMMFar:
+			if info.Version >= 2 {
+				break
+			}
+			off, err := unwindCodeOffset(codes, i, op == UwopSaveXMMFar, 8)
+			if err != nil {
+				return false, err
+			}
+			if _, err := mem.ReadAt(ctx.Xmm[opinfo][:8], int64(frame+off)); err != nil {
+				return false, fmt.Errorf("pe: reading stack at %#x: %w", frame+off, err)
+			}
+
+		case UwopPushMachFrame:
+			// The machine frame optionally starts with an error code.
+			rsp := ctx.Regs[RegRSP]
+			if opinfo != 0 {
+				rsp += 8
+			}
+			rip, err := readUint64(mem, rsp)
+			if err != nil {
+				return false, err
+			}
+			newRsp, err := readUint64(mem, rsp+24)
+			if err != nil {
+				return false, err
+			}
+			ctx.Rip = rip
+			ctx.Regs[RegRSP] = newRsp
+			return true, nil
+		}
+
+		i += size
+	}
+
+	return false, nil
+}
+
+// unwindCodeSlots returns the number of slots used by an unwind code of the
+// specified unwind info version, or 0 if the operation is unknown.
+func unwindCodeSlots(code UnwindCode, version uint8) int {
+	op := code.Op()
+	if version < 2 {
+		switch op {
+		case UwopSaveXMM:
+			return 2
+		case UwopSaveXMMFar:
+			return 3
+		}
+	}
+	switch op {
+	case UwopPushNonvol, UwopAllocSmall, UwopSetFPReg, UwopPushMachFrame, UwopEpilog:
+		return 1
+	case UwopSaveNonvol, UwopSaveXMM128, UwopSpareCode:
+		return 2
+	case UwopSaveNonvolFar, UwopSaveXMM128Far:
+		return 3
+	case UwopAllocLarge:
+		if code.OpInfo() == 0 {
+			return 2
+		}
+		return 3
+	default:
+		return 0
+	}
+}
+
+// unwindCodeOffset returns the stack offset operand of a save operation. Near
+// offsets are scaled; far offsets are not.
+func unwindCodeOffset(codes []UnwindCode, i int, far bool, scale uint64) (uint64, error) {
+	if far {
+		if i+2 >= len(codes) {
+			return 0, errors.New("pe: truncated unwind code")
+		}
+		return uint64(codes[i+1]) | uint64(codes[i+2])<<16, nil
+	}
+	if i+1 >= len(codes) {
+		return 0, errors.New("pe: truncated unwind code")
+	}
+	return uint64(codes[i+1]) * scale, nil
+}
+
+// unwindEpilog checks whether Rip is inside of an epilog and if so, emulates
+// the remainder of the epilog. Epilogs are detected from the code itself,
+// which must match the forms that the x64 calling convention allows:
+//
+//	add rsp, imm  or  lea rsp, [frame+disp]   (optional)
+//	pop reg                                   (zero or more)
+//	ret  or  jmp                              (tail call)
+//
+// For version 2 unwind info, the epilog unwind codes are also consulted.
+func (u *UnwindModule) unwindEpilog(ctx *AMD64Context, mem io.ReaderAt, fn *RuntimeFunction, info *UnwindInfo) (bool, error) {
+	rva := uint32(ctx.Rip - u.Base)
+	if rva-fn.BeginAddress < uint32(info.SizeOfProlog) {
+		return false, nil
+	}
+
+	if info.Version >= 2 && len(info.Codes) > 0 && info.Codes[0].Op() == UwopEpilog {
+		if !inVersion2Epilog(fn, info, rva) {
+			return false, nil
+		}
+	}
+
+	code := make([]byte, 64)
+	n, err := u.Image.ReadAt(code, int64(rva))
+	if n == 0 && err != nil {
+		return false, nil
+	}
+	code = code[:n]
+
+	regs := ctx.Regs
+	i := 0
+	peek := func(k int) byte {
+		if i+k < len(code) {
+			return code[i+k]
+		}
+		return 0
+	}
+
+	// add rsp, imm8 / add rsp, imm32
+	switch {
+	case peek(0) == 0x48 && peek(1) == 0x83 && peek(2) == 0xC4:
+		regs[RegRSP] += uint64(int64(int8(peek(3))))
+		i += 4
+	case peek(0) == 0x48 && peek(1) == 0x81 && peek(2) == 0xC4:
+		regs[RegRSP] += uint64(int64(int32(binary.LittleEndian.Uint32([]byte{peek(3), peek(4), peek(5), peek(6)}))))
+		i += 7
+	case (peek(0)&0xFE) == 0x48 && peek(1) == 0x8D && (peek(2)&0x38) == 0x20:
+		// lea rsp, [reg+disp8] / lea rsp, [reg+disp32]
+		reg := int(peek(2)&7) | int(peek(0)&1)<<3
+		switch peek(2) >> 6 {
+		case 1:
+			regs[RegRSP] = regs[reg] + uint64(int64(int8(peek(3))))
+			i += 4
+		case 2:
+			regs[RegRSP] = regs[reg] + uint64(int64(int32(binary.LittleEndian.Uint32([]byte{peek(3), peek(4), peek(5), peek(6)}))))
+			i += 7
+		default:
+			return false, nil
+		}
+	}
+
+	// pop reg
+	for {
+		if peek(0)&0xF8 == 0x58 {
+			value, err := readUint64(mem, regs[RegRSP])
+			if err != nil {
+				return false, nil
+			}
+			regs[peek(0)&7] = value
+			regs[RegRSP] += 8
+			i++
+		} else if peek(0) == 0x41 && peek(1)&0xF8 == 0x58 {
+			value, err := readUint64(mem, regs[RegRSP])
+			if err != nil {
+				return false, nil
+			}
+			regs[8+peek(1)&7] = value
+			regs[RegRSP] += 8
+			i += 2
+		} else {
+			break
+		}
+	}
+
+	// ret / rep ret / jmp
+	switch {
+	case peek(0) == 0xC3, peek(0) == 0xF3 && peek(1) == 0xC3:
+	case peek(0) == 0xE9, peek(0) == 0xEB:
+		// A jmp is only an epilog if it is a tail call out of the function.
+		target := rva + uint32(i) + 5
+		if peek(0) == 0xE9 {
+			target += binary.LittleEndian.Uint32([]byte{peek(1), peek(2), peek(3), peek(4)})
+		} else {
+			target = rva + uint32(i) + 2 + uint32(int32(int8(peek(1))))
+		}
+		if fn.Contains(target) {
+			return false, nil
+		}
+	case peek(0) == 0xFF && peek(1) == 0x25, peek(0) == 0x48 && peek(1) == 0xFF && peek(2)&0x38 == 0x20:
+	default:
+		return false, nil
+	}
+
+	ctx.Regs = regs
+	return true, unwindReturn(ctx, mem)
+}
+
+// inVersion2Epilog returns whether rva is inside of one of the epilogs
+// described by version 2 epilog unwind codes. The first epilog code contains
+// the size of the epilogs and a flag specifying that there is an epilog at
+// the end of the function; subsequent ones contain offsets of epilogs from
+// the end of the function.
+func inVersion2Epilog(fn *RuntimeFunction, info *UnwindInfo, rva uint32) bool {
+	first := info.Codes[0]
+	size := uint32(first.CodeOffset())
+	if first.OpInfo()&1 != 0 && rva >= fn.EndAddress-size && rva < fn.EndAddress {
+		return true
+	}
+	for _, code := range info.Codes[1:] {
+		if code.Op() != UwopEpilog {
+			break
+		}
+		offset := uint32(code.CodeOffset()) | uint32(code.OpInfo())<<8
+		if offset == 0 {
+			continue
+		}
+		start := fn.EndAddress - offset
+		if rva >= start && rva < start+size {
+			return true
+		}
+	}
+	return false
+}
+
+// StackTraceAMD64 unwinds the stack starting at ctx, returning the
+// instruction pointer of each frame. Unwinding stops when a frame is outside
+// of modules, when the return address is zero, or after maxFrames frames.
+func StackTraceAMD64(modules []*UnwindModule, ctx AMD64Context, mem io.ReaderAt, maxFrames int) ([]uint64, error) {
+	frames := []uint64{}
+	for len(frames) < maxFrames && ctx.Rip != 0 {
+		frames = append(frames, ctx.Rip)
+
+		var mod *UnwindModule
+		for _, m := range modules {
+			if m.Contains(ctx.Rip) {
+				mod = m
+				break
+			}
+		}
+		if mod == nil {
+			break
+		}
+
+		rsp := ctx.Regs[RegRSP]
+		if err := mod.VirtualUnwindAMD64(&ctx, mem); err != nil {
+			return frames, err
+		}
+		if ctx.Regs[RegRSP] <= rsp {
+			return frames, errors.New("pe: stack pointer did not advance while unwinding")
+		}
+	}
+	return frames, nil
+}
@@ -0,0 +1,209 @@
+package pe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+const (
+	testImageBase = 0x180000000
+	testStackBase = 0x7FF000
+	testReturn    = 0x401234
+)
+
+// testAddressSpace is an io.ReaderAt over an image and a stack.
+type testAddressSpace struct {
+	image []byte
+	stack []byte
+}
+
+func (a *testAddressSpace) ReadAt(b []byte, off int64) (int, error) {
+	addr := uint64(off)
+	switch {
+	case addr >= testImageBase && addr-testImageBase < uint64(len(a.image)):
+		return bytes.NewReader(a.image).ReadAt(b, int64(addr-testImageBase))
+	case addr >= testStackBase && addr-testStackBase < uint64(len(a.stack)):
+		return bytes.NewReader(a.stack).ReadAt(b, int64(addr-testStackBase))
+	}
+	return 0, io.EOF
+}
+
+func (a *testAddressSpace) put64(addr uint64, value uint64) {
+	binary.LittleEndian.PutUint64(a.stack[addr-testStackBase:], value)
+}
+
+func makeUnwindTestImage() (*UnwindModule, *testAddressSpace) {
+	image := make([]byte, 0x3000)
+
+	// Function A: push rbp; sub rsp, 0x20; ...; add rsp, 0x20; pop rbp; ret
+	copy(image[0x1000:], []byte{0x55, 0x48, 0x83, 0xEC, 0x20})
+	copy(image[0x1030:], []byte{0x48, 0x83, 0xC4, 0x20, 0x5D, 0xC3})
+	copy(image[0x2000:], []byte{0x01, 0x05, 0x02, 0x00, 0x05, 0x32, 0x01, 0x50})
+
+	// Function B: push rbp; sub rsp, 0x100; mov [rsp+0x10], rbx;
+	// movaps [rsp+0x20], xmm6; lea rbp, [rsp]
+	copy(image[0x2040:], []byte{
+		0x01, 22, 0x08, RegRBP,
+		22, 0x03,
+		18, 0x68, 0x02, 0x00,
+		13, 0x34, 0x02, 0x00,
+		8, 0x01, 0x20, 0x00,
+		1, 0x50,
+	})
+
+	// Function C: interrupt handler with a machine frame.
+	copy(image[0x2080:], []byte{0x01, 0x00, 0x01, 0x00, 0x00, 0x0A, 0x00, 0x00})
+
+	// Function D: sub rsp, 8, chained to function A.
+	copy(image[0x20C0:], []byte{
+		0x01 | UnwFlagChainInfo<<3, 0x04, 0x01, 0x00,
+		0x04, 0x02, 0x00, 0x00,
+		0x00, 0x10, 0x00, 0x00, 0x40, 0x10, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00,
+	})
+
+	// Function E: sub rsp, 0x20; movsd [rsp+0x10], xmm6, using the version 1
+	// encoding of UwopSaveXMM.
+	copy(image[0x2100:], []byte{
+		0x01, 9, 0x03, 0x00,
+		9, 0x66, 0x02, 0x00,
+		4, 0x32, 0x00, 0x00,
+	})
+
+	mod := &UnwindModule{
+		Base:  testImageBase,
+		Size:  uint64(len(image)),
+		Image: bytes.NewReader(image),
+		Functions: []RuntimeFunction{
+			{BeginAddress: 0x1000, EndAddress: 0x1040, UnwindInfoAddress: 0x2000},
+			{BeginAddress: 0x1100, EndAddress: 0x1200, UnwindInfoAddress: 0x2040},
+			{BeginAddress: 0x1200, EndAddress: 0x1210, UnwindInfoAddress: 0x2080},
+			{BeginAddress: 0x1300, EndAddress: 0x1310, UnwindInfoAddress: 0x20C0},
+			{BeginAddress: 0x1400, EndAddress: 0x1440, UnwindInfoAddress: 0x2100},
+		},
+	}
+	return mod, &testAddressSpace{image: image, stack: make([]byte, 0x1000)}
+}
+
+func TestVirtualUnwindAMD64(t *testing.T) {
+	const s = testStackBase + 0x100
+
+	tests := []struct {
+		name        string
+		rip         uint64
+		rsp         uint64
+		expectedRsp uint64
+	}{
+		{"body", testImageBase + 0x1010, s, s + 0x30},
+		{"prolog", testImageBase + 0x1001, s + 0x20, s + 0x30},
+		{"epilog start", testImageBase + 0x1030, s, s + 0x30},
+		{"epilog pop", testImageBase + 0x1034, s + 0x20, s + 0x30},
+		{"chained", testImageBase + 0x1308, s - 8, s + 0x30},
+		{"leaf", testImageBase + 0x1500, s + 0x28, s + 0x30},
+	}
+
+	for _, test := range tests {
+		mod, mem := makeUnwindTestImage()
+		mem.put64(s+0x20, 0xBBBB)
+		mem.put64(s+0x28, testReturn)
+
+		ctx := AMD64Context{Rip: test.rip}
+		ctx.Regs[RegRSP] = test.rsp
+		if err := mod.VirtualUnwindAMD64(&ctx, mem); err != nil {
+			t.Errorf("%s: expected nil error got %v", test.name, err)
+			continue
+		}
+		if ctx.Rip != testReturn {
+			t.Errorf("%s: expected rip %#x got %#x", test.name, testReturn, ctx.Rip)
+		}
+		if ctx.Regs[RegRSP] != test.expectedRsp {
+			t.Errorf("%s: expected rsp %#x got %#x", test.name, test.expectedRsp, ctx.Regs[RegRSP])
+		}
+		if test.name != "leaf" && ctx.Regs[RegRBP] != 0xBBBB {
+			t.Errorf("%s: expected rbp %#x got %#x", test.name, 0xBBBB, ctx.Regs[RegRBP])
+		}
+	}
+}
+
+func TestVirtualUnwindAMD64FramePointer(t *testing.T) {
+	const f = testStackBase + 0x200
+
+	mod, mem := makeUnwindTestImage()
+	mem.put64(f+0x10, 0x1111)
+	copy(mem.stack[f+0x20-testStackBase:], "xmm6 saved here!")
+	mem.put64(f+0x100, 0xBBBB)
+	mem.put64(f+0x108, testReturn)
+
+	// The stack pointer has moved due to a dynamic allocation.
+	ctx := AMD64Context{Rip: testImageBase + 0x1180}
+	ctx.Regs[RegRSP] = f - 0x40
+	ctx.Regs[RegRBP] = f
+	if err := mod.VirtualUnwindAMD64(&ctx, mem); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if ctx.Rip != testReturn || ctx.Regs[RegRSP] != f+0x110 {
+		t.Errorf("expected rip %#x rsp %#x got rip %#x rsp %#x", testReturn, f+0x110, ctx.Rip, ctx.Regs[RegRSP])
+	}
+	if ctx.Regs[RegRBX] != 0x1111 || ctx.Regs[RegRBP] != 0xBBBB {
+		t.Errorf("unexpected rbx %#x rbp %#x", ctx.Regs[RegRBX], ctx.Regs[RegRBP])
+	}
+	if string(ctx.Xmm[6][:]) != "xmm6 saved here!" {
+		t.Errorf("unexpected xmm6 %q", ctx.Xmm[6][:])
+	}
+}
+
+func TestVirtualUnwindAMD64MachineFrame(t *testing.T) {
+	const m = testStackBase + 0x300
+
+	mod, mem := makeUnwindTestImage()
+	mem.put64(m, testReturn)
+	mem.put64(m+24, 0x12345678)
+
+	ctx := AMD64Context{Rip: testImageBase + 0x1204}
+	ctx.Regs[RegRSP] = m
+	if err := mod.VirtualUnwindAMD64(&ctx, mem); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if ctx.Rip != testReturn || ctx.Regs[RegRSP] != 0x12345678 {
+		t.Errorf("unexpected rip %#x rsp %#x", ctx.Rip, ctx.Regs[RegRSP])
+	}
+}
+
+func TestVirtualUnwindAMD64Version1SaveXMM(t *testing.T) {
+	const s = testStackBase + 0x400
+
+	mod, mem := makeUnwindTestImage()
+	copy(mem.stack[s+0x10-testStackBase:], "xmm6 low")
+	mem.put64(s+0x20, testReturn)
+
+	ctx := AMD64Context{Rip: testImageBase + 0x1420}
+	ctx.Regs[RegRSP] = s
+	if err := mod.VirtualUnwindAMD64(&ctx, mem); err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if ctx.Rip != testReturn || ctx.Regs[RegRSP] != s+0x28 {
+		t.Errorf("expected rip %#x rsp %#x got rip %#x rsp %#x", testReturn, s+0x28, ctx.Rip, ctx.Regs[RegRSP])
+	}
+	if string(ctx.Xmm[6][:8]) != "xmm6 low" {
+		t.Errorf("unexpected xmm6 %q", ctx.Xmm[6][:8])
+	}
+}
+
+func TestStackTraceAMD64(t *testing.T) {
+	const s = testStackBase + 0x100
+
+	mod, mem := makeUnwindTestImage()
+	mem.put64(s+0x20, 0xBBBB)
+	mem.put64(s+0x28, testReturn)
+
+	ctx := AMD64Context{Rip: testImageBase + 0x1010}
+	ctx.Regs[RegRSP] = s
+	frames, err := StackTraceAMD64([]*UnwindModule{mod}, ctx, mem, 16)
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if len(frames) != 2 || frames[0] != testImageBase+0x1010 || frames[1] != testReturn {
+		t.Errorf("unexpected frames %x", frames)
+	}
+}
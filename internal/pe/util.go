@@ -1,6 +1,74 @@
 package pe
 
-import "io"
+import (
+	"errors"
+	"io"
+)
+
+// Resource limits for parsing images, in addition to MaxNumSections. Images
+// that exceed these limits are rejected, to bound the work done on crafted
+// input.
+const (
+	// MaxNameLength is the maximum length of a name in an image, such as
+	// the name of an import or export.
+	MaxNameLength = 4096
+
+	// MaxExports is the maximum number of exported functions or names.
+	MaxExports = 0x10000
+
+	// MaxImportModules is the maximum number of modules imported from.
+	MaxImportModules = 0x1000
+
+	// MaxImportsPerModule is the maximum number of symbols imported from a
+	// single module.
+	MaxImportsPerModule = 0x10000
+
+	// MaxRelocations is the maximum number of base relocations.
+	MaxRelocations = 1 << 24
+
+	// MaxSymbols is the maximum number of records in a COFF symbol table.
+	MaxSymbols = 1 << 24
+
+	// MaxStringTableSize is the maximum size of a COFF string table.
+	MaxStringTableSize = 1 << 28
+
+	// MaxDebugDataSize is the maximum size of the data of a debug directory
+	// entry.
+	MaxDebugDataSize = 1 << 20
+
+	// MaxArchiveMembers is the maximum number of members in an archive.
+	MaxArchiveMembers = 1 << 20
+
+	// MaxArchiveSymbols is the maximum number of symbols in the linker
+	// members of an archive.
+	MaxArchiveSymbols = 1 << 24
+
+	// MaxDOSStubSize is the maximum number of bytes before the NT headers
+	// that are searched for a Rich header.
+	MaxDOSStubSize = 0x10000
+
+	// MaxCertificateTableSize is the maximum size of the attribute
+	// certificate table.
+	MaxCertificateTableSize = 1 << 26
+)
+
+var (
+	// ErrNameTooLong is returned when a name exceeds MaxNameLength.
+	ErrNameTooLong = errors.New("pe: name too long")
+
+	// ErrInvalidDirectory is returned when a data directory does not fit
+	// inside the image.
+	ErrInvalidDirectory = errors.New("pe: invalid data directory")
+)
+
+// checkDirectory returns an error if a data directory extends past the end
+// of the image.
+func (m *Module) checkDirectory(dir ImageDataDirectory) error {
+	if uint64(dir.VirtualAddress)+uint64(dir.Size) > uint64(m.Header.OptionalHeader.SizeOfImage) {
+		return ErrInvalidDirectory
+	}
+	return nil
+}
 
 func readfully(r io.Reader, p []byte) error {
 	n, err := r.Read(p)
@@ -26,15 +94,36 @@ func readfully(r io.Reader, p []byte) error {
 	return nil
 }
 
-func readsz(r io.Reader) string {
+func readsz(r io.Reader) (string, error) {
 	name := []byte{}
+	b := [1]byte{}
 	for {
-		b := [1]byte{}
-		r.Read(b[:])
+		if err := readfully(r, b[:]); err != nil {
+			return "", err
+		}
 		if b[0] == 0 {
 			break
 		}
+		if len(name) >= MaxNameLength {
+			return "", ErrNameTooLong
+		}
 		name = append(name, b[0])
 	}
-	return string(name)
+	return string(name), nil
+}
+
+func min32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// roundUp32 rounds n up to a multiple of align, which must be a power of
+// two or zero.
+func roundUp32(n, align uint32) uint32 {
+	if align == 0 {
+		return n
+	}
+	return (n + align - 1) &^ (align - 1)
 }
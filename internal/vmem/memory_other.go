@@ -65,6 +65,11 @@ func (m *Memory) Protect(addr, size uint64, protect int) error {
 	panic("not implemented")
 }
 
+// Decommit decommits a range of memory.
+func (m *Memory) Decommit(addr, size uint64) error {
+	panic("not implemented")
+}
+
 // Clear sets all bytes in the memory block to zero.
 func (m *Memory) Clear() {
 	panic("not implemented")
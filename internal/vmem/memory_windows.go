@@ -172,8 +172,19 @@ func (m *Memory) Clear() {
 
 // Protect changes the memory protection for a range of memory.
 func (m *Memory) Protect(addr, size uint64, protect int) error {
-	// TODO: error handling
 	oldProtect := uint32(0)
-	kernel32VirtualProtect.Call(uintptr(m.Addr()+addr), uintptr(size), uintptr(protect), uintptr(unsafe.Pointer(&oldProtect)))
+	r, _, err := kernel32VirtualProtect.Call(uintptr(m.Addr()+addr), uintptr(size), uintptr(protect), uintptr(unsafe.Pointer(&oldProtect)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Decommit decommits a range of memory.
+func (m *Memory) Decommit(addr, size uint64) error {
+	r, _, err := kernel32VirtualFree.Call(uintptr(m.Addr()+addr), uintptr(size), memDecommit)
+	if r == 0 {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,24 @@
+package vmem
+
+import "github.com/jchv/go-winloader/internal/pe"
+
+// SectionProtection returns the page protection for a section of an image or
+// object file with the specified characteristics.
+func SectionProtection(characteristics uint32) int {
+	executable := characteristics&pe.ImageSectionCharacteristicsMemoryExecute != 0
+	readable := characteristics&pe.ImageSectionCharacteristicsMemoryRead != 0
+	writable := characteristics&pe.ImageSectionCharacteristicsMemoryWrite != 0
+	switch {
+	case executable && writable:
+		return PageExecuteReadWrite
+	case executable && readable:
+		return PageExecuteRead
+	case executable:
+		return PageExecute
+	case writable:
+		return PageReadWrite
+	case readable:
+		return PageReadOnly
+	}
+	return PageNoAccess
+}
@@ -0,0 +1,35 @@
+package winloader
+
+import (
+	"errors"
+)
+
+var (
+	kernel32RtlAddFunctionTable    = kernel32Module.NewProc("RtlAddFunctionTable")
+	kernel32RtlDeleteFunctionTable = kernel32Module.NewProc("RtlDeleteFunctionTable")
+)
+
+// AddFunctionTable implements loader.ExceptionTables. On x86, where there are
+// no function tables, it does nothing.
+func (NativeMachine) AddFunctionTable(table uint64, count uint32, base uint64) error {
+	if kernel32RtlAddFunctionTable.Find() != nil {
+		return nil
+	}
+	r, _, _ := kernel32RtlAddFunctionTable.Call(uintptr(table), uintptr(count), uintptr(base))
+	if r&0xFF == 0 {
+		return errors.New("RtlAddFunctionTable failed")
+	}
+	return nil
+}
+
+// DeleteFunctionTable implements loader.ExceptionTables.
+func (NativeMachine) DeleteFunctionTable(table uint64) error {
+	if kernel32RtlDeleteFunctionTable.Find() != nil {
+		return nil
+	}
+	r, _, _ := kernel32RtlDeleteFunctionTable.Call(uintptr(table))
+	if r&0xFF == 0 {
+		return errors.New("RtlDeleteFunctionTable failed")
+	}
+	return nil
+}
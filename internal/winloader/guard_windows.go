@@ -0,0 +1,56 @@
+package winloader
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+)
+
+var (
+	// guardMu guards guardValidate and guardCallback.
+	guardMu sync.Mutex
+
+	// guardValidate validates the call targets passed to the Control Flow
+	// Guard thunks.
+	guardValidate func(target uint64) bool
+
+	// guardCallback is the native callback that the thunks call with the
+	// call target. It is read by the thunks, which are written in assembly.
+	guardCallback uintptr
+)
+
+// guardCheck is the callback of the Control Flow Guard thunks. It terminates
+// the process if the call target is not valid.
+func guardCheck(target uintptr) uintptr {
+	guardMu.Lock()
+	valid := guardValidate
+	guardMu.Unlock()
+	if !valid(uint64(target)) {
+		panic(fmt.Sprintf("control flow guard: invalid call target %#x", target))
+	}
+	return 0
+}
+
+// NewGuardCFFunctions returns native functions that can be installed as the
+// Control Flow Guard check and dispatch functions of a module. They call
+// valid with the target of each checked indirect call and terminate the
+// process if it returns false. Unlike a callback, they preserve the argument
+// registers of the checked call, and the dispatch function jumps to the
+// target once it is validated.
+//
+// The functions are shared by the process, so valid replaces the function
+// passed to earlier calls. They are only available on amd64.
+func NewGuardCFFunctions(valid func(target uint64) bool) (check, dispatch uint64, err error) {
+	if !guardThunksSupported {
+		return 0, 0, errors.New("guard check functions are not supported on this architecture")
+	}
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	if guardCallback == 0 {
+		guardCallback = syscall.NewCallback(guardCheck)
+	}
+	guardValidate = valid
+	c, d := guardThunks()
+	return uint64(c), uint64(d), nil
+}
@@ -0,0 +1,13 @@
+package winloader
+
+// guardThunksSupported specifies whether the Control Flow Guard thunks are
+// implemented for the native architecture.
+const guardThunksSupported = true
+
+// guardCheckThunk and guardDispatchThunk are the Control Flow Guard check and
+// dispatch functions. They are only called by native code.
+func guardCheckThunk()
+func guardDispatchThunk()
+
+// guardThunks returns the native addresses of the Control Flow Guard thunks.
+func guardThunks() (check, dispatch uintptr)
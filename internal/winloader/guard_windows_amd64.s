@@ -0,0 +1,60 @@
+#include "textflag.h"
+
+// The thunks save the argument registers of the checked call, CX, DX, R8, R9
+// and X0-X3, along with AX, R10 and R11, then call guardCallback with the
+// target in CX. After the seven pushes, the stack is 16-byte aligned again;
+// the X registers are saved above the 32 bytes of shadow space of the call.
+
+#define SAVE \
+	PUSHQ CX; \
+	PUSHQ DX; \
+	PUSHQ R8; \
+	PUSHQ R9; \
+	PUSHQ R10; \
+	PUSHQ R11; \
+	PUSHQ AX; \
+	SUBQ $96, SP; \
+	MOVUPS X0, 32(SP); \
+	MOVUPS X1, 48(SP); \
+	MOVUPS X2, 64(SP); \
+	MOVUPS X3, 80(SP)
+
+#define RESTORE \
+	MOVUPS 32(SP), X0; \
+	MOVUPS 48(SP), X1; \
+	MOVUPS 64(SP), X2; \
+	MOVUPS 80(SP), X3; \
+	ADDQ $96, SP; \
+	POPQ AX; \
+	POPQ R11; \
+	POPQ R10; \
+	POPQ R9; \
+	POPQ R8; \
+	POPQ DX; \
+	POPQ CX
+
+// guardCheckThunk is called with the call target in CX.
+TEXT ·guardCheckThunk(SB),NOSPLIT|NOFRAME,$0
+	SAVE
+	MOVQ ·guardCallback(SB), AX
+	CALL AX
+	RESTORE
+	RET
+
+// guardDispatchThunk is called with the call target in AX, and jumps to it
+// once it is validated, with the arguments of the call left in place.
+TEXT ·guardDispatchThunk(SB),NOSPLIT|NOFRAME,$0
+	SAVE
+	MOVQ AX, CX
+	MOVQ ·guardCallback(SB), AX
+	CALL AX
+	RESTORE
+	JMP AX
+
+// func guardThunks() (check, dispatch uintptr)
+TEXT ·guardThunks(SB),NOSPLIT,$0-16
+	LEAQ ·guardCheckThunk(SB), AX
+	MOVQ AX, check+0(FP)
+	LEAQ ·guardDispatchThunk(SB), AX
+	MOVQ AX, dispatch+8(FP)
+	RET
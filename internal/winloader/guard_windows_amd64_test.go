@@ -0,0 +1,24 @@
+package winloader
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestGuardCFCheckFunction(t *testing.T) {
+	var targets []uint64
+	check, dispatch, err := NewGuardCFFunctions(func(target uint64) bool {
+		targets = append(targets, target)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("expected nil error got %v", err)
+	}
+	if check == 0 || dispatch == 0 {
+		t.Fatalf("expected check and dispatch functions got %#x and %#x", check, dispatch)
+	}
+	syscall.SyscallN(uintptr(check), 0x1234)
+	if len(targets) != 1 || targets[0] != 0x1234 {
+		t.Errorf("expected target 0x1234 to be checked, got %#x", targets)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows && !amd64
+// +build windows,!amd64
+
+package winloader
+
+// guardThunksSupported specifies whether the Control Flow Guard thunks are
+// implemented for the native architecture.
+const guardThunksSupported = false
+
+// guardThunks returns the native addresses of the Control Flow Guard thunks.
+func guardThunks() (check, dispatch uintptr) {
+	return 0, 0
+}
@@ -1,6 +1,7 @@
 package winloader
 
 import (
+	"runtime"
 	"syscall"
 
 	"github.com/jchv/go-winloader/internal/loader"
@@ -46,6 +47,11 @@ func (l Library) Ordinal(ordinal uint64) loader.Proc {
 	return Proc(proc)
 }
 
+// Initialize implements loader.Module
+func (l Library) Initialize() error {
+	return nil
+}
+
 // Free implements loader.Module
 func (l Library) Free() error {
 	return windows.FreeLibrary(windows.Handle(l))
@@ -54,9 +60,14 @@ func (l Library) Free() error {
 // Loader is a loader that uses the native Windows library loader.
 type Loader struct{}
 
-// Load loads a module into memory.
+// Load loads a module into memory. The TLS data of memory modules for the
+// calling thread is preserved if the module uses static TLS.
 func (Loader) Load(libname string) (loader.Module, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	saved := saveTLSData()
 	handle, err := windows.LoadLibrary(libname)
+	restoreTLSData(saved)
 	if err != nil {
 		return nil, err
 	}
@@ -7,3 +7,6 @@ import (
 // NativeArch is a constant that will be equal to the PE machine type
 // enumeration value that corresponds to the arch the binary is running as.
 const NativeArch = pe.ImageFileMachinei386
+
+// tebTLSPointerOffset is the offset of ThreadLocalStoragePointer in the TEB.
+const tebTLSPointerOffset = 0x2C
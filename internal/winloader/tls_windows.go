@@ -0,0 +1,235 @@
+package winloader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/jchv/go-winloader/internal/vmem"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32Module         = windows.NewLazySystemDLL("kernel32")
+	kernel32GetProcessHeap = kernel32Module.NewProc("GetProcessHeap")
+	kernel32HeapAlloc      = kernel32Module.NewProc("HeapAlloc")
+	kernel32HeapSize       = kernel32Module.NewProc("HeapSize")
+	kernel32HeapFree       = kernel32Module.NewProc("HeapFree")
+
+	ntdllNtQueryInformationThread = ntdllModule.NewProc("NtQueryInformationThread")
+)
+
+const (
+	heapZeroMemory            = 0x00000008
+	threadBasicInformation    = 0
+	currentThreadPseudoHandle = ^uintptr(1)
+
+	ptrSize = unsafe.Sizeof(uintptr(0))
+)
+
+// firstTLSIndex is the first static TLS index used for memory modules. The
+// native loader assigns static TLS indices starting from zero, so a high
+// starting index avoids collisions with modules loaded by it.
+//
+// The native loader does not know about these indices. When it loads a module
+// that uses static TLS, it replaces the TLS array of every thread with a new
+// one that only holds its own entries, so the TLS data of memory modules is
+// dropped. Loader.Load puts the data of the calling thread back afterwards;
+// other threads lose it, as they do when a module with TLS is loaded by other
+// means, such as LoadLibrary. Likewise, getTLSArray replaces the array of the
+// native loader when it is too small, and the old array is leaked.
+const firstTLSIndex = 64
+
+// maxTLSIndices is the number of static TLS indices available for memory
+// modules.
+const maxTLSIndices = 64
+
+var (
+	tlsMu   sync.Mutex
+	tlsUsed [maxTLSIndices]bool
+)
+
+type _ThreadBasicInformation struct {
+	ExitStatus     int32
+	TebBaseAddress uintptr
+	ClientID       [2]uintptr
+	AffinityMask   uintptr
+	Priority       int32
+	BasePriority   int32
+}
+
+// readPtr reads a native pointer at addr.
+func readPtr(addr uintptr) uintptr {
+	b := [8]byte{}
+	vmem.Get(uint64(addr), uint64(ptrSize)).ReadAt(b[:ptrSize], 0)
+	return uintptr(binary.LittleEndian.Uint64(b[:]))
+}
+
+// writePtr writes a native pointer at addr.
+func writePtr(addr uintptr, value uintptr) {
+	b := [8]byte{}
+	binary.LittleEndian.PutUint64(b[:], uint64(value))
+	vmem.Get(uint64(addr), uint64(ptrSize)).WriteAt(b[:ptrSize], 0)
+}
+
+// getTLSPointerSlot returns the address of the ThreadLocalStoragePointer
+// field of the current thread's TEB.
+func getTLSPointerSlot() (uintptr, error) {
+	tbi := _ThreadBasicInformation{}
+	status, _, _ := ntdllNtQueryInformationThread.Call(
+		currentThreadPseudoHandle,
+		threadBasicInformation,
+		uintptr(unsafe.Pointer(&tbi)),
+		unsafe.Sizeof(tbi),
+		0,
+	)
+	if status != 0 {
+		return 0, fmt.Errorf("NtQueryInformationThread failed: %08x", status)
+	}
+	return tbi.TebBaseAddress + tebTLSPointerOffset, nil
+}
+
+// getTLSArray returns the address of the current thread's TLS array,
+// reallocating it if it is too small to contain index.
+func getTLSArray(index uint32) (uintptr, error) {
+	slot, err := getTLSPointerSlot()
+	if err != nil {
+		return 0, err
+	}
+	heap, _, _ := kernel32GetProcessHeap.Call()
+	arr := readPtr(slot)
+
+	count := uintptr(0)
+	if arr != 0 {
+		size, _, _ := kernel32HeapSize.Call(heap, 0, arr)
+		if size != ^uintptr(0) {
+			count = size / ptrSize
+		}
+	}
+
+	if count <= uintptr(index) {
+		// The old array is not freed, since the native loader may still
+		// reference it. It is leaked once per thread at most, unless the
+		// native loader replaces the array again.
+		newArr, _, lastErr := kernel32HeapAlloc.Call(heap, heapZeroMemory, (uintptr(index)+1)*ptrSize)
+		if newArr == 0 {
+			return 0, lastErr
+		}
+		for i := uintptr(0); i < count; i++ {
+			writePtr(newArr+i*ptrSize, readPtr(arr+i*ptrSize))
+		}
+		writePtr(slot, newArr)
+		arr = newArr
+	}
+
+	return arr, nil
+}
+
+// AllocTLSIndex implements loader.TLS.
+func (NativeMachine) AllocTLSIndex() (uint32, error) {
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	for i := range tlsUsed {
+		if !tlsUsed[i] {
+			tlsUsed[i] = true
+			return uint32(firstTLSIndex + i), nil
+		}
+	}
+	return 0, errors.New("out of static TLS indices")
+}
+
+// FreeTLSIndex implements loader.TLS.
+func (NativeMachine) FreeTLSIndex(index uint32) {
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	if index >= firstTLSIndex && index < firstTLSIndex+maxTLSIndices {
+		tlsUsed[index-firstTLSIndex] = false
+	}
+}
+
+// SetTLSData implements loader.TLS.
+func (NativeMachine) SetTLSData(index uint32, data []byte) error {
+	arr, err := getTLSArray(index)
+	if err != nil {
+		return err
+	}
+	heap, _, _ := kernel32GetProcessHeap.Call()
+	size := uintptr(len(data))
+	if size == 0 {
+		size = 1
+	}
+	block, _, lastErr := kernel32HeapAlloc.Call(heap, heapZeroMemory, size)
+	if block == 0 {
+		return lastErr
+	}
+	if len(data) > 0 {
+		vmem.Get(uint64(block), uint64(len(data))).WriteAt(data, 0)
+	}
+	entry := arr + uintptr(index)*ptrSize
+	if old := readPtr(entry); old != 0 {
+		kernel32HeapFree.Call(heap, 0, old)
+	}
+	writePtr(entry, block)
+	return nil
+}
+
+// FreeTLSData implements loader.TLS.
+func (NativeMachine) FreeTLSData(index uint32) {
+	slot, err := getTLSPointerSlot()
+	if err != nil {
+		return
+	}
+	arr := readPtr(slot)
+	if arr == 0 {
+		return
+	}
+	heap, _, _ := kernel32GetProcessHeap.Call()
+	size, _, _ := kernel32HeapSize.Call(heap, 0, arr)
+	if size == ^uintptr(0) || size/ptrSize <= uintptr(index) {
+		return
+	}
+	entry := arr + uintptr(index)*ptrSize
+	if block := readPtr(entry); block != 0 {
+		kernel32HeapFree.Call(heap, 0, block)
+		writePtr(entry, 0)
+	}
+}
+
+// saveTLSData returns the TLS data blocks of memory modules for the current
+// thread, by index.
+func saveTLSData() map[uint32]uintptr {
+	slot, err := getTLSPointerSlot()
+	if err != nil {
+		return nil
+	}
+	arr := readPtr(slot)
+	if arr == 0 {
+		return nil
+	}
+	heap, _, _ := kernel32GetProcessHeap.Call()
+	size, _, _ := kernel32HeapSize.Call(heap, 0, arr)
+	if size == ^uintptr(0) {
+		return nil
+	}
+	saved := map[uint32]uintptr{}
+	for index := uint32(firstTLSIndex); index < firstTLSIndex+maxTLSIndices && uintptr(index) < size/ptrSize; index++ {
+		if block := readPtr(arr + uintptr(index)*ptrSize); block != 0 {
+			saved[index] = block
+		}
+	}
+	return saved
+}
+
+// restoreTLSData puts the TLS data blocks returned by saveTLSData back into
+// the TLS array of the current thread, in case the native loader replaced it.
+func restoreTLSData(saved map[uint32]uintptr) {
+	for index, block := range saved {
+		arr, err := getTLSArray(index)
+		if err != nil {
+			continue
+		}
+		writePtr(arr+uintptr(index)*ptrSize, block)
+	}
+}
@@ -0,0 +1,71 @@
+package winloader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/jchv/go-winloader/internal/pe"
+	"github.com/jchv/go-winloader/internal/vmem"
+)
+
+// TestLoadNativeTLSAfterMemoryTLS checks that the TLS data of a memory module
+// survives the native loader loading a module that uses static TLS, which
+// replaces the TLS array of the thread.
+func TestLoadNativeTLSAfterMemoryTLS(t *testing.T) {
+	b := &pe.Builder{
+		Machine: NativeArch,
+		TLS:     &pe.BuilderTLS{Data: []byte("native!!")},
+	}
+	image, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "winloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nativetls.dll")
+	if err := ioutil.WriteFile(path, image, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	m := NativeMachine{}
+	index, err := m.AllocTLSIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.FreeTLSIndex(index)
+	data := []byte("memory!!")
+	if err := m.SetTLSData(index, data); err != nil {
+		t.Fatal(err)
+	}
+	defer m.FreeTLSData(index)
+
+	lib, err := Loader{}.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lib.Free()
+
+	slot, err := getTLSPointerSlot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := readPtr(readPtr(slot) + uintptr(index)*ptrSize)
+	if block == 0 {
+		t.Fatal("expected TLS data of memory module to be kept")
+	}
+	actual := make([]byte, len(data))
+	vmem.Get(uint64(block), uint64(len(actual))).ReadAt(actual, 0)
+	if !bytes.Equal(actual, data) {
+		t.Errorf("expected TLS data %q got %q", data, actual)
+	}
+}
@@ -16,6 +16,19 @@ func LoadFromMemory(data []byte) (Module, error) {
 	return nil, fmt.Errorf("unsupported platform")
 }
 
+// LoadFromMemoryWithOptions loads a Windows module from memory with the
+// specified options.
+func LoadFromMemoryWithOptions(data []byte, opts LoadOptions) (Module, error) {
+	return nil, fmt.Errorf("unsupported platform")
+}
+
+// LoadObjectFromMemory loads a COFF object file (.obj) from memory. Its
+// sections are mapped and relocated, and its global symbols can be looked up
+// with Module.Proc. Object files have no entrypoint or ordinals.
+func LoadObjectFromMemory(data []byte, opts ObjectOptions) (Module, error) {
+	return nil, fmt.Errorf("unsupported platform")
+}
+
 // AddToCache adds a module to the loader cache, allowing in-memory libraries
 // to link to it. Note that modules in the cache must exist in the same
 // address space.
@@ -1,7 +1,11 @@
 package winloader
 
 import (
+	"sync"
+
 	"github.com/jchv/go-winloader/internal/memloader"
+	"github.com/jchv/go-winloader/internal/objloader"
+	"github.com/jchv/go-winloader/internal/pe"
 	"github.com/jchv/go-winloader/internal/winloader"
 )
 
@@ -14,6 +18,22 @@ var ldr = memloader.New(memloader.Options{
 	Machine: winloader.NativeMachine{},
 })
 
+var (
+	guardOnce     sync.Once
+	guardCheck    uint64
+	guardDispatch uint64
+	guardErr      error
+)
+
+// getGuardFunctions returns the native Control Flow Guard check and dispatch
+// functions that validate call targets, creating them on first use.
+func getGuardFunctions() (check, dispatch uint64, err error) {
+	guardOnce.Do(func() {
+		guardCheck, guardDispatch, guardErr = winloader.NewGuardCFFunctions(memloader.IsValidCallTarget)
+	})
+	return guardCheck, guardDispatch, guardErr
+}
+
 // LoadFromFile loads a Windows module from file using the native Windows
 // loader.
 func LoadFromFile(name string) (Module, error) {
@@ -25,6 +45,47 @@ func LoadFromMemory(data []byte) (Module, error) {
 	return ldr.LoadMem(data)
 }
 
+// LoadFromMemoryWithOptions loads a Windows module from memory with the
+// specified options.
+func LoadFromMemoryWithOptions(data []byte, opts LoadOptions) (Module, error) {
+	check, dispatch := uint64(0), uint64(0)
+	if opts.ValidateCallTargets {
+		var err error
+		if check, dispatch, err = getGuardFunctions(); err != nil {
+			return nil, err
+		}
+	}
+	var verify func(data []byte) error
+	if opts.TrustedRoots != nil {
+		verify = func(data []byte) error {
+			return pe.VerifyAuthenticode(data, opts.TrustedRoots)
+		}
+	}
+	return memloader.New(memloader.Options{
+		Next:                    cache,
+		Machine:                 winloader.NativeMachine{},
+		SkipTLSCallbacks:        opts.SkipTLSCallbacks,
+		SkipEntryPoint:          opts.SkipEntryPoint,
+		GuardCFCheckFunction:    check,
+		GuardCFDispatchFunction: dispatch,
+		VerifyImage:             verify,
+		Policies:                opts.Policies,
+		StrictWX:                opts.StrictWX,
+	}).LoadMem(data)
+}
+
+// LoadObjectFromMemory loads a COFF object file (.obj) from memory. Its
+// sections are mapped and relocated, and its global symbols can be looked up
+// with Module.Proc. Object files have no entrypoint or ordinals.
+func LoadObjectFromMemory(data []byte, opts ObjectOptions) (Module, error) {
+	return objloader.New(objloader.Options{
+		Next:      cache,
+		Machine:   winloader.NativeMachine{},
+		Libraries: opts.Libraries,
+		Symbols:   opts.Symbols,
+	}).LoadMem(data)
+}
+
 // AddToCache adds a module to the loader cache, allowing in-memory libraries
 // to link to it. Note that modules in the cache must exist in the same
 // address space.